@@ -15,6 +15,7 @@ import (
 	"github.com/diskfs/go-diskfs/filesystem/fat32"
 	"github.com/diskfs/go-diskfs/filesystem/iso9660"
 	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+	"github.com/diskfs/go-diskfs/filesystem/udf"
 	"github.com/diskfs/go-diskfs/partition"
 	log "github.com/sirupsen/logrus"
 )
@@ -178,6 +179,8 @@ func (d *Disk) CreateFilesystem(spec FilesystemSpec) (filesystem.FileSystem, err
 		return ext4.Create(d.Backend, size, start, d.LogicalBlocksize, nil)
 	case filesystem.TypeSquashfs:
 		return squashfs.Create(d.Backend, size, start, d.LogicalBlocksize)
+	case filesystem.TypeUDF:
+		return udf.Create(d.Backend, size, start, d.LogicalBlocksize, spec.WorkDir)
 	default:
 		return nil, errors.New("unknown filesystem type requested")
 	}
@@ -231,6 +234,12 @@ func (d *Disk) GetFilesystem(part int) (filesystem.FileSystem, error) {
 		return iso9660FS, nil
 	}
 	log.Debugf("iso9660 failed: %v", err)
+	log.Debugf("trying udf with physical block size %d", pbs)
+	udfFS, err := udf.Read(d.Backend, size, start, pbs)
+	if err == nil {
+		return udfFS, nil
+	}
+	log.Debugf("udf failed: %v", err)
 	squashFS, err := squashfs.Read(d.Backend, size, start, d.LogicalBlocksize)
 	if err == nil {
 		return squashFS, nil
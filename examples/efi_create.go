@@ -68,4 +68,9 @@ func CreateEfi(diskImg string) {
 		log.Panic(err)
 	}
 	fmt.Printf("wrote %d bytes\n", n)
+
+	// write out the file allocation table
+	if err := fs.Close(); err != nil {
+		log.Panic(err)
+	}
 }
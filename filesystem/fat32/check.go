@@ -0,0 +1,569 @@
+package fat32
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+)
+
+// CheckProblemType is the kind of inconsistency found by Check
+type CheckProblemType int
+
+const (
+	// CheckLostChain is a chain of allocated clusters that does not belong to any file or directory
+	CheckLostChain CheckProblemType = iota
+	// CheckCrossLinkedChain is a cluster chain that runs into clusters belonging to another file or directory
+	CheckCrossLinkedChain
+	// CheckChainLength is a cluster chain that is broken, or whose length does not match the file size
+	CheckChainLength
+	// CheckInvalidEntry is a directory entry that cannot be valid, e.g. with an illegal name or start cluster
+	CheckInvalidEntry
+	// CheckFATMismatch is a copy of the FAT that differs from the primary FAT
+	CheckFATMismatch
+	// CheckFSInfo is an FS Information Sector with a stale free cluster count or next free cluster hint
+	CheckFSInfo
+	// CheckBootSectorBackup is a backup of the boot sectors, including the FS Information Sector, that differs from them
+	CheckBootSectorBackup
+)
+
+func (t CheckProblemType) String() string {
+	switch t {
+	case CheckLostChain:
+		return "lost cluster chain"
+	case CheckCrossLinkedChain:
+		return "cross-linked cluster chain"
+	case CheckChainLength:
+		return "cluster chain length"
+	case CheckInvalidEntry:
+		return "invalid directory entry"
+	case CheckFATMismatch:
+		return "FAT copy mismatch"
+	case CheckFSInfo:
+		return "stale FS information sector"
+	case CheckBootSectorBackup:
+		return "backup boot sectors mismatch"
+	}
+	return fmt.Sprintf("unknown problem %d", int(t))
+}
+
+// CheckProblem is a single inconsistency found by Check
+type CheckProblem struct {
+	Type CheckProblemType
+	// Path is the file or directory affected, if any
+	Path string
+	// Cluster is the first cluster involved, if any
+	Cluster     uint32
+	Description string
+	// Repaired is true if the fix for the problem was written to disk
+	Repaired bool
+}
+
+func (p CheckProblem) String() string {
+	s := p.Type.String()
+	if p.Path != "" {
+		s += " " + p.Path
+	}
+	return s + ": " + p.Description
+}
+
+// CheckReport is the result of checking a filesystem with Check
+type CheckReport struct {
+	Problems []CheckProblem
+}
+
+// Clean reports whether no problems were found
+func (r *CheckReport) Clean() bool {
+	return len(r.Problems) == 0
+}
+
+// checker holds the state of a single run of Check
+type checker struct {
+	fs     *FileSystem
+	repair bool
+	report *CheckReport
+	// owners maps every cluster that belongs to a file or directory to its path
+	owners map[uint32]string
+	// fatChanged is set once any repair was made to the FAT
+	fatChanged bool
+	// fatRepairs are the indexes of the problems that are repaired once the FAT is written
+	fatRepairs []int
+}
+
+// chainResult is a cluster chain as far as it could be followed
+type chainResult struct {
+	clusters []uint32
+	// broken is why the chain ended before an end-of-chain marker, if it did
+	broken string
+	// crossLinked is the owner of the cluster the chain ran into, if it did
+	crossLinked string
+}
+
+// Check checks the filesystem for consistency, similar to dosfsck, and returns a report of all the problems found.
+//
+// It detects lost cluster chains, cross-linked chains, chains that are broken or whose length does not match
+// the file size, invalid directory entries, FAT copies that differ from the primary FAT,
+// a stale free cluster count or next free cluster hint in the FS Information Sector, and a backup of the boot
+// sectors, which include the FS Information Sector, that differs from them.
+//
+// If repair is true, the problems are fixed as well:
+//   - lost chains are freed
+//   - cross-linked chains are truncated where they run into the other chain
+//   - chains that are too long are truncated, files whose chains are too short are cut down to their chain length
+//   - invalid directory entries are removed
+//   - FAT copies, the FS Information Sector and the backup boot sectors are rewritten from the primary data
+//
+// Each problem is marked as repaired once its fix is written to disk.
+// An error is returned only if the check itself cannot be completed.
+func (fs *FileSystem) Check(repair bool) (*CheckReport, error) {
+	c := &checker{
+		fs:     fs,
+		repair: repair,
+		report: &CheckReport{},
+		owners: make(map[uint32]string),
+	}
+
+	// what is on disk must be current, or it would differ from what is in memory
+	if err := fs.Sync(); err != nil {
+		return nil, err
+	}
+
+	if err := c.checkBootSector(); err != nil {
+		return nil, err
+	}
+	fatMismatch, err := c.checkFatCopies()
+	if err != nil {
+		return nil, err
+	}
+	freeBefore := c.fs.table.countFree()
+
+	// the root directory
+	root := &Directory{
+		directoryEntry: directoryEntry{
+			clusterLocation: fs.table.rootDirCluster,
+			isSubdirectory:  true,
+			filesystem:      fs,
+		},
+	}
+	res := c.followChain(root.clusterLocation)
+	if len(res.clusters) == 0 {
+		return nil, fmt.Errorf("root directory at cluster %d is invalid: %s", root.clusterLocation, res.broken)
+	}
+	if res.broken != "" {
+		problem := c.addProblem(CheckChainLength, "/", root.clusterLocation, "root directory chain is broken: %s", res.broken)
+		c.truncateChain(problem, res.clusters, len(res.clusters))
+	}
+	c.own(res.clusters, "/")
+	if err := c.checkDirectory(root, "/", res.clusters); err != nil {
+		return nil, err
+	}
+
+	c.checkLostChains()
+
+	if repair && (fatMismatch || c.fatChanged) {
+		if err := fs.writeFat(); err != nil {
+			return nil, fmt.Errorf("failed to write the file allocation table: %w", err)
+		}
+		c.repaired(c.fatRepairs...)
+	}
+
+	if err := c.checkFsis(freeBefore); err != nil {
+		return nil, err
+	}
+
+	return c.report, nil
+}
+
+// addProblem records a problem, and returns its index in the report, to mark it repaired once its fix is written
+func (c *checker) addProblem(t CheckProblemType, p string, cluster uint32, format string, args ...interface{}) int {
+	c.report.Problems = append(c.report.Problems, CheckProblem{
+		Type:        t,
+		Path:        p,
+		Cluster:     cluster,
+		Description: fmt.Sprintf(format, args...),
+	})
+	return len(c.report.Problems) - 1
+}
+
+// repaired marks the problems with the given indexes as repaired
+func (c *checker) repaired(problems ...int) {
+	for _, i := range problems {
+		c.report.Problems[i].Repaired = true
+	}
+}
+
+// checkBootSector compares the boot sectors on disk, from the boot sector up to the backup boot sector, with their
+// backup, which takes up as many sectors after the backup boot sector, within the reserved sectors
+func (c *checker) checkBootSector() error {
+	fs := c.fs
+	backupBootSector := fs.bootSector.biosParameterBlock.backupBootSector
+	reservedSectors := fs.bootSector.biosParameterBlock.dos331BPB.dos20BPB.reservedSectors
+	if backupBootSector == 0 || backupBootSector >= reservedSectors {
+		return nil
+	}
+	count := backupBootSector
+	if backupBootSector+count > reservedSectors {
+		count = reservedSectors - backupBootSector
+	}
+	backupStart := int64(backupBootSector)*int64(SectorSize512) + fs.start
+	primary := make([]byte, int(count)*int(SectorSize512))
+	if _, err := fs.backend.ReadAt(primary, fs.start); err != nil {
+		return fmt.Errorf("unable to read boot sectors: %w", err)
+	}
+	backup := make([]byte, len(primary))
+	if _, err := fs.backend.ReadAt(backup, backupStart); err != nil {
+		return fmt.Errorf("unable to read backup boot sectors: %w", err)
+	}
+	if bytes.Equal(primary, backup) {
+		return nil
+	}
+	var differ int
+	for i := 0; i < int(count); i++ {
+		sector := i * int(SectorSize512)
+		if !bytes.Equal(primary[sector:sector+int(SectorSize512)], backup[sector:sector+int(SectorSize512)]) {
+			differ = i
+			break
+		}
+	}
+	problem := c.addProblem(CheckBootSectorBackup, "", 0, "backup of sector %d at sector %d differs from it", differ, int(backupBootSector)+differ)
+	if !c.repair {
+		return nil
+	}
+	writableFile, err := fs.backend.Writable()
+	if err != nil {
+		return err
+	}
+	if _, err := writableFile.WriteAt(primary, backupStart); err != nil {
+		return fmt.Errorf("unable to write backup boot sectors: %w", err)
+	}
+	c.repaired(problem)
+	return nil
+}
+
+// checkFatCopies compares every copy of the FAT on disk with the primary one, and reports if any differs
+func (c *checker) checkFatCopies() (bool, error) {
+	fs := c.fs
+	reservedSectors := fs.bootSector.biosParameterBlock.dos331BPB.dos20BPB.reservedSectors
+	fatCount := int(fs.bootSector.biosParameterBlock.dos331BPB.dos20BPB.fatCount)
+	fatPrimaryStart := int64(reservedSectors) * int64(SectorSize512)
+
+	primary := make([]byte, fs.table.size)
+	if _, err := fs.backend.ReadAt(primary, fatPrimaryStart+fs.start); err != nil {
+		return false, fmt.Errorf("unable to read primary FAT: %w", err)
+	}
+	var mismatch bool
+	fatCopy := make([]byte, fs.table.size)
+	for i := 1; i < fatCount; i++ {
+		if _, err := fs.backend.ReadAt(fatCopy, fatPrimaryStart+int64(i)*int64(fs.table.size)+fs.start); err != nil {
+			return false, fmt.Errorf("unable to read FAT copy %d: %w", i, err)
+		}
+		if !bytes.Equal(primary, fatCopy) {
+			c.fatRepairs = append(c.fatRepairs, c.addProblem(CheckFATMismatch, "", 0, "FAT copy %d differs from the primary FAT", i))
+			mismatch = true
+		}
+	}
+	return mismatch, nil
+}
+
+// checkFsis compares the FS Information Sector with the actual count of free clusters, and repairs it if needed
+func (c *checker) checkFsis(freeBefore uint32) error {
+	fs := c.fs
+	var problems []int
+	if count := fs.fsis.freeDataClustersCount; count != unknownFreeDataClusterCount && count != freeBefore {
+		problems = append(problems, c.addProblem(CheckFSInfo, "", 0, "free cluster count is %d, actual %d", count, freeBefore))
+	}
+	if hint := fs.fsis.lastAllocatedCluster; hint != unknownlastAllocatedCluster && (hint < 2 || hint >= fs.table.maxCluster) {
+		problems = append(problems, c.addProblem(CheckFSInfo, "", hint, "next free cluster hint %d is out of range", hint))
+	}
+	free := c.fs.table.countFree()
+	if !c.repair || (len(problems) == 0 && free == freeBefore) {
+		return nil
+	}
+	fs.fsis.freeDataClustersCount = free
+	// point the hint just before the first free cluster, so a search after it finds free space immediately
+	fs.fsis.lastAllocatedCluster = unknownlastAllocatedCluster
+	for i := uint32(2); i < fs.table.maxCluster; i++ {
+		if fs.table.clusters[i] == fs.table.unusedMarker {
+			if i > 2 {
+				fs.fsis.lastAllocatedCluster = i - 1
+			}
+			break
+		}
+	}
+	if err := fs.writeFsis(); err != nil {
+		return fmt.Errorf("failed to write the file system information sector: %w", err)
+	}
+	fs.fsisDirty = false
+	c.repaired(problems...)
+	return nil
+}
+
+// followChain follows a cluster chain from its first cluster for as long as it is valid
+func (c *checker) followChain(first uint32) chainResult {
+	t := &c.fs.table
+	var res chainResult
+	seen := make(map[uint32]bool)
+	for cluster := first; ; {
+		switch {
+		case cluster < 2 || cluster >= t.maxCluster:
+			res.broken = fmt.Sprintf("invalid cluster %d", cluster)
+			return res
+		case t.clusters[cluster] == t.unusedMarker:
+			res.broken = fmt.Sprintf("cluster %d is free", cluster)
+			return res
+		case seen[cluster]:
+			res.broken = fmt.Sprintf("loop at cluster %d", cluster)
+			return res
+		}
+		if owner, ok := c.owners[cluster]; ok {
+			res.crossLinked = owner
+			return res
+		}
+		seen[cluster] = true
+		res.clusters = append(res.clusters, cluster)
+		next := t.clusters[cluster] & 0x0fffffff
+		switch {
+		case t.isEoc(next):
+			return res
+		case next == badCluster:
+			res.broken = fmt.Sprintf("cluster %d points to a bad cluster", cluster)
+			return res
+		}
+		cluster = next
+	}
+}
+
+// own marks clusters as belonging to a file or directory
+func (c *checker) own(clusters []uint32, p string) {
+	for _, cluster := range clusters {
+		c.owners[cluster] = p
+	}
+}
+
+// truncateChain cuts a chain down to its first count clusters, freeing the rest, if repairing the problem
+func (c *checker) truncateChain(problem int, clusters []uint32, count int) {
+	if !c.repair {
+		return
+	}
+	c.fatRepairs = append(c.fatRepairs, problem)
+	t := &c.fs.table
+	for _, cluster := range clusters[count:] {
+		t.set(cluster, t.unusedMarker)
+	}
+	if count > 0 {
+		t.set(clusters[count-1], t.eocMarker)
+	}
+	c.fatChanged = true
+}
+
+// repairChain repairs the chain of an entry that is left with count of its clusters. With none of them left, the
+// FAT has nothing of the entry to change, and it is the entry that is emptied or removed, so the problem is added to
+// the repairs of its directory, which it returns.
+func (c *checker) repairChain(problem int, clusters []uint32, count int, repairs []int) []int {
+	if count > 0 {
+		c.truncateChain(problem, clusters, count)
+		return repairs
+	}
+	if c.repair {
+		repairs = append(repairs, problem)
+	}
+	return repairs
+}
+
+// invalidEntry returns why a directory entry cannot be valid, or "" if it can
+func (c *checker) invalidEntry(e *directoryEntry) string {
+	name := e.filenameShort + e.fileExtension
+	for i := 0; i < len(name); i++ {
+		switch ch := name[i]; {
+		case i == 0 && ch == 0x05:
+			// stands for a name starting with 0xe5
+		case ch < 0x20 || bytes.IndexByte([]byte(`"*+,./:;<=>?[\]|`), ch) >= 0:
+			return fmt.Sprintf("invalid character %#02x in short name", ch)
+		}
+	}
+	switch {
+	case e.filenameShort == "":
+		return "empty short name"
+	case e.clusterLocation == 1 || e.clusterLocation >= c.fs.table.maxCluster:
+		return fmt.Sprintf("invalid start cluster %d", e.clusterLocation)
+	case e.isSubdirectory && e.clusterLocation == 0:
+		return "directory without a start cluster"
+	case !e.isSubdirectory && e.clusterLocation == 0 && e.fileSize > 0:
+		return fmt.Sprintf("file of %d bytes without a start cluster", e.fileSize)
+	}
+	return ""
+}
+
+// checkDirectory checks all of the entries in a directory, and recursively in its subdirectories
+func (c *checker) checkDirectory(dir *Directory, p string, clusters []uint32) error {
+	fs := c.fs
+	b := make([]byte, len(clusters)*fs.bytesPerCluster)
+	for i, cluster := range clusters {
+		clusterStart := fs.start + int64(fs.dataStart) + int64(cluster-2)*int64(fs.bytesPerCluster)
+		if _, err := fs.backend.ReadAt(b[i*fs.bytesPerCluster:(i+1)*fs.bytesPerCluster], clusterStart); err != nil {
+			return fmt.Errorf("unable to read directory %s: %w", p, err)
+		}
+	}
+	if err := dir.entriesFromBytes(b); err != nil {
+		return fmt.Errorf("unable to parse directory %s: %w", p, err)
+	}
+
+	// the problems repaired by writing the directory entries, which also are written if a file is emptied, as the
+	// rest of its chain is gone
+	var (
+		repairs    []int
+		dirChanged bool
+	)
+	entries := make([]*directoryEntry, 0, len(dir.entries))
+	for _, e := range dir.entries {
+		if e.isVolumeLabel || e.filenameShort == "." || e.filenameShort == ".." {
+			entries = append(entries, e)
+			continue
+		}
+		name := e.filenameLong
+		if name == "" {
+			name = e.displayShortName()
+		}
+		entryPath := path.Join(p, name)
+
+		if reason := c.invalidEntry(e); reason != "" {
+			problem := c.addProblem(CheckInvalidEntry, entryPath, e.clusterLocation, "%s", reason)
+			if c.repair {
+				repairs = append(repairs, problem)
+				continue
+			}
+			entries = append(entries, e)
+			continue
+		}
+		entries = append(entries, e)
+		if e.clusterLocation == 0 {
+			continue
+		}
+
+		res := c.followChain(e.clusterLocation)
+		c.own(res.clusters, entryPath)
+		count := len(res.clusters)
+		switch {
+		case res.crossLinked != "":
+			var at uint32
+			if count > 0 {
+				at = c.fs.table.clusters[res.clusters[count-1]] & 0x0fffffff
+			} else {
+				at = e.clusterLocation
+			}
+			problem := c.addProblem(CheckCrossLinkedChain, entryPath, at, "cross-linked with %s at cluster %d", res.crossLinked, at)
+			repairs = c.repairChain(problem, res.clusters, count, repairs)
+		case res.broken != "":
+			problem := c.addProblem(CheckChainLength, entryPath, e.clusterLocation, "chain is broken: %s", res.broken)
+			repairs = c.repairChain(problem, res.clusters, count, repairs)
+		}
+
+		if e.isSubdirectory {
+			if count == 0 {
+				// nothing left of the directory at all
+				problem := c.addProblem(CheckInvalidEntry, entryPath, e.clusterLocation, "directory has no clusters of its own")
+				if c.repair {
+					repairs = append(repairs, problem)
+					entries = entries[:len(entries)-1]
+				}
+				continue
+			}
+			sub := &Directory{
+				directoryEntry: directoryEntry{
+					clusterLocation: e.clusterLocation,
+					isSubdirectory:  true,
+					filesystem:      fs,
+				},
+			}
+			if err := c.checkDirectory(sub, entryPath, res.clusters); err != nil {
+				return err
+			}
+			continue
+		}
+
+		// regular file, so the chain must hold exactly the file size; an empty file may keep a single cluster
+		if count == 0 {
+			// nothing is left of the chain, so the file is emptied
+			if c.repair {
+				e.clusterLocation = 0
+				e.fileSize = 0
+				dirChanged = true
+			}
+			continue
+		}
+		bytesPerCluster := uint64(fs.bytesPerCluster)
+		needed := (uint64(e.fileSize) + bytesPerCluster - 1) / bytesPerCluster
+		allowed := needed
+		if allowed == 0 {
+			allowed = 1
+		}
+		switch {
+		case uint64(count) > allowed:
+			problem := c.addProblem(CheckChainLength, entryPath, e.clusterLocation, "chain of %d clusters is longer than the %d needed for %d bytes", count, allowed, e.fileSize)
+			c.truncateChain(problem, res.clusters, int(allowed))
+		case uint64(count) < needed:
+			problem := c.addProblem(CheckChainLength, entryPath, e.clusterLocation, "chain of %d clusters is too short for %d bytes", count, e.fileSize)
+			if c.repair {
+				e.fileSize = uint32(uint64(count) * bytesPerCluster)
+				repairs = append(repairs, problem)
+			}
+		}
+	}
+
+	if len(repairs) == 0 && !dirChanged {
+		return nil
+	}
+	dir.entries = entries
+	if err := fs.writeDirectoryEntries(dir); err != nil {
+		return fmt.Errorf("error writing directory entries for %s to disk: %w", p, err)
+	}
+	c.repaired(repairs...)
+	return nil
+}
+
+// checkLostChains finds allocated clusters that do not belong to any file or directory, and frees them if repairing
+func (c *checker) checkLostChains() {
+	t := &c.fs.table
+	lost := make(map[uint32]bool)
+	for i := uint32(2); i < t.maxCluster; i++ {
+		if t.clusters[i] != t.unusedMarker && t.clusters[i]&0x0fffffff != badCluster && c.owners[i] == "" {
+			lost[i] = true
+		}
+	}
+	if len(lost) == 0 {
+		return
+	}
+	// the heads of lost chains are the lost clusters no other lost cluster points to
+	pointedTo := make(map[uint32]bool)
+	for cluster := range lost {
+		pointedTo[t.clusters[cluster]&0x0fffffff] = true
+	}
+	var problems []int
+	reported := make(map[uint32]bool)
+	report := func(head uint32) {
+		count := 0
+		for cluster := head; lost[cluster] && !reported[cluster]; cluster = t.clusters[cluster] & 0x0fffffff {
+			reported[cluster] = true
+			count++
+		}
+		problems = append(problems, c.addProblem(CheckLostChain, "", head, "%d clusters starting at cluster %d do not belong to any file", count, head))
+	}
+	for i := uint32(2); i < t.maxCluster; i++ {
+		if lost[i] && !pointedTo[i] {
+			report(i)
+		}
+	}
+	// whatever is left is in loops
+	for i := uint32(2); i < t.maxCluster; i++ {
+		if lost[i] && !reported[i] {
+			report(i)
+		}
+	}
+
+	if c.repair {
+		for cluster := range lost {
+			t.set(cluster, t.unusedMarker)
+		}
+		c.fatChanged = true
+		c.fatRepairs = append(c.fatRepairs, problems...)
+	}
+}
@@ -0,0 +1,312 @@
+package fat32_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+)
+
+// testFatLayout is where the interesting structures of a FAT32 image are, read from its boot sector
+type testFatLayout struct {
+	fatStart        int64
+	fatSize         int64
+	dataStart       int64
+	bytesPerCluster int64
+}
+
+func testReadFatLayout(t *testing.T, f *os.File) testFatLayout {
+	t.Helper()
+	bs := make([]byte, 512)
+	if _, err := f.ReadAt(bs, 0); err != nil {
+		t.Fatalf("error reading boot sector: %v", err)
+	}
+	reserved := int64(binary.LittleEndian.Uint16(bs[14:16]))
+	fatCount := int64(bs[16])
+	sectorsPerFat := int64(binary.LittleEndian.Uint32(bs[36:40]))
+	return testFatLayout{
+		fatStart:        reserved * 512,
+		fatSize:         sectorsPerFat * 512,
+		dataStart:       (reserved + fatCount*sectorsPerFat) * 512,
+		bytesPerCluster: int64(bs[13]) * 512,
+	}
+}
+
+// setFatEntry sets the value for a cluster in the given copies of the FAT
+func (l testFatLayout) setFatEntry(t *testing.T, f *os.File, cluster, value uint32, copies ...int) {
+	t.Helper()
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, value)
+	for _, c := range copies {
+		if _, err := f.WriteAt(b, l.fatStart+int64(c)*l.fatSize+int64(cluster)*4); err != nil {
+			t.Fatalf("error writing FAT entry: %v", err)
+		}
+	}
+}
+
+func testClusterChain(t *testing.T, fs *fat32.FileSystem, p string) []uint32 {
+	t.Helper()
+	f, err := fs.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening %s: %v", p, err)
+	}
+	clusters, err := f.(*fat32.File).GetClusterChain()
+	if err != nil {
+		t.Fatalf("error getting cluster chain of %s: %v", p, err)
+	}
+	return clusters
+}
+
+func TestFat32Check(t *testing.T) {
+	f, err := tmpFat32(false, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keepTmpFiles == "" {
+		defer os.Remove(f.Name())
+	} else {
+		fmt.Println(f.Name())
+	}
+	fileInfo, err := f.Stat()
+	if err != nil {
+		t.Fatalf("error getting file info for tmpfile %s: %v", f.Name(), err)
+	}
+	fs, err := fat32.Create(file.New(f, false), fileInfo.Size(), 0, 512, "go-diskfs")
+	if err != nil {
+		t.Fatalf("error creating fat32 filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/a"); err != nil {
+		t.Fatalf("error making directory: %v", err)
+	}
+	contents := map[string][]byte{
+		"/a/file1": bytes.Repeat([]byte{1}, 3000),
+		"/file2":   bytes.Repeat([]byte{2}, 600),
+		"/file3":   bytes.Repeat([]byte{3}, 1500),
+		"/removed": bytes.Repeat([]byte{4}, 5000),
+	}
+	for _, p := range []string{"/a/file1", "/file2", "/file3", "/removed"} {
+		rw, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating %s: %v", p, err)
+		}
+		if _, err := rw.Write(contents[p]); err != nil {
+			t.Fatalf("error writing %s: %v", p, err)
+		}
+	}
+	if err := fs.Remove("/removed"); err != nil {
+		t.Fatalf("error removing file: %v", err)
+	}
+
+	report, err := fs.Check(false)
+	if err != nil {
+		t.Fatalf("error checking filesystem: %v", err)
+	}
+	if !report.Clean() {
+		t.Fatalf("unexpected problems on a new filesystem: %v", report.Problems)
+	}
+
+	// now break it in every way we know
+	layout := testReadFatLayout(t, f)
+	file2 := testClusterChain(t, fs, "/file2")
+	file3 := testClusterChain(t, fs, "/file3")
+	var lastUsed uint32
+	for _, p := range []string{"/a/file1", "/file2", "/file3"} {
+		for _, c := range testClusterChain(t, fs, p) {
+			if c > lastUsed {
+				lastUsed = c
+			}
+		}
+	}
+	eoc := uint32(0x0fffffff)
+	// file2 gets an extra cluster at the end of its chain
+	layout.setFatEntry(t, f, file2[len(file2)-1], lastUsed+1, 0, 1)
+	layout.setFatEntry(t, f, lastUsed+1, eoc, 0, 1)
+	// file3 runs into the chain of file2
+	layout.setFatEntry(t, f, file3[len(file3)-1], file2[0], 0, 1)
+	// a lost chain of 3 clusters
+	layout.setFatEntry(t, f, lastUsed+10, lastUsed+11, 0, 1)
+	layout.setFatEntry(t, f, lastUsed+11, lastUsed+12, 0, 1)
+	layout.setFatEntry(t, f, lastUsed+12, eoc, 0, 1)
+	// a difference in the second FAT only
+	layout.setFatEntry(t, f, lastUsed+20, eoc, 1)
+	// a wrong free cluster count, in the FS Information Sector and its backup
+	for _, sector := range []int64{1, 7} {
+		if _, err := f.WriteAt([]byte{0x39, 0x30, 0, 0}, sector*512+488); err != nil {
+			t.Fatalf("error writing FS Information Sector: %v", err)
+		}
+	}
+	// a backup of the FS Information Sector that differs in its reserved bytes, while the backup boot sector does not
+	if _, err := f.WriteAt([]byte("corrupt!"), 7*512+100); err != nil {
+		t.Fatalf("error writing backup FS Information Sector: %v", err)
+	}
+
+	fs, err = fat32.Read(file.New(f, false), fileInfo.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading fat32 filesystem: %v", err)
+	}
+	report, err = fs.Check(false)
+	if err != nil {
+		t.Fatalf("error checking filesystem: %v", err)
+	}
+	expected := map[fat32.CheckProblemType]string{
+		fat32.CheckBootSectorBackup: "",
+		fat32.CheckFATMismatch:      "",
+		fat32.CheckChainLength:      "/file2",
+		fat32.CheckCrossLinkedChain: "/file3",
+		fat32.CheckLostChain:        "",
+		fat32.CheckFSInfo:           "",
+	}
+	found := make(map[fat32.CheckProblemType]bool)
+	for _, p := range report.Problems {
+		expectedPath, ok := expected[p.Type]
+		switch {
+		case !ok:
+			t.Errorf("unexpected problem: %v", p)
+		case p.Path != expectedPath:
+			t.Errorf("problem %v for path %s, expected %s", p, p.Path, expectedPath)
+		case p.Repaired:
+			t.Errorf("problem %v marked as repaired when not repairing", p)
+		case p.Type == fat32.CheckLostChain && p.Cluster != lastUsed+10:
+			t.Errorf("lost chain reported at cluster %d instead of %d", p.Cluster, lastUsed+10)
+		}
+		found[p.Type] = true
+	}
+	for pt := range expected {
+		if !found[pt] {
+			t.Errorf("problem %v not found", pt)
+		}
+	}
+
+	// repair, and check again from a fresh read
+	report, err = fs.Check(true)
+	if err != nil {
+		t.Fatalf("error repairing filesystem: %v", err)
+	}
+	if len(report.Problems) < len(expected) {
+		t.Errorf("found %d problems repairing, expected at least %d", len(report.Problems), len(expected))
+	}
+	for _, p := range report.Problems {
+		if !p.Repaired {
+			t.Errorf("problem %v not marked as repaired", p)
+		}
+	}
+	fs, err = fat32.Read(file.New(f, false), fileInfo.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading fat32 filesystem: %v", err)
+	}
+	report, err = fs.Check(false)
+	if err != nil {
+		t.Fatalf("error checking filesystem: %v", err)
+	}
+	if !report.Clean() {
+		t.Errorf("unexpected problems after repair: %v", report.Problems)
+	}
+	for _, p := range []string{"/a/file1", "/file2", "/file3"} {
+		rw, err := fs.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("error opening %s: %v", p, err)
+		}
+		b, err := io.ReadAll(rw)
+		if err != nil {
+			t.Fatalf("error reading %s: %v", p, err)
+		}
+		if !bytes.Equal(b, contents[p]) {
+			t.Errorf("mismatched contents of %s after repair", p)
+		}
+	}
+}
+
+func TestFat32CheckSharedStart(t *testing.T) {
+	f, err := tmpFat32(false, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keepTmpFiles == "" {
+		defer os.Remove(f.Name())
+	} else {
+		fmt.Println(f.Name())
+	}
+	fileInfo, err := f.Stat()
+	if err != nil {
+		t.Fatalf("error getting file info for tmpfile %s: %v", f.Name(), err)
+	}
+	fs, err := fat32.Create(file.New(f, false), fileInfo.Size(), 0, 512, "go-diskfs")
+	if err != nil {
+		t.Fatalf("error creating fat32 filesystem: %v", err)
+	}
+	contents := bytes.Repeat([]byte{1}, 600)
+	for _, p := range []string{"/first", "/second"} {
+		rw, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating %s: %v", p, err)
+		}
+		if _, err := rw.Write(contents); err != nil {
+			t.Fatalf("error writing %s: %v", p, err)
+		}
+	}
+
+	// first runs into the chain of second, so once first is cut back, nothing of the chain of second is left
+	layout := testReadFatLayout(t, f)
+	first := testClusterChain(t, fs, "/first")
+	second := testClusterChain(t, fs, "/second")
+	layout.setFatEntry(t, f, first[len(first)-1], second[0], 0, 1)
+
+	fs, err = fat32.Read(file.New(f, false), fileInfo.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading fat32 filesystem: %v", err)
+	}
+	report, err := fs.Check(true)
+	if err != nil {
+		t.Fatalf("error repairing filesystem: %v", err)
+	}
+	var emptied bool
+	for _, p := range report.Problems {
+		if p.Path == "/second" {
+			emptied = true
+		}
+		if !p.Repaired {
+			t.Errorf("problem %v not marked as repaired", p)
+		}
+	}
+	if !emptied {
+		t.Errorf("problem with /second not found in %v", report.Problems)
+	}
+
+	fs, err = fat32.Read(file.New(f, false), fileInfo.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading fat32 filesystem: %v", err)
+	}
+	report, err = fs.Check(false)
+	if err != nil {
+		t.Fatalf("error checking filesystem: %v", err)
+	}
+	if !report.Clean() {
+		t.Errorf("unexpected problems after repair: %v", report.Problems)
+	}
+	rw, err := fs.OpenFile("/first", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening /first: %v", err)
+	}
+	b, err := io.ReadAll(rw)
+	if err != nil {
+		t.Fatalf("error reading /first: %v", err)
+	}
+	if !bytes.Equal(b, contents) {
+		t.Errorf("mismatched contents of /first after repair")
+	}
+	// second is emptied, as nothing of its chain was left
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("error reading root directory: %v", err)
+	}
+	for _, e := range entries {
+		if e.Name() == "second" && e.Size() != 0 {
+			t.Errorf("/second is %d bytes after repair instead of emptied", e.Size())
+		}
+	}
+}
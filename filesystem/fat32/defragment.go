@@ -0,0 +1,184 @@
+package fat32
+
+import (
+	"fmt"
+	"path"
+)
+
+// Defragment moves the clusters of the named file into a single contiguous run, if they are not already.
+// If p is a directory, every file in it and all of its subdirectories is defragmented; the directories themselves
+// are left where they are.
+//
+// It returns an error if there is no run of free clusters large enough for a file. Files handled before that
+// one remain defragmented.
+func (fs *FileSystem) Defragment(p string) error {
+	if path.Dir(p) != path.Base(p) {
+		parentDir, entry, err := fs.findEntry(p)
+		if err != nil {
+			return err
+		}
+		if !entry.isSubdirectory {
+			moved, err := fs.defragmentEntry(entry)
+			if err != nil {
+				return fmt.Errorf("unable to defragment %s: %w", p, err)
+			}
+			if !moved {
+				return nil
+			}
+			if err := fs.writeDirectoryEntries(parentDir); err != nil {
+				return fmt.Errorf("error writing directory entries to disk: %w", err)
+			}
+			return nil
+		}
+	}
+	dir, entries, err := fs.readDirWithMkdir(p, false)
+	if err != nil {
+		return fmt.Errorf("could not read directory entries for %s: %w", p, err)
+	}
+	return fs.defragmentDir(dir, p, entries)
+}
+
+// defragmentDir defragments all of the files in a directory, and recursively in its subdirectories
+func (fs *FileSystem) defragmentDir(dir *Directory, p string, entries []*directoryEntry) error {
+	var changed bool
+	for _, e := range entries {
+		if e.isVolumeLabel || e.filenameShort == "." || e.filenameShort == ".." || e.clusterLocation < 2 {
+			continue
+		}
+		name := e.filenameLong
+		if name == "" {
+			name = e.displayShortName()
+		}
+		entryPath := path.Join(p, name)
+		if e.isSubdirectory {
+			sub := &Directory{
+				directoryEntry: *e,
+			}
+			subEntries, err := fs.readDirectory(sub)
+			if err != nil {
+				return fmt.Errorf("could not read directory entries for %s: %w", entryPath, err)
+			}
+			if err := fs.defragmentDir(sub, entryPath, subEntries); err != nil {
+				return err
+			}
+			continue
+		}
+		moved, err := fs.defragmentEntry(e)
+		if err != nil {
+			return fmt.Errorf("unable to defragment %s: %w", entryPath, err)
+		}
+		changed = changed || moved
+	}
+	if !changed {
+		return nil
+	}
+	if err := fs.writeDirectoryEntries(dir); err != nil {
+		return fmt.Errorf("error writing directory entries for %s to disk: %w", p, err)
+	}
+	return nil
+}
+
+// defragmentEntry makes the cluster chain of a file contiguous, and reports whether it had to move it.
+// The directory holding the entry must be written to disk afterwards if it did.
+func (fs *FileSystem) defragmentEntry(e *directoryEntry) (bool, error) {
+	clusters, err := fs.getClusterList(e.clusterLocation)
+	if err != nil {
+		return false, fmt.Errorf("unable to get list of clusters: %w", err)
+	}
+	if isContiguous(clusters) {
+		return false, nil
+	}
+	if _, err := fs.makeContiguous(e, clusters, len(clusters)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// makeContiguous ensures that the entry has a contiguous chain of count clusters, given its current chain,
+// which may be empty but must not be longer than count. The chain is extended in place if that is possible, or else moved
+// along with its contents to the first run of free clusters that is large enough.
+// Nothing is changed if there is no such run.
+//
+// The new chain is returned, and the start cluster of the entry is updated; the directory holding the entry
+// must be written to disk afterwards.
+func (fs *FileSystem) makeContiguous(e *directoryEntry, clusters []uint32, count int) ([]uint32, error) {
+	t := &fs.table
+
+	// can it be extended right where it is?
+	if len(clusters) > 0 && isContiguous(clusters) && clusters[0]+uint32(count) <= t.maxCluster {
+		first := clusters[0]
+		inPlace := true
+		for c := first + uint32(len(clusters)); c < first+uint32(count); c++ {
+			if t.clusters[c] != t.unusedMarker {
+				inPlace = false
+				break
+			}
+		}
+		if inPlace {
+			fs.chainRun(first, count, count-len(clusters))
+			for i := len(clusters); i < count; i++ {
+				clusters = append(clusters, first+uint32(i))
+			}
+			return clusters, fs.syncFat()
+		}
+	}
+
+	start, ok := t.freeRun(uint32(count))
+	if !ok {
+		return nil, fmt.Errorf("no run of %d contiguous free clusters", count)
+	}
+
+	// copy the contents over
+	writableFile, err := fs.backend.Writable()
+	if err != nil {
+		return nil, err
+	}
+	b := make([]byte, fs.bytesPerCluster)
+	for i, cluster := range clusters {
+		if _, err := fs.backend.ReadAt(b, fs.start+int64(fs.dataStart)+int64(cluster-2)*int64(fs.bytesPerCluster)); err != nil {
+			return nil, fmt.Errorf("unable to read cluster %d: %w", cluster, err)
+		}
+		to := start + uint32(i)
+		if _, err := writableFile.WriteAt(b, fs.start+int64(fs.dataStart)+int64(to-2)*int64(fs.bytesPerCluster)); err != nil {
+			return nil, fmt.Errorf("unable to write cluster %d: %w", to, err)
+		}
+	}
+
+	fs.chainRun(start, count, count)
+	for _, cluster := range clusters {
+		t.set(cluster, t.unusedMarker)
+	}
+	fs.adjustFreeCount(len(clusters))
+	e.clusterLocation = start
+
+	newClusters := make([]uint32, count)
+	for i := range newClusters {
+		newClusters[i] = start + uint32(i)
+	}
+	return newClusters, fs.syncFat()
+}
+
+// chainRun links count clusters starting at first into a single chain, of which the last added ones are newly allocated
+func (fs *FileSystem) chainRun(first uint32, count, added int) {
+	t := &fs.table
+	last := first + uint32(count) - 1
+	for c := first; c < last; c++ {
+		t.set(c, c+1)
+	}
+	t.set(last, t.eocMarker)
+	if added > 0 {
+		fs.adjustFreeCount(-added)
+		fs.fsis.lastAllocatedCluster = last
+		fs.fsisDirty = true
+	}
+}
+
+// isContiguous reports whether a chain of clusters is a single run
+func isContiguous(clusters []uint32) bool {
+	for i := 1; i < len(clusters); i++ {
+		if clusters[i] != clusters[i-1]+1 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,254 @@
+package fat32_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+)
+
+func TestFat32Contiguous(t *testing.T) {
+	f, err := tmpFat32(false, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keepTmpFiles == "" {
+		defer os.Remove(f.Name())
+	} else {
+		fmt.Println(f.Name())
+	}
+	fileInfo, err := f.Stat()
+	if err != nil {
+		t.Fatalf("error getting file info for tmpfile %s: %v", f.Name(), err)
+	}
+	fs, err := fat32.Create(file.New(f, false), fileInfo.Size(), 0, 512, "go-diskfs")
+	if err != nil {
+		t.Fatalf("error creating fat32 filesystem: %v", err)
+	}
+
+	contents := make(map[string][]byte)
+	appendFile := func(p string, data []byte) {
+		t.Helper()
+		rw, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR|os.O_APPEND)
+		if err != nil {
+			t.Fatalf("error opening %s: %v", p, err)
+		}
+		if _, err := rw.Write(data); err != nil {
+			t.Fatalf("error writing %s: %v", p, err)
+		}
+		contents[p] = append(contents[p], data...)
+	}
+	ranges := func(p string) []fat32.DiskRange {
+		t.Helper()
+		rw, err := fs.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("error opening %s: %v", p, err)
+		}
+		r, err := rw.(*fat32.File).GetDiskRanges()
+		if err != nil {
+			t.Fatalf("error getting disk ranges of %s: %v", p, err)
+		}
+		return r
+	}
+
+	// fragment a few files by appending to them in turns
+	if err := fs.Mkdir("/sub"); err != nil {
+		t.Fatalf("error making directory: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		appendFile("/frag1", bytes.Repeat([]byte{byte(1 + i)}, 1000))
+		appendFile("/sub/frag2", bytes.Repeat([]byte{byte(10 + i)}, 700))
+		appendFile("/other", bytes.Repeat([]byte{byte(20 + i)}, 600))
+	}
+	for _, p := range []string{"/frag1", "/sub/frag2"} {
+		if r := ranges(p); len(r) < 2 {
+			t.Fatalf("%s is not fragmented to begin with: %v", p, r)
+		}
+	}
+
+	t.Run("defragment file", func(t *testing.T) {
+		if err := fs.Defragment("/frag1"); err != nil {
+			t.Fatalf("error defragmenting: %v", err)
+		}
+		if r := ranges("/frag1"); len(r) != 1 {
+			t.Errorf("file still has %d ranges", len(r))
+		}
+	})
+	t.Run("defragment directory", func(t *testing.T) {
+		if err := fs.Defragment("/"); err != nil {
+			t.Fatalf("error defragmenting: %v", err)
+		}
+		for _, p := range []string{"/frag1", "/sub/frag2", "/other"} {
+			if r := ranges(p); len(r) != 1 {
+				t.Errorf("%s still has %d ranges", p, len(r))
+			}
+		}
+	})
+	t.Run("preallocate contiguous", func(t *testing.T) {
+		appendFile("/pre", []byte("hello"))
+		rw, err := fs.OpenFile("/pre", os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error opening file: %v", err)
+		}
+		if err := rw.(*fat32.File).Preallocate(100000, true); err != nil {
+			t.Fatalf("error preallocating: %v", err)
+		}
+		contents["/pre"] = append(contents["/pre"], make([]byte, 100000-5)...)
+		r := ranges("/pre")
+		if len(r) != 1 || r[0].Length < 100000 {
+			t.Errorf("preallocated ranges %v, expected a single one of at least 100000 bytes", r)
+		}
+		// writing into the preallocated space, from the start, keeps it
+		if _, err := rw.Write([]byte("world")); err != nil {
+			t.Fatalf("error writing: %v", err)
+		}
+		copy(contents["/pre"], "world")
+		if r2 := ranges("/pre"); len(r2) != 1 || r2[0] != r[0] {
+			t.Errorf("ranges changed by writing, %v then %v", r, r2)
+		}
+	})
+	t.Run("preallocate too large", func(t *testing.T) {
+		rw, err := fs.OpenFile("/other", os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error opening file: %v", err)
+		}
+		if err := rw.(*fat32.File).Preallocate(fileInfo.Size(), true); err == nil {
+			t.Fatalf("preallocating more than the filesystem holds did not return an error")
+		}
+	})
+	t.Run("preallocate read-only", func(t *testing.T) {
+		rw, err := fs.OpenFile("/other", os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("error opening file: %v", err)
+		}
+		if err := rw.(*fat32.File).Preallocate(10000, false); err == nil {
+			t.Fatalf("preallocating a read-only file did not return an error")
+		}
+	})
+
+	// everything must be consistent on disk
+	if err := fs.Close(); err != nil {
+		t.Fatalf("error closing filesystem: %v", err)
+	}
+	fs, err = fat32.Read(file.New(f, false), fileInfo.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading fat32 filesystem: %v", err)
+	}
+	report, err := fs.Check(false)
+	if err != nil {
+		t.Fatalf("error checking filesystem: %v", err)
+	}
+	if !report.Clean() {
+		t.Errorf("unexpected problems: %v", report.Problems)
+	}
+	for p, data := range contents {
+		rw, err := fs.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("error opening %s: %v", p, err)
+		}
+		b, err := io.ReadAll(rw)
+		if err != nil {
+			t.Fatalf("error reading %s: %v", p, err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Errorf("mismatched contents of %s", p)
+		}
+	}
+}
+
+func TestFat32EmptyFileWithoutCluster(t *testing.T) {
+	f, err := tmpFat32(false, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keepTmpFiles == "" {
+		defer os.Remove(f.Name())
+	} else {
+		fmt.Println(f.Name())
+	}
+	fileInfo, err := f.Stat()
+	if err != nil {
+		t.Fatalf("error getting file info for tmpfile %s: %v", f.Name(), err)
+	}
+	fs, err := fat32.Create(file.New(f, false), fileInfo.Size(), 0, 512, "go-diskfs")
+	if err != nil {
+		t.Fatalf("error creating fat32 filesystem: %v", err)
+	}
+	names := []string{"WRITE", "PREALLOC", "CONTIG", "READ"}
+	for _, name := range names {
+		if _, err := fs.OpenFile("/"+name, os.O_CREATE|os.O_RDWR); err != nil {
+			t.Fatalf("error creating %s: %v", name, err)
+		}
+	}
+
+	// empty files as other tools write them, with start cluster 0 and no chain
+	layout := testReadFatLayout(t, f)
+	root := make([]byte, layout.bytesPerCluster)
+	if _, err := f.ReadAt(root, layout.dataStart); err != nil {
+		t.Fatalf("error reading root directory: %v", err)
+	}
+	for _, name := range names {
+		i := bytes.Index(root, []byte(fmt.Sprintf("%-11s", name)))
+		if i < 0 || i%32 != 0 {
+			t.Fatalf("directory entry of %s not found", name)
+		}
+		e := root[i : i+32]
+		cluster := uint32(binary.LittleEndian.Uint16(e[20:22]))<<16 | uint32(binary.LittleEndian.Uint16(e[26:28]))
+		if cluster >= 2 {
+			layout.setFatEntry(t, f, cluster, 0, 0, 1)
+		}
+		for _, j := range []int{20, 21, 26, 27} {
+			e[j] = 0
+		}
+	}
+	if _, err := f.WriteAt(root, layout.dataStart); err != nil {
+		t.Fatalf("error writing root directory: %v", err)
+	}
+	fs, err = fat32.Read(file.New(f, false), fileInfo.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading fat32 filesystem: %v", err)
+	}
+
+	contents := map[string][]byte{
+		"/WRITE":    []byte("hello"),
+		"/PREALLOC": make([]byte, 3000),
+		"/CONTIG":   make([]byte, 3000),
+		"/READ":     {},
+	}
+	open := func(p string) *fat32.File {
+		t.Helper()
+		rw, err := fs.OpenFile(p, os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error opening %s: %v", p, err)
+		}
+		return rw.(*fat32.File)
+	}
+	if _, err := open("/WRITE").Write(contents["/WRITE"]); err != nil {
+		t.Errorf("error writing: %v", err)
+	}
+	if err := open("/PREALLOC").Preallocate(3000, false); err != nil {
+		t.Errorf("error preallocating: %v", err)
+	}
+	if err := open("/CONTIG").Preallocate(3000, true); err != nil {
+		t.Errorf("error preallocating contiguous: %v", err)
+	}
+
+	fs, err = fat32.Read(file.New(f, false), fileInfo.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading fat32 filesystem: %v", err)
+	}
+	for p, data := range contents {
+		b, err := io.ReadAll(open(p))
+		if err != nil {
+			t.Fatalf("error reading %s: %v", p, err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Errorf("mismatched contents of %s, %d bytes instead of %d", p, len(b), len(data))
+		}
+	}
+}
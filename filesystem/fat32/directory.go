@@ -2,9 +2,13 @@ package fat32
 
 import (
 	"fmt"
+	"strings"
 	"time"
 )
 
+// maxNumericTail is the highest numeric tail, as in ~999999, that can be generated for a short name
+const maxNumericTail = 999999
+
 // Directory represents a single directory in a FAT32 filesystem
 type Directory struct {
 	directoryEntry
@@ -40,44 +44,119 @@ func (d *Directory) entriesToBytes(bytesPerCluster int) ([]byte, error) {
 
 // createEntry creates an entry in the given directory, and returns the handle to it
 func (d *Directory) createEntry(name string, cluster uint32, dir bool) (*directoryEntry, error) {
-	// is it a long filename or a short filename?
-	var isLFN bool
-	// TODO: convertLfnSfn does not calculate if the short name conflicts and thus should increment the last character
-	//       that should happen here, once we can look in the directory entry
-	shortName, extension, isLFN, _ := convertLfnSfn(name)
-	lfn := ""
-	if isLFN {
-		lfn = name
-	}
-
 	// allocate a slot for the new filename in the existing directory
 	entry := directoryEntry{
-		filenameLong:      lfn,
-		longFilenameSlots: -1, // indicate that we do not know how many slots, which will force a recalculation
-		filenameShort:     shortName,
-		fileExtension:     extension,
-		fileSize:          uint32(0),
-		clusterLocation:   cluster,
-		filesystem:        d.filesystem,
-		createTime:        time.Now(),
-		modifyTime:        time.Now(),
-		accessTime:        time.Now(),
-		isSubdirectory:    dir,
-		isNew:             true,
+		fileSize:        uint32(0),
+		clusterLocation: cluster,
+		filesystem:      d.filesystem,
+		createTime:      time.Now(),
+		modifyTime:      time.Now(),
+		accessTime:      time.Now(),
+		isSubdirectory:  dir,
+		isNew:           true,
+	}
+	if err := d.setEntryName(&entry, name); err != nil {
+		return nil, err
 	}
 
-	entry.longFilenameSlots = calculateSlots(entry.filenameLong)
 	d.entries = append(d.entries, &entry)
 	return &entry, nil
 }
 
+// setEntryName sets the long and short filenames of an entry in the given directory.
+// A long filename is used only if the name cannot be represented as an 8.3 name, possibly with the
+// lower-case flags. If the short name had to be derived lossily from the long one, it gets a
+// numeric tail that does not conflict with any other entry in the directory.
+func (d *Directory) setEntryName(entry *directoryEntry, name string) error {
+	shortName, extension, isLFN, isTruncated := convertLfnSfn(name)
+	var lowercaseShortname, lowercaseExtension bool
+
+	if isLFN {
+		// a name that is a valid 8.3 name except for its case keeps its own short name, unless that is taken
+		_, _, isLossy, _ := convertLfnSfn(strings.ToUpper(name))
+		switch {
+		case !isLossy && !d.shortNameExists(shortName, extension, entry):
+			// if the case is uniform in each part, the lower-case flags are all we need
+			if _, _, lcName, lcExt, ok := caseOnlyShortName(name); ok {
+				lowercaseShortname, lowercaseExtension = lcName, lcExt
+				isLFN = false
+			}
+		default:
+			// the short name needs a numeric tail, which replaces the one convertLfnSfn added
+			if isTruncated {
+				shortName = shortName[:6]
+			}
+			tailed, err := d.numericTailShortName(name, shortName, extension, entry)
+			if err != nil {
+				return err
+			}
+			shortName = tailed
+		}
+	}
+
+	entry.filenameLong = ""
+	if isLFN {
+		entry.filenameLong = name
+	}
+	entry.filenameShort = shortName
+	entry.fileExtension = extension
+	entry.lowercaseShortname = lowercaseShortname
+	entry.lowercaseExtension = lowercaseExtension
+	entry.longFilenameSlots = calculateSlots(entry.filenameLong)
+	return nil
+}
+
+// numericTailShortName finds a short name of the form BASIS~N that is not yet used in the directory,
+// the way Windows does: ~1 through ~4 are tried on the basis name, after which the basis is replaced
+// by its first two characters and a hash of the long name, and the tail is counted up from ~1 again.
+// The entry that is being named, if any, is ignored when checking for conflicts.
+func (d *Directory) numericTailShortName(name, basis, extension string, ignore *directoryEntry) (string, error) {
+	hashed := basis
+	if len(hashed) > 2 {
+		hashed = hashed[:2]
+	}
+	hashed += fmt.Sprintf("%04X", shortNameHash(name))
+
+	for i := 1; i <= 4; i++ {
+		if candidate := withNumericTail(basis, i); !d.shortNameExists(candidate, extension, ignore) {
+			return candidate, nil
+		}
+	}
+	for i := 1; i <= maxNumericTail; i++ {
+		if candidate := withNumericTail(hashed, i); !d.shortNameExists(candidate, extension, ignore) {
+			return candidate, nil
+		}
+	}
+	return "", fmt.Errorf("no unused short name left for %s", name)
+}
+
+// withNumericTail appends the tail ~n to a short name, truncating it as needed to stay within 8 characters
+func withNumericTail(shortName string, n int) string {
+	tail := fmt.Sprintf("~%d", n)
+	if len(shortName)+len(tail) > 8 {
+		shortName = shortName[:8-len(tail)]
+	}
+	return shortName + tail
+}
+
+// shortNameExists checks if any entry in the directory, other than ignore, already uses a given 8.3 name
+func (d *Directory) shortNameExists(shortName, extension string, ignore *directoryEntry) bool {
+	for _, e := range d.entries {
+		if e == ignore || e.isVolumeLabel {
+			continue
+		}
+		if strings.EqualFold(e.filenameShort, shortName) && strings.EqualFold(e.fileExtension, extension) {
+			return true
+		}
+	}
+	return false
+}
+
 // removeEntry removes an entry in the given directory
 func (d *Directory) removeEntry(name string) error {
-	// TODO implement check for long/short filename after increment of sfn is correctly implemented
-
 	removeEntryIndex := -1
 	for i, entry := range d.entries {
-		if entry.filenameLong == name { // || entry.filenameShort == shortName  do not compare SFN, since it is not incremented correctly
+		if !entry.isVolumeLabel && entry.matchesName(name) {
 			removeEntryIndex = i
 		}
 	}
@@ -94,33 +173,27 @@ func (d *Directory) removeEntry(name string) error {
 
 // renameEntry renames an entry in the given directory, and returns the handle to it
 func (d *Directory) renameEntry(oldFileName, newFileName string) error {
-	// TODO implement check for long/short filename after increment of sfn is correctly implemented
-
+	var renamed *directoryEntry
 	newEntries := make([]*directoryEntry, 0, len(d.entries))
-	var isReplaced = false
 	for _, entry := range d.entries {
-		if entry.filenameLong == newFileName {
+		switch {
+		case entry.isVolumeLabel:
+		case entry.matchesName(oldFileName):
+			renamed = entry
+		case entry.matchesName(newFileName):
 			continue // skip adding already existing file, will be overwritten
 		}
-		if entry.filenameLong == oldFileName { //  || entry.filenameShort == shortName  do not compare SFN, since it is not incremented correctly
-			var lfn string
-			shortName, extension, isLFN, _ := convertLfnSfn(newFileName)
-			if isLFN {
-				lfn = newFileName
-			}
-			entry.filenameLong = lfn
-			entry.filenameShort = shortName
-			entry.fileExtension = extension
-			entry.modifyTime = time.Now()
-			isReplaced = true
-		}
 		newEntries = append(newEntries, entry)
 	}
-	if !isReplaced {
+	if renamed == nil {
 		return fmt.Errorf("cannot find file entry for %s", oldFileName)
 	}
 
 	d.entries = newEntries
+	if err := d.setEntryName(renamed, newFileName); err != nil {
+		return err
+	}
+	renamed.modifyTime = time.Now()
 
 	return nil
 }
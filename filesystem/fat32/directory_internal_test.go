@@ -87,17 +87,38 @@ func TestDirectoryCreateEntry(t *testing.T) {
 		{"long", 55, false, &directoryEntry{
 			filenameShort:   "LONG",
 			fileExtension:   "",
-			filenameLong:    "long",
+			filenameLong:    "",
 			isSubdirectory:  false,
 			clusterLocation: 55,
 		}},
 		{"long.txt", 99, true, &directoryEntry{
 			filenameShort:   "LONG",
 			fileExtension:   "TXT",
-			filenameLong:    "long.txt",
+			filenameLong:    "",
 			isSubdirectory:  true,
 			clusterLocation: 99,
 		}},
+		{"Long.md", 100, false, &directoryEntry{
+			filenameShort:   "LONG",
+			fileExtension:   "MD",
+			filenameLong:    "Long.md",
+			isSubdirectory:  false,
+			clusterLocation: 100,
+		}},
+		{"Program Files A", 101, true, &directoryEntry{
+			filenameShort:   "PROGRA~1",
+			fileExtension:   "",
+			filenameLong:    "Program Files A",
+			isSubdirectory:  true,
+			clusterLocation: 101,
+		}},
+		{"Program Files B", 102, true, &directoryEntry{
+			filenameShort:   "PROGRA~2",
+			fileExtension:   "",
+			filenameLong:    "Program Files B",
+			isSubdirectory:  true,
+			clusterLocation: 102,
+		}},
 	}
 
 	d := &Directory{}
@@ -130,3 +151,72 @@ func TestDirectoryCreateEntry(t *testing.T) {
 		}
 	}
 }
+
+func TestDirectoryNumericTails(t *testing.T) {
+	d := &Directory{}
+	names := make(map[string]bool)
+	for i := 0; i < 20; i++ {
+		name := fmt.Sprintf("Some long file name %d.text", i)
+		de, err := d.createEntry(name, uint32(i+10), false)
+		if err != nil {
+			t.Fatalf("createEntry(%s) returned error: %v", name, err)
+		}
+		if de.filenameLong != name {
+			t.Errorf("createEntry(%s) mismatched long filename %s", name, de.filenameLong)
+		}
+		if de.fileExtension != "TEX" {
+			t.Errorf("createEntry(%s) mismatched extension %s", name, de.fileExtension)
+		}
+		sfn := de.filenameShort + "." + de.fileExtension
+		if names[sfn] {
+			t.Errorf("createEntry(%s) duplicate short name %s", name, sfn)
+		}
+		names[sfn] = true
+		switch {
+		case i < 4:
+			expected := fmt.Sprintf("SOMELO~%d", i+1)
+			if de.filenameShort != expected {
+				t.Errorf("createEntry(%s) short name %s, expected %s", name, de.filenameShort, expected)
+			}
+		default:
+			// after ~4, short names are 2 characters of the basis plus a 4 digit hash
+			expected := fmt.Sprintf("SO%04X~1", shortNameHash(name))
+			if de.filenameShort != expected {
+				t.Errorf("createEntry(%s) short name %s, expected %s", name, de.filenameShort, expected)
+			}
+		}
+		// the long filename must be tied to the generated short name
+		if _, err := de.toBytes(); err != nil {
+			t.Errorf("toBytes() for %s returned error: %v", name, err)
+		}
+	}
+
+	// renaming should not conflict with itself or others
+	if err := d.renameEntry("Some long file name 0.text", "Some long file name X.text"); err != nil {
+		t.Fatalf("renameEntry returned error: %v", err)
+	}
+	if !d.entries[0].matchesName("SOMELO~1.TEX") {
+		t.Errorf("renamed entry changed short name to %s", d.entries[0].filenameShort)
+	}
+	if err := d.removeEntry("somelo~2.tex"); err != nil {
+		t.Errorf("removeEntry by short name returned error: %v", err)
+	}
+}
+
+func TestWithNumericTail(t *testing.T) {
+	tests := []struct {
+		name     string
+		n        int
+		expected string
+	}{
+		{"AB", 1, "AB~1"},
+		{"PROGRA", 1, "PROGRA~1"},
+		{"PROGRA", 10, "PROGR~10"},
+		{"PROGRA", 999999, "P~999999"},
+	}
+	for _, tt := range tests {
+		if output := withNumericTail(tt.name, tt.n); output != tt.expected {
+			t.Errorf("withNumericTail(%s, %d) = %s, expected %s", tt.name, tt.n, output, tt.expected)
+		}
+	}
+}
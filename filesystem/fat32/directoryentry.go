@@ -6,6 +6,7 @@ import (
 	"regexp"
 	"strings"
 	"time"
+	"unicode/utf16"
 
 	"github.com/elliotwutingfeng/asciiset"
 )
@@ -19,6 +20,29 @@ const (
 	charsPerSlot          int          = 13
 )
 
+// FileAttributes are the DOS attribute flags of a file or directory in a FAT filesystem
+type FileAttributes uint8
+
+const (
+	// AttrReadOnly marks a file that should not be written to
+	AttrReadOnly FileAttributes = 0x01
+	// AttrHidden marks a file that is not shown in normal directory listings
+	AttrHidden FileAttributes = 0x02
+	// AttrSystem marks a file that belongs to the operating system
+	AttrSystem FileAttributes = 0x04
+	// AttrVolumeLabel marks the special entry holding the volume label; it cannot be set or cleared
+	AttrVolumeLabel FileAttributes = 0x08
+	// AttrDirectory marks a subdirectory; it cannot be set or cleared
+	AttrDirectory FileAttributes = 0x10
+	// AttrArchive marks a file that has changed since it was last backed up
+	AttrArchive FileAttributes = 0x20
+	// attrDevice marks a device, and never should be found on disk
+	attrDevice FileAttributes = 0x40
+
+	// settableAttributes are the attributes that can be changed on an existing entry
+	settableAttributes = AttrReadOnly | AttrHidden | AttrSystem | AttrArchive
+)
+
 // valid shortname characters - [A-F][0-9][$%'-_@~`!(){}^#&]
 var validShortNameCharacters, _ = asciiset.MakeASCIISet("!#$%&'()-0123456789@ABCDEFGHIJKLMNOPQRSTUVWXYZ^_`{}~")
 
@@ -64,6 +88,7 @@ func (de *directoryEntry) toBytes() ([]byte, error) {
 	createDate, createTime := timeToDateTime(de.createTime)
 	modifyDate, modifyTime := timeToDateTime(de.modifyTime)
 	accessDate, _ := timeToDateTime(de.accessTime)
+	dosBytes[13] = timeToFineResolution(de.createTime)
 	binary.LittleEndian.PutUint16(dosBytes[14:16], createTime)
 	binary.LittleEndian.PutUint16(dosBytes[16:18], createDate)
 	binary.LittleEndian.PutUint16(dosBytes[18:20], accessDate)
@@ -90,15 +115,7 @@ func (de *directoryEntry) toBytes() ([]byte, error) {
 	dosBytes[21] = clusterLocation[3]
 
 	// set the flags
-	if de.isVolumeLabel {
-		dosBytes[11] |= 0x08
-	}
-	if de.isSubdirectory {
-		dosBytes[11] |= 0x10
-	}
-	if de.isArchiveDirty {
-		dosBytes[11] |= 0x20
-	}
+	dosBytes[11] = byte(de.attributes())
 
 	if de.lowercaseExtension {
 		dosBytes[12] |= 0x10
@@ -147,42 +164,57 @@ byteLoop:
 			continue
 		}
 		// not LFN, so parse regularly
-		createTime := binary.LittleEndian.Uint16(b[i+14 : i+16])
-		createDate := binary.LittleEndian.Uint16(b[i+16 : i+18])
-		accessDate := binary.LittleEndian.Uint16(b[i+18 : i+20])
-		modifyTime := binary.LittleEndian.Uint16(b[i+22 : i+24])
-		modifyDate := binary.LittleEndian.Uint16(b[i+24 : i+26])
-		re := regexp.MustCompile(" +$")
-		sfn := re.ReplaceAllString(string(b[i:i+8]), "")
-		extension := re.ReplaceAllString(string(b[i+8:i+11]), "")
-		isSubdirectory := b[i+11]&0x10 == 0x10
-		isArchiveDirty := b[i+11]&0x20 == 0x20
-		isVolumeLabel := b[i+11]&0x08 == 0x08
-		lowercaseShortname := b[i+12]&0x08 == 0x08
-		lowercaseExtension := b[i+12]&0x10 == 0x10
-
-		entry := directoryEntry{
-			filenameLong:       lfn,
-			longFilenameSlots:  calculateSlots(lfn),
-			filenameShort:      sfn,
-			fileExtension:      extension,
-			fileSize:           binary.LittleEndian.Uint32(b[i+28 : i+32]),
-			clusterLocation:    binary.LittleEndian.Uint32(append(b[i+26:i+28], b[i+20:i+22]...)),
-			createTime:         dateTimeToTime(createDate, createTime),
-			modifyTime:         dateTimeToTime(modifyDate, modifyTime),
-			accessTime:         dateTimeToTime(accessDate, 0),
-			isSubdirectory:     isSubdirectory,
-			isArchiveDirty:     isArchiveDirty,
-			isVolumeLabel:      isVolumeLabel,
-			lowercaseShortname: lowercaseShortname,
-			lowercaseExtension: lowercaseExtension,
-		}
+		entry := directoryEntryFromBytes(b[i:i+32], lfn)
 		lfn = ""
-		dirEntries = append(dirEntries, &entry)
+		dirEntries = append(dirEntries, entry)
 	}
 	return dirEntries, nil
 }
 
+// directoryEntryFromBytes parses a single 32-byte directory entry that is not a long filename entry,
+// given the long filename that was assembled from the entries before it, if any
+func directoryEntryFromBytes(b []byte, lfn string) *directoryEntry {
+	createTimeFine := b[13]
+	createTime := binary.LittleEndian.Uint16(b[14:16])
+	createDate := binary.LittleEndian.Uint16(b[16:18])
+	accessDate := binary.LittleEndian.Uint16(b[18:20])
+	modifyTime := binary.LittleEndian.Uint16(b[22:24])
+	modifyDate := binary.LittleEndian.Uint16(b[24:26])
+	re := regexp.MustCompile(" +$")
+	sfn := re.ReplaceAllString(string(b[0:8]), "")
+	extension := re.ReplaceAllString(string(b[8:11]), "")
+	isReadOnly := b[11]&0x01 == 0x01
+	isHidden := b[11]&0x02 == 0x02
+	isSystem := b[11]&0x04 == 0x04
+	isSubdirectory := b[11]&0x10 == 0x10
+	isArchiveDirty := b[11]&0x20 == 0x20
+	isVolumeLabel := b[11]&0x08 == 0x08
+	isDevice := b[11]&0x40 == 0x40
+	lowercaseShortname := b[12]&0x08 == 0x08
+	lowercaseExtension := b[12]&0x10 == 0x10
+
+	return &directoryEntry{
+		filenameLong:       lfn,
+		longFilenameSlots:  calculateSlots(lfn),
+		filenameShort:      sfn,
+		fileExtension:      extension,
+		fileSize:           binary.LittleEndian.Uint32(b[28:32]),
+		clusterLocation:    uint32(binary.LittleEndian.Uint16(b[20:22]))<<16 | uint32(binary.LittleEndian.Uint16(b[26:28])),
+		createTime:         dateTimeToTime(createDate, createTime).Add(fineResolutionToDuration(createTimeFine)),
+		modifyTime:         dateTimeToTime(modifyDate, modifyTime),
+		accessTime:         dateTimeToTime(accessDate, 0),
+		isReadOnly:         isReadOnly,
+		isHidden:           isHidden,
+		isSystem:           isSystem,
+		isSubdirectory:     isSubdirectory,
+		isArchiveDirty:     isArchiveDirty,
+		isVolumeLabel:      isVolumeLabel,
+		isDevice:           isDevice,
+		lowercaseShortname: lowercaseShortname,
+		lowercaseExtension: lowercaseExtension,
+	}
+}
+
 func dateTimeToTime(d, t uint16) time.Time {
 	year := int(d>>9) + 1980
 	month := time.Month((d >> 5) & 0x0f)
@@ -204,6 +236,56 @@ func timeToDateTime(t time.Time) (datePart, timePart uint16) {
 	return uint16(retDate), uint16(retTime)
 }
 
+// timeToFineResolution returns the count of 10ms units that a time is past the 2-second resolution of
+// timeToDateTime, as stored in the create time fine resolution byte of a directory entry
+func timeToFineResolution(t time.Time) byte {
+	return byte((t.Second()%2)*100 + t.Nanosecond()/int(10*time.Millisecond))
+}
+
+// fineResolutionToDuration converts a create time fine resolution byte to a duration.
+// Values above the valid maximum of 199 are ignored.
+func fineResolutionToDuration(fine byte) time.Duration {
+	if fine > 199 {
+		return 0
+	}
+	return time.Duration(fine) * 10 * time.Millisecond
+}
+
+// attributes returns the attribute flags of the entry
+func (de *directoryEntry) attributes() FileAttributes {
+	var attrs FileAttributes
+	if de.isReadOnly {
+		attrs |= AttrReadOnly
+	}
+	if de.isHidden {
+		attrs |= AttrHidden
+	}
+	if de.isSystem {
+		attrs |= AttrSystem
+	}
+	if de.isVolumeLabel {
+		attrs |= AttrVolumeLabel
+	}
+	if de.isSubdirectory {
+		attrs |= AttrDirectory
+	}
+	if de.isArchiveDirty {
+		attrs |= AttrArchive
+	}
+	if de.isDevice {
+		attrs |= attrDevice
+	}
+	return attrs
+}
+
+// setAttributes sets the changeable attribute flags of the entry, leaving the directory and volume label flags as is
+func (de *directoryEntry) setAttributes(attrs FileAttributes) {
+	de.isReadOnly = attrs&AttrReadOnly == AttrReadOnly
+	de.isHidden = attrs&AttrHidden == AttrHidden
+	de.isSystem = attrs&AttrSystem == AttrSystem
+	de.isArchiveDirty = attrs&AttrArchive == AttrArchive
+}
+
 func longFilenameBytes(s, shortName, extension string) ([]byte, error) {
 	// we need the checksum of the short name
 	checksum, err := lfnChecksum(shortName, extension)
@@ -331,12 +413,16 @@ func lfnChecksum(name, extension string) (byte, error) {
 	copy(b, nameBytes)
 	b = append(b, extensionBytes...)
 
-	// calculate the checksum
+	return shortNameChecksum(b), nil
+}
+
+// shortNameChecksum calculates the checksum of the 11 bytes of a padded 8.3 name, as stored in its long filename entries
+func shortNameChecksum(b []byte) byte {
 	var sum byte = 0x00
 	for i := 11; i > 0; i-- {
 		sum = ((sum & 0x01) << 7) + (sum >> 1) + b[11-i]
 	}
-	return sum, nil
+	return sum
 }
 
 // convert a string to ascii bytes, but only accept valid 8.3 bytes
@@ -391,6 +477,11 @@ func calculateSlots(s string) int {
 //	isLFN : was there an LFN that had to be converted
 //	isTruncated : was the shortname longer than 8 chars and had to be converted?
 func convertLfnSfn(name string) (shortName, extension string, isLFN, isTruncated bool) {
+	// leading periods are not allowed in a short name, so they are dropped
+	if trimmed := strings.TrimLeft(name, "."); trimmed != name {
+		name = trimmed
+		isLFN = true
+	}
 	// get last period in name
 	lastDot := strings.LastIndex(name, ".")
 	// now convert it
@@ -429,6 +520,82 @@ func convertLfnSfn(name string) (shortName, extension string, isLFN, isTruncated
 	return shortName, extension, isLFN, isTruncated
 }
 
+// caseOnlyShortName checks if a name is a valid 8.3 name except for its case, and each of the base name
+// and extension is entirely lower-case or entirely upper-case. Such a name can be stored as a short name
+// with the lower-case flags set, without the need for a long filename.
+// returns shortName, extension, lowercaseShortname, lowercaseExtension, ok
+func caseOnlyShortName(name string) (shortName, extension string, lowercaseShortname, lowercaseExtension, ok bool) {
+	shortName, extension, isLFN, _ := convertLfnSfn(strings.ToUpper(name))
+	if isLFN {
+		return "", "", false, false, false
+	}
+	rawShortName, rawExtension := name, ""
+	if lastDot := strings.LastIndex(name, "."); lastDot > -1 {
+		rawShortName, rawExtension = name[:lastDot], name[lastDot+1:]
+	}
+	switch rawShortName {
+	case shortName:
+	case strings.ToLower(shortName):
+		lowercaseShortname = true
+	default:
+		return "", "", false, false, false
+	}
+	switch rawExtension {
+	case extension:
+	case strings.ToLower(extension):
+		lowercaseExtension = true
+	default:
+		return "", "", false, false, false
+	}
+	return shortName, extension, lowercaseShortname, lowercaseExtension, true
+}
+
+// shortNameHash calculates the 16-bit hash of a long filename used to build short names once the
+// simple numeric tails ~1 through ~4 are taken, modelled on the one used by Windows NT.
+func shortNameHash(name string) uint16 {
+	var checksum uint16
+	for _, r := range utf16.Encode([]rune(name)) {
+		checksum = checksum*0x25 + r
+	}
+	temp := int32(uint32(checksum) * 314159269)
+	if temp < 0 {
+		temp = -temp
+	}
+	temp -= int32((uint64(temp)*1152921497)>>60) * 1000000007
+	checksum = uint16(temp)
+	// the nibbles are used in reverse order
+	return (checksum&0xf000)>>12 | (checksum&0x0f00)>>4 | (checksum&0x00f0)<<4 | (checksum&0x000f)<<12
+}
+
+// displayShortName returns the 8.3 name as it should be shown, honouring the lowercase flags
+func (de *directoryEntry) displayShortName() string {
+	shortName := de.filenameShort
+	if de.lowercaseShortname {
+		shortName = strings.ToLower(shortName)
+	}
+	fileExtension := de.fileExtension
+	if de.lowercaseExtension {
+		fileExtension = strings.ToLower(fileExtension)
+	}
+	if fileExtension != "" {
+		shortName = fmt.Sprintf("%s.%s", shortName, fileExtension)
+	}
+	return shortName
+}
+
+// matchesName reports whether name refers to this entry, either by its long filename or by its 8.3 name.
+// As on any FAT filesystem, the comparison ignores case.
+func (de *directoryEntry) matchesName(name string) bool {
+	if de.filenameLong != "" && strings.EqualFold(de.filenameLong, name) {
+		return true
+	}
+	shortName := de.filenameShort
+	if de.fileExtension != "" {
+		shortName += "." + de.fileExtension
+	}
+	return strings.EqualFold(shortName, name)
+}
+
 // converts a string into upper-case with only valid characters
 func uCaseValid(name string) string {
 	// easiest way to do this is to go through the name one char at a time
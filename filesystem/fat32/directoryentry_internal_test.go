@@ -143,6 +143,80 @@ func TestTimeToDateTime(t *testing.T) {
 	}
 }
 
+func TestTimeToFineResolution(t *testing.T) {
+	tests := []struct {
+		t    time.Time
+		fine byte
+	}{
+		{time.Date(2024, 1, 2, 3, 4, 6, 0, time.UTC), 0},
+		{time.Date(2024, 1, 2, 3, 4, 7, 0, time.UTC), 100},
+		{time.Date(2024, 1, 2, 3, 4, 7, 990_000_000, time.UTC), 199},
+		{time.Date(2024, 1, 2, 3, 4, 8, 123_456_789, time.UTC), 12},
+	}
+	for _, tt := range tests {
+		fine := timeToFineResolution(tt.t)
+		if fine != tt.fine {
+			t.Errorf("timeToFineResolution(%v) expected %d, actual %d", tt.t, tt.fine, fine)
+		}
+		// converting back should give us the time truncated to 10ms
+		date, tm := timeToDateTime(tt.t)
+		output := dateTimeToTime(date, tm).Add(fineResolutionToDuration(fine))
+		if expected := tt.t.Truncate(10 * time.Millisecond); !output.Equal(expected) {
+			t.Errorf("round trip of %v expected %v, actual %v", tt.t, expected, output)
+		}
+	}
+	if d := fineResolutionToDuration(200); d != 0 {
+		t.Errorf("fineResolutionToDuration(200) expected 0 for invalid value, actual %v", d)
+	}
+}
+
+func TestDirectoryEntryAttributes(t *testing.T) {
+	de := &directoryEntry{
+		filenameShort:  "VENDOR",
+		fileExtension:  "BIN",
+		isSubdirectory: false,
+		createTime:     time.Date(2024, 5, 6, 7, 8, 9, 870_000_000, time.UTC),
+		modifyTime:     time.Date(2024, 5, 6, 7, 8, 10, 0, time.UTC),
+		accessTime:     time.Date(2024, 5, 7, 0, 0, 0, 0, time.UTC),
+	}
+	de.setAttributes(AttrHidden | AttrSystem | AttrReadOnly)
+	if attrs := de.attributes(); attrs != AttrHidden|AttrSystem|AttrReadOnly {
+		t.Fatalf("attributes() expected %#02x, actual %#02x", AttrHidden|AttrSystem|AttrReadOnly, attrs)
+	}
+	b, err := de.toBytes()
+	if err != nil {
+		t.Fatalf("unexpected error converting to bytes: %v", err)
+	}
+	if b[11] != 0x07 {
+		t.Errorf("mismatched attribute byte %#02x", b[11])
+	}
+	entries, err := parseDirEntries(b)
+	if err != nil {
+		t.Fatalf("unexpected error parsing bytes: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("expected 1 entry, got %d", len(entries))
+	}
+	output := entries[0]
+	switch {
+	case output.attributes() != de.attributes():
+		t.Errorf("mismatched attributes actual %#02x expected %#02x", output.attributes(), de.attributes())
+	case !output.createTime.Equal(de.createTime):
+		t.Errorf("mismatched create time actual %v expected %v", output.createTime, de.createTime)
+	case !output.modifyTime.Equal(de.modifyTime):
+		t.Errorf("mismatched modify time actual %v expected %v", output.modifyTime, de.modifyTime)
+	case !output.accessTime.Equal(de.accessTime):
+		t.Errorf("mismatched access time actual %v expected %v", output.accessTime, de.accessTime)
+	}
+
+	// directory and volume label flags are not changed
+	de.isSubdirectory = true
+	de.setAttributes(AttrArchive)
+	if attrs := de.attributes(); attrs != AttrArchive|AttrDirectory {
+		t.Errorf("attributes() expected %#02x, actual %#02x", AttrArchive|AttrDirectory, attrs)
+	}
+}
+
 func TestDirectoryEntryLfnChecksum(t *testing.T) {
 	/*
 		the values for the hashes are taken from testdata/calcsfn_checksum.c, which is based on the
@@ -236,6 +310,7 @@ func TestDirectoryEntryConvertLfnSfn(t *testing.T) {
 		{"aBC.q", "ABC", "Q", true, false},
 		{"ABC.q.rt", "ABCQ", "RT", true, false},
 		{"VeryLongName.ft", "VERYLO~1", "FT", true, true},
+		{".bashrc", "BASHRC", "", true, false},
 	}
 	for _, tt := range tests {
 		sfn, extension, isLfn, isTruncated := convertLfnSfn(tt.input)
@@ -245,6 +320,33 @@ func TestDirectoryEntryConvertLfnSfn(t *testing.T) {
 	}
 }
 
+func TestDirectoryEntryCaseOnlyShortName(t *testing.T) {
+	tests := []struct {
+		input              string
+		sfn                string
+		extension          string
+		lowercaseShortname bool
+		lowercaseExtension bool
+		ok                 bool
+	}{
+		{"abc", "ABC", "", true, false, true},
+		{"abc.txt", "ABC", "TXT", true, true, true},
+		{"ABC.txt", "ABC", "TXT", false, true, true},
+		{"abc.TXT", "ABC", "TXT", true, false, true},
+		{"Abc.txt", "", "", false, false, false},
+		{"abcdefghi.txt", "", "", false, false, false},
+		{"a b.txt", "", "", false, false, false},
+		{".abc", "", "", false, false, false},
+	}
+	for _, tt := range tests {
+		sfn, extension, lcName, lcExt, ok := caseOnlyShortName(tt.input)
+		if sfn != tt.sfn || extension != tt.extension || lcName != tt.lowercaseShortname || lcExt != tt.lowercaseExtension || ok != tt.ok {
+			t.Errorf("caseOnlyShortName(%s) expected %s / %s / %t / %t / %t ; actual %s / %s / %t / %t / %t", tt.input,
+				tt.sfn, tt.extension, tt.lowercaseShortname, tt.lowercaseExtension, tt.ok, sfn, extension, lcName, lcExt, ok)
+		}
+	}
+}
+
 func TestDirectoryEntryUCaseValid(t *testing.T) {
 	tests := []struct {
 		input  string
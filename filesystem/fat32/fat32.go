@@ -10,6 +10,7 @@ import (
 
 	"github.com/diskfs/go-diskfs/backend"
 	"github.com/diskfs/go-diskfs/filesystem"
+	"golang.org/x/exp/slices"
 )
 
 // MsdosMediaType is the (mostly unused) media type. However, we provide and export the known constants for it.
@@ -60,6 +61,10 @@ type FileSystem struct {
 	size            int64
 	start           int64
 	backend         backend.Storage
+	// fsisDirty is set when the FS Information Sector changed since it last was written to disk
+	fsisDirty bool
+	// deferFatWrites keeps changes to the FAT and the FS Information Sector in memory until Sync or Close
+	deferFatWrites bool
 }
 
 // Equal compare if two filesystems are equal
@@ -144,19 +149,7 @@ func Create(b backend.Storage, size, start, blocksize int64, volumeLabel string)
 			  >  32G      / 128 sector = 65536 bytes
 	*/
 
-	var sectorsPerCluster uint8
-	switch {
-	case size <= 260*MB:
-		sectorsPerCluster = 1
-	case size <= 8*GB:
-		sectorsPerCluster = 8
-	case size <= 16*GB:
-		sectorsPerCluster = 32
-	case size <= 32*GB:
-		sectorsPerCluster = 64
-	case size <= Fat32MaxSize:
-		sectorsPerCluster = 128
-	}
+	sectorsPerCluster := uint8(ClusterSize(size) / int64(SectorSize512))
 
 	// stick with uint32 and round down
 	totalSectors := uint32(size / int64(SectorSize512))
@@ -313,6 +306,25 @@ func Create(b backend.Storage, size, start, blocksize int64, volumeLabel string)
 	return fs, nil
 }
 
+// ClusterSize returns the size in bytes of the clusters of a filesystem of size bytes made by Create, or 0 for
+// a size that is larger than a FAT32 can be
+func ClusterSize(size int64) int64 {
+	var sectorsPerCluster int64
+	switch {
+	case size <= 260*MB:
+		sectorsPerCluster = 1
+	case size <= 8*GB:
+		sectorsPerCluster = 8
+	case size <= 16*GB:
+		sectorsPerCluster = 32
+	case size <= 32*GB:
+		sectorsPerCluster = 64
+	case size <= Fat32MaxSize:
+		sectorsPerCluster = 128
+	}
+	return sectorsPerCluster * int64(SectorSize512)
+}
+
 // Read reads a filesystem from a given disk.
 //
 // requires the backend.Storage where to read the filesystem, size is the size of the filesystem in bytes,
@@ -379,12 +391,11 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 	partitionTableBytes := make([]byte, fatSize)
 	_, _ = b.ReadAt(partitionTableBytes, int64(fatPrimaryStart)+start)
 	fat := tableFromBytes(partitionTableBytes)
-
-	_, _ = b.ReadAt(partitionTableBytes, int64(fatSecondaryStart)+start)
-	fat2 := tableFromBytes(partitionTableBytes)
-	if !fat.equal(fat2) {
-		return nil, errors.New("fat tables did not match")
+	if root := bs.biosParameterBlock.rootDirectoryCluster; root >= 2 && root < fat.maxCluster {
+		fat.rootDirCluster = root
 	}
+
+	// like most FAT drivers, we rely on the primary FAT only; Check reports any copies that differ from it
 	dataStart := uint32(fatSecondaryStart) + fat.size
 
 	return &FileSystem{
@@ -483,15 +494,83 @@ func (fs *FileSystem) writeFat() error {
 		return fmt.Errorf("unable to write backup FAT table: %w", err)
 	}
 
+	fs.table.dirty = nil
 	return nil
 }
 
+// flushFat writes only the sectors of the FAT that changed since it last was written to disk
+func (fs *FileSystem) flushFat() error {
+	if len(fs.table.dirty) == 0 {
+		return nil
+	}
+	reservedSectors := fs.bootSector.biosParameterBlock.dos331BPB.dos20BPB.reservedSectors
+	fatPrimaryStart := uint64(reservedSectors) * uint64(SectorSize512)
+	fatSecondaryStart := fatPrimaryStart + uint64(fs.table.size)
+
+	writableFile, err := fs.backend.Writable()
+	if err != nil {
+		return err
+	}
+
+	for _, sector := range fs.table.dirtySectors() {
+		b := fs.table.sectorBytes(sector)
+		offset := int64(sector) * int64(SectorSize512)
+		if _, err := writableFile.WriteAt(b, int64(fatPrimaryStart)+offset+fs.start); err != nil {
+			return fmt.Errorf("unable to write primary FAT table: %w", err)
+		}
+		if _, err := writableFile.WriteAt(b, int64(fatSecondaryStart)+offset+fs.start); err != nil {
+			return fmt.Errorf("unable to write backup FAT table: %w", err)
+		}
+	}
+
+	fs.table.dirty = nil
+	return nil
+}
+
+// Sync writes the changes to the FAT and the FS Information Sector that are kept in memory to disk.
+//
+// By default, these are written at the end of each operation that makes them, along with the file data and
+// directory entries, so there is nothing left for Sync to do. See SetDeferFatWrites.
+func (fs *FileSystem) Sync() error {
+	if err := fs.flushFat(); err != nil {
+		return fmt.Errorf("failed to write the file allocation table: %w", err)
+	}
+	if fs.fsisDirty {
+		if err := fs.writeFsis(); err != nil {
+			return fmt.Errorf("failed to write the file system information sector: %w", err)
+		}
+		fs.fsisDirty = false
+	}
+	return nil
+}
+
+// SetDeferFatWrites sets whether changes to the FAT and the FS Information Sector are kept in memory until Sync or
+// Close is called, rather than written to disk at the end of each operation that makes them, which is the default.
+// Deferring them saves writing the same sectors of the FAT over and over when writing many files, but the
+// filesystem on disk is not consistent until Sync or Close. Turning it off writes out whatever was deferred.
+func (fs *FileSystem) SetDeferFatWrites(deferred bool) error {
+	fs.deferFatWrites = deferred
+	if deferred {
+		return nil
+	}
+	return fs.Sync()
+}
+
+// syncFat writes the changes to the FAT and the FS Information Sector to disk at the end of an operation that made
+// them, unless they are deferred
+func (fs *FileSystem) syncFat() error {
+	if fs.deferFatWrites {
+		return nil
+	}
+	return fs.Sync()
+}
+
 // interface guard
 var _ filesystem.FileSystem = (*FileSystem)(nil)
 
-// Do cleaning job for fat32. Note that fat32 does not have side-effects so we do not do anything.
+// Close writes any changes to the FAT and the FS Information Sector that still are in memory to disk.
 func (fs *FileSystem) Close() error {
-	return nil
+	return fs.Sync()
 }
 
 // Type returns the type code for the filesystem. Always returns filesystem.TypeFat32
@@ -527,8 +606,99 @@ func (fs *FileSystem) Symlink(_, _ string) error {
 
 // Chmod changes the mode of the named file to mode. If the file is a symbolic link,
 // it changes the mode of the link's target.
-func (fs *FileSystem) Chmod(_ string, _ os.FileMode) error {
-	return filesystem.ErrNotSupported
+//
+// FAT has no permissions, so the only thing this changes is the read-only attribute, which is set if
+// mode has no write permission bits at all, and cleared otherwise.
+func (fs *FileSystem) Chmod(name string, mode os.FileMode) error {
+	parentDir, entry, err := fs.findEntry(name)
+	if err != nil {
+		return err
+	}
+	entry.isReadOnly = mode.Perm()&0o222 == 0
+	if err := fs.writeDirectoryEntries(parentDir); err != nil {
+		return fmt.Errorf("error writing directory entries to disk: %w", err)
+	}
+	return nil
+}
+
+// Chtimes changes the creation, access and modification times of the named file or directory.
+//
+// FAT stores the creation time to a resolution of 10ms, the modification time to a resolution of 2 seconds,
+// and only the date of the last access. A zero time.Time value leaves the corresponding time unchanged.
+//
+// The times of a directory are those of its "." entry as well. The root directory has no entry of its own, so
+// for it, the times of the volume label entry are changed.
+func (fs *FileSystem) Chtimes(name string, ctime, atime, mtime time.Time) error {
+	setTimes := func(entry *directoryEntry) {
+		if !ctime.IsZero() {
+			entry.createTime = ctime
+		}
+		if !atime.IsZero() {
+			entry.accessTime = atime
+		}
+		if !mtime.IsZero() {
+			entry.modifyTime = mtime
+		}
+	}
+	// setOwnTimes sets the times of the entry in the directory p that stands for the directory itself
+	setOwnTimes := func(p string, own func(*directoryEntry) bool) error {
+		dir, entries, err := fs.readDirWithMkdir(p, false)
+		if err != nil {
+			return fmt.Errorf("could not read directory entries for %s: %w", p, err)
+		}
+		for _, e := range entries {
+			if own(e) {
+				setTimes(e)
+			}
+		}
+		if err := fs.writeDirectoryEntries(dir); err != nil {
+			return fmt.Errorf("error writing directory entries to disk: %w", err)
+		}
+		return nil
+	}
+
+	if path.Clean(name) == "/" {
+		return setOwnTimes("/", func(e *directoryEntry) bool { return e.isVolumeLabel })
+	}
+	parentDir, entry, err := fs.findEntry(name)
+	if err != nil {
+		return err
+	}
+	setTimes(entry)
+	if err := fs.writeDirectoryEntries(parentDir); err != nil {
+		return fmt.Errorf("error writing directory entries to disk: %w", err)
+	}
+	if entry.isSubdirectory {
+		return setOwnTimes(name, func(e *directoryEntry) bool { return e.filenameShort == "." })
+	}
+	return nil
+}
+
+// Attributes returns the DOS attribute flags of the named file or directory
+func (fs *FileSystem) Attributes(name string) (FileAttributes, error) {
+	_, entry, err := fs.findEntry(name)
+	if err != nil {
+		return 0, err
+	}
+	return entry.attributes(), nil
+}
+
+// SetAttributes sets the DOS attribute flags of the named file or directory. Only AttrReadOnly, AttrHidden,
+// AttrSystem and AttrArchive can be changed; any of those not included in attrs are cleared.
+// It returns an error if attrs includes any other flag.
+func (fs *FileSystem) SetAttributes(name string, attrs FileAttributes) error {
+	if attrs&^settableAttributes != 0 {
+		return fmt.Errorf("cannot set attributes %#02x, only read-only, hidden, system and archive can be changed", uint8(attrs&^settableAttributes))
+	}
+	parentDir, entry, err := fs.findEntry(name)
+	if err != nil {
+		return err
+	}
+	entry.setAttributes(attrs)
+	if err := fs.writeDirectoryEntries(parentDir); err != nil {
+		return fmt.Errorf("error writing directory entries to disk: %w", err)
+	}
+	return nil
 }
 
 // Chown changes the numeric uid and gid of the named file. If the file is a symbolic link,
@@ -555,21 +725,10 @@ func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
 		if e.isVolumeLabel {
 			continue
 		}
-		shortName := e.filenameShort
-		if e.lowercaseShortname {
-			shortName = strings.ToLower(shortName)
-		}
-		fileExtension := e.fileExtension
-		if e.lowercaseExtension {
-			fileExtension = strings.ToLower(fileExtension)
-		}
-		if fileExtension != "" {
-			shortName = fmt.Sprintf("%s.%s", shortName, fileExtension)
-		}
 		ret = append(ret, FileInfo{
 			modTime:   e.modifyTime,
 			name:      e.filenameLong,
-			shortName: shortName,
+			shortName: e.displayShortName(),
 			size:      int64(e.fileSize),
 			isDir:     e.isSubdirectory,
 		})
@@ -675,11 +834,7 @@ func (fs *FileSystem) Remove(pathname string) error {
 	// we now know that the directory exists, see if the file exists
 	var targetEntry *directoryEntry
 	for _, e := range entries {
-		shortName := e.filenameShort
-		if e.fileExtension != "" {
-			shortName += "." + e.fileExtension
-		}
-		if e.filenameLong != filename && shortName != filename {
+		if e.isVolumeLabel || !e.matchesName(filename) {
 			continue
 		}
 		// cannot do anything with directories
@@ -707,6 +862,13 @@ func (fs *FileSystem) Remove(pathname string) error {
 		return fmt.Errorf("failed to remove file %s: %v", pathname, err)
 	}
 
+	// release the clusters of the removed file or directory, so they do not become a lost chain
+	if targetEntry.clusterLocation >= 2 {
+		if err := fs.freeClusters(targetEntry.clusterLocation); err != nil {
+			return fmt.Errorf("failed to free clusters of %s: %v", pathname, err)
+		}
+	}
+
 	// we need to make sure that clusters are removed which may not be used anymore
 	_, err = fs.allocateSpace(uint64(parentDir.fileSize), parentDir.clusterLocation)
 	if err != nil {
@@ -746,11 +908,7 @@ func (fs *FileSystem) Rename(oldpath, newpath string) error {
 	// we now know that the directory exists, see if the file exists
 	var targetEntry *directoryEntry
 	for _, e := range entries {
-		shortName := e.filenameShort
-		if e.fileExtension != "" {
-			shortName += "." + e.fileExtension
-		}
-		if e.filenameLong != filename && shortName != filename {
+		if e.isVolumeLabel || !e.matchesName(filename) {
 			continue
 		}
 		// if we got this far, we have found the file
@@ -864,6 +1022,155 @@ func (fs *FileSystem) SetLabel(volumeLabel string) error {
 	return nil
 }
 
+// OEMName returns the OEM name in the boot sector, usually the name of the system that formatted the filesystem
+func (fs *FileSystem) OEMName() string {
+	return strings.TrimRight(fs.bootSector.oemName, " ")
+}
+
+// SetOEMName changes the OEM name in the boot sector and its backup. It may be at most 8 ASCII characters long.
+func (fs *FileSystem) SetOEMName(name string) error {
+	if len(name) > 8 {
+		return fmt.Errorf("OEM name %q is longer than 8 bytes", name)
+	}
+	for _, r := range name {
+		if r < 0x20 || r > 0x7e {
+			return fmt.Errorf("OEM name %q has non-printable or non-ASCII characters", name)
+		}
+	}
+	fs.bootSector.oemName = name
+	if err := fs.writeBootSector(); err != nil {
+		return fmt.Errorf("failed to write the boot sector: %w", err)
+	}
+	return nil
+}
+
+// VolumeID returns the volume ID, a.k.a. serial number, of the filesystem
+func (fs *FileSystem) VolumeID() uint32 {
+	return fs.bootSector.biosParameterBlock.volumeSerialNumber
+}
+
+// SetVolumeID changes the volume ID, a.k.a. serial number, in the boot sector and its backup
+func (fs *FileSystem) SetVolumeID(id uint32) error {
+	fs.bootSector.biosParameterBlock.volumeSerialNumber = id
+	if err := fs.writeBootSector(); err != nil {
+		return fmt.Errorf("failed to write the boot sector: %w", err)
+	}
+	return nil
+}
+
+// MediaType returns the media type in the boot sector
+func (fs *FileSystem) MediaType() MsdosMediaType {
+	return MsdosMediaType(fs.bootSector.biosParameterBlock.dos331BPB.dos20BPB.mediaType)
+}
+
+// SetMediaType changes the media type in the boot sector and its backup, as well as the copy of it
+// in the FAT ID, the first entry of the FAT
+func (fs *FileSystem) SetMediaType(mediaType MsdosMediaType) error {
+	if mediaType < Media8InchDrDos {
+		return fmt.Errorf("invalid media type %#02x", uint8(mediaType))
+	}
+	fs.bootSector.biosParameterBlock.dos331BPB.dos20BPB.mediaType = uint8(mediaType)
+	if err := fs.writeBootSector(); err != nil {
+		return fmt.Errorf("failed to write the boot sector: %w", err)
+	}
+	fs.table.setFatID(fs.table.fatID&^0xff | uint32(mediaType))
+	if err := fs.flushFat(); err != nil {
+		return fmt.Errorf("failed to write the file allocation table: %w", err)
+	}
+	return nil
+}
+
+// BootCode returns the jump instruction and the boot code in the boot sector
+func (fs *FileSystem) BootCode() (jump [3]byte, code []byte) {
+	return fs.bootSector.jumpInstruction, slices.Clone(fs.bootSector.bootCode)
+}
+
+// SetBootCode replaces the jump instruction and the boot code in the boot sector and its backup, keeping the
+// BIOS Parameter Block between them. The jump instruction must be a short (0xeb) or near (0xe9) jump, and
+// the code must fit between the BIOS Parameter Block and the boot sector signature, which usually leaves 420 bytes.
+// Shorter code is padded with zeroes.
+//
+// This is how to install a legacy BIOS bootloader: take the jump instruction from the first 3 bytes of its
+// boot sector, and the code from the same offset as in this boot sector, as returned by BootCodeOffset.
+func (fs *FileSystem) SetBootCode(jump [3]byte, code []byte) error {
+	if jump[0] != 0xeb && jump[0] != 0xe9 {
+		return fmt.Errorf("invalid jump instruction % x, must start with 0xeb or 0xe9", jump)
+	}
+	offset, err := fs.BootCodeOffset()
+	if err != nil {
+		return err
+	}
+	if maxSize := int(SectorSize512) - 2 - offset; len(code) > maxSize {
+		return fmt.Errorf("boot code of %d bytes is longer than the %d bytes available", len(code), maxSize)
+	}
+	fs.bootSector.jumpInstruction = jump
+	fs.bootSector.bootCode = slices.Clone(code)
+	if err := fs.writeBootSector(); err != nil {
+		return fmt.Errorf("failed to write the boot sector: %w", err)
+	}
+	return nil
+}
+
+// BootCodeOffset returns where the boot code starts in the boot sector, right after the BIOS Parameter Block
+func (fs *FileSystem) BootCodeOffset() (int, error) {
+	bpbBytes, err := fs.bootSector.biosParameterBlock.toBytes()
+	if err != nil {
+		return 0, fmt.Errorf("error getting FAT32 EBPB: %w", err)
+	}
+	return 11 + len(bpbBytes), nil
+}
+
+// WriteReservedSectors writes b to the reserved sectors, beginning at the given sector, for bootloaders that
+// need more room than the boot sector has. b is padded with zeroes to full sectors.
+//
+// The sectors may be anywhere in the reserved area except the boot sector, the FS Information Sector and
+// the backup copies of the first sectors. As on Windows, which keeps its boot code in sector 2, any sectors before
+// the backup boot sector are copied to the same position after it as well, so the backup stays complete.
+func (fs *FileSystem) WriteReservedSectors(sector uint16, b []byte) error {
+	bpb := fs.bootSector.biosParameterBlock
+	reservedSectors := bpb.dos331BPB.dos20BPB.reservedSectors
+	backupBootSector := bpb.backupBootSector
+
+	count := (len(b) + int(SectorSize512) - 1) / int(SectorSize512)
+	if count == 0 {
+		return nil
+	}
+	end := int(sector) + count
+	if end > int(reservedSectors) {
+		return fmt.Errorf("sectors %d to %d are beyond the %d reserved sectors", sector, end-1, reservedSectors)
+	}
+	// the backups take up as many sectors after the backup boot sector as there are before it
+	overlaps := func(start, length int) bool {
+		return int(sector) < start+length && end > start
+	}
+	switch {
+	case sector == 0:
+		return errors.New("cannot overwrite the boot sector, use SetBootCode instead")
+	case overlaps(int(bpb.fsInformationSector), 1):
+		return fmt.Errorf("sectors %d to %d would overwrite the FS Information Sector", sector, end-1)
+	case backupBootSector > 0 && overlaps(int(backupBootSector), int(backupBootSector)):
+		return fmt.Errorf("sectors %d to %d would overwrite the backup boot sectors", sector, end-1)
+	case backupBootSector > 0 && sector < backupBootSector && int(backupBootSector)+end > int(reservedSectors):
+		return fmt.Errorf("the backup of sectors %d to %d would be beyond the %d reserved sectors", sector, end-1, reservedSectors)
+	}
+
+	padded := make([]byte, count*int(SectorSize512))
+	copy(padded, b)
+	writableFile, err := fs.backend.Writable()
+	if err != nil {
+		return err
+	}
+	if _, err := writableFile.WriteAt(padded, int64(sector)*int64(SectorSize512)+fs.start); err != nil {
+		return fmt.Errorf("unable to write reserved sectors: %w", err)
+	}
+	if backupBootSector > 0 && sector < backupBootSector {
+		if _, err := writableFile.WriteAt(padded, int64(backupBootSector+sector)*int64(SectorSize512)+fs.start); err != nil {
+			return fmt.Errorf("unable to write backup of reserved sectors: %w", err)
+		}
+	}
+	return nil
+}
+
 // read directory entries for a given cluster
 func (fs *FileSystem) getClusterList(firstCluster uint32) ([]uint32, error) {
 	// first, get the chain of clusters
@@ -897,9 +1204,23 @@ func (fs *FileSystem) getClusterList(firstCluster uint32) ([]uint32, error) {
 
 // read directory entries for a given cluster
 func (fs *FileSystem) readDirectory(dir *Directory) ([]*directoryEntry, error) {
-	clusterList, err := fs.getClusterList(dir.clusterLocation)
+	b, _, err := fs.readDirectoryBytes(dir.clusterLocation)
+	if err != nil {
+		return nil, err
+	}
+	// get the directory
+	if err := dir.entriesFromBytes(b); err != nil {
+		return nil, err
+	}
+	return dir.entries, nil
+}
+
+// readDirectoryBytes reads the raw contents of the directory that starts at the given cluster,
+// along with the clusters it was read from
+func (fs *FileSystem) readDirectoryBytes(clusterLocation uint32) ([]byte, []uint32, error) {
+	clusterList, err := fs.getClusterList(clusterLocation)
 	if err != nil {
-		return nil, fmt.Errorf("could not read cluster list: %w", err)
+		return nil, nil, fmt.Errorf("could not read cluster list: %w", err)
 	}
 	// read the data from all of the cluster entries in the list
 	byteCount := len(clusterList) * fs.bytesPerCluster
@@ -913,11 +1234,7 @@ func (fs *FileSystem) readDirectory(dir *Directory) ([]*directoryEntry, error) {
 		_, _ = fs.backend.ReadAt(tmpb, clusterStart)
 		b = append(b, tmpb...)
 	}
-	// get the directory
-	if err := dir.entriesFromBytes(b); err != nil {
-		return nil, err
-	}
-	return dir.entries, nil
+	return b, clusterList, nil
 }
 
 // make a subdirectory
@@ -956,6 +1273,10 @@ func (fs *FileSystem) writeDirectoryEntries(dir *Directory) error {
 		}
 		clusterList = clusters
 	}
+	// any clusters beyond the entries are zeroed out, so they hold no stale entries
+	if len(b) < len(clusterList)*fs.bytesPerCluster {
+		b = append(b, make([]byte, len(clusterList)*fs.bytesPerCluster-len(b))...)
+	}
 	// now write everything out to the cluster list
 	// read the data from all of the cluster entries in the list
 	for i, cluster := range clusterList {
@@ -990,6 +1311,27 @@ func (fs *FileSystem) mkLabel(parent *Directory, name string) (*directoryEntry,
 	return parent.createVolumeLabel(name)
 }
 
+// findEntry locates the entry for a file or directory, along with the directory that holds it.
+// The root directory has no entry of its own, so it cannot be found this way.
+func (fs *FileSystem) findEntry(p string) (*Directory, *directoryEntry, error) {
+	dir := path.Dir(p)
+	filename := path.Base(p)
+	// if the dir == filename, then it is just /
+	if dir == filename {
+		return nil, nil, fmt.Errorf("root directory %s has no directory entry", p)
+	}
+	parentDir, entries, err := fs.readDirWithMkdir(dir, false)
+	if err != nil {
+		return nil, nil, fmt.Errorf("could not read directory entries for %s: %w", dir, err)
+	}
+	for _, e := range entries {
+		if !e.isVolumeLabel && e.matchesName(filename) {
+			return parentDir, e, nil
+		}
+	}
+	return nil, nil, fmt.Errorf("target file %s does not exist", p)
+}
+
 // readDirWithMkdir - walks down a directory tree to the last entry
 // if it does not exist, it may or may not make it
 func (fs *FileSystem) readDirWithMkdir(p string, doMake bool) (*Directory, []*directoryEntry, error) {
@@ -1024,7 +1366,7 @@ func (fs *FileSystem) readDirWithMkdir(p string, doMake bool) (*Directory, []*di
 			// match is determined by any one of:
 			// - long filename == provided name
 			// - uppercase(short filename) == uppercase(provided name)
-			if !strings.EqualFold(e.filenameLong, subp) && !strings.EqualFold(e.filenameShort, subp) {
+			if !e.matchesName(subp) {
 				continue
 			}
 			if !e.isSubdirectory {
@@ -1149,19 +1491,25 @@ func (fs *FileSystem) allocateSpace(size uint64, previous uint32) ([]uint32, err
 		return clusters, nil
 	}
 
-	// get a list of allocated clusters, so we can know which ones are unallocated and therefore allocatable
-	maxCluster := fs.table.maxCluster
-
 	if extraClusterCount > 0 {
-		for i := uint32(2); i < maxCluster && len(allocated) < extraClusterCount; i++ {
-			if fs.table.clusters[i] == 0 {
-				// these become the same at this point
-				allocated = append(allocated, i)
+		// start looking for free clusters after the one allocated last
+		next := fs.fsis.lastAllocatedCluster
+		for len(allocated) < extraClusterCount {
+			cluster, ok := fs.table.nextFree(next)
+			if !ok {
+				break
 			}
+			// mark it right away, so it is not found again
+			fs.table.set(cluster, fs.table.eocMarker)
+			allocated = append(allocated, cluster)
+			next = cluster
 		}
 
 		// did we allocate them all?
 		if len(allocated) < extraClusterCount {
+			for _, cl := range allocated {
+				fs.table.set(cl, fs.table.unusedMarker)
+			}
 			return nil, errors.New("no space left on device")
 		}
 
@@ -1170,12 +1518,13 @@ func (fs *FileSystem) allocateSpace(size uint64, previous uint32) ([]uint32, err
 
 		// extend the chain and fill them in
 		if previous > 0 {
-			fs.table.clusters[previous] = allocated[0]
+			fs.table.set(previous, allocated[0])
 		}
 		for i := 0; i < lastAlloc; i++ {
-			fs.table.clusters[allocated[i]] = allocated[i+1]
+			fs.table.set(allocated[i], allocated[i+1])
 		}
-		fs.table.clusters[allocated[lastAlloc]] = fs.table.eocMarker
+		fs.table.set(allocated[lastAlloc], fs.table.eocMarker)
+		fs.adjustFreeCount(-len(allocated))
 
 		// update the FSIS
 		lastAllocatedCluster = allocated[len(allocated)-1]
@@ -1196,7 +1545,7 @@ func (fs *FileSystem) allocateSpace(size uint64, previous uint32) ([]uint32, err
 		}
 
 		// mark last allocated one as EOC
-		fs.table.clusters[clusters[lastAlloc]] = fs.table.eocMarker
+		fs.table.set(clusters[lastAlloc], fs.table.eocMarker)
 
 		// unmark all of the unused ones
 		lastAllocatedCluster = fs.fsis.lastAllocatedCluster
@@ -1205,28 +1554,47 @@ func (fs *FileSystem) allocateSpace(size uint64, previous uint32) ([]uint32, err
 				return nil, fmt.Errorf("invalid cluster chain at %d", cl)
 			}
 
-			fs.table.clusters[cl] = fs.table.unusedMarker
+			fs.table.set(cl, fs.table.unusedMarker)
 			if cl == lastAllocatedCluster {
 				lastAllocatedCluster--
 			}
 		}
+		fs.adjustFreeCount(len(deallocated))
 	}
 
-	// update the FSIS
+	// update the FSIS, and write it to disk along with the FAT
 	fs.fsis.lastAllocatedCluster = lastAllocatedCluster
-	if err := fs.writeFsis(); err != nil {
-		return nil, fmt.Errorf("failed to write the file system information sector: %w", err)
-	}
-
-	// write the FAT tables
-	if err := fs.writeFat(); err != nil {
-		return nil, fmt.Errorf("failed to write the file allocation table: %w", err)
+	fs.fsisDirty = true
+	if err := fs.syncFat(); err != nil {
+		return nil, err
 	}
 
 	// return all of the clusters
 	return append(clusters, allocated...), nil
 }
 
+// freeClusters releases an entire cluster chain, given its first cluster
+func (fs *FileSystem) freeClusters(first uint32) error {
+	clusters, err := fs.getClusterList(first)
+	if err != nil {
+		return fmt.Errorf("unable to get cluster list: %w", err)
+	}
+	for _, cl := range clusters {
+		fs.table.set(cl, fs.table.unusedMarker)
+	}
+	fs.adjustFreeCount(len(clusters))
+	return fs.syncFat()
+}
+
+// adjustFreeCount changes the free cluster count in the FS Information Sector by delta, if it is known at all
+func (fs *FileSystem) adjustFreeCount(delta int) {
+	if fs.fsis.freeDataClustersCount == unknownFreeDataClusterCount {
+		return
+	}
+	fs.fsis.freeDataClustersCount = uint32(int64(fs.fsis.freeDataClustersCount) + int64(delta))
+	fs.fsisDirty = true
+}
+
 func abs(x int) int {
 	if x < 0 {
 		return -x
@@ -235,7 +235,7 @@ func TestFat32MkSubdir(t *testing.T) {
 	expected := &directoryEntry{
 		filenameShort:   "SUB",
 		fileExtension:   "",
-		filenameLong:    "sub",
+		filenameLong:    "",
 		isSubdirectory:  true,
 		clusterLocation: 12,
 	}
@@ -260,13 +260,14 @@ func TestFat32MkFile(t *testing.T) {
 		entries: []*directoryEntry{},
 	}
 	expected := &directoryEntry{
-		filenameShort:     "FILE",
-		fileExtension:     "",
-		filenameLong:      "file",
-		isSubdirectory:    false,
-		clusterLocation:   12,
-		longFilenameSlots: 1,
-		isNew:             true,
+		filenameShort:      "FILE",
+		fileExtension:      "",
+		filenameLong:       "",
+		isSubdirectory:     false,
+		clusterLocation:    12,
+		longFilenameSlots:  0,
+		lowercaseShortname: true,
+		isNew:              true,
 	}
 	de, err := fs.mkFile(d, "file")
 	switch {
@@ -8,6 +8,7 @@ package fat32_test
 import (
 	"bytes"
 	"crypto/rand"
+	"encoding/binary"
 	"fmt"
 	"io"
 	mathrandv2 "math/rand/v2"
@@ -16,6 +17,7 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	diskfs "github.com/diskfs/go-diskfs"
 	"github.com/diskfs/go-diskfs/backend"
@@ -1519,3 +1521,351 @@ func Test_Remove(t *testing.T) {
 		})
 	}
 }
+
+func TestFat32ShortNameCollisions(t *testing.T) {
+	f, err := tmpFat32(false, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keepTmpFiles == "" {
+		defer os.Remove(f.Name())
+	} else {
+		fmt.Println(f.Name())
+	}
+	fileInfo, err := f.Stat()
+	if err != nil {
+		t.Fatalf("error getting file info for tmpfile %s: %v", f.Name(), err)
+	}
+	fs, err := fat32.Create(file.New(f, false), fileInfo.Size(), 0, 512, "go-diskfs")
+	if err != nil {
+		t.Fatalf("error creating fat32 filesystem: %v", err)
+	}
+
+	dirs := []string{"Program Files A", "Program Files B", "Program Files C", "Program Files D", "Program Files E", "Program Files F"}
+	for _, dir := range dirs {
+		if err := fs.Mkdir("/" + dir); err != nil {
+			t.Fatalf("error making directory %s: %v", dir, err)
+		}
+	}
+	if err := testMkFile(fs, "/readme.txt", 10); err != nil {
+		t.Fatalf("error making file: %v", err)
+	}
+
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("error reading root directory: %v", err)
+	}
+	shortNames := make(map[string]string)
+	for _, e := range entries {
+		fi, ok := e.(fat32.FileInfo)
+		if !ok {
+			t.Fatalf("unexpected FileInfo type %T", e)
+		}
+		if other, ok := shortNames[fi.ShortName()]; ok {
+			t.Errorf("%s and %s have the same short name %s", other, fi.Name(), fi.ShortName())
+		}
+		shortNames[fi.ShortName()] = fi.Name()
+	}
+	for i, dir := range dirs[:4] {
+		sfn := fmt.Sprintf("PROGRA~%d", i+1)
+		if shortNames[sfn] != dir {
+			t.Errorf("expected short name %s for %s, got %s", sfn, dir, shortNames[sfn])
+		}
+	}
+	// a name that fits 8.3 needs no long filename, and keeps its case through the lower-case flags
+	if shortNames["readme.txt"] != "readme.txt" {
+		t.Errorf("expected readme.txt to be stored as a short name only")
+	}
+	// each directory can be found by its short name as well
+	for sfn, name := range shortNames {
+		if _, err := fs.ReadDir("/" + sfn); err != nil && name != "readme.txt" {
+			t.Errorf("error reading directory %s by short name %s: %v", name, sfn, err)
+		}
+	}
+}
+
+func TestFat32Attributes(t *testing.T) {
+	f, err := tmpFat32(false, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keepTmpFiles == "" {
+		defer os.Remove(f.Name())
+	} else {
+		fmt.Println(f.Name())
+	}
+	fileInfo, err := f.Stat()
+	if err != nil {
+		t.Fatalf("error getting file info for tmpfile %s: %v", f.Name(), err)
+	}
+	fs, err := fat32.Create(file.New(f, false), fileInfo.Size(), 0, 512, "go-diskfs")
+	if err != nil {
+		t.Fatalf("error creating fat32 filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/EFI/Vendor"); err != nil {
+		t.Fatalf("error making directory: %v", err)
+	}
+	if err := testMkFile(fs, "/EFI/Vendor/firmware.bin", 1000); err != nil {
+		t.Fatalf("error making file: %v", err)
+	}
+
+	if err := fs.SetAttributes("/EFI/Vendor", fat32.AttrHidden|fat32.AttrSystem); err != nil {
+		t.Fatalf("error setting attributes on directory: %v", err)
+	}
+	if err := fs.SetAttributes("/EFI/Vendor/firmware.bin", fat32.AttrHidden|fat32.AttrArchive); err != nil {
+		t.Fatalf("error setting attributes on file: %v", err)
+	}
+	if err := fs.SetAttributes("/EFI/Vendor/firmware.bin", fat32.AttrDirectory); err == nil {
+		t.Errorf("unexpected nil error setting directory attribute on file")
+	}
+	if err := fs.Chmod("/EFI/Vendor/firmware.bin", 0o444); err != nil {
+		t.Fatalf("error changing mode: %v", err)
+	}
+	if err := fs.SetAttributes("/EFI/missing", fat32.AttrHidden); err == nil {
+		t.Errorf("unexpected nil error setting attributes on missing file")
+	}
+
+	ctime := time.Date(2021, 3, 4, 5, 6, 7, 890_000_000, time.UTC)
+	atime := time.Date(2022, 4, 5, 0, 0, 0, 0, time.UTC)
+	mtime := time.Date(2023, 5, 6, 7, 8, 10, 0, time.UTC)
+	for _, p := range []string{"/EFI/Vendor/firmware.bin", "/EFI/Vendor", "/"} {
+		if err := fs.Chtimes(p, ctime, atime, mtime); err != nil {
+			t.Fatalf("error changing times of %s: %v", p, err)
+		}
+	}
+
+	// read it back from disk
+	if err := fs.Close(); err != nil {
+		t.Fatalf("error closing filesystem: %v", err)
+	}
+	fs, err = fat32.Read(file.New(f, false), fileInfo.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading fat32 filesystem: %v", err)
+	}
+	attrs, err := fs.Attributes("/EFI/Vendor")
+	if err != nil {
+		t.Fatalf("error getting attributes of directory: %v", err)
+	}
+	if expected := fat32.AttrHidden | fat32.AttrSystem | fat32.AttrDirectory; attrs != expected {
+		t.Errorf("directory attributes %#02x, expected %#02x", attrs, expected)
+	}
+	attrs, err = fs.Attributes("/EFI/Vendor/firmware.bin")
+	if err != nil {
+		t.Fatalf("error getting attributes of file: %v", err)
+	}
+	if expected := fat32.AttrHidden | fat32.AttrArchive | fat32.AttrReadOnly; attrs != expected {
+		t.Errorf("file attributes %#02x, expected %#02x", attrs, expected)
+	}
+	if err := fs.Chmod("/EFI/Vendor/firmware.bin", 0o644); err != nil {
+		t.Fatalf("error changing mode: %v", err)
+	}
+	if attrs, _ = fs.Attributes("/EFI/Vendor/firmware.bin"); attrs&fat32.AttrReadOnly != 0 {
+		t.Errorf("read-only attribute not cleared by Chmod")
+	}
+
+	entries, err := fs.ReadDir("/EFI/Vendor")
+	if err != nil {
+		t.Fatalf("error reading directory: %v", err)
+	}
+	var found bool
+	for _, e := range entries {
+		if e.Name() != "firmware.bin" {
+			continue
+		}
+		found = true
+		if !e.ModTime().Equal(mtime) {
+			t.Errorf("modification time %v, expected %v", e.ModTime(), mtime)
+		}
+	}
+	if !found {
+		t.Errorf("firmware.bin not found in directory")
+	}
+	entries, err = fs.ReadDir("/EFI")
+	if err != nil {
+		t.Fatalf("error reading directory: %v", err)
+	}
+	for _, e := range entries {
+		if e.Name() == "Vendor" && !e.ModTime().Equal(mtime) {
+			t.Errorf("modification time of directory %v, expected %v", e.ModTime(), mtime)
+		}
+	}
+}
+
+func TestFat32DeferFatWrites(t *testing.T) {
+	f, err := tmpFat32(false, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keepTmpFiles == "" {
+		defer os.Remove(f.Name())
+	} else {
+		fmt.Println(f.Name())
+	}
+	fileInfo, err := f.Stat()
+	if err != nil {
+		t.Fatalf("error getting file info for tmpfile %s: %v", f.Name(), err)
+	}
+	b := file.New(f, false)
+	fs, err := fat32.Create(b, fileInfo.Size(), 0, 512, "go-diskfs")
+	if err != nil {
+		t.Fatalf("error creating fat32 filesystem: %v", err)
+	}
+	// problems reports what is wrong with the filesystem on disk, as a fresh read of it finds it
+	problems := func() []fat32.CheckProblem {
+		t.Helper()
+		onDisk, err := fat32.Read(b, fileInfo.Size(), 0, 512)
+		if err != nil {
+			t.Fatalf("error reading fat32 filesystem: %v", err)
+		}
+		report, err := onDisk.Check(false)
+		if err != nil {
+			t.Fatalf("error checking filesystem: %v", err)
+		}
+		return report.Problems
+	}
+
+	// without Close, what is written is on disk at the end of each operation
+	if err := testMkFile(fs, "/written", 5000); err != nil {
+		t.Fatalf("error making file: %v", err)
+	}
+	if p := problems(); len(p) != 0 {
+		t.Errorf("unexpected problems on disk without deferring FAT writes: %v", p)
+	}
+
+	if err := fs.SetDeferFatWrites(true); err != nil {
+		t.Fatalf("error deferring FAT writes: %v", err)
+	}
+	if err := testMkFile(fs, "/deferred", 5000); err != nil {
+		t.Fatalf("error making file: %v", err)
+	}
+	if p := problems(); len(p) == 0 {
+		t.Errorf("FAT written to disk before Sync while deferring writes")
+	}
+	if err := fs.Sync(); err != nil {
+		t.Fatalf("error syncing filesystem: %v", err)
+	}
+	if p := problems(); len(p) != 0 {
+		t.Errorf("unexpected problems on disk after Sync: %v", p)
+	}
+}
+
+func TestFat32BootSector(t *testing.T) {
+	f, err := tmpFat32(false, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keepTmpFiles == "" {
+		defer os.Remove(f.Name())
+	} else {
+		fmt.Println(f.Name())
+	}
+	fileInfo, err := f.Stat()
+	if err != nil {
+		t.Fatalf("error getting file info for tmpfile %s: %v", f.Name(), err)
+	}
+	fs, err := fat32.Create(file.New(f, false), fileInfo.Size(), 0, 512, "go-diskfs")
+	if err != nil {
+		t.Fatalf("error creating fat32 filesystem: %v", err)
+	}
+	if name := fs.OEMName(); name != "godiskfs" {
+		t.Errorf("OEM name %q, expected %q", name, "godiskfs")
+	}
+
+	if err := fs.SetOEMName("MSWIN4.1"); err != nil {
+		t.Fatalf("error setting OEM name: %v", err)
+	}
+	if err := fs.SetOEMName("much too long"); err == nil {
+		t.Errorf("unexpected nil error setting too long OEM name")
+	}
+	if err := fs.SetVolumeID(0x1234abcd); err != nil {
+		t.Fatalf("error setting volume ID: %v", err)
+	}
+	if err := fs.SetMediaType(fat32.Media35Inch); err != nil {
+		t.Fatalf("error setting media type: %v", err)
+	}
+	offset, err := fs.BootCodeOffset()
+	if err != nil {
+		t.Fatalf("error getting boot code offset: %v", err)
+	}
+	if offset != 90 {
+		t.Errorf("boot code offset %d, expected 90", offset)
+	}
+	code := bytes.Repeat([]byte{0xfa, 0xf4}, 210)
+	jump := [3]byte{0xeb, 0x58, 0x90}
+	if err := fs.SetBootCode(jump, append(code, 0x00)); err == nil {
+		t.Errorf("unexpected nil error setting too long boot code")
+	}
+	if err := fs.SetBootCode([3]byte{0x00, 0x00, 0x00}, code); err == nil {
+		t.Errorf("unexpected nil error setting invalid jump instruction")
+	}
+	if err := fs.SetBootCode(jump, code); err != nil {
+		t.Fatalf("error setting boot code: %v", err)
+	}
+	extra := bytes.Repeat([]byte("stage2"), 100)
+	if err := fs.WriteReservedSectors(2, extra); err != nil {
+		t.Fatalf("error writing reserved sectors: %v", err)
+	}
+	if err := fs.WriteReservedSectors(20, extra); err != nil {
+		t.Fatalf("error writing reserved sectors: %v", err)
+	}
+	for _, sector := range []uint16{0, 1, 5, 6, 11, 31} {
+		if err := fs.WriteReservedSectors(sector, extra); err == nil {
+			t.Errorf("unexpected nil error writing reserved sector %d", sector)
+		}
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatalf("error closing filesystem: %v", err)
+	}
+
+	// everything must be on disk, in the backups too
+	fs, err = fat32.Read(file.New(f, false), fileInfo.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading fat32 filesystem: %v", err)
+	}
+	if name := fs.OEMName(); name != "MSWIN4.1" {
+		t.Errorf("OEM name %q, expected %q", name, "MSWIN4.1")
+	}
+	if id := fs.VolumeID(); id != 0x1234abcd {
+		t.Errorf("volume ID %#x, expected %#x", id, 0x1234abcd)
+	}
+	if mt := fs.MediaType(); mt != fat32.Media35Inch {
+		t.Errorf("media type %#x, expected %#x", mt, fat32.Media35Inch)
+	}
+	readJump, readCode := fs.BootCode()
+	if readJump != jump || !bytes.Equal(readCode, code) {
+		t.Errorf("mismatched boot code % x % x", readJump, readCode)
+	}
+	report, err := fs.Check(false)
+	if err != nil {
+		t.Fatalf("error checking filesystem: %v", err)
+	}
+	if !report.Clean() {
+		t.Errorf("unexpected problems: %v", report.Problems)
+	}
+
+	image := make([]byte, 64*512)
+	if _, err := f.ReadAt(image, 0); err != nil {
+		t.Fatalf("error reading image: %v", err)
+	}
+	sector := func(n int) []byte { return image[n*512 : (n+1)*512] }
+	if !bytes.Equal(sector(0), sector(6)) {
+		t.Errorf("backup boot sector differs from boot sector")
+	}
+	if !bytes.Equal(image[2*512:2*512+len(extra)], extra) || !bytes.Equal(image[8*512:8*512+len(extra)], extra) {
+		t.Errorf("reserved sectors 2 and 3 or their backup at 8 and 9 do not hold what was written")
+	}
+	if !bytes.Equal(image[20*512:20*512+len(extra)], extra) {
+		t.Errorf("reserved sectors 20 and 21 do not hold what was written")
+	}
+	reserved := int(binary.LittleEndian.Uint16(sector(0)[14:16]))
+	sectorsPerFat := int(binary.LittleEndian.Uint32(sector(0)[36:40]))
+	fats := make([]byte, 512)
+	for _, fat := range []int{reserved, reserved + sectorsPerFat} {
+		if _, err := f.ReadAt(fats, int64(fat)*512); err != nil {
+			t.Fatalf("error reading FAT: %v", err)
+		}
+		if fats[0] != byte(fat32.Media35Inch) {
+			t.Errorf("FAT at sector %d has FAT ID %#x, expected media type %#x", fat, fats[0], fat32.Media35Inch)
+		}
+	}
+}
@@ -3,6 +3,7 @@ package fat32
 import (
 	"fmt"
 	"io"
+	"math"
 	"os"
 
 	"github.com/diskfs/go-diskfs/filesystem"
@@ -77,6 +78,86 @@ func (fl *File) GetDiskRanges() ([]DiskRange, error) {
 	return ranges, nil
 }
 
+// Preallocate ensures the File is at least size bytes long, allocating all of the clusters it needs now.
+// Any bytes added to the File are zero; a File larger than size is left as it is.
+//
+// If contiguous is true, all of the clusters of the File form a single contiguous run afterwards, moving the
+// existing contents if needed, or else an error is returned and the File is not changed. Together with
+// GetDiskRanges, this allows handing the location of the File on disk to firmware or bootloaders that cannot
+// follow a cluster chain. Note that clusters allocated later on by writing beyond the preallocated size
+// need not be contiguous.
+func (fl *File) Preallocate(size int64, contiguous bool) error {
+	if fl == nil || fl.filesystem == nil {
+		return os.ErrClosed
+	}
+	if !fl.isReadWrite {
+		return filesystem.ErrReadonlyFilesystem
+	}
+	if size > math.MaxUint32 {
+		return fmt.Errorf("cannot preallocate %d bytes, FAT32 files are limited to %d bytes", size, uint32(math.MaxUint32))
+	}
+	oldSize := int64(fl.fileSize)
+	if size < oldSize {
+		size = oldSize
+	}
+
+	fs := fl.filesystem
+	bytesPerCluster := int64(fs.bytesPerCluster)
+	clusters, err := fl.clusters()
+	if err != nil {
+		return fmt.Errorf("unable to get list of clusters for file: %v", err)
+	}
+	needed := int((size + bytesPerCluster - 1) / bytesPerCluster)
+	switch {
+	case contiguous:
+		if needed < len(clusters) {
+			needed = len(clusters)
+		}
+		if needed == 0 {
+			break
+		}
+		clusters, err = fs.makeContiguous(fl.directoryEntry, clusters, needed)
+		if err != nil {
+			return err
+		}
+	case needed > len(clusters):
+		clusters, err = fs.allocateSpace(uint64(size), fl.clusterLocation)
+		if err != nil {
+			return fmt.Errorf("unable to allocate clusters for file: %v", err)
+		}
+		fl.clusterLocation = clusters[0]
+	}
+
+	// zero out what the file grows by, so no stale data shows up in it
+	if size > oldSize {
+		writableFile, err := fs.backend.Writable()
+		if err != nil {
+			return err
+		}
+		zeroes := make([]byte, bytesPerCluster)
+		for offset := oldSize; offset < size; {
+			cluster := clusters[offset/bytesPerCluster]
+			remainder := offset % bytesPerCluster
+			toWrite := bytesPerCluster - remainder
+			if toWrite > size-offset {
+				toWrite = size - offset
+			}
+			diskOffset := int64(fs.dataStart) + int64(cluster-2)*bytesPerCluster + remainder
+			if _, err := writableFile.WriteAt(zeroes[:toWrite], diskOffset+fs.start); err != nil {
+				return fmt.Errorf("unable to zero out file: %v", err)
+			}
+			offset += toWrite
+		}
+		fl.fileSize = uint32(size)
+	}
+
+	// the size or the start cluster may have changed
+	if err := fs.writeDirectoryEntries(fl.parent); err != nil {
+		return fmt.Errorf("error writing directory entries to disk: %v", err)
+	}
+	return nil
+}
+
 // Read reads up to len(b) bytes from the File.
 // It returns the number of bytes read and any error encountered.
 // At end of file, Read returns 0, io.EOF
@@ -97,17 +178,18 @@ func (fl *File) Read(b []byte) (int, error) {
 	size := int(fl.fileSize) - int(fl.offset)
 	maxRead := size
 	file := fs.backend
-	clusters, err := fs.getClusterList(fl.clusterLocation)
-	if err != nil {
-		return totalRead, fmt.Errorf("unable to get list of clusters for file: %v", err)
-	}
-	clusterIndex := 0
 
 	// if there is nothing left to read, just return EOF
 	if size <= 0 {
 		return totalRead, io.EOF
 	}
 
+	clusters, err := fl.clusters()
+	if err != nil {
+		return totalRead, fmt.Errorf("unable to get list of clusters for file: %v", err)
+	}
+	clusterIndex := 0
+
 	// we stop when we hit the lesser of
 	//   1- len(b)
 	//   2- file end
@@ -124,8 +206,8 @@ func (fl *File) Read(b []byte) (int, error) {
 		if remainder != 0 {
 			offset := int64(start) + int64(lastCluster-2)*int64(bytesPerCluster) + remainder
 			toRead := int64(bytesPerCluster) - remainder
-			if toRead > int64(len(b)) {
-				toRead = int64(len(b))
+			if toRead > int64(maxRead) {
+				toRead = int64(maxRead)
 			}
 			_, _ = file.ReadAt(b[0:toRead], offset+fs.start)
 			totalRead += int(toRead)
@@ -184,10 +266,18 @@ func (fl *File) Write(p []byte) (int, error) {
 	if newSize < oldSize {
 		newSize = oldSize
 	}
-	// 1- ensure we have space and clusters
-	clusters, err := fs.allocateSpace(uint64(newSize), fl.clusterLocation)
+	// 1- ensure we have space and clusters; any clusters beyond those needed, e.g. from Preallocate, are kept
+	clusters, err := fl.clusters()
 	if err != nil {
-		return 0x00, fmt.Errorf("unable to allocate clusters for file: %v", err)
+		return 0x00, fmt.Errorf("unable to get list of clusters for file: %v", err)
+	}
+	if int64(len(clusters))*int64(fs.bytesPerCluster) < newSize {
+		clusters, err = fs.allocateSpace(uint64(newSize), fl.clusterLocation)
+		if err != nil {
+			return 0x00, fmt.Errorf("unable to allocate clusters for file: %v", err)
+		}
+		// a file that had no clusters at all starts a new chain
+		fl.clusterLocation = clusters[0]
 	}
 
 	// update the directory entry size for the file
@@ -246,6 +336,15 @@ func (fl *File) Write(p []byte) (int, error) {
 	return totalWritten, nil
 }
 
+// clusters returns the cluster chain of the File, which is empty for a File without a start cluster, as empty
+// files often are
+func (fl *File) clusters() ([]uint32, error) {
+	if fl.clusterLocation < 2 {
+		return nil, nil
+	}
+	return fl.filesystem.getClusterList(fl.clusterLocation)
+}
+
 // Seek set the offset to a particular point in the file
 func (fl *File) Seek(offset int64, whence int) (int64, error) {
 	if fl == nil || fl.filesystem == nil {
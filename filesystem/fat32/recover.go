@@ -0,0 +1,329 @@
+package fat32
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+)
+
+const (
+	// deletedEntryMarker is what the first byte of the short name of a deleted entry is replaced with
+	deletedEntryMarker byte = 0xe5
+	// kanjiLeadByte is stored in place of a first byte of 0xe5, so it is not taken for a deleted entry
+	kanjiLeadByte byte = 0x05
+	// unknownFirstChar stands in for the first character of a short name that could not be recovered
+	unknownFirstChar byte = '_'
+)
+
+// RecoveryConfidence is how likely it is that the contents of a deleted file still can be recovered
+type RecoveryConfidence int
+
+const (
+	// RecoveryUnlikely means the start cluster of the deleted entry is invalid or in use again,
+	// or there are not enough free clusters after it to hold the recorded size, so the contents most likely are gone
+	RecoveryUnlikely RecoveryConfidence = iota
+	// RecoveryPossible means that the free clusters from the start cluster onwards hold the recorded size,
+	// but only by skipping clusters that are in use, so the contents may be mixed up with those of other files
+	RecoveryPossible
+	// RecoveryLikely means that as many clusters as the recorded size needs, from the start cluster onwards,
+	// all are free, which is what is left behind when a file that was not fragmented is deleted
+	RecoveryLikely
+)
+
+func (c RecoveryConfidence) String() string {
+	switch c {
+	case RecoveryUnlikely:
+		return "unlikely"
+	case RecoveryPossible:
+		return "possible"
+	case RecoveryLikely:
+		return "likely"
+	}
+	return fmt.Sprintf("RecoveryConfidence(%d)", int(c))
+}
+
+// DeletedEntry is a directory entry that is marked as deleted, as found by ListDeleted
+type DeletedEntry struct {
+	// Path is the full path the entry would have if it were undeleted
+	Path string
+	// Name is the long filename if it could be recovered, and the short name otherwise
+	Name string
+	// ShortName is the 8.3 name. Deleting an entry overwrites the first character of it, which is recovered
+	// from the checksum in the long filename entries if they are intact, and replaced by '_' otherwise
+	ShortName string
+	// LongNameRecovered reports whether the long filename entries of the entry were intact
+	LongNameRecovered bool
+	IsDir             bool
+	// Size is the recorded size in bytes; it always is 0 for directories
+	Size         uint32
+	StartCluster uint32
+	ModTime      time.Time
+	// Clusters are the clusters that most likely held the contents, found by following the free clusters
+	// from the start cluster for the recorded size. A directory is assumed to have had just its start cluster.
+	Clusters   []uint32
+	Confidence RecoveryConfidence
+
+	// where the entry is: the start cluster of the directory holding it, and the offset of its short name entry
+	// in that directory, which has lfnSlots long filename entries right before it
+	dirCluster uint32
+	offset     int
+	lfnSlots   int
+	firstChar  byte
+	// the rest of the short name, to tell whether the slot still holds this entry
+	shortName [10]byte
+}
+
+// ListDeleted lists the entries marked as deleted in the directory at p, and in all of its subdirectories
+// if recursive is set. The subdirectories searched are the ones that still exist; deleted directories are
+// listed, but not searched.
+//
+// Nothing is changed on disk; use Undelete to restore any of the entries returned.
+func (fs *FileSystem) ListDeleted(p string, recursive bool) ([]DeletedEntry, error) {
+	dir, _, err := fs.readDirWithMkdir(p, false)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory entries for %s: %w", p, err)
+	}
+	return fs.listDeleted(dir.clusterLocation, p, recursive)
+}
+
+func (fs *FileSystem) listDeleted(dirCluster uint32, p string, recursive bool) ([]DeletedEntry, error) {
+	b, _, err := fs.readDirectoryBytes(dirCluster)
+	if err != nil {
+		return nil, fmt.Errorf("could not read directory %s: %w", p, err)
+	}
+	deleted := parseDeletedEntries(b)
+	list := make([]DeletedEntry, 0, len(deleted))
+	for _, d := range deleted {
+		d.Path = path.Join(p, d.Name)
+		d.dirCluster = dirCluster
+		count := 1
+		if !d.IsDir {
+			count = int((int64(d.Size) + int64(fs.bytesPerCluster) - 1) / int64(fs.bytesPerCluster))
+		}
+		d.Clusters, d.Confidence = fs.freeClustersFrom(d.StartCluster, count)
+		list = append(list, *d)
+	}
+	if !recursive {
+		return list, nil
+	}
+
+	entries, err := parseDirEntries(b)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse directory %s: %w", p, err)
+	}
+	for _, e := range entries {
+		if !e.isSubdirectory || e.isVolumeLabel || e.filenameShort == "." || e.filenameShort == ".." || e.clusterLocation < 2 {
+			continue
+		}
+		name := e.filenameLong
+		if name == "" {
+			name = e.displayShortName()
+		}
+		sub, err := fs.listDeleted(e.clusterLocation, path.Join(p, name), true)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, sub...)
+	}
+	return list, nil
+}
+
+// freeClustersFrom follows the free clusters from start onwards until it has found count of them,
+// and says how likely it is that they are the ones a deleted file of that many clusters used
+func (fs *FileSystem) freeClustersFrom(start uint32, count int) ([]uint32, RecoveryConfidence) {
+	t := &fs.table
+	if count == 0 {
+		return nil, RecoveryLikely
+	}
+	if start < 2 || start >= t.maxCluster || t.clusters[start] != t.unusedMarker {
+		return nil, RecoveryUnlikely
+	}
+	clusters := make([]uint32, 0, count)
+	skipped := false
+	for c := start; c < t.maxCluster && len(clusters) < count; c++ {
+		if t.clusters[c] != t.unusedMarker {
+			skipped = true
+			continue
+		}
+		clusters = append(clusters, c)
+	}
+	switch {
+	case len(clusters) < count:
+		return clusters, RecoveryUnlikely
+	case skipped:
+		return clusters, RecoveryPossible
+	}
+	return clusters, RecoveryLikely
+}
+
+// parseDeletedEntries finds the deleted entries in the raw contents of a directory, along with their long filenames
+// where the long filename entries before them are intact. Only the fields that come from the directory itself are set.
+func parseDeletedEntries(b []byte) []*DeletedEntry {
+	var (
+		deleted []*DeletedEntry
+		// offsets of the deleted long filename entries right before the current one
+		lfnOffsets []int
+	)
+	for i := 0; i+32 <= len(b); i += 32 {
+		if b[i] == 0x00 {
+			break
+		}
+		attr := b[i+11]
+		switch {
+		case b[i] != deletedEntryMarker:
+			lfnOffsets = nil
+			continue
+		case attr == 0x0f:
+			// a long filename entry belongs with the ones before it only if they have the same checksum
+			if len(lfnOffsets) > 0 && b[lfnOffsets[0]+13] != b[i+13] {
+				lfnOffsets = nil
+			}
+			lfnOffsets = append(lfnOffsets, i)
+			continue
+		case attr&0x08 == 0x08:
+			// deleted volume labels are of no interest
+			lfnOffsets = nil
+			continue
+		}
+
+		entry := directoryEntryFromBytes(b[i:i+32], "")
+		d := &DeletedEntry{
+			IsDir:        entry.isSubdirectory,
+			Size:         entry.fileSize,
+			StartCluster: entry.clusterLocation,
+			ModTime:      entry.modifyTime,
+			offset:       i,
+			firstChar:    unknownFirstChar,
+		}
+		copy(d.shortName[:], b[i+1:i+11])
+		if entry.isSubdirectory {
+			d.Size = 0
+		}
+
+		// the checksum in the long filename entries covers the whole short name, and each value of the
+		// first byte gives a different checksum, so the first byte can be found again
+		var lfn string
+		if len(lfnOffsets) > 0 {
+			raw := make([]byte, 11)
+			copy(raw, b[i:i+11])
+			checksum := b[lfnOffsets[0]+13]
+			for c := 0; c < 256; c++ {
+				raw[0] = byte(c)
+				if shortNameChecksum(raw) != checksum {
+					continue
+				}
+				if validShortNameCharacters.Contains(raw[0]) || raw[0] == kanjiLeadByte || raw[0] >= 0x80 {
+					d.firstChar = raw[0]
+				}
+				break
+			}
+			if d.firstChar != unknownFirstChar || raw[0] == unknownFirstChar {
+				lfn = deletedLongFilename(b, lfnOffsets)
+			}
+		}
+		if lfn != "" {
+			d.LongNameRecovered = true
+			d.lfnSlots = len(lfnOffsets)
+		}
+
+		first := d.firstChar
+		if first == kanjiLeadByte {
+			first = deletedEntryMarker
+		}
+		entry.filenameShort = strings.TrimRight(string(append([]byte{first}, d.shortName[:7]...)), " ")
+		d.ShortName = entry.displayShortName()
+		d.Name = lfn
+		if d.Name == "" {
+			d.Name = d.ShortName
+		}
+		deleted = append(deleted, d)
+		lfnOffsets = nil
+	}
+	return deleted
+}
+
+// deletedLongFilename puts together the long filename from its deleted entries, which come in reverse order
+func deletedLongFilename(b []byte, offsets []int) string {
+	var lfn string
+	for _, offset := range offsets {
+		tmp, err := longFilenameEntryFromBytes(b[offset : offset+32])
+		if err != nil {
+			return ""
+		}
+		lfn = tmp + lfn
+	}
+	return lfn
+}
+
+// Undelete restores an entry found by ListDeleted. The directory entry is marked as in use again, with its long
+// filename entries if they were recovered, and the clusters in d.Clusters are chained together as its contents.
+//
+// It returns an error without changing anything if the confidence of the entry is RecoveryUnlikely, if any of its
+// clusters no longer are free, if its directory entry has changed since it was listed, or if there already is an
+// entry with the same name. A restored directory has only its first cluster back.
+func (fs *FileSystem) Undelete(d DeletedEntry) error {
+	if d.dirCluster == 0 {
+		return fmt.Errorf("entry %s was not found by ListDeleted", d.Path)
+	}
+	if d.Confidence == RecoveryUnlikely {
+		return fmt.Errorf("cannot undelete %s: its contents most likely were overwritten", d.Path)
+	}
+	t := &fs.table
+	for _, c := range d.Clusters {
+		if c < 2 || c >= t.maxCluster || t.clusters[c] != t.unusedMarker {
+			return fmt.Errorf("cannot undelete %s: cluster %d no longer is free", d.Path, c)
+		}
+	}
+
+	b, clusterList, err := fs.readDirectoryBytes(d.dirCluster)
+	if err != nil {
+		return fmt.Errorf("could not read directory of %s: %w", d.Path, err)
+	}
+	if d.offset+32 > len(b) || b[d.offset] != deletedEntryMarker || string(b[d.offset+1:d.offset+11]) != string(d.shortName[:]) {
+		return fmt.Errorf("cannot undelete %s: its directory entry has changed", d.Path)
+	}
+	entries, err := parseDirEntries(b)
+	if err != nil {
+		return fmt.Errorf("could not parse directory of %s: %w", d.Path, err)
+	}
+	for _, e := range entries {
+		if strings.EqualFold(e.filenameLong, d.Name) || strings.EqualFold(e.displayShortName(), d.Name) ||
+			strings.EqualFold(e.displayShortName(), d.ShortName) {
+			return fmt.Errorf("cannot undelete %s: an entry with the same name exists", d.Path)
+		}
+	}
+
+	// restore the directory entries
+	b[d.offset] = d.firstChar
+	for i := 1; i <= d.lfnSlots; i++ {
+		seq := byte(i)
+		if i == d.lfnSlots {
+			seq |= 0x40
+		}
+		b[d.offset-32*i] = seq
+	}
+	writableFile, err := fs.backend.Writable()
+	if err != nil {
+		return err
+	}
+	first := (d.offset - 32*d.lfnSlots) / fs.bytesPerCluster
+	last := d.offset / fs.bytesPerCluster
+	for i := first; i <= last; i++ {
+		clusterStart := fs.start + int64(fs.dataStart) + int64(clusterList[i]-2)*int64(fs.bytesPerCluster)
+		if _, err := writableFile.WriteAt(b[i*fs.bytesPerCluster:(i+1)*fs.bytesPerCluster], clusterStart); err != nil {
+			return fmt.Errorf("unable to write directory entries for %s: %w", d.Path, err)
+		}
+	}
+
+	// and the chain of its contents
+	if len(d.Clusters) == 0 {
+		return nil
+	}
+	for i := 0; i < len(d.Clusters)-1; i++ {
+		t.set(d.Clusters[i], d.Clusters[i+1])
+	}
+	t.set(d.Clusters[len(d.Clusters)-1], t.eocMarker)
+	fs.adjustFreeCount(-len(d.Clusters))
+	return fs.syncFat()
+}
@@ -0,0 +1,221 @@
+package fat32_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+)
+
+// findEntry finds the short name entry with the given 11 bytes in the directory starting at dirCluster.
+// It returns the offsets of its long filename entries and then of itself, and its start cluster.
+func (l testFatLayout) findEntry(t *testing.T, f *os.File, dirCluster uint32, name string) (slots []int64, cluster uint32) {
+	t.Helper()
+	for cluster := dirCluster; cluster < 0x0ffffff8; {
+		b := make([]byte, l.bytesPerCluster)
+		start := l.dataStart + int64(cluster-2)*l.bytesPerCluster
+		if _, err := f.ReadAt(b, start); err != nil {
+			t.Fatalf("error reading directory cluster %d: %v", cluster, err)
+		}
+		for i := 0; i < len(b); i += 32 {
+			switch {
+			case b[i] == 0:
+				t.Fatalf("entry %s not found", name)
+			case b[i+11] == 0x0f:
+				slots = append(slots, start+int64(i))
+				continue
+			case string(b[i:i+11]) != name:
+				slots = nil
+				continue
+			}
+			return append(slots, start+int64(i)), uint32(binary.LittleEndian.Uint16(b[i+20:i+22]))<<16 | uint32(binary.LittleEndian.Uint16(b[i+26:i+28]))
+		}
+		next := make([]byte, 4)
+		if _, err := f.ReadAt(next, l.fatStart+int64(cluster)*4); err != nil {
+			t.Fatalf("error reading FAT entry: %v", err)
+		}
+		cluster = binary.LittleEndian.Uint32(next) & 0x0fffffff
+	}
+	t.Fatalf("entry %s not found", name)
+	return nil, 0
+}
+
+// deleteEntry marks an entry as deleted along with its long filename entries, the way a FAT driver does,
+// but leaves its clusters allocated. It returns the offset of the short name entry.
+func (l testFatLayout) deleteEntry(t *testing.T, f *os.File, dirCluster uint32, name string) int64 {
+	t.Helper()
+	slots, _ := l.findEntry(t, f, dirCluster, name)
+	for _, slot := range slots {
+		if _, err := f.WriteAt([]byte{0xe5}, slot); err != nil {
+			t.Fatalf("error writing directory entry: %v", err)
+		}
+	}
+	return slots[len(slots)-1]
+}
+
+func TestFat32Undelete(t *testing.T) {
+	f, err := tmpFat32(false, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keepTmpFiles == "" {
+		defer os.Remove(f.Name())
+	} else {
+		fmt.Println(f.Name())
+	}
+	fileInfo, err := f.Stat()
+	if err != nil {
+		t.Fatalf("error getting file info for tmpfile %s: %v", f.Name(), err)
+	}
+	fs, err := fat32.Create(file.New(f, false), fileInfo.Size(), 0, 512, "go-diskfs")
+	if err != nil {
+		t.Fatalf("error creating fat32 filesystem: %v", err)
+	}
+	contents := make(map[string][]byte)
+	appendFile := func(p string, data []byte) {
+		t.Helper()
+		rw, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR|os.O_APPEND)
+		if err != nil {
+			t.Fatalf("error opening %s: %v", p, err)
+		}
+		if _, err := rw.Write(data); err != nil {
+			t.Fatalf("error writing %s: %v", p, err)
+		}
+		contents[p] = append(contents[p], data...)
+	}
+	if err := fs.Mkdir("/sub"); err != nil {
+		t.Fatalf("error making directory: %v", err)
+	}
+	appendFile("/Recoverable File.txt", bytes.Repeat([]byte("recover me "), 500))
+	appendFile("/sub/NESTED.BIN", bytes.Repeat([]byte{7}, 3000))
+	appendFile("/live", []byte("still here"))
+	appendFile("/gone.dat", bytes.Repeat([]byte{9}, 2000))
+	// a file that is fragmented around another one
+	for i := 0; i < 3; i++ {
+		appendFile("/fragmented", bytes.Repeat([]byte{byte(1 + i)}, 1000))
+		appendFile("/between", bytes.Repeat([]byte{byte(10 + i)}, 1000))
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatalf("error closing filesystem: %v", err)
+	}
+
+	// delete behind the back of the filesystem, then have the checker free the clusters
+	layout := testReadFatLayout(t, f)
+	const rootCluster = 2
+	_, subCluster := layout.findEntry(t, f, rootCluster, "SUB        ")
+	_, liveCluster := layout.findEntry(t, f, rootCluster, "LIVE       ")
+	layout.deleteEntry(t, f, rootCluster, "RECOVE~1TXT")
+	layout.deleteEntry(t, f, rootCluster, "FRAGME~1   ")
+	layout.deleteEntry(t, f, subCluster, "NESTED  BIN")
+	// the clusters of this one since were reused for another file
+	gone := layout.deleteEntry(t, f, rootCluster, "GONE    DAT")
+	reused := make([]byte, 4)
+	binary.LittleEndian.PutUint16(reused[0:2], uint16(liveCluster>>16))
+	binary.LittleEndian.PutUint16(reused[2:4], uint16(liveCluster))
+	if _, err := f.WriteAt(reused[0:2], gone+20); err != nil {
+		t.Fatalf("error writing directory entry: %v", err)
+	}
+	if _, err := f.WriteAt(reused[2:4], gone+26); err != nil {
+		t.Fatalf("error writing directory entry: %v", err)
+	}
+	fs, err = fat32.Read(file.New(f, false), fileInfo.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading fat32 filesystem: %v", err)
+	}
+	if _, err := fs.Check(true); err != nil {
+		t.Fatalf("error repairing filesystem: %v", err)
+	}
+
+	deleted, err := fs.ListDeleted("/", true)
+	if err != nil {
+		t.Fatalf("error listing deleted entries: %v", err)
+	}
+	byPath := make(map[string]fat32.DeletedEntry)
+	for _, d := range deleted {
+		byPath[d.Path] = d
+	}
+	expected := map[string]struct {
+		shortName  string
+		lfn        bool
+		confidence fat32.RecoveryConfidence
+	}{
+		"/Recoverable File.txt": {"RECOVE~1.TXT", true, fat32.RecoveryLikely},
+		"/fragmented":           {"FRAGME~1", true, fat32.RecoveryPossible},
+		"/_one.dat":             {"_one.dat", false, fat32.RecoveryUnlikely},
+		"/sub/_ESTED.BIN":       {"_ESTED.BIN", false, fat32.RecoveryLikely},
+	}
+	for p, e := range expected {
+		d, ok := byPath[p]
+		switch {
+		case !ok:
+			t.Errorf("deleted entry %s not found in %v", p, deleted)
+		case d.ShortName != e.shortName:
+			t.Errorf("%s: short name %s, expected %s", p, d.ShortName, e.shortName)
+		case d.LongNameRecovered != e.lfn:
+			t.Errorf("%s: long name recovered %v, expected %v", p, d.LongNameRecovered, e.lfn)
+		case d.Confidence != e.confidence:
+			t.Errorf("%s: confidence %v, expected %v", p, d.Confidence, e.confidence)
+		case d.IsDir:
+			t.Errorf("%s: listed as a directory", p)
+		}
+	}
+	if len(deleted) != len(expected) {
+		t.Errorf("found %d deleted entries, expected %d: %v", len(deleted), len(expected), deleted)
+	}
+	nonRecursive, err := fs.ListDeleted("/", false)
+	if err != nil {
+		t.Fatalf("error listing deleted entries: %v", err)
+	}
+	if len(nonRecursive) != len(expected)-1 {
+		t.Errorf("found %d deleted entries in the root directory, expected %d", len(nonRecursive), len(expected)-1)
+	}
+
+	if err := fs.Undelete(byPath["/_one.dat"]); err == nil {
+		t.Errorf("undeleting an overwritten file did not return an error")
+	}
+	if err := fs.Undelete(fat32.DeletedEntry{Path: "/made-up"}); err == nil {
+		t.Errorf("undeleting an entry not found by ListDeleted did not return an error")
+	}
+	for _, p := range []string{"/Recoverable File.txt", "/fragmented", "/sub/_ESTED.BIN"} {
+		if err := fs.Undelete(byPath[p]); err != nil {
+			t.Fatalf("error undeleting %s: %v", p, err)
+		}
+	}
+	contents["/sub/_ESTED.BIN"] = contents["/sub/NESTED.BIN"]
+	delete(contents, "/sub/NESTED.BIN")
+	delete(contents, "/gone.dat")
+
+	// everything must be consistent on disk
+	if err := fs.Close(); err != nil {
+		t.Fatalf("error closing filesystem: %v", err)
+	}
+	fs, err = fat32.Read(file.New(f, false), fileInfo.Size(), 0, 512)
+	if err != nil {
+		t.Fatalf("error reading fat32 filesystem: %v", err)
+	}
+	report, err := fs.Check(false)
+	if err != nil {
+		t.Fatalf("error checking filesystem: %v", err)
+	}
+	if !report.Clean() {
+		t.Errorf("unexpected problems after undelete: %v", report.Problems)
+	}
+	for p, data := range contents {
+		rw, err := fs.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("error opening %s: %v", p, err)
+		}
+		b, err := io.ReadAll(rw)
+		if err != nil {
+			t.Fatalf("error reading %s: %v", p, err)
+		}
+		if !bytes.Equal(b, data) {
+			t.Errorf("mismatched contents of %s", p)
+		}
+	}
+}
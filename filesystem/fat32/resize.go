@@ -0,0 +1,277 @@
+package fat32
+
+import (
+	"errors"
+	"fmt"
+)
+
+// resizeCopyChunk is how much data is moved at a time when relocating the data region
+const resizeCopyChunk = 4 * 1024 * 1024
+
+// Resize changes the size of the filesystem to newSize bytes, in place.
+//
+// The cluster size is kept as it is. The FATs are sized the same way Create would for a filesystem of newSize,
+// so when they grow or shrink, the data region moves along with them.
+//
+// Growing requires that the underlying storage, e.g. the partition, already is at least newSize bytes.
+// Shrinking first moves all clusters in use beyond the new end of the filesystem into free clusters before it,
+// rewriting the cluster chains, the start clusters in directory entries that point at them and the root directory
+// cluster in the boot sector; it returns an error without changing anything if there are not enough free clusters
+// to do so.
+//
+// Resize is not crash safe: if it is interrupted, the filesystem may be left inconsistent.
+func (fs *FileSystem) Resize(newSize int64) error {
+	if newSize > Fat32MaxSize {
+		return fmt.Errorf("requested size is larger than maximum allowed FAT32, requested %d, maximum %d", newSize, Fat32MaxSize)
+	}
+	if newSize < int64(SectorSize512)*4 {
+		return fmt.Errorf("requested size is smaller than minimum allowed FAT32, requested %d minimum %d", newSize, int64(SectorSize512)*4)
+	}
+
+	dos20bpb := fs.bootSector.biosParameterBlock.dos331BPB.dos20BPB
+	reservedSectors := uint32(dos20bpb.reservedSectors)
+	sectorsPerCluster := uint32(dos20bpb.sectorsPerCluster)
+	fatCount := uint32(dos20bpb.fatCount)
+	if fatCount == 0 {
+		fatCount = 2
+	}
+
+	// the same calculations as in Create, keeping the cluster size we have
+	totalSectors := uint32(newSize / int64(SectorSize512))
+	if totalSectors <= reservedSectors {
+		return fmt.Errorf("requested size %d leaves no room beyond the %d reserved sectors", newSize, reservedSectors)
+	}
+	totalClusters := (totalSectors - reservedSectors) / sectorsPerCluster
+	sectorsPerFat := totalClusters / 128
+	if sectorsPerFat == 0 {
+		return fmt.Errorf("requested size %d is too small to hold a FAT", newSize)
+	}
+	fatSize := sectorsPerFat * uint32(SectorSize512)
+	maxCluster := fatSize / 4
+	dataStart := (reservedSectors + fatCount*sectorsPerFat) * uint32(SectorSize512)
+	if totalSectors*uint32(SectorSize512) <= dataStart {
+		return fmt.Errorf("requested size %d leaves no room for data", newSize)
+	}
+	// clusters from limit onwards are beyond the end of the new filesystem
+	limit := (totalSectors*uint32(SectorSize512)-dataStart)/(sectorsPerCluster*uint32(SectorSize512)) + 2
+	if limit > maxCluster {
+		limit = maxCluster
+	}
+
+	// the highest cluster in use limits how much data ever needs to be moved
+	var highest uint32
+	for i := uint32(2); i < fs.table.maxCluster; i++ {
+		if fs.table.clusters[i] != fs.table.unusedMarker {
+			highest = i
+		}
+	}
+
+	if highest >= limit {
+		if err := fs.relocateClusters(limit); err != nil {
+			return err
+		}
+		highest = limit - 1
+	}
+
+	// move the data region to where it starts now
+	if dataStart != fs.dataStart && highest >= 2 {
+		length := int64(highest-1) * int64(fs.bytesPerCluster)
+		if err := fs.moveData(int64(fs.dataStart), int64(dataStart), length); err != nil {
+			return fmt.Errorf("unable to move data region: %w", err)
+		}
+	}
+
+	// resize the FAT itself
+	keep := limit
+	if keep > fs.table.maxCluster {
+		keep = fs.table.maxCluster
+	}
+	clusters := make([]uint32, maxCluster+1)
+	copy(clusters[:keep], fs.table.clusters[:keep])
+	fs.table.clusters = clusters
+	fs.table.maxCluster = maxCluster
+	fs.table.size = fatSize
+	// the whole FAT is written below, and the free clusters are found anew
+	fs.table.free = nil
+	fs.dataStart = dataStart
+	fs.size = newSize
+
+	bpb := fs.bootSector.biosParameterBlock
+	bpb.sectorsPerFat = sectorsPerFat
+	bpb.dos331BPB.totalSectors = totalSectors
+	// FAT32 always uses the 32-bit count
+	dos20bpb.totalSectors = 0
+
+	if fs.fsis.freeDataClustersCount != unknownFreeDataClusterCount {
+		fs.fsis.freeDataClustersCount = fs.table.countFree()
+	}
+	if hint := fs.fsis.lastAllocatedCluster; hint != unknownlastAllocatedCluster && hint >= maxCluster {
+		fs.fsis.lastAllocatedCluster = unknownlastAllocatedCluster
+	}
+
+	if err := fs.writeBootSector(); err != nil {
+		return fmt.Errorf("failed to write the boot sector: %w", err)
+	}
+	if err := fs.writeFsis(); err != nil {
+		return fmt.Errorf("failed to write the file system information sector: %w", err)
+	}
+	fs.fsisDirty = false
+	if err := fs.writeFat(); err != nil {
+		return fmt.Errorf("failed to write the file allocation table: %w", err)
+	}
+	return nil
+}
+
+// relocateClusters moves every cluster in use at or beyond limit to a free cluster before it,
+// and rewrites the FAT and all directory entries to match. Nothing is changed if there is not enough room.
+func (fs *FileSystem) relocateClusters(limit uint32) error {
+	t := &fs.table
+	remap := make(map[uint32]uint32)
+	next := uint32(2)
+	for i := limit; i < t.maxCluster; i++ {
+		value := t.clusters[i]
+		// bad clusters past the end simply are not part of the filesystem anymore
+		if value == t.unusedMarker || value&0x0fffffff == badCluster {
+			continue
+		}
+		for next < limit && t.clusters[next] != t.unusedMarker {
+			next++
+		}
+		if next >= limit {
+			return errors.New("not enough free space to shrink the filesystem to the requested size")
+		}
+		remap[i] = next
+		next++
+	}
+
+	// read the directory tree before any of it moves
+	dirs, err := fs.allDirectories()
+	if err != nil {
+		return err
+	}
+
+	// copy the data over
+	writableFile, err := fs.backend.Writable()
+	if err != nil {
+		return err
+	}
+	b := make([]byte, fs.bytesPerCluster)
+	for from, to := range remap {
+		if _, err := fs.backend.ReadAt(b, fs.start+int64(fs.dataStart)+int64(from-2)*int64(fs.bytesPerCluster)); err != nil {
+			return fmt.Errorf("unable to read cluster %d: %w", from, err)
+		}
+		if _, err := writableFile.WriteAt(b, fs.start+int64(fs.dataStart)+int64(to-2)*int64(fs.bytesPerCluster)); err != nil {
+			return fmt.Errorf("unable to write cluster %d: %w", to, err)
+		}
+	}
+
+	// rewrite the chains
+	for from, to := range remap {
+		t.set(to, t.clusters[from])
+		t.set(from, t.unusedMarker)
+	}
+	for i := uint32(2); i < t.maxCluster; i++ {
+		if to, ok := remap[t.clusters[i]&0x0fffffff]; ok && !t.isEoc(t.clusters[i]) {
+			t.set(i, to)
+		}
+	}
+
+	// the root directory is found through the boot sector, which Resize writes along with its backup
+	if to, ok := remap[t.rootDirCluster]; ok {
+		t.rootDirCluster = to
+		fs.bootSector.biosParameterBlock.rootDirectoryCluster = to
+	}
+
+	// and finally the start clusters of everything that moved, including the . and .. entries of directories
+	for _, dir := range dirs {
+		changed := false
+		if to, ok := remap[dir.clusterLocation]; ok {
+			dir.clusterLocation = to
+		}
+		for _, e := range dir.entries {
+			if to, ok := remap[e.clusterLocation]; ok {
+				e.clusterLocation = to
+				changed = true
+			}
+		}
+		if !changed {
+			continue
+		}
+		if err := fs.writeDirectoryEntries(dir); err != nil {
+			return fmt.Errorf("error writing directory entries to disk: %w", err)
+		}
+	}
+	return nil
+}
+
+// allDirectories reads every directory in the filesystem, starting with the root directory
+func (fs *FileSystem) allDirectories() ([]*Directory, error) {
+	root := &Directory{
+		directoryEntry: directoryEntry{
+			clusterLocation: fs.table.rootDirCluster,
+			isSubdirectory:  true,
+			filesystem:      fs,
+		},
+	}
+	dirs := []*Directory{root}
+	for i := 0; i < len(dirs); i++ {
+		entries, err := fs.readDirectory(dirs[i])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read directory at cluster %d: %w", dirs[i].clusterLocation, err)
+		}
+		for _, e := range entries {
+			if !e.isSubdirectory || e.isVolumeLabel || e.filenameShort == "." || e.filenameShort == ".." || e.clusterLocation < 2 {
+				continue
+			}
+			dirs = append(dirs, &Directory{
+				directoryEntry: directoryEntry{
+					clusterLocation: e.clusterLocation,
+					isSubdirectory:  true,
+					filesystem:      fs,
+				},
+			})
+		}
+	}
+	return dirs, nil
+}
+
+// moveData moves length bytes of the data region from one offset in the filesystem to another.
+// The two ranges may overlap.
+func (fs *FileSystem) moveData(from, to, length int64) error {
+	writableFile, err := fs.backend.Writable()
+	if err != nil {
+		return err
+	}
+	b := make([]byte, resizeCopyChunk)
+	copyChunk := func(offset, size int64) error {
+		if _, err := fs.backend.ReadAt(b[:size], fs.start+from+offset); err != nil {
+			return err
+		}
+		_, err := writableFile.WriteAt(b[:size], fs.start+to+offset)
+		return err
+	}
+	if to < from {
+		// moving down, so go from the front, to never overwrite what is still to be read
+		for offset := int64(0); offset < length; offset += resizeCopyChunk {
+			size := length - offset
+			if size > resizeCopyChunk {
+				size = resizeCopyChunk
+			}
+			if err := copyChunk(offset, size); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	// moving up, so go from the back
+	for end := length; end > 0; end -= resizeCopyChunk {
+		offset := end - resizeCopyChunk
+		if offset < 0 {
+			offset = 0
+		}
+		if err := copyChunk(offset, end-offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
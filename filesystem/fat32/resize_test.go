@@ -0,0 +1,226 @@
+package fat32_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+)
+
+func TestFat32Resize(t *testing.T) {
+	f, err := os.CreateTemp("", "fat32_test")
+	if err != nil {
+		t.Fatalf("failed to create tempfile: %v", err)
+	}
+	if keepTmpFiles == "" {
+		defer os.Remove(f.Name())
+	} else {
+		fmt.Println(f.Name())
+	}
+	if err := f.Truncate(40 * fat32.MB); err != nil {
+		t.Fatalf("failed to size tempfile: %v", err)
+	}
+	b := file.New(f, false)
+
+	fs, err := fat32.Create(b, 10*fat32.MB, 0, 512, "go-diskfs")
+	if err != nil {
+		t.Fatalf("error creating fat32 filesystem: %v", err)
+	}
+	contents := make(map[string][]byte)
+	writeFile := func(p string, size int) {
+		t.Helper()
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(len(contents) + i/512)
+		}
+		rw, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("error creating %s: %v", p, err)
+		}
+		if _, err := rw.Write(data); err != nil {
+			t.Fatalf("error writing %s: %v", p, err)
+		}
+		contents[p] = data
+	}
+	verify := func(size int64) {
+		t.Helper()
+		if err := fs.Close(); err != nil {
+			t.Fatalf("error closing filesystem: %v", err)
+		}
+		fs, err = fat32.Read(b, size, 0, 512)
+		if err != nil {
+			t.Fatalf("error reading fat32 filesystem: %v", err)
+		}
+		report, err := fs.Check(false)
+		if err != nil {
+			t.Fatalf("error checking filesystem: %v", err)
+		}
+		if !report.Clean() {
+			t.Errorf("unexpected problems after resize: %v", report.Problems)
+		}
+		for p, data := range contents {
+			rw, err := fs.OpenFile(p, os.O_RDONLY)
+			if err != nil {
+				t.Fatalf("error opening %s: %v", p, err)
+			}
+			read, err := io.ReadAll(rw)
+			if err != nil {
+				t.Fatalf("error reading %s: %v", p, err)
+			}
+			if !bytes.Equal(read, data) {
+				t.Errorf("mismatched contents of %s", p)
+			}
+		}
+	}
+
+	if err := fs.Mkdir("/a"); err != nil {
+		t.Fatalf("error making directory: %v", err)
+	}
+	writeFile("/a/small", 1000)
+	writeFile("/medium", 100*1024)
+
+	t.Run("grow", func(t *testing.T) {
+		if err := fs.Resize(40 * fat32.MB); err != nil {
+			t.Fatalf("error growing filesystem: %v", err)
+		}
+		verify(40 * fat32.MB)
+		// the new space must be usable
+		writeFile("/big1", 12*int(fat32.MB))
+		if err := fs.Mkdir("/c/d"); err != nil {
+			t.Fatalf("error making directory: %v", err)
+		}
+		writeFile("/c/d/big2", 6*int(fat32.MB))
+		writeFile("/c/small", 5000)
+		verify(40 * fat32.MB)
+	})
+	t.Run("shrink too far", func(t *testing.T) {
+		if err := fs.Resize(10 * fat32.MB); err == nil {
+			t.Fatalf("shrinking below the space in use did not return an error")
+		}
+		verify(40 * fat32.MB)
+	})
+	t.Run("shrink", func(t *testing.T) {
+		if err := fs.Remove("/big1"); err != nil {
+			t.Fatalf("error removing file: %v", err)
+		}
+		delete(contents, "/big1")
+		if err := fs.Resize(9 * fat32.MB); err != nil {
+			t.Fatalf("error shrinking filesystem: %v", err)
+		}
+		verify(9 * fat32.MB)
+		if _, err := fs.ReadDir("/c/d"); err != nil {
+			t.Errorf("error reading relocated directory: %v", err)
+		}
+	})
+}
+
+func TestFat32ResizeRootDirectory(t *testing.T) {
+	f, err := os.CreateTemp("", "fat32_test")
+	if err != nil {
+		t.Fatalf("failed to create tempfile: %v", err)
+	}
+	if keepTmpFiles == "" {
+		defer os.Remove(f.Name())
+	} else {
+		fmt.Println(f.Name())
+	}
+	size := 10 * fat32.MB
+	if err := f.Truncate(size); err != nil {
+		t.Fatalf("failed to size tempfile: %v", err)
+	}
+	b := file.New(f, false)
+	fs, err := fat32.Create(b, size, 0, 512, "go-diskfs")
+	if err != nil {
+		t.Fatalf("error creating fat32 filesystem: %v", err)
+	}
+	if err := fs.Mkdir("/a"); err != nil {
+		t.Fatalf("error making directory: %v", err)
+	}
+	data := bytes.Repeat([]byte{1}, 5000)
+	rw, err := fs.OpenFile("/a/file", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("error creating /a/file: %v", err)
+	}
+	if _, err := rw.Write(data); err != nil {
+		t.Fatalf("error writing /a/file: %v", err)
+	}
+	if err := fs.Close(); err != nil {
+		t.Fatalf("error closing filesystem: %v", err)
+	}
+
+	// move the root directory, of a single cluster, to near the end of the filesystem, as other tools may put it
+	layout := testReadFatLayout(t, f)
+	root := uint32((size-layout.dataStart)/layout.bytesPerCluster) - 5
+	cluster := make([]byte, layout.bytesPerCluster)
+	if _, err := f.ReadAt(cluster, layout.dataStart); err != nil {
+		t.Fatalf("error reading root directory: %v", err)
+	}
+	if _, err := f.WriteAt(cluster, layout.dataStart+int64(root-2)*layout.bytesPerCluster); err != nil {
+		t.Fatalf("error writing root directory: %v", err)
+	}
+	layout.setFatEntry(t, f, root, 0x0fffffff, 0, 1)
+	layout.setFatEntry(t, f, 2, 0, 0, 1)
+	rootCluster := make([]byte, 4)
+	binary.LittleEndian.PutUint32(rootCluster, root)
+	for _, sector := range []int64{0, 6} {
+		if _, err := f.WriteAt(rootCluster, sector*512+44); err != nil {
+			t.Fatalf("error writing root directory cluster to boot sector: %v", err)
+		}
+	}
+
+	check := func(size int64) {
+		t.Helper()
+		fs, err := fat32.Read(b, size, 0, 512)
+		if err != nil {
+			t.Fatalf("error reading fat32 filesystem: %v", err)
+		}
+		report, err := fs.Check(false)
+		if err != nil {
+			t.Fatalf("error checking filesystem: %v", err)
+		}
+		if !report.Clean() {
+			t.Errorf("unexpected problems: %v", report.Problems)
+		}
+		rw, err := fs.OpenFile("/a/file", os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("error opening /a/file: %v", err)
+		}
+		read, err := io.ReadAll(rw)
+		if err != nil {
+			t.Fatalf("error reading /a/file: %v", err)
+		}
+		if !bytes.Equal(read, data) {
+			t.Errorf("mismatched contents of /a/file")
+		}
+	}
+	check(size)
+
+	fs, err = fat32.Read(b, size, 0, 512)
+	if err != nil {
+		t.Fatalf("error reading fat32 filesystem: %v", err)
+	}
+	size = 5 * fat32.MB
+	if err := fs.Resize(size); err != nil {
+		t.Fatalf("error shrinking filesystem: %v", err)
+	}
+	check(size)
+
+	// the boot sector and its backup both point at the relocated root directory
+	sectors := make([]byte, 7*512)
+	if _, err := f.ReadAt(sectors, 0); err != nil {
+		t.Fatalf("error reading boot sectors: %v", err)
+	}
+	moved := binary.LittleEndian.Uint32(sectors[44:48])
+	if backup := binary.LittleEndian.Uint32(sectors[6*512+44 : 6*512+48]); backup != moved {
+		t.Errorf("backup boot sector has root directory cluster %d, expected %d", backup, moved)
+	}
+	layout = testReadFatLayout(t, f)
+	if end := layout.dataStart + int64(moved-1)*layout.bytesPerCluster; moved == root || end > size {
+		t.Errorf("root directory at cluster %d is not within the shrunk filesystem", moved)
+	}
+}
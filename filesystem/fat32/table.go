@@ -2,9 +2,17 @@ package fat32
 
 import (
 	"encoding/binary"
+	"math/bits"
+
 	"golang.org/x/exp/slices"
 )
 
+// badCluster is the FAT entry value that marks a bad cluster
+const badCluster uint32 = 0x0ffffff7
+
+// entriesPerSector is how many cluster entries fit in one sector of the FAT
+const entriesPerSector = uint32(SectorSize512) / 4
+
 // table a FAT32 table
 type table struct {
 	fatID          uint32
@@ -14,6 +22,10 @@ type table struct {
 	rootDirCluster uint32
 	size           uint32
 	maxCluster     uint32
+	// dirty holds the sectors of the FAT that changed since it last was written to disk
+	dirty map[uint32]bool
+	// free has a bit set for every free cluster; it is built when first needed
+	free []uint64
 }
 
 func (t *table) equal(a *table) bool {
@@ -45,7 +57,7 @@ func tableFromBytes(b []byte) *table {
 		size:           uint32(len(b)),
 		clusters:       make([]uint32, maxCluster+1),
 		maxCluster:     maxCluster,
-		rootDirCluster: 2, // always 2 for FAT32
+		rootDirCluster: 2, // normally 2, Read takes it from the boot sector
 	}
 	// just need to map the clusters in
 	for i := uint32(2); i < t.maxCluster; i++ {
@@ -80,6 +92,132 @@ func (t *table) bytes() []byte {
 	return b
 }
 
+// countFree counts the free clusters in the table
+func (t *table) countFree() uint32 {
+	var free uint32
+	for i := uint32(2); i < t.maxCluster; i++ {
+		if t.clusters[i] == t.unusedMarker {
+			free++
+		}
+	}
+	return free
+}
+
+// set changes the entry for a cluster, keeping track of what needs to be written to disk
+func (t *table) set(cluster, value uint32) {
+	t.clusters[cluster] = value
+	if t.dirty == nil {
+		t.dirty = make(map[uint32]bool)
+	}
+	t.dirty[cluster/entriesPerSector] = true
+	if t.free == nil {
+		return
+	}
+	if value == t.unusedMarker {
+		t.free[cluster/64] |= 1 << (cluster % 64)
+	} else {
+		t.free[cluster/64] &^= 1 << (cluster % 64)
+	}
+}
+
+// setFatID changes the FAT ID, which is kept in the first entry of the FAT
+func (t *table) setFatID(fatID uint32) {
+	t.fatID = fatID
+	if t.dirty == nil {
+		t.dirty = make(map[uint32]bool)
+	}
+	t.dirty[0] = true
+}
+
+// nextFree returns the first free cluster after the given one, wrapping around at the end of the table,
+// and false if there is no free cluster at all
+func (t *table) nextFree(after uint32) (uint32, bool) {
+	if t.free == nil {
+		t.free = make([]uint64, t.maxCluster/64+1)
+		for i := uint32(2); i < t.maxCluster; i++ {
+			if t.clusters[i] == t.unusedMarker {
+				t.free[i/64] |= 1 << (i % 64)
+			}
+		}
+	}
+	start := after + 1
+	if start < 2 || start >= t.maxCluster {
+		start = 2
+	}
+	if cluster, ok := t.findFree(start, t.maxCluster); ok {
+		return cluster, true
+	}
+	return t.findFree(2, start)
+}
+
+// findFree returns the first free cluster in the range [from, to)
+func (t *table) findFree(from, to uint32) (uint32, bool) {
+	for word := from / 64; word <= (to-1)/64 && from < to; word++ {
+		w := t.free[word]
+		if word == from/64 {
+			// ignore the clusters before from in the first word
+			w &^= 1<<(from%64) - 1
+		}
+		if w == 0 {
+			continue
+		}
+		cluster := word*64 + uint32(bits.TrailingZeros64(w))
+		if cluster >= to {
+			return 0, false
+		}
+		return cluster, true
+	}
+	return 0, false
+}
+
+// freeRun returns the first cluster of the first run of count free clusters, and false if there is none
+func (t *table) freeRun(count uint32) (uint32, bool) {
+	var start, length uint32
+	for i := uint32(2); i < t.maxCluster; i++ {
+		if t.clusters[i] != t.unusedMarker {
+			length = 0
+			continue
+		}
+		if length == 0 {
+			start = i
+		}
+		length++
+		if length == count {
+			return start, true
+		}
+	}
+	return 0, false
+}
+
+// dirtySectors returns the sectors of the FAT that need to be written to disk, in order
+func (t *table) dirtySectors() []uint32 {
+	sectors := make([]uint32, 0, len(t.dirty))
+	for sector := range t.dirty {
+		sectors = append(sectors, sector)
+	}
+	slices.Sort(sectors)
+	return sectors
+}
+
+// sectorBytes returns one sector of the FAT as bytes ready to be written to disk
+func (t *table) sectorBytes(sector uint32) []byte {
+	b := make([]byte, SectorSize512)
+	first := sector * entriesPerSector
+	for i := uint32(0); i < entriesPerSector; i++ {
+		var val uint32
+		switch cluster := first + i; {
+		case cluster == 0:
+			val = t.fatID
+		case cluster == 1:
+			val = t.eocMarker
+		case cluster < t.maxCluster:
+			val = t.clusters[cluster]
+		}
+		binary.LittleEndian.PutUint32(b[i*4:i*4+4], val)
+	}
+	return b
+}
+
 func (t *table) isEoc(cluster uint32) bool {
 	return cluster&0xFFFFFF8 == 0xFFFFFF8
 }
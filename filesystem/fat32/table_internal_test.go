@@ -91,3 +91,56 @@ func TestFat32TableIsEoc(t *testing.T) {
 		}
 	}
 }
+
+func TestFat32TableNextFree(t *testing.T) {
+	tab := &table{
+		maxCluster: 200,
+		clusters:   make([]uint32, 201),
+		eocMarker:  eoc,
+	}
+	for i := uint32(2); i < 200; i++ {
+		if i != 5 && i != 70 && i != 130 {
+			tab.clusters[i] = eoc
+		}
+	}
+	tests := []struct {
+		after   uint32
+		cluster uint32
+	}{
+		{0, 5},
+		{0xffffffff, 5},
+		{4, 5},
+		{5, 70},
+		{69, 70},
+		{70, 130},
+		{130, 5},
+		{199, 5},
+	}
+	for _, tt := range tests {
+		cluster, ok := tab.nextFree(tt.after)
+		if !ok || cluster != tt.cluster {
+			t.Errorf("nextFree(%d): actual %d %t, expected %d", tt.after, cluster, ok, tt.cluster)
+		}
+	}
+
+	// the free map follows changes
+	tab.set(70, eoc)
+	tab.set(100, tab.unusedMarker)
+	if cluster, _ := tab.nextFree(5); cluster != 100 {
+		t.Errorf("nextFree(5) after changes: actual %d, expected 100", cluster)
+	}
+	tab.set(5, eoc)
+	tab.set(100, eoc)
+	tab.set(130, eoc)
+	if cluster, ok := tab.nextFree(0); ok {
+		t.Errorf("nextFree(0) on full table: unexpected free cluster %d", cluster)
+	}
+
+	// and so do the dirty sectors
+	if sectors := tab.dirtySectors(); !slices.Equal(sectors, []uint32{0, 1}) {
+		t.Errorf("dirty sectors %v, expected [0 1]", sectors)
+	}
+	if b := tab.sectorBytes(1); !bytes.Equal(b[(130-128)*4:(130-128)*4+4], []byte{0xff, 0xff, 0xff, 0x0f}) {
+		t.Errorf("sector bytes for cluster 130 were % x", b[(130-128)*4:(130-128)*4+4])
+	}
+}
@@ -0,0 +1,326 @@
+package iso9660
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Builder builds an ISO9660 image out of a tree of files and directories, without a workspace. The files can be
+// in memory, in an fs.FS, or on the host, where they are read from when the image is written, rather than copied
+// anywhere first. The image is written in order from its first byte to its last, so it can go to any io.Writer,
+// such as a pipe or an upload, rather than only to a file that can be written anywhere.
+//
+// Paths in the image are slash-separated, and relative to its root whether or not they begin with "/". Directories
+// above any path that is added are created as needed.
+//
+// A Builder from FileSystem.NewSession builds a new session of an existing image instead, which starts out with
+// everything that is in its last session.
+type Builder struct {
+	blocksize int64
+	dirs      map[string]*finalizeFileInfo
+	files     []*finalizeFileInfo
+	serial    uint64
+	built     bool
+	// previous is the primary volume descriptor of the session that this is a new session after, if it is one,
+	// and start the block where it begins
+	previous *primaryVolumeDescriptor
+	start    uint32
+	// tempFiles are files on the host that the Builder made for the image, and removes once it is built
+	tempFiles []string
+}
+
+// NewBuilder creates a Builder for an image of the given blocksize. If the provided blocksize is 0, it will use
+// the default of 2 KB.
+func NewBuilder(blocksize int64) (*Builder, error) {
+	if blocksize == 0 {
+		blocksize = defaultSectorSize
+	}
+	if err := validateBlocksize(blocksize); err != nil {
+		return nil, err
+	}
+	root := newBuilderEntry(true)
+	root.path = "."
+	root.name = string([]byte{0x00})
+	root.shortname = root.name
+	root.isRoot = true
+	return &Builder{
+		blocksize: blocksize,
+		dirs:      map[string]*finalizeFileInfo{".": root},
+		serial:    1,
+	}, nil
+}
+
+// Mkdir creates the directory p in the image, along with any directories above it.
+// It is not an error if the directory already exists.
+func (b *Builder) Mkdir(p string) error {
+	_, err := b.mkdirAll(builderPath(p))
+	return err
+}
+
+// AddFile adds a file at p in the image with the given contents, which are kept in memory until the image is built.
+func (b *Builder) AddFile(p string, content []byte) error {
+	e := newBuilderEntry(false)
+	if content == nil {
+		content = []byte{}
+	}
+	e.content = content
+	e.size = int64(len(content))
+	return b.add(p, e)
+}
+
+// AddFileFromFS adds a file at p in the image, whose contents are those of name in fsys. They are read when the
+// image is built.
+func (b *Builder) AddFileFromFS(p string, fsys fs.FS, name string) error {
+	fi, err := fs.Stat(fsys, name)
+	if err != nil {
+		return fmt.Errorf("could not stat %s: %v", name, err)
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", name)
+	}
+	return b.add(p, finalizeFileInfoFromFS(fsys, name, fi))
+}
+
+// AddFS adds all of the files and directories of fsys to the image, in the directory p.
+// Files in fsys are read when the image is built. Anything in fsys that is neither a regular file nor a directory
+// is an error.
+func (b *Builder) AddFS(p string, fsys fs.FS) error {
+	p = builderPath(p)
+	if _, err := b.mkdirAll(p); err != nil {
+		return err
+	}
+	return fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("error walking path %s: %v", name, err)
+		}
+		if name == "." {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("could not get file info for %s: %v", name, err)
+		}
+		target := path.Join(p, name)
+		switch {
+		case fi.IsDir():
+			_, err = b.mkdirAll(target)
+			return err
+		case fi.Mode().IsRegular():
+			return b.add(target, finalizeFileInfoFromFS(fsys, name, fi))
+		default:
+			return fmt.Errorf("%s is neither a regular file nor a directory", name)
+		}
+	})
+}
+
+// AddHostPath adds the file or directory hostPath on the host at p in the image. A directory is added with
+// everything under it. The files stay where they are, and are read when the image is built, so they should not
+// change until then.
+func (b *Builder) AddHostPath(p, hostPath string) error {
+	p = builderPath(p)
+	hostPath = filepath.Clean(hostPath)
+	return filepath.WalkDir(hostPath, func(actualPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("error walking path %s: %v", actualPath, err)
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("could not get file info for %s: %v", actualPath, err)
+		}
+		rel, err := filepath.Rel(hostPath, actualPath)
+		if err != nil {
+			return err
+		}
+		target := path.Join(p, filepath.ToSlash(rel))
+		if fi.IsDir() {
+			_, existed := b.dirs[target]
+			dir, err := b.mkdirAll(target)
+			if err != nil {
+				return err
+			}
+			// keep the properties of the directory on the host, if we just created it
+			if !existed {
+				e, err := finalizeFileInfoFromFile(target, actualPath, fi)
+				if err != nil {
+					return err
+				}
+				dir.mode, dir.modTime, dir.accessTime, dir.changeTime = e.mode, e.modTime, e.accessTime, e.changeTime
+				dir.uid, dir.gid, dir.nlink = e.uid, e.gid, e.nlink
+			}
+			return nil
+		}
+		e, err := finalizeFileInfoFromFile(target, actualPath, fi)
+		if err != nil {
+			return err
+		}
+		return b.add(target, e)
+	})
+}
+
+// Remove removes the file or directory p from the image, along with everything under a directory. For a new
+// session, it hides what was in a previous one, which still takes up its space in the image. To replace a file,
+// remove it and add the new one.
+func (b *Builder) Remove(p string) error {
+	p = builderPath(p)
+	if p == "." {
+		return fmt.Errorf("cannot remove the root directory")
+	}
+	if b.built {
+		return fmt.Errorf("cannot remove from an already built image")
+	}
+	parent, ok := b.dirs[path.Dir(p)]
+	if !ok {
+		return fmt.Errorf("%s does not exist", p)
+	}
+	removed := parent.removeChild(path.Base(p))
+	if removed == nil {
+		return fmt.Errorf("%s does not exist", p)
+	}
+	for dir := range b.dirs {
+		if dir == p || strings.HasPrefix(dir, p+"/") {
+			delete(b.dirs, dir)
+		}
+	}
+	files := make([]*finalizeFileInfo, 0, len(b.files))
+	for _, e := range b.files {
+		if e != removed && !strings.HasPrefix(e.path, p+"/") {
+			files = append(files, e)
+		}
+	}
+	b.files = files
+	return nil
+}
+
+// SessionStart returns where the image that Build writes begins, in bytes. It is 0, unless the Builder is for a
+// new session of an existing image, when the session goes at that offset in the image.
+func (b *Builder) SessionStart() int64 {
+	return int64(b.start) * b.blocksize
+}
+
+// Build lays out the image with the given options and writes it to w. A Builder can be built only once.
+//
+// For a new session, w gets just the session, which goes at SessionStart in the image, as it would be written to
+// the next writable address of a multisession disc. The session can be read on its own, with Read from
+// SessionStart. To write it into the image itself, and have the image open to it, use FileSystem.AppendSession
+// instead.
+func (b *Builder) Build(w io.Writer, options FinalizeOptions) error {
+	if b.built {
+		return fmt.Errorf("cannot build an already built image")
+	}
+	b.built = true
+	defer func() {
+		for _, p := range b.tempFiles {
+			os.Remove(p)
+		}
+	}()
+	fsm := &FileSystem{blocksize: b.blocksize}
+	return fsm.finalize(b.files, b.dirs, options, w, b.start)
+}
+
+// add adds the file e at p, which must not exist yet
+func (b *Builder) add(p string, e *finalizeFileInfo) error {
+	p = builderPath(p)
+	if p == "." {
+		return fmt.Errorf("cannot add a file as the root directory")
+	}
+	if b.built {
+		return fmt.Errorf("cannot add to an already built image")
+	}
+	parent, err := b.mkdirAll(path.Dir(p))
+	if err != nil {
+		return err
+	}
+	if existing, _ := parent.findEntry(path.Base(p)); existing != nil {
+		return fmt.Errorf("%s already exists", p)
+	}
+	e.path = p
+	e.name = path.Base(p)
+	e.shortname, e.extension = calculateShortnameExtension(e.name)
+	if e.isDir {
+		e.extension = ""
+		e.children = make([]*finalizeFileInfo, 0, 20)
+		b.dirs[p] = e
+	} else {
+		b.files = append(b.files, e)
+	}
+	e.serial = b.serial
+	b.serial++
+	parent.children = append(parent.children, e)
+	return nil
+}
+
+// mkdirAll returns the directory p, creating it and any directories above it that do not exist yet
+func (b *Builder) mkdirAll(p string) (*finalizeFileInfo, error) {
+	if dir, ok := b.dirs[p]; ok {
+		return dir, nil
+	}
+	if b.built {
+		return nil, fmt.Errorf("cannot add to an already built image")
+	}
+	parent, err := b.mkdirAll(path.Dir(p))
+	if err != nil {
+		return nil, err
+	}
+	if existing, _ := parent.findEntry(path.Base(p)); existing != nil {
+		return nil, fmt.Errorf("%s already exists and is not a directory", p)
+	}
+	dir := newBuilderEntry(true)
+	if err := b.add(p, dir); err != nil {
+		return nil, err
+	}
+	return dir, nil
+}
+
+// newBuilderEntry creates a file or directory that was made up rather than taken from anywhere
+func newBuilderEntry(isDir bool) *finalizeFileInfo {
+	now := time.Now()
+	mode := os.FileMode(0o644)
+	if isDir {
+		mode = os.ModeDir | 0o755
+	}
+	return &finalizeFileInfo{
+		isDir:      isDir,
+		mode:       mode,
+		modTime:    now,
+		accessTime: now,
+		changeTime: now,
+		nlink:      1,
+	}
+}
+
+// finalizeFileInfoFromFS creates a file whose contents are those of name in fsys
+func finalizeFileInfoFromFS(fsys fs.FS, name string, fi fs.FileInfo) *finalizeFileInfo {
+	nlink, uid, gid := statt(fi)
+	if nlink == 0 {
+		nlink = 1
+	}
+	return &finalizeFileInfo{
+		mode:       fi.Mode(),
+		modTime:    fi.ModTime(),
+		accessTime: fi.ModTime(),
+		changeTime: fi.ModTime(),
+		size:       fi.Size(),
+		uid:        uid,
+		gid:        gid,
+		nlink:      nlink,
+		open: func() (io.ReadCloser, error) {
+			return fsys.Open(name)
+		},
+		hostFile: hostFileOf(fi),
+	}
+}
+
+// builderPath cleans up a path in the image, to be relative to its root
+func builderPath(p string) string {
+	p = strings.TrimPrefix(path.Clean("/"+p), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
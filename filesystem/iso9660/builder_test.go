@@ -0,0 +1,198 @@
+package iso9660_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+)
+
+// writerOnly hides everything but Write, to make sure an image is written in order
+type writerOnly struct {
+	w io.Writer
+}
+
+func (w writerOnly) Write(b []byte) (int, error) {
+	return w.w.Write(b)
+}
+
+func TestBuilder(t *testing.T) {
+	hostDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(hostDir, "sub"), 0o755); err != nil {
+		t.Fatalf("could not create host directory: %v", err)
+	}
+	hostFiles := map[string]string{
+		"host.txt":     "on the host\n",
+		"sub/deep.txt": strings.Repeat("deep", 1000),
+	}
+	for p, contents := range hostFiles {
+		if err := os.WriteFile(filepath.Join(hostDir, p), []byte(contents), 0o644); err != nil {
+			t.Fatalf("could not write host file %s: %v", p, err)
+		}
+	}
+	fsys := fstest.MapFS{
+		"a.txt":     {Data: []byte("from fs a\n"), Mode: 0o644},
+		"dir/b.txt": {Data: []byte("from fs b\n"), Mode: 0o644},
+		"dir/empty": {Data: []byte{}, Mode: 0o644},
+	}
+	// a boot image, with a recognizable pattern after where the boot table goes
+	bootImage := make([]byte, 4096)
+	for i := range bootImage {
+		bootImage[i] = byte(i)
+	}
+
+	builder, err := iso9660.NewBuilder(0)
+	if err != nil {
+		t.Fatalf("unexpected error creating builder: %v", err)
+	}
+	if err := builder.AddFile("/README.md", []byte("readme\n")); err != nil {
+		t.Fatalf("unexpected error adding file: %v", err)
+	}
+	if err := builder.AddFile("/boot/boot.img", bootImage); err != nil {
+		t.Fatalf("unexpected error adding boot image: %v", err)
+	}
+	if err := builder.Mkdir("/empty/dir"); err != nil {
+		t.Fatalf("unexpected error making directory: %v", err)
+	}
+	if err := builder.AddFS("/fromfs", fsys); err != nil {
+		t.Fatalf("unexpected error adding fs.FS: %v", err)
+	}
+	if err := builder.AddFileFromFS("single.txt", fsys, "dir/b.txt"); err != nil {
+		t.Fatalf("unexpected error adding file from fs.FS: %v", err)
+	}
+	if err := builder.AddHostPath("/host", hostDir); err != nil {
+		t.Fatalf("unexpected error adding host path: %v", err)
+	}
+	if err := builder.AddFile("/README.md", []byte("again")); err == nil {
+		t.Errorf("unexpected nil error adding existing file")
+	}
+	if err := builder.Mkdir("/README.md/sub"); err == nil {
+		t.Errorf("unexpected nil error making directory under a file")
+	}
+
+	bootEntry := &iso9660.ElToritoEntry{Platform: iso9660.BIOS, Emulation: iso9660.NoEmulation, BootFile: "/boot/boot.img", BootTable: true, LoadSize: 4}
+	options := iso9660.FinalizeOptions{
+		RockRidge: true,
+		Joliet:    true,
+		ElTorito:  &iso9660.ElTorito{Entries: []*iso9660.ElToritoEntry{bootEntry}},
+	}
+	var buf bytes.Buffer
+	if err := builder.Build(writerOnly{&buf}, options); err != nil {
+		t.Fatalf("unexpected error building image: %v", err)
+	}
+	if buf.Len()%2048 != 0 {
+		t.Errorf("image of %d bytes is not whole blocks", buf.Len())
+	}
+	if err := builder.Build(io.Discard, options); err == nil {
+		t.Errorf("unexpected nil error building twice")
+	}
+	if err := builder.AddFile("/late", nil); err == nil {
+		t.Errorf("unexpected nil error adding after build")
+	}
+
+	f, err := os.CreateTemp("", "iso_builder_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	if _, err := f.Write(buf.Bytes()); err != nil {
+		t.Fatalf("Failed to write tmpfile: %v", err)
+	}
+	fs, err := iso9660.Read(file.New(f, true), 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading the tmpfile as iso: %v", err)
+	}
+
+	files := map[string]string{
+		"/README.md":         "readme\n",
+		"/fromfs/a.txt":      "from fs a\n",
+		"/fromfs/dir/b.txt":  "from fs b\n",
+		"/fromfs/dir/empty":  "",
+		"/single.txt":        "from fs b\n",
+		"/host/host.txt":     hostFiles["host.txt"],
+		"/host/sub/deep.txt": hostFiles["sub/deep.txt"],
+	}
+	for p, contents := range files {
+		isoFile, err := fs.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			t.Errorf("error opening file %s: %v", p, err)
+			continue
+		}
+		b, err := io.ReadAll(isoFile)
+		if err != nil {
+			t.Errorf("error reading from file %s: %v", p, err)
+		}
+		if string(b) != contents {
+			t.Errorf("mismatched content of %s, actual %q expected %q", p, b, contents)
+		}
+	}
+
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("error reading root directory: %v", err)
+	}
+	names := make([]string, 0, len(entries))
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	sort.Strings(names)
+	if expected := "README.md,boot,boot.catalog,empty,fromfs,host,single.txt"; strings.Join(names, ",") != expected {
+		t.Errorf("mismatched root entries, actual %v expected %s", names, expected)
+	}
+	entries, err = fs.ReadDir("/empty/dir")
+	if err != nil {
+		t.Fatalf("error reading empty directory: %v", err)
+	}
+	if len(entries) != 0 {
+		t.Errorf("empty directory has %d entries", len(entries))
+	}
+
+	// the boot table is inserted into the boot image, which is otherwise as it was
+	isoFile, err := fs.OpenFile("/boot/boot.img", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening boot image: %v", err)
+	}
+	b, err := io.ReadAll(isoFile)
+	if err != nil {
+		t.Fatalf("error reading boot image: %v", err)
+	}
+	if len(b) != len(bootImage) {
+		t.Fatalf("boot image of %d bytes instead of %d", len(b), len(bootImage))
+	}
+	if !bytes.Equal(b[:8], bootImage[:8]) || !bytes.Equal(b[64:], bootImage[64:]) {
+		t.Errorf("boot image changed outside of the boot table")
+	}
+	if pvd := binary.LittleEndian.Uint32(b[8:12]); pvd != 16 {
+		t.Errorf("boot table has primary volume descriptor at %d instead of 16", pvd)
+	}
+	if location := binary.LittleEndian.Uint32(b[12:16]); location != bootEntry.Location() {
+		t.Errorf("boot table has boot image at %d instead of %d", location, bootEntry.Location())
+	}
+	if size := binary.LittleEndian.Uint32(b[16:20]); size != uint32(len(bootImage)) {
+		t.Errorf("boot table has boot image of %d bytes instead of %d", size, len(bootImage))
+	}
+	var checksum uint32
+	for i := 64; i < len(bootImage); i += 4 {
+		checksum += binary.LittleEndian.Uint32(bootImage[i:])
+	}
+	if actual := binary.LittleEndian.Uint32(b[20:24]); actual != checksum {
+		t.Errorf("boot table has checksum %x instead of %x", actual, checksum)
+	}
+}
+
+func TestNewBuilderInvalidBlocksize(t *testing.T) {
+	if _, err := iso9660.NewBuilder(1000); err == nil {
+		t.Errorf("unexpected nil error")
+	}
+}
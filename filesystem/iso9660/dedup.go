@@ -0,0 +1,104 @@
+package iso9660
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+)
+
+// hostFile identifies a file on the host by its device and inode, which hard links of it share
+type hostFile struct {
+	dev, ino uint64
+}
+
+// deduplicate finds those of files whose contents are written just once, because they are hard links of another
+// file, or the same byte for byte as one. Their duplicateOf is that file. Hard links have the serial number of the
+// first of them, and as many links as there are of them. Boot images of et, which might get a boot table, and files
+// of a previous session are left as they are.
+func deduplicate(files []*finalizeFileInfo, et *ElTorito) error {
+	bootFiles := elToritoBootFiles(et)
+	candidates := make([]*finalizeFileInfo, 0, len(files))
+	for _, e := range files {
+		if !e.mode.IsRegular() || e.trueChild != nil || e.fixedLocation || e.size == 0 || bootFiles[e.path] {
+			continue
+		}
+		candidates = append(candidates, e)
+	}
+	// the first of each is the same one, however the files came to be in the list
+	sort.Slice(candidates, func(i, j int) bool {
+		return candidates[i].path < candidates[j].path
+	})
+
+	// hard links, in the order that they come in
+	links := map[hostFile][]*finalizeFileInfo{}
+	for _, e := range candidates {
+		if e.hostFile != (hostFile{}) {
+			links[e.hostFile] = append(links[e.hostFile], e)
+		}
+	}
+	for _, e := range candidates {
+		if e.hostFile == (hostFile{}) {
+			continue
+		}
+		link := links[e.hostFile]
+		e.nlink = uint32(len(link))
+		if e != link[0] {
+			e.duplicateOf = link[0]
+			e.serial = link[0].serial
+		}
+	}
+
+	// files the same byte for byte, which can only be those of the same size
+	bySize := map[int64][]*finalizeFileInfo{}
+	for _, e := range candidates {
+		if e.duplicateOf == nil {
+			bySize[e.size] = append(bySize[e.size], e)
+		}
+	}
+	byHash := map[[sha256.Size]byte]*finalizeFileInfo{}
+	for _, e := range candidates {
+		if len(bySize[e.size]) < 2 || e.duplicateOf != nil {
+			continue
+		}
+		sum, err := e.contentHash()
+		if err != nil {
+			return err
+		}
+		if original, ok := byHash[sum]; ok {
+			e.duplicateOf = original
+			continue
+		}
+		byHash[sum] = e
+	}
+	return nil
+}
+
+// contentHash returns the SHA-256 hash of the contents of the file
+func (fi *finalizeFileInfo) contentHash() ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	r, err := fi.reader()
+	if err != nil {
+		return sum, fmt.Errorf("failed to open file for reading %s: %v", fi.path, err)
+	}
+	defer r.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return sum, fmt.Errorf("failed to read file %s: %v", fi.path, err)
+	}
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// elToritoBootFiles returns the paths of the boot images of et, as they are in the list of files
+func elToritoBootFiles(et *ElTorito) map[string]bool {
+	bootFiles := map[string]bool{}
+	if et != nil {
+		for _, e := range et.Entries {
+			bootFiles[strings.TrimPrefix(path.Clean("/"+e.BootFile), "/")] = true
+		}
+	}
+	return bootFiles
+}
@@ -0,0 +1,15 @@
+//go:build !linux && !unix && !freebsd && !netbsd && !openbsd && !darwin
+
+package iso9660
+
+import "os"
+
+// devt returns the major and minor numbers of a device file, which are not known on this platform
+func devt(_ os.FileInfo) (major, minor uint32) {
+	return 0, 0
+}
+
+// hostFileOf returns the device and inode of a file on the host, which are not known on this platform
+func hostFileOf(_ os.FileInfo) hostFile {
+	return hostFile{}
+}
@@ -0,0 +1,30 @@
+//go:build linux || unix || freebsd || netbsd || openbsd || darwin
+
+package iso9660
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// devt returns the major and minor numbers of a device file
+func devt(fi os.FileInfo) (major, minor uint32) {
+	if sys := fi.Sys(); sys != nil {
+		if stat, ok := sys.(*syscall.Stat_t); ok {
+			major, minor = unix.Major(uint64(stat.Rdev)), unix.Minor(uint64(stat.Rdev))
+		}
+	}
+	return major, minor
+}
+
+// hostFileOf returns the device and inode of a file on the host
+func hostFileOf(fi os.FileInfo) hostFile {
+	if sys := fi.Sys(); sys != nil {
+		if stat, ok := sys.(*syscall.Stat_t); ok {
+			return hostFile{dev: uint64(stat.Dev), ino: uint64(stat.Ino)}
+		}
+	}
+	return hostFile{}
+}
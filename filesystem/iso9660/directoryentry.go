@@ -4,6 +4,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"fmt"
+	"math"
 	"os"
 	"path"
 	"regexp"
@@ -16,6 +17,12 @@ const (
 	directoryEntryMaxSize int   = 254 // max size allowed
 )
 
+// directoryExtent is one of the extents of a file, each in a directory record of its own
+type directoryExtent struct {
+	location uint32
+	size     uint32
+}
+
 // directoryEntry is a single directory entry
 // also fulfills os.FileInfo
 //
@@ -42,6 +49,23 @@ type directoryEntry struct {
 	filesystem               *FileSystem
 	filename                 string
 	extensions               []directoryEntrySystemUseExtension
+	// extents of a file that is in more than one directory record, each but the last with hasMoreEntries set, as
+	// ISO9660 level 3 allows for files of 4GB or more; nil for a file in a single extent
+	extents []directoryExtent
+}
+
+// maxExtentSize is the largest extent of a file in a single directory record, a whole number of blocks so that
+// the next extent of a multi-extent file starts on a block
+func maxExtentSize(blocksize int64) int64 {
+	return (math.MaxUint32 / blocksize) * blocksize
+}
+
+// fileExtents returns the extents of the contents of a file, in order
+func (de *directoryEntry) fileExtents() []directoryExtent {
+	if len(de.extents) > 0 {
+		return de.extents
+	}
+	return []directoryExtent{{location: de.location, size: de.size}}
 }
 
 func (de *directoryEntry) countNamelenBytes() int {
@@ -52,6 +76,8 @@ func (de *directoryEntry) countNamelenBytes() int {
 		namelen = 1
 	case de.isParent:
 		namelen = 1
+	case de.filesystem != nil && de.filesystem.joliet:
+		namelen = 2 * len([]rune(de.filename))
 	default:
 		namelen = len(de.filename)
 	}
@@ -117,6 +143,12 @@ func (de *directoryEntry) toBytes(skipExt bool, ceBlocks []uint32) ([][]byte, er
 		filenameBytes = []byte{0x00}
 	case de.isParent:
 		filenameBytes = []byte{0x01}
+	case de.filesystem.joliet:
+		err = validateJolietFilename(de.filename, de.isSubdirectory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Joliet name %s: %v", de.filename, err)
+		}
+		filenameBytes = ucs2StringToBytes(de.filename)
 	default:
 		// first validate the filename
 		err = validateFilename(de.filename, de.isSubdirectory, de.filesystem.suspEnabled)
@@ -293,6 +325,10 @@ func parseDirEntry(b []byte, f *FileSystem) (*directoryEntry, error) {
 		return nil, fmt.Errorf("invalid directory entry : %v", err)
 	}
 	de.filesystem = f
+	// Joliet names are in UCS-2
+	if f.joliet && !de.isSelf && !de.isParent {
+		de.filename = bytesToUCS2String([]byte(de.filename))
+	}
 
 	if f.suspEnabled && len(de.extensions) > 0 {
 		// if the last entry is a continuation SUSP entry and SUSP is enabled, we need to follow and parse them
@@ -332,6 +368,8 @@ func parseDirEntry(b []byte, f *FileSystem) (*directoryEntry, error) {
 // this is, essentially, the equivalent of `ls -l` or if you prefer `dir`
 func parseDirEntries(b []byte, f *FileSystem) ([]*directoryEntry, error) {
 	dirEntries := make([]*directoryEntry, 0, 20)
+	// multiExtent is the file whose records we are in the middle of, if it is in more than one
+	var multiExtent *directoryEntry
 	count := 0
 	for i := 0; i < len(b); count++ {
 		// empty entry means nothing more to read - this might not actually be accurate, but work with it for now
@@ -344,6 +382,19 @@ func parseDirEntries(b []byte, f *FileSystem) ([]*directoryEntry, error) {
 		if err != nil {
 			return nil, fmt.Errorf("invalid directory entry %d at byte %d: %v", count, i, err)
 		}
+		// the records of a file in more than one extent follow each other, and make a single entry
+		if multiExtent != nil {
+			multiExtent.extents = append(multiExtent.extents, directoryExtent{location: de.location, size: de.size})
+			if !de.hasMoreEntries {
+				multiExtent = nil
+			}
+			i += entryLen
+			continue
+		}
+		if de.hasMoreEntries && !de.isSubdirectory {
+			de.extents = []directoryExtent{{location: de.location, size: de.size}}
+			multiExtent = de
+		}
 		// some extensions to directory relocation, so check if we should ignore it
 		if f.suspEnabled {
 			for _, e := range f.suspExtensions {
@@ -475,18 +526,40 @@ func (de *directoryEntry) Name() string {
 }
 
 // Size() int64        // length in bytes for regular files; system-dependent for others
+//
+// for a file that is compressed with zisofs, it is the size of the file when it is decompressed
 func (de *directoryEntry) Size() int64 {
-	return int64(de.size)
+	if zf, ok := de.zisofs(); ok {
+		return int64(zf.size)
+	}
+	return de.storedSize()
+}
+
+// storedSize the size of the file as it is stored, in all of its extents
+func (de *directoryEntry) storedSize() int64 {
+	if len(de.extents) == 0 {
+		return int64(de.size)
+	}
+	var size int64
+	for _, e := range de.extents {
+		size += int64(e.size)
+	}
+	return size
 }
 
 // Mode() FileMode     // file mode bits
 func (de *directoryEntry) Mode() os.FileMode {
-	for _, ext := range de.extensions {
-		if s, ok := ext.(rockRidgeSymlink); ok && !s.continued {
-			return 0o755 | os.ModeSymlink
-		}
+	if info := de.rockRidgeInfo(); info != nil {
+		return info.Mode
+	}
+	mode := os.FileMode(0o755)
+	if de.isSubdirectory {
+		mode |= os.ModeDir
 	}
-	return 0o755
+	if _, ok := de.ReadLink(); ok {
+		mode |= os.ModeSymlink
+	}
+	return mode
 }
 
 // Readlink tries to return the target link, only valid for symlinks
@@ -501,6 +574,9 @@ func (de *directoryEntry) ReadLink() (string, bool) {
 
 // ModTime() time.Time // modification time
 func (de *directoryEntry) ModTime() time.Time {
+	if info := de.rockRidgeInfo(); info != nil && !info.ModTime.IsZero() {
+		return info.ModTime
+	}
 	return de.creation
 }
 
@@ -510,10 +586,57 @@ func (de *directoryEntry) IsDir() bool {
 }
 
 // Sys() interface{}   // underlying data source (can return nil)
+//
+// returns a *RockRidgeInfo for an entry with Rock Ridge extensions, else nil
 func (de *directoryEntry) Sys() interface{} {
+	if info := de.rockRidgeInfo(); info != nil {
+		return info
+	}
 	return nil
 }
 
+// rockRidgeInfo gathers what the Rock Ridge extensions of the entry say about it, or returns nil if it has none
+func (de *directoryEntry) rockRidgeInfo() *RockRidgeInfo {
+	var (
+		info  RockRidgeInfo
+		found bool
+	)
+	for _, ext := range de.extensions {
+		switch e := ext.(type) {
+		case rockRidgePosixAttributes:
+			found = true
+			info.Mode = e.mode
+			info.Nlink = e.linkCount
+			info.UID = e.uid
+			info.GID = e.gid
+			info.Serial = e.serial
+		case rockRidgePosixDeviceNumber:
+			info.DevMajor, info.DevMinor = e.device()
+		case rockRidgeSymlink:
+			if !e.continued {
+				info.LinkTarget = e.name
+			}
+		case rockRidgeTimestamps:
+			for _, t := range e.stamps {
+				switch t.timestampType {
+				case rockRidgeTimestampCreation:
+					info.CreationTime = t.time
+				case rockRidgeTimestampModify:
+					info.ModTime = t.time
+				case rockRidgeTimestampAccess:
+					info.AccessTime = t.time
+				case rockRidgeTimestampAttribute:
+					info.ChangeTime = t.time
+				}
+			}
+		}
+	}
+	if !found {
+		return nil
+	}
+	return &info
+}
+
 // utilities
 
 func bytesToTime(b []byte) time.Time {
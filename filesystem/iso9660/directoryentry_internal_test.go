@@ -3,6 +3,7 @@ package iso9660
 import (
 	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 	"testing"
@@ -870,3 +871,106 @@ func TestDirectoryEntryGetLocation(t *testing.T) {
 		}
 	}
 }
+
+func TestDirectoryEntryMultiExtent(t *testing.T) {
+	fs := &FileSystem{blocksize: 2048}
+	// the contents of the image are a pattern from the offset, so that reading any of it is cheap
+	pattern := func(offset int64) byte {
+		return byte(offset % 251)
+	}
+	fs.backend = file.New(&testhelper.FileImpl{
+		Reader: func(b []byte, offset int64) (int, error) {
+			for i := range b {
+				b[i] = pattern(offset + int64(i))
+			}
+			return len(b), nil
+		},
+	}, true)
+
+	maxSize := maxExtentSize(fs.blocksize)
+	size := 2*maxSize + 1000
+	fi := &finalizeFileInfo{
+		name:      "large.img",
+		shortname: "LARGE",
+		extension: "IMG",
+		size:      size,
+		location:  100,
+		modTime:   time.Now(),
+	}
+	records, err := fi.toDirectoryEntries(fs)
+	if err != nil {
+		t.Fatalf("unexpected error converting to directory entries: %v", err)
+	}
+	expected := []directoryExtent{
+		{location: 100, size: uint32(maxSize)},
+		{location: 100 + uint32(maxSize/fs.blocksize), size: uint32(maxSize)},
+		{location: 100 + 2*uint32(maxSize/fs.blocksize), size: 1000},
+	}
+	if len(records) != len(expected) {
+		t.Fatalf("mismatched number of records, actual %d expected %d", len(records), len(expected))
+	}
+	var b []byte
+	for i, record := range records {
+		if record.location != expected[i].location || record.size != expected[i].size {
+			t.Errorf("%d: mismatched extent, actual location %d size %d, expected location %d size %d", i, record.location, record.size, expected[i].location, expected[i].size)
+		}
+		if more := i < len(records)-1; record.hasMoreEntries != more {
+			t.Errorf("%d: mismatched more entries flag, actual %v expected %v", i, record.hasMoreEntries, more)
+		}
+		rb, err := record.toBytes(false, nil)
+		if err != nil {
+			t.Fatalf("%d: unexpected error converting to bytes: %v", i, err)
+		}
+		b = append(b, rb[0]...)
+	}
+
+	entries, err := parseDirEntries(b, fs)
+	if err != nil {
+		t.Fatalf("unexpected error parsing directory entries: %v", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("mismatched number of entries, actual %d expected 1", len(entries))
+	}
+	de := entries[0]
+	if de.Size() != size {
+		t.Errorf("mismatched size, actual %d expected %d", de.Size(), size)
+	}
+
+	// read across each of the boundaries between extents
+	fl := &File{directoryEntry: de}
+	for i, extent := range expected[:len(expected)-1] {
+		offset := int64(i+1)*maxSize - 10
+		if _, err := fl.Seek(offset, io.SeekStart); err != nil {
+			t.Fatalf("unexpected error seeking to %d: %v", offset, err)
+		}
+		buf := make([]byte, 20)
+		n, err := fl.Read(buf)
+		if err != nil {
+			t.Fatalf("unexpected error reading at %d: %v", offset, err)
+		}
+		if n != len(buf) {
+			t.Fatalf("read %d bytes at %d instead of %d", n, offset, len(buf))
+		}
+		next := expected[i+1]
+		for j := range buf {
+			var want byte
+			if j < 10 {
+				want = pattern(int64(extent.location)*fs.blocksize + maxSize - 10 + int64(j))
+			} else {
+				want = pattern(int64(next.location)*fs.blocksize + int64(j-10))
+			}
+			if buf[j] != want {
+				t.Errorf("mismatched byte %d at offset %d, actual %d expected %d", j, offset, buf[j], want)
+			}
+		}
+	}
+	// and up to the end
+	if _, err := fl.Seek(-5, io.SeekEnd); err != nil {
+		t.Fatalf("unexpected error seeking to end: %v", err)
+	}
+	buf := make([]byte, 20)
+	n, err := fl.Read(buf)
+	if n != 5 || err != io.EOF {
+		t.Errorf("read %d bytes with error %v at end, expected 5 bytes and EOF", n, err)
+	}
+}
@@ -0,0 +1,237 @@
+package iso9660
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+)
+
+const (
+	// efiBootImageMinSize is the least size of an EFI boot image, so that its FAT32 has enough clusters that
+	// firmware, which tells FAT32 from FAT16 by how many clusters it has, takes it for FAT32. That is more sectors
+	// than the El Torito boot entry can count, so it records 0 for them.
+	efiBootImageMinSize = 33 * 1024 * 1024
+	// efiBootImageLabel is the volume label of an EFI boot image
+	efiBootImageLabel = "EFIBOOT"
+)
+
+// AddEFIBootImage creates a FAT image of everything in the directory dir on the host, such as EFI/BOOT/BOOTX64.EFI,
+// at p in the workspace, and adds it to options as a no emulation El Torito boot entry for EFI. The image is
+// sized to fit what is in dir, and is at least 33 MB, the smallest that a FAT32 can be. If options has a
+// SourceDateEpoch already, the image is reproducible, as the rest of the image is.
+func (fsm *FileSystem) AddEFIBootImage(options *FinalizeOptions, p, dir string) error {
+	if fsm.workspace == "" {
+		return fmt.Errorf("cannot add an EFI boot image to a filesystem that is finalized")
+	}
+	imagePath := filepath.Join(fsm.workspace, filepath.FromSlash(path.Clean("/"+p)))
+	if err := os.MkdirAll(filepath.Dir(imagePath), 0o755); err != nil {
+		return fmt.Errorf("could not create directory for EFI boot image %s: %v", p, err)
+	}
+	if err := createEFIBootImage(imagePath, dir, options.SourceDateEpoch); err != nil {
+		return err
+	}
+	addEFIBootEntry(options, p)
+	return nil
+}
+
+// AddEFIBootImage creates a FAT image of everything in the directory dir on the host, such as EFI/BOOT/BOOTX64.EFI,
+// at p in the image, and adds it to options as a no emulation El Torito boot entry for EFI. The image is kept in
+// a temporary file on the host until the image is built. It is sized to fit what is in dir, and is at least 33 MB,
+// the smallest that a FAT32 can be. If options has a SourceDateEpoch already, the image is reproducible, as the
+// rest of the image is.
+func (b *Builder) AddEFIBootImage(options *FinalizeOptions, p, dir string) error {
+	f, err := os.CreateTemp("", "efiboot")
+	if err != nil {
+		return fmt.Errorf("could not create temporary EFI boot image: %v", err)
+	}
+	imagePath := f.Name()
+	f.Close()
+	// as any file added to a Builder, rather than as a temporary file is
+	if err := os.Chmod(imagePath, 0o644); err != nil {
+		os.Remove(imagePath)
+		return fmt.Errorf("could not set mode of temporary EFI boot image: %v", err)
+	}
+	if err := createEFIBootImage(imagePath, dir, options.SourceDateEpoch); err != nil {
+		os.Remove(imagePath)
+		return err
+	}
+	b.tempFiles = append(b.tempFiles, imagePath)
+	if err := b.AddHostPath(p, imagePath); err != nil {
+		return err
+	}
+	addEFIBootEntry(options, p)
+	return nil
+}
+
+// addEFIBootEntry adds the EFI boot image at p to the El Torito boot entries of options
+func addEFIBootEntry(options *FinalizeOptions, p string) {
+	if options.ElTorito == nil {
+		options.ElTorito = &ElTorito{}
+	}
+	et := options.ElTorito
+	// the platform of the first entry is that of the validation entry
+	if len(et.Entries) == 0 {
+		et.Platform = EFI
+	}
+	et.Entries = append(et.Entries, &ElToritoEntry{
+		Platform:  EFI,
+		Emulation: NoEmulation,
+		BootFile:  p,
+	})
+}
+
+// createEFIBootImage creates a FAT32 image at imagePath on the host of everything in the directory dir on the host.
+// With a non-zero epoch, the image is reproducible: its volume ID is derived from epoch, and the times of its
+// entries are clamped to it.
+func createEFIBootImage(imagePath, dir string, epoch time.Time) error {
+	size, err := efiBootImageSize(dir)
+	if err != nil {
+		return fmt.Errorf("could not size EFI boot image of %s: %v", dir, err)
+	}
+	f, err := os.Create(imagePath)
+	if err != nil {
+		return fmt.Errorf("could not create EFI boot image: %v", err)
+	}
+	defer f.Close()
+	if err := f.Truncate(size); err != nil {
+		return fmt.Errorf("could not size EFI boot image: %v", err)
+	}
+	fatfs, err := fat32.Create(file.New(f, false), size, 0, 512, efiBootImageLabel)
+	if err != nil {
+		return fmt.Errorf("could not create FAT32 for EFI boot image: %v", err)
+	}
+	// setTimes sets the times of p in the image to those of the host path, clamped to epoch
+	setTimes := func(p string, d fs.DirEntry) error {
+		if epoch.IsZero() {
+			return nil
+		}
+		t := epoch
+		if d != nil {
+			fi, err := d.Info()
+			if err != nil {
+				return err
+			}
+			if mtime := fi.ModTime(); mtime.Before(epoch) {
+				t = mtime
+			}
+		}
+		return fatfs.Chtimes(p, t, t, t)
+	}
+	if !epoch.IsZero() {
+		if err := fatfs.SetVolumeID(uint32(epoch.Unix())); err != nil {
+			return fmt.Errorf("could not set volume ID of EFI boot image: %v", err)
+		}
+		// the volume label entry
+		if err := setTimes("/", nil); err != nil {
+			return fmt.Errorf("could not set times of EFI boot image: %v", err)
+		}
+	}
+	err = filepath.WalkDir(dir, func(hostPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(dir, hostPath)
+		if err != nil {
+			return err
+		}
+		p := path.Join("/", filepath.ToSlash(rel))
+		switch {
+		case d.IsDir():
+			if p == "/" {
+				return nil
+			}
+			if err := fatfs.Mkdir(p); err != nil {
+				return fmt.Errorf("could not create directory %s in EFI boot image: %v", p, err)
+			}
+		case d.Type().IsRegular():
+			if err := copyToFAT(fatfs, p, hostPath); err != nil {
+				return fmt.Errorf("could not copy %s to EFI boot image: %v", hostPath, err)
+			}
+		default:
+			return fmt.Errorf("%s is neither a regular file nor a directory, which cannot go in an EFI boot image", hostPath)
+		}
+		// before anything is created in a directory, whose .. entry takes the times of the directory
+		if err := setTimes(p, d); err != nil {
+			return fmt.Errorf("could not set times of %s in EFI boot image: %v", p, err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	// the allocation of clusters is kept in memory until then
+	if err := fatfs.Close(); err != nil {
+		return fmt.Errorf("could not write FAT of EFI boot image: %v", err)
+	}
+	return nil
+}
+
+// copyToFAT copies the file hostPath on the host to p in fatfs
+func copyToFAT(fatfs *fat32.FileSystem, p, hostPath string) error {
+	in, err := os.Open(hostPath)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := fatfs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, in)
+	return err
+}
+
+// efiBootImageSize works out the size in bytes of an EFI boot image of everything in the directory dir on the host,
+// with room for its FATs and the entries of its directories, rounded up to a whole MB
+func efiBootImageSize(dir string) (int64, error) {
+	var files []int64
+	entries := map[string]int64{}
+	err := filepath.WalkDir(dir, func(hostPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if hostPath == dir {
+			return nil
+		}
+		// a directory entry of 32 bytes, and long file name entries of 13 characters each
+		entries[filepath.Dir(hostPath)] += 32 * (1 + int64(len(d.Name())+12)/13)
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		files = append(files, fi.Size())
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	// each file and directory takes whole clusters, whose size depends on that of the image, so a size that
+	// takes larger clusters than it was worked out with is worked out again with those
+	var size int64
+	for cluster, next := int64(0), fat32.ClusterSize(efiBootImageMinSize); next > cluster; next = fat32.ClusterSize(size) {
+		cluster = next
+		size = 0
+		for _, fileSize := range files {
+			size += roundUpInt64(fileSize, cluster)
+		}
+		for _, dirSize := range entries {
+			// with the . and .. entries, and the volume label of the root
+			size += roundUpInt64(dirSize+3*32, cluster)
+		}
+		// the reserved sectors, and two FATs of 4 bytes for every sector
+		size += 32*512 + 2*4*size/512
+		size = maxInt64(roundUpInt64(size+1024*1024, 1024*1024), efiBootImageMinSize)
+	}
+	return size, nil
+}
@@ -0,0 +1,139 @@
+package iso9660_test
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem/fat32"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+)
+
+// createEFIBootDir creates a directory on the host with what goes in an EFI boot image
+func createEFIBootDir(t *testing.T) (dir string, files map[string]string) {
+	t.Helper()
+	dir = t.TempDir()
+	files = map[string]string{
+		"EFI/BOOT/BOOTX64.EFI": strings.Repeat("MZ boot loader", 5000),
+		"EFI/BOOT/grub.cfg":    "set timeout=5\n",
+	}
+	for p, contents := range files {
+		hostPath := filepath.Join(dir, filepath.FromSlash(p))
+		if err := os.MkdirAll(filepath.Dir(hostPath), 0o755); err != nil {
+			t.Fatalf("could not create directory for %s: %v", p, err)
+		}
+		if err := os.WriteFile(hostPath, []byte(contents), 0o644); err != nil {
+			t.Fatalf("could not write %s: %v", p, err)
+		}
+	}
+	return dir, files
+}
+
+// checkEFIBootImage checks that the image in f has an EFI boot entry for the FAT image at p, with the files in it
+func checkEFIBootImage(t *testing.T, f *os.File, p string, files map[string]string) {
+	t.Helper()
+	b := file.New(f, true)
+	fs, err := iso9660.Read(b, 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading the tmpfile as iso: %v", err)
+	}
+	et, err := fs.ElTorito()
+	if err != nil {
+		t.Fatalf("error reading El Torito boot catalog: %v", err)
+	}
+	if et.Platform != iso9660.EFI || len(et.Entries) != 1 {
+		t.Fatalf("mismatched El Torito boot catalog %+v", et)
+	}
+	entry := et.Entries[0]
+	// the boot image is too large for its sectors to be counted, so there are none
+	if entry.Emulation != iso9660.NoEmulation || entry.LoadSize != 0 {
+		t.Errorf("mismatched El Torito boot entry %+v", entry)
+	}
+	bootFile, err := fs.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening EFI boot image: %v", err)
+	}
+	if location := bootFile.(*iso9660.File).Location(); location != entry.Location() {
+		t.Errorf("boot entry at block %d, instead of that of the boot image %d", entry.Location(), location)
+	}
+	size, err := bootFile.Seek(0, io.SeekEnd)
+	if err != nil {
+		t.Fatalf("error getting size of EFI boot image: %v", err)
+	}
+	if size < 33*1024*1024 {
+		t.Errorf("EFI boot image of %d bytes is too small for FAT32", size)
+	}
+
+	fatfs, err := fat32.Read(b, size, int64(entry.Location())*2048, 512)
+	if err != nil {
+		t.Fatalf("error reading EFI boot image as FAT32: %v", err)
+	}
+	for p, contents := range files {
+		fatFile, err := fatfs.OpenFile("/"+p, os.O_RDONLY)
+		if err != nil {
+			t.Errorf("error opening %s in EFI boot image: %v", p, err)
+			continue
+		}
+		actual, err := io.ReadAll(fatFile)
+		if err != nil {
+			t.Errorf("error reading %s in EFI boot image: %v", p, err)
+		}
+		if string(actual) != contents {
+			t.Errorf("mismatched contents of %s in EFI boot image", p)
+		}
+	}
+}
+
+func TestAddEFIBootImage(t *testing.T) {
+	dir, files := createEFIBootDir(t)
+	t.Run("finalize", func(t *testing.T) {
+		f, err := os.CreateTemp("", "iso_efiboot_test")
+		if err != nil {
+			t.Fatalf("Failed to create tmpfile: %v", err)
+		}
+		defer func() {
+			f.Close()
+			os.Remove(f.Name())
+		}()
+		fs, err := iso9660.Create(file.New(f, false), 0, 0, 2048, "")
+		if err != nil {
+			t.Fatalf("Failed to iso9660.Create: %v", err)
+		}
+		var options iso9660.FinalizeOptions
+		if err := fs.AddEFIBootImage(&options, "/boot/efiboot.img", dir); err != nil {
+			t.Fatalf("unexpected error adding EFI boot image: %v", err)
+		}
+		if err := fs.Finalize(options); err != nil {
+			t.Fatalf("unexpected error fs.Finalize(%+v): %v", options, err)
+		}
+		checkEFIBootImage(t, f, "/BOOT/EFIBOOT.IMG", files)
+	})
+	t.Run("builder", func(t *testing.T) {
+		builder, err := iso9660.NewBuilder(2048)
+		if err != nil {
+			t.Fatalf("unexpected error creating builder: %v", err)
+		}
+		options := iso9660.FinalizeOptions{RockRidge: true}
+		if err := builder.AddEFIBootImage(&options, "/boot/efiboot.img", dir); err != nil {
+			t.Fatalf("unexpected error adding EFI boot image: %v", err)
+		}
+		if err := builder.AddEFIBootImage(&options, "/boot/other.img", filepath.Join(dir, "missing")); err == nil {
+			t.Errorf("unexpected nil error adding EFI boot image of missing directory")
+		}
+		f, err := os.CreateTemp("", "iso_efiboot_test")
+		if err != nil {
+			t.Fatalf("Failed to create tmpfile: %v", err)
+		}
+		defer func() {
+			f.Close()
+			os.Remove(f.Name())
+		}()
+		if err := builder.Build(f, options); err != nil {
+			t.Fatalf("unexpected error building image: %v", err)
+		}
+		checkEFIBootImage(t, f, "/boot/efiboot.img", files)
+	})
+}
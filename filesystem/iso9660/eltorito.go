@@ -2,9 +2,11 @@ package iso9660
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
-	"os"
+	"math"
+	"path"
 
 	"github.com/diskfs/go-diskfs/partition/mbr"
 	"github.com/diskfs/go-diskfs/util"
@@ -16,6 +18,21 @@ const (
 	elToritoDefaultBlocks = 4
 )
 
+const (
+	// elToritoEntrySize is the size of each entry of a boot catalog, of whatever kind
+	elToritoEntrySize          = 0x20
+	elToritoHeaderValidation   = 0x01
+	elToritoHeaderSection      = 0x90
+	elToritoHeaderSectionFinal = 0x91
+	elToritoBootable           = 0x88
+	elToritoNotBootable        = 0x00
+	elToritoExtension          = 0x44
+	// elToritoExtensionFollows is the bit of the media type of a section entry that says an extension entry follows
+	elToritoExtensionFollows = 0x20
+	// elToritoMaxCatalogBlocks is as many blocks of a boot catalog as we read, far more than any real one has
+	elToritoMaxCatalogBlocks = 16
+)
+
 // Platform target booting system for a bootable iso
 type Platform uint8
 
@@ -74,10 +91,18 @@ type ElToritoEntry struct {
 	BootTable bool
 	// SystemType type of system the partition is, according to the MBR standard
 	SystemType mbr.Type
-	// LoadSize how many blocks of BootFile to load, equivalent to genisoimage option `-boot-load-size`
+	// LoadSize how many blocks of BootFile to load, equivalent to genisoimage option `-boot-load-size`.
+	// If 0, it is the size of BootFile, or 0 if that is more than 65535 blocks.
 	LoadSize uint16
-	size     uint32
-	location uint32
+	// NotBootable marks an entry that is in the boot catalog, but that the firmware must not boot
+	NotBootable bool
+	size        uint32
+	location    uint32
+}
+
+// Location the block of the boot image, as read from the boot catalog of an existing iso, or as laid out by Finalize
+func (e *ElToritoEntry) Location() uint32 {
+	return e.location
 }
 
 // generateCatalog generate the el torito boot catalog file
@@ -127,13 +152,18 @@ func (e *ElToritoEntry) headerBytes(last bool, entries uint16) []byte {
 func (e *ElToritoEntry) entryBytes() []byte {
 	blocks := e.LoadSize
 	if blocks == 0 {
-		blocks = uint16(e.size / 512)
-		if e.size%512 > 1 {
-			blocks++
+		// a boot image too large to count in 16 bits, such as a FAT32 EFI boot image, is recorded with 0 sectors, as
+		// xorriso does, rather than with a count that is cut short; EFI firmware reads it as the file system that
+		// it is, whatever its count
+		if sectors := (e.size + 511) / 512; sectors <= math.MaxUint16 {
+			blocks = uint16(sectors)
 		}
 	}
 	b := make([]byte, 0x20)
-	b[0] = 0x88
+	b[0] = elToritoBootable
+	if e.NotBootable {
+		b[0] = elToritoNotBootable
+	}
 	b[1] = byte(e.Emulation)
 	binary.LittleEndian.PutUint16(b[2:4], e.LoadSegment)
 	// b[4] is system type, taken from byte 5 in the partition table in the boot image
@@ -148,34 +178,36 @@ func (e *ElToritoEntry) entryBytes() []byte {
 	return b
 }
 
-// generateBootTable generate the el torito boot table for this entry
-func (e *ElToritoEntry) generateBootTable(pvdSector uint32, p string) ([]byte, error) {
+// generateBootTable generate the el torito boot table for this entry, from the contents of its boot file in r
+func (e *ElToritoEntry) generateBootTable(pvdSector uint32, r io.Reader) ([]byte, error) {
 	b := make([]byte, 56)
 	binary.LittleEndian.PutUint32(b[0:4], pvdSector)
 	binary.LittleEndian.PutUint32(b[4:8], e.location)
 	binary.LittleEndian.PutUint32(b[8:12], e.size)
 	// Checksum - simply add up all 32-bit words beginning at byte position 64
-	f, err := os.Open(p)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open boot file for checksum reading %s: %v", p, err)
+	if _, err := io.CopyN(io.Discard, r, 64); err != nil && err != io.EOF {
+		return nil, fmt.Errorf("failed to read boot file for checksum: %v", err)
 	}
-	defer f.Close()
 
 	var (
 		checksum uint32
+		buf      = make([]byte, 4)
 	)
 
-	for offset := 64; offset <= int(e.size); offset += 4 {
-		buf := make([]byte, 4)
-		n, err := f.ReadAt(buf, int64(offset))
-		if err != nil && err != io.EOF {
-			return nil, err
+	for {
+		n, err := io.ReadFull(r, buf)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("failed to read boot file for checksum: %v", err)
 		}
 		if n == 0 {
 			break
 		}
+		// a partial last word is padded with zeroes
+		for i := n; i < len(buf); i++ {
+			buf[i] = 0
+		}
 		checksum += binary.LittleEndian.Uint32(buf)
-		if err == io.EOF {
+		if err != nil {
 			break
 		}
 	}
@@ -183,3 +215,178 @@ func (e *ElToritoEntry) generateBootTable(pvdSector uint32, p string) ([]byte, e
 	binary.LittleEndian.PutUint32(b[12:16], checksum)
 	return b, nil
 }
+
+// errElToritoCatalogShort is returned by parseElToritoCatalog when the catalog goes on beyond the bytes it was given
+var errElToritoCatalogShort = errors.New("el torito boot catalog continues beyond the bytes read")
+
+// parseElToritoCatalog parses a boot catalog, with its validation entry, its initial entry and any sections after
+// it. Entries that are not bootable are kept, marked as such, but for an initial entry that is not recorded.
+func parseElToritoCatalog(b []byte) (*ElTorito, error) {
+	if len(b) < 2*elToritoEntrySize {
+		return nil, errElToritoCatalogShort
+	}
+	validation := b[:elToritoEntrySize]
+	if validation[0] != elToritoHeaderValidation || validation[0x1e] != 0x55 || validation[0x1f] != 0xaa {
+		return nil, fmt.Errorf("invalid el torito validation entry")
+	}
+	checksum := uint16(0x0)
+	for i := 0; i < len(validation); i += 2 {
+		checksum += binary.LittleEndian.Uint16(validation[i : i+2])
+	}
+	if checksum != 0 {
+		return nil, fmt.Errorf("el torito validation entry checksum does not add up to 0 but to %#04x", checksum)
+	}
+	et := &ElTorito{
+		Platform: Platform(validation[1]),
+	}
+	// the initial entry is for the platform of the validation entry
+	initial := b[elToritoEntrySize : 2*elToritoEntrySize]
+	if initial[0] == elToritoBootable || (initial[0] == elToritoNotBootable && binary.LittleEndian.Uint32(initial[8:12]) != 0) {
+		et.Entries = append(et.Entries, parseElToritoEntry(initial, et.Platform))
+	}
+
+	offset := 2 * elToritoEntrySize
+	for last := false; !last; {
+		if offset+elToritoEntrySize > len(b) {
+			return nil, errElToritoCatalogShort
+		}
+		header := b[offset : offset+elToritoEntrySize]
+		switch header[0] {
+		case elToritoHeaderSection:
+		case elToritoHeaderSectionFinal:
+			last = true
+		default:
+			// no more sections
+			return et, nil
+		}
+		platform := Platform(header[1])
+		count := int(binary.LittleEndian.Uint16(header[2:4]))
+		offset += elToritoEntrySize
+		for i := 0; i < count; i++ {
+			if offset+elToritoEntrySize > len(b) {
+				return nil, errElToritoCatalogShort
+			}
+			entry := b[offset : offset+elToritoEntrySize]
+			offset += elToritoEntrySize
+			// the selection criteria of an entry may go on in extension entries, which we skip
+			for extension := entry[1]&elToritoExtensionFollows != 0; extension; {
+				if offset+elToritoEntrySize > len(b) {
+					return nil, errElToritoCatalogShort
+				}
+				if b[offset] != elToritoExtension {
+					return nil, fmt.Errorf("invalid el torito extension entry indicator %#02x at byte %d", b[offset], offset)
+				}
+				extension = b[offset+1]&elToritoExtensionFollows != 0
+				offset += elToritoEntrySize
+			}
+			if entry[0] == elToritoBootable || entry[0] == elToritoNotBootable {
+				et.Entries = append(et.Entries, parseElToritoEntry(entry, platform))
+			}
+		}
+	}
+	return et, nil
+}
+
+// parseElToritoEntry parses the initial or a section entry of a boot catalog
+func parseElToritoEntry(b []byte, platform Platform) *ElToritoEntry {
+	return &ElToritoEntry{
+		Platform:    platform,
+		Emulation:   Emulation(b[1] & 0x0f),
+		LoadSegment: binary.LittleEndian.Uint16(b[2:4]),
+		SystemType:  mbr.Type(b[4]),
+		LoadSize:    binary.LittleEndian.Uint16(b[6:8]),
+		NotBootable: b[0] != elToritoBootable,
+		location:    binary.LittleEndian.Uint32(b[8:12]),
+	}
+}
+
+// ElTorito returns the El Torito boot catalog of an iso that was read, with all of its entries, or nil
+// if the iso has no boot record.
+//
+// The boot catalog and the boot images are looked up in the directory tree, to fill in BootCatalog and BootFile.
+// Those that are not in it are marked as hidden, and have no path, in which case they cannot be used as they are
+// to Finalize another iso. BootTable is set for boot images that have a boot information table for this iso.
+func (fsm *FileSystem) ElTorito() (*ElTorito, error) {
+	if fsm.workspace != "" {
+		return nil, fmt.Errorf("cannot read the el torito boot catalog of a filesystem that is not finalized")
+	}
+	var bvd *bootVolumeDescriptor
+	for _, vd := range fsm.volumes.descriptors {
+		if b, ok := vd.(*bootVolumeDescriptor); ok {
+			bvd = b
+			break
+		}
+	}
+	if bvd == nil {
+		return nil, nil
+	}
+
+	// read as much of the catalog as it needs
+	var (
+		et  *ElTorito
+		err error
+	)
+	for blocks := int64(1); et == nil; blocks *= 2 {
+		if blocks > elToritoMaxCatalogBlocks {
+			return nil, fmt.Errorf("el torito boot catalog at block %d is larger than %d blocks", bvd.location, elToritoMaxCatalogBlocks)
+		}
+		b := make([]byte, blocks*fsm.blocksize)
+		n, err := fsm.backend.ReadAt(b, int64(bvd.location)*fsm.blocksize)
+		if err != nil && err != io.EOF {
+			return nil, fmt.Errorf("could not read el torito boot catalog at block %d: %v", bvd.location, err)
+		}
+		et, err = parseElToritoCatalog(b[:n])
+		switch {
+		case err == errElToritoCatalogShort && n == len(b):
+			continue
+		case err != nil:
+			return nil, fmt.Errorf("could not parse el torito boot catalog at block %d: %v", bvd.location, err)
+		}
+	}
+
+	// find the catalog and boot images in the directory tree
+	paths := map[uint32]string{bvd.location: ""}
+	for _, e := range et.Entries {
+		paths[e.location] = ""
+	}
+	if err = fsm.findFilesAt(paths, "/"); err != nil {
+		return nil, fmt.Errorf("could not look for el torito boot catalog and images: %v", err)
+	}
+	et.BootCatalog = paths[bvd.location]
+	et.HideBootCatalog = et.BootCatalog == ""
+	for _, e := range et.Entries {
+		e.BootFile = paths[e.location]
+		e.HideBootFile = e.BootFile == ""
+		// a boot information table has the block of the primary volume descriptor, then that of the boot image
+		b := make([]byte, 8)
+		if _, err := fsm.backend.ReadAt(b, int64(e.location)*fsm.blocksize+elToritoBootTableOffset); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("could not read boot image at block %d: %v", e.location, err)
+		}
+		e.BootTable = binary.LittleEndian.Uint32(b[0:4]) == dataStartSector && binary.LittleEndian.Uint32(b[4:8]) == e.location
+	}
+	return et, nil
+}
+
+// findFilesAt walks the directory tree under p to fill in the paths of the files that start at the given blocks
+func (fsm *FileSystem) findFilesAt(paths map[uint32]string, p string) error {
+	entries, err := fsm.readDirectory(p)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if e.isSelf || e.isParent {
+			continue
+		}
+		fullPath := path.Join(p, e.Name())
+		if e.IsDir() {
+			if err := fsm.findFilesAt(paths, fullPath); err != nil {
+				return err
+			}
+			continue
+		}
+		if found, ok := paths[e.location]; ok && found == "" {
+			paths[e.location] = fullPath
+		}
+	}
+	return nil
+}
@@ -112,4 +112,113 @@ func TestElToritoEntryBytes(t *testing.T) {
 	if !bytes.Equal(b, expected) {
 		t.Errorf("Mismatched bytes, actual then expected\n% x\n% x\n", b, expected)
 	}
+
+	// a boot image of more sectors than can be counted has none recorded, rather than a count cut short
+	for _, size := range []uint32{65535 * 512, 65535*512 + 1, 33 * 1024 * 1024} {
+		large := &ElToritoEntry{Platform: EFI, Emulation: NoEmulation, size: size, location: 193}
+		expected := uint16(0)
+		if size <= 65535*512 {
+			expected = 65535
+		}
+		et := &ElTorito{Platform: EFI, Entries: []*ElToritoEntry{large}}
+		b := et.generateCatalog()
+		parsed, err := parseElToritoCatalog(append(b, make([]byte, 2048-len(b))...))
+		if err != nil {
+			t.Fatalf("unexpected error parsing catalog: %v", err)
+		}
+		if actual := parsed.Entries[0].LoadSize; actual != expected {
+			t.Errorf("boot image of %d bytes read back with %d sectors, expected %d", size, actual, expected)
+		}
+	}
+}
+
+func TestParseElToritoCatalog(t *testing.T) {
+	et := &ElTorito{
+		Platform: EFI,
+		Entries: []*ElToritoEntry{
+			{Platform: EFI, Emulation: HardDiskEmulation, LoadSegment: 23, SystemType: mbr.Linux, LoadSize: 4, location: 100},
+			{Platform: BIOS, Emulation: NoEmulation, SystemType: mbr.Fat32LBA, size: 2000, location: 200},
+			{Platform: PPC, Emulation: Floppy144Emulation, SystemType: mbr.Fat16, LoadSize: 1, location: 300},
+			{Platform: EFI, Emulation: NoEmulation, LoadSize: 8, NotBootable: true, location: 400},
+		},
+	}
+	expected := []*ElToritoEntry{
+		{Platform: EFI, Emulation: HardDiskEmulation, LoadSegment: 23, SystemType: mbr.Linux, LoadSize: 4, location: 100},
+		{Platform: BIOS, Emulation: NoEmulation, SystemType: mbr.Fat32LBA, LoadSize: 4, location: 200},
+		{Platform: PPC, Emulation: Floppy144Emulation, SystemType: mbr.Fat16, LoadSize: 1, location: 300},
+		{Platform: EFI, Emulation: NoEmulation, LoadSize: 8, NotBootable: true, location: 400},
+	}
+	check := func(t *testing.T, parsed *ElTorito) {
+		t.Helper()
+		if parsed.Platform != et.Platform {
+			t.Errorf("mismatched platform, actual %v expected %v", parsed.Platform, et.Platform)
+		}
+		if len(parsed.Entries) != len(expected) {
+			t.Fatalf("mismatched number of entries, actual %d expected %d", len(parsed.Entries), len(expected))
+		}
+		for i, e := range parsed.Entries {
+			if *e != *expected[i] {
+				t.Errorf("%d: mismatched entry, actual then expected\n%+v\n%+v", i, *e, *expected[i])
+			}
+		}
+	}
+
+	t.Run("generated", func(t *testing.T) {
+		b := et.generateCatalog()
+		// the catalog is followed by the rest of its block
+		b = append(b, make([]byte, 2048-len(b))...)
+		parsed, err := parseElToritoCatalog(b)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		check(t, parsed)
+	})
+	t.Run("extension entries", func(t *testing.T) {
+		b := et.generateCatalog()
+		// give the second entry two extension entries
+		second := 3 * elToritoEntrySize
+		b[second+1] |= elToritoExtensionFollows
+		extensions := make([]byte, 2*elToritoEntrySize)
+		extensions[0] = elToritoExtension
+		extensions[1] = elToritoExtensionFollows
+		extensions[elToritoEntrySize] = elToritoExtension
+		b = append(b[:second+elToritoEntrySize], append(extensions, b[second+elToritoEntrySize:]...)...)
+		parsed, err := parseElToritoCatalog(b)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		check(t, parsed)
+	})
+	t.Run("initial not bootable", func(t *testing.T) {
+		b := et.generateCatalog()
+		b[elToritoEntrySize] = elToritoNotBootable
+		parsed, err := parseElToritoCatalog(b)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(parsed.Entries) != len(expected) || !parsed.Entries[0].NotBootable {
+			t.Errorf("initial entry is not kept as not bootable")
+		}
+		// an initial entry that is not recorded at all is not one
+		copy(b[elToritoEntrySize:2*elToritoEntrySize], make([]byte, elToritoEntrySize))
+		if parsed, err = parseElToritoCatalog(b); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(parsed.Entries) != len(expected)-1 {
+			t.Errorf("mismatched number of entries, actual %d expected %d", len(parsed.Entries), len(expected)-1)
+		}
+	})
+	t.Run("short", func(t *testing.T) {
+		b := et.generateCatalog()
+		if _, err := parseElToritoCatalog(b[:len(b)-elToritoEntrySize]); err != errElToritoCatalogShort {
+			t.Errorf("mismatched error, actual %v expected %v", err, errElToritoCatalogShort)
+		}
+	})
+	t.Run("bad checksum", func(t *testing.T) {
+		b := et.generateCatalog()
+		b[0x1c]++
+		if _, err := parseElToritoCatalog(b); err == nil {
+			t.Errorf("unexpected nil error")
+		}
+	})
 }
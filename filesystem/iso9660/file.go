@@ -17,6 +17,9 @@ type File struct {
 	isAppend    bool
 	offset      int64
 	closed      bool
+	// the last block of a zisofs file that was decompressed
+	zisofsData  []byte
+	zisofsIndex int64
 }
 
 // Read reads up to len(b) bytes from the File.
@@ -24,18 +27,17 @@ type File struct {
 // At end of file, Read returns 0, io.EOF
 // reads from the last known offset in the file from last read or write
 // use Seek() to set at a particular point
+//
+// a file that is compressed with zisofs is decompressed as it is read
 func (fl *File) Read(b []byte) (int, error) {
 	if fl == nil || fl.closed {
 		return 0, os.ErrClosed
 	}
-	// we have the DirectoryEntry, so we can get the starting location and size
-	// since iso9660 files are contiguous, we only need the starting location and size
-	//   to get the entire file
-	fs := fl.filesystem
-	size := int(fl.size) - int(fl.offset)
-	location := int(fl.location)
+	if zf, ok := fl.zisofs(); ok {
+		return fl.readZisofs(b, zf)
+	}
+	size := fl.Size() - fl.offset
 	maxRead := size
-	file := fs.backend
 
 	// if there is nothing left to read, just return EOF
 	if size <= 0 {
@@ -45,22 +47,62 @@ func (fl *File) Read(b []byte) (int, error) {
 	// we stop when we hit the lesser of
 	//   1- len(b)
 	//   2- file end
-	if len(b) < maxRead {
-		maxRead = len(b)
+	if int64(len(b)) < maxRead {
+		maxRead = int64(len(b))
 	}
 
-	// just read the requested number of bytes and change our offset
-	_, err := file.ReadAt(b[0:maxRead], int64(location)*fs.blocksize+fl.offset)
-	if err != nil && err != io.EOF {
-		return 0, err
+	read, err := fl.readStored(b[:maxRead], fl.offset)
+	fl.offset += int64(read)
+	if err != nil {
+		return read, err
 	}
 
-	fl.offset += int64(maxRead)
 	var retErr error
-	if fl.offset >= int64(fl.size) {
+	if fl.offset >= fl.Size() {
 		retErr = io.EOF
 	}
-	return maxRead, retErr
+	return read, retErr
+}
+
+// readStored reads len(b) bytes of the file as it is stored, beginning at offset off
+func (fl *File) readStored(b []byte, off int64) (int, error) {
+	// we have the DirectoryEntry, so we can get the starting location and size
+	// since iso9660 files are contiguous, we only need the starting location and size
+	//   to get the entire file, or of each of its extents if it is in more than one
+	fs := fl.filesystem
+	file := fs.backend
+
+	// just read the requested number of bytes from each extent in turn
+	var (
+		read  int64
+		start int64
+	)
+	for _, e := range fl.fileExtents() {
+		if read == int64(len(b)) {
+			break
+		}
+		end := start + int64(e.size)
+		if off >= end {
+			start = end
+			continue
+		}
+		pos := off - start
+		n := end - off
+		if n > int64(len(b))-read {
+			n = int64(len(b)) - read
+		}
+		_, err := file.ReadAt(b[read:read+n], int64(e.location)*fs.blocksize+pos)
+		if err != nil && err != io.EOF {
+			return int(read), err
+		}
+		read += n
+		off += n
+		start = end
+	}
+	if read < int64(len(b)) {
+		return int(read), io.ErrUnexpectedEOF
+	}
+	return int(read), nil
 }
 
 // Write writes len(b) bytes to the File.
@@ -80,7 +122,7 @@ func (fl *File) Seek(offset int64, whence int) (int64, error) {
 	case io.SeekStart:
 		newOffset = offset
 	case io.SeekEnd:
-		newOffset = int64(fl.size) + offset
+		newOffset = fl.Size() + offset
 	case io.SeekCurrent:
 		newOffset = fl.offset + offset
 	}
@@ -1,6 +1,7 @@
 package iso9660
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"io/fs"
@@ -12,7 +13,7 @@ import (
 	"strings"
 	"time"
 
-	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/filesystem/udf"
 	"github.com/diskfs/go-diskfs/util"
 	"github.com/djherbis/times"
 )
@@ -31,8 +32,61 @@ type FinalizeOptions struct {
 	DeepDirectories bool
 	// ElTorito slice of el torito entry configs
 	ElTorito *ElTorito
-	// VolumeIdentifier custom volume name, defaults to "ISOIMAGE"
+	// VolumeIdentifier custom volume name, defaults to "ISOIMAGE", of up to 32 characters
 	VolumeIdentifier string
+	// SystemIdentifier the system that can act on the system area, of up to 32 characters
+	SystemIdentifier string
+	// VolumeSetIdentifier, PublisherIdentifier, PreparerIdentifier and ApplicationIdentifier identify the set of
+	// volumes, the publisher, who prepared the data and the application on it, each of up to 128 characters.
+	// PreparerIdentifier defaults to go-diskfs and its version.
+	VolumeSetIdentifier   string
+	PublisherIdentifier   string
+	PreparerIdentifier    string
+	ApplicationIdentifier string
+	// CopyrightFile, AbstractFile and BibliographicFile the names of files in the root directory with the copyright,
+	// abstract and bibliographic information of the volume, each of up to 37 characters
+	CopyrightFile     string
+	AbstractFile      string
+	BibliographicFile string
+	// CreationTime and ModificationTime of the volume, both default to when it is finalized
+	CreationTime     time.Time
+	ModificationTime time.Time
+	// ExpirationTime after which the volume is obsolete, and EffectiveTime from when it may be used, neither of
+	// which is set by default
+	ExpirationTime time.Time
+	EffectiveTime  time.Time
+	// SourceDateEpoch make the image reproducible, the same byte for byte from the same files and options, as
+	// SOURCE_DATE_EPOCH does for builds, which SourceDateEpochFromEnv reads. It is the time of everything that
+	// otherwise would get the time that the image is made, such as the volume descriptors, unless their times are
+	// set, and the boot catalog. The times of files and directories that are later than it are clamped to it, and
+	// the entries of each directory are in order by name, however they were added.
+	SourceDateEpoch time.Time
+	// NormalizeOwners make the Rock Ridge owner and group of every file and directory 0, rather than what they
+	// are on the host, for the same image from files that belong to anyone
+	NormalizeOwners bool
+	// Joliet add a Joliet supplementary volume descriptor, with its own directory tree and path tables,
+	// holding the names of files and directories as they are in the workspace, in UCS-2, of up to 64 characters.
+	// Its identifiers have room for half as many characters as those of the primary volume descriptor, so longer
+	// ones are cut short there, such as the volume identifier to 16 characters.
+	Joliet bool
+	// UDF make an ISO9660/UDF bridge image, with a UDF file system that shares the contents of the files
+	UDF bool
+	// UDFRevision revision of UDF to write for a bridge image, udf.Revision102 or udf.Revision201, defaults to
+	// udf.Revision201
+	UDFRevision udf.Revision
+	// Zisofs compress files with zisofs, which Linux decompresses as it reads them, as does reading them here.
+	// Requires RockRidge, and cannot be used with UDF. Files that would not get any smaller, those of 4 GB or
+	// more, and El Torito boot images are left as they are.
+	Zisofs bool
+	// Deduplicate write the contents of files that are hard links of each other, or that are the same byte for
+	// byte, just once, with the directory records of all of them pointing at it. With Rock Ridge, hard links have
+	// the same serial number, and as many links as there are of them in the image. El Torito boot images are
+	// written on their own.
+	Deduplicate bool
+	// Hybrid make an image that also boots as a disk, such as when written to a USB stick, with a master boot
+	// record and partition tables in its system area. Requires ElTorito, and cannot be used in a session appended
+	// to an image.
+	Hybrid *Hybrid
 }
 
 // finalizeFileInfo is a file info useful for finalization
@@ -80,10 +134,30 @@ type finalizeFileInfo struct {
 	uid                uint32
 	gid                uint32
 	nlink              uint32
+	devMajor           uint32
+	devMinor           uint32
 	// content in memory content of file. If this is anything other than nil, including a zero-length slice,
 	// then this content is used, rather than anything on disk.
 	content []byte
-	serial  uint64
+	// open opens the contents of the file where they are, when they are not in memory
+	open   func() (io.ReadCloser, error)
+	serial uint64
+	// zisofs how the file is compressed, if it is
+	zisofs *zisofsFile
+	// location and size of the directory in the Joliet directory tree, if any
+	jolietLocation uint32
+	jolietSize     int64
+	// jolietUnique the name in the Joliet directory tree, without version, if it was made unique with a numeric tail
+	jolietUnique string
+	// fixedLocation the contents of the file already are in the image, at location, as they are for a file of a
+	// previous session; they are neither laid out nor written again
+	fixedLocation bool
+	// extents of a file with a fixed location that is in more than one extent, which might not follow each other
+	extents []directoryExtent
+	// hostFile the file on the host that this is, to find hard links of it, if known
+	hostFile hostFile
+	// duplicateOf the file with the same contents as this one, which are written just once, where that one is
+	duplicateOf *finalizeFileInfo
 }
 
 func finalizeFileInfoFromFile(p, fullPath string, fi fs.FileInfo) (*finalizeFileInfo, error) {
@@ -109,6 +183,27 @@ func finalizeFileInfoFromFile(p, fullPath string, fi fs.FileInfo) (*finalizeFile
 	}
 	nlink, uid, gid := statt(fi)
 
+	// only regular files have contents; for the others, such as symlinks and devices, it is all in Rock Ridge
+	var (
+		open               func() (io.ReadCloser, error)
+		content            []byte
+		size               = fi.Size()
+		devMajor, devMinor uint32
+	)
+	switch {
+	case fi.IsDir():
+	case mode.IsRegular():
+		open = func() (io.ReadCloser, error) {
+			return os.Open(fullPath)
+		}
+	default:
+		content = []byte{}
+		size = 0
+		if mode&os.ModeDevice == os.ModeDevice {
+			devMajor, devMinor = devt(fi)
+		}
+	}
+
 	return &finalizeFileInfo{
 		path:       p,
 		name:       name,
@@ -118,12 +213,17 @@ func finalizeFileInfoFromFile(p, fullPath string, fi fs.FileInfo) (*finalizeFile
 		accessTime: t.AccessTime(),
 		changeTime: t.ChangeTime(),
 		mode:       mode,
-		size:       fi.Size(),
+		size:       size,
 		shortname:  shortname,
 		linkTarget: target,
 		uid:        uid,
 		gid:        gid,
 		nlink:      nlink,
+		devMajor:   devMajor,
+		devMinor:   devMinor,
+		open:       open,
+		content:    content,
+		hostFile:   hostFileOf(fi),
 	}, nil
 }
 
@@ -180,10 +280,18 @@ func (fi *finalizeFileInfo) GID() uint32 {
 }
 
 func (fi *finalizeFileInfo) toDirectoryEntry(fsm *FileSystem, isSelf, isParent bool) (*directoryEntry, error) {
+	location, size, name := fi.location, fi.Size(), fi.Name()
+	// the Joliet directory tree has directories of its own, but shares the files
+	if fsm.joliet {
+		name = fi.jolietName()
+		if fi.isDir {
+			location, size = fi.jolietLocation, fi.jolietSize
+		}
+	}
 	de := &directoryEntry{
 		extAttrSize:              0,
-		location:                 fi.location,
-		size:                     uint32(fi.Size()),
+		location:                 location,
+		size:                     uint32(size),
 		creation:                 fi.ModTime(),
 		isHidden:                 false,
 		isSubdirectory:           fi.IsDir(),
@@ -196,7 +304,7 @@ func (fi *finalizeFileInfo) toDirectoryEntry(fsm *FileSystem, isSelf, isParent b
 		volumeSequence:           1,
 		filesystem:               fsm,
 		// we keep the full filename until after processing
-		filename: fi.Name(),
+		filename: name,
 	}
 	// if it is root, and we have susp enabled, add the necessary entries
 	if fsm.suspEnabled {
@@ -232,8 +340,8 @@ func (fi *finalizeFileInfo) toDirectoryEntry(fsm *FileSystem, isSelf, isParent b
 func (fi *finalizeFileInfo) toDirectory(fsm *FileSystem) (*Directory, error) {
 	// also need to add self and parent to it
 	var (
-		self, parent, dirEntry *directoryEntry
-		err                    error
+		self, parent *directoryEntry
+		err          error
 	)
 	if !fi.IsDir() {
 		return nil, fmt.Errorf("cannot convert a file entry to a directtory")
@@ -256,11 +364,12 @@ func (fi *finalizeFileInfo) toDirectory(fsm *FileSystem) (*Directory, error) {
 
 	entries := []*directoryEntry{self, parent}
 	for _, child := range fi.children {
-		dirEntry, err = child.toDirectoryEntry(fsm, false, false)
+		var records []*directoryEntry
+		records, err = child.toDirectoryEntries(fsm)
 		if err != nil {
 			return nil, fmt.Errorf("could not convert child entry %s to dirEntry: %v", child.path, err)
 		}
-		entries = append(entries, dirEntry)
+		entries = append(entries, records...)
 	}
 	d := &Directory{
 		directoryEntry: *self,
@@ -271,12 +380,17 @@ func (fi *finalizeFileInfo) toDirectory(fsm *FileSystem) (*Directory, error) {
 
 // calculate the size of a directory entry single record
 func (fi *finalizeFileInfo) calculateRecordSize(fsm *FileSystem, isSelf, isParent bool) (dirEntrySize, continuationBlocksSize int, err error) {
-	// we do not actually need the the continuation blocks to calculate size, just length, so use an empty slice
-	extTmpBlocks := make([]uint32, 100)
 	dirEntry, err := fi.toDirectoryEntry(fsm, isSelf, isParent)
 	if err != nil {
 		return 0, 0, fmt.Errorf("could not convert to dirEntry: %v", err)
 	}
+	return calculateDirectoryEntrySize(dirEntry)
+}
+
+// calculate the size of a directory record and the number of continuation blocks for its SUSP entries
+func calculateDirectoryEntrySize(dirEntry *directoryEntry) (dirEntrySize, continuationBlocksSize int, err error) {
+	// we do not actually need the the continuation blocks to calculate size, just length, so use an empty slice
+	extTmpBlocks := make([]uint32, 100)
 	dirBytes, err := dirEntry.toBytes(false, extTmpBlocks)
 	if err != nil {
 		return 0, 0, fmt.Errorf("could not convert dirEntry to bytes: %v", err)
@@ -286,6 +400,49 @@ func (fi *finalizeFileInfo) calculateRecordSize(fsm *FileSystem, isSelf, isParen
 	return len(dirBytes[0]), len(dirBytes) - 1, nil
 }
 
+// toDirectoryEntries converts a child of a directory to its directory records: one, unless it is a file too
+// large for a single extent, in which case it is split into as many extents as it needs, each in a record of its
+// own, with all but the last flagged as having more
+func (fi *finalizeFileInfo) toDirectoryEntries(fsm *FileSystem) ([]*directoryEntry, error) {
+	de, err := fi.toDirectoryEntry(fsm, false, false)
+	if err != nil {
+		return nil, err
+	}
+	size := fi.Size()
+	maxSize := maxExtentSize(fsm.blocksize)
+	if fi.IsDir() || (len(fi.extents) == 0 && size <= maxSize) {
+		return []*directoryEntry{de}, nil
+	}
+	// a file of a previous session keeps the extents that it has
+	if len(fi.extents) > 0 {
+		entries := make([]*directoryEntry, 0, len(fi.extents))
+		for i, e := range fi.extents {
+			record := *de
+			record.location = e.location
+			record.size = e.size
+			record.hasMoreEntries = i < len(fi.extents)-1
+			entries = append(entries, &record)
+		}
+		return entries, nil
+	}
+	entries := make([]*directoryEntry, 0, (size+maxSize-1)/maxSize)
+	location := fi.location
+	for size > 0 {
+		extentSize := size
+		if extentSize > maxSize {
+			extentSize = maxSize
+		}
+		record := *de
+		record.location = location
+		record.size = uint32(extentSize)
+		size -= extentSize
+		record.hasMoreEntries = size > 0
+		entries = append(entries, &record)
+		location += uint32(extentSize / fsm.blocksize)
+	}
+	return entries, nil
+}
+
 // calculate the size of a directory, similar to a file size
 func (fi *finalizeFileInfo) calculateDirectorySize(fsm *FileSystem) (dirEntrySize, continuationBlocksSize int, err error) {
 	var (
@@ -309,20 +466,26 @@ func (fi *finalizeFileInfo) calculateDirectorySize(fsm *FileSystem) (dirEntrySiz
 	continuationBlocksSize += recCE
 
 	for _, e := range fi.children {
-		// get size of data and CE blocks
-		recSize, recCE, err = e.calculateRecordSize(fsm, false, false)
+		records, err := e.toDirectoryEntries(fsm)
 		if err != nil {
-			return 0, 0, fmt.Errorf("could not calculate child %s entry size %s: %v", e.path, fi.path, err)
+			return 0, 0, fmt.Errorf("could not convert child %s of %s to dirEntry: %v", e.path, fi.path, err)
 		}
-		// do not go over a block boundary; pad if necessary
-		newSize := dirEntrySize + recSize
-		blocksize := int(fsm.blocksize)
-		left := blocksize - dirEntrySize%blocksize
-		if left != 0 && newSize/blocksize > dirEntrySize/blocksize {
-			dirEntrySize += left
+		for _, record := range records {
+			// get size of data and CE blocks
+			recSize, recCE, err = calculateDirectoryEntrySize(record)
+			if err != nil {
+				return 0, 0, fmt.Errorf("could not calculate child %s entry size %s: %v", e.path, fi.path, err)
+			}
+			// do not go over a block boundary; pad if necessary
+			newSize := dirEntrySize + recSize
+			blocksize := int(fsm.blocksize)
+			left := blocksize - dirEntrySize%blocksize
+			if left != 0 && newSize/blocksize > dirEntrySize/blocksize {
+				dirEntrySize += left
+			}
+			continuationBlocksSize += recCE
+			dirEntrySize += recSize
 		}
-		continuationBlocksSize += recCE
-		dirEntrySize += recSize
 	}
 	return dirEntrySize, continuationBlocksSize, nil
 }
@@ -350,14 +513,9 @@ func (fi *finalizeFileInfo) collapseAndSortChildren() (dirs, files []*finalizeFi
 	}
 
 	// next sort them
-	sort.Slice(tmpDirs, func(i, j int) bool {
-		// just sort by filename; as good as anything else
-		return tmpDirs[i].Name() < tmpDirs[j].Name()
-	})
-	sort.Slice(tmpFiles, func(i, j int) bool {
-		// just sort by filename; as good as anything else
-		return tmpFiles[i].Name() < tmpFiles[j].Name()
-	})
+	// just sort by filename; as good as anything else
+	sortChildren(tmpDirs)
+	sortChildren(tmpFiles)
 	// finally add in the children going down
 	dirs = make([]*finalizeFileInfo, 0)
 	files = tmpFiles
@@ -420,19 +578,117 @@ func (fi *finalizeFileInfo) addChild(entry *finalizeFileInfo) {
 	fi.children = append(fi.children, entry)
 }
 
+// udfBridgeEntries builds the tree of entries for the UDF side of a bridge image, with the directories where they
+// are in the workspace rather than where Rock Ridge relocated them. It records which entry is for which file, so
+// that their locations can be filled in once the files are laid out.
+func (fi *finalizeFileInfo) udfBridgeEntries(entries map[*finalizeFileInfo]*udf.BridgeEntry) *udf.BridgeEntry {
+	e := &udf.BridgeEntry{
+		Name:    fi.name,
+		IsDir:   fi.isDir,
+		Mode:    fi.mode,
+		ModTime: fi.modTime,
+	}
+	if !fi.isDir {
+		e.Size = fi.size
+		entries[fi] = e
+		return e
+	}
+	for _, c := range fi.children {
+		if c.trueChild != nil {
+			c = c.trueChild
+		}
+		if c.trueParent != nil && c.trueParent != fi {
+			continue
+		}
+		// UDF has just the files and directories, not any of the special files of Rock Ridge
+		if !c.isDir && !c.mode.IsRegular() {
+			continue
+		}
+		e.Children = append(e.Children, c.udfBridgeEntries(entries))
+	}
+	return e
+}
+
 // Finalize finalize a read-only filesystem by writing it out to a read-only format
-//
-//nolint:gocyclo // this finalize function is complex and needs to be. We might be better off refactoring it to multiple functions, but it does not buy all that much.
 func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 	if fsm.workspace == "" {
 		return fmt.Errorf("cannot finalize an already finalized filesystem")
 	}
 
+	fileList, dirList, err := walkTree(fsm.Workspace())
+	if err != nil {
+		return fmt.Errorf("error walking tree: %v", err)
+	}
+
+	f, err := fsm.backend.Writable()
+	if err != nil {
+		return err
+	}
+
+	if err := fsm.finalize(fileList, dirList, options, io.NewOffsetWriter(f, 0), 0); err != nil {
+		return err
+	}
+
+	_ = os.RemoveAll(fsm.workspace)
+
+	// finish by setting as finalized
+	fsm.workspace = ""
+	return nil
+}
+
+// finalize lays out the tree of files and directories of fileList and dirList, and writes the image to w,
+// from its first byte to its last, without ever going back.
+//
+// start is the block at which the image begins, which is 0 unless it is a session appended to an image, when it
+// is the block after the previous session. w gets just what is from start on, and all locations are from the
+// beginning of the whole image, as they are for multisession images.
+//
+//nolint:gocyclo // this finalize function is complex and needs to be. We might be better off refactoring it to multiple functions, but it does not buy all that much.
+func (fsm *FileSystem) finalize(fileList []*finalizeFileInfo, dirList map[string]*finalizeFileInfo, options FinalizeOptions, w io.Writer, start uint32) error {
+	var err error
+
+	if options.Zisofs && !options.RockRidge {
+		return fmt.Errorf("zisofs compression requires Rock Ridge extensions")
+	}
+	if options.Zisofs && options.UDF {
+		return fmt.Errorf("zisofs compression cannot be used with UDF, which would share the compressed contents")
+	}
+	if options.UDF && start != 0 {
+		return fmt.Errorf("UDF cannot be used in a session appended to an image")
+	}
+	if err := validateVolumeDescriptorOptions(options); err != nil {
+		return err
+	}
+	if options.Hybrid != nil {
+		if start != 0 {
+			return fmt.Errorf("hybrid image cannot be made in a session appended to an image")
+		}
+		if err := options.Hybrid.validate(options.ElTorito); err != nil {
+			return err
+		}
+	}
+	for _, e := range fileList {
+		if e.zisofs != nil && e.fixedLocation && !options.RockRidge {
+			return fmt.Errorf("%s is compressed with zisofs, which requires Rock Ridge extensions", e.path)
+		}
+	}
+
+	normalizeFileInfo(fileList, dirList, options)
+
 	// did we ask for susp?
 	if options.RockRidge {
 		fsm.suspEnabled = true
 		fsm.suspExtensions = append(fsm.suspExtensions, getRockRidgeExtension(rockRidge112))
 	}
+	// the Joliet directory tree is written by way of a filesystem that has it in use
+	var jolietFS *FileSystem
+	if options.Joliet {
+		jolietFS = &FileSystem{
+			backend:   fsm.backend,
+			blocksize: fsm.blocksize,
+			joliet:    true,
+		}
+	}
 
 	/*
 		There is nothing in the iso9660 spec about the order of directories and files,
@@ -450,41 +706,22 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 		- data sectors for files, sorted alphabetically, matching order of directories
 
 		this is where we build our filesystem
-		 1- blank out sectors 0-15 for system use
-		 2- skip sectors 16-17 for PVD and terminator (fill later)
-		 3- calculate how many sectors required for root directory
-		 4- calculate each child directory, working our way down, including number of sectors and location
-		 5- write path tables (L & M)
-		 6- write files for root directory
-		 7- write root directory entry into its sector (18)
-		 8- repeat steps 6&7 for all other directories
-		 9- write PVD
-		 10- write volume descriptor set terminator
+		 1- calculate how many sectors required for root directory
+		 2- calculate each child directory, working our way down, including number of sectors and location
+		 3- calculate the path tables (L & M)
+		 4- calculate the locations of the files
+		 5- blank out sectors 0-15 for system use
+		 6- write PVD and the other volume descriptors, and the volume descriptor set terminator
+		 7- write the directories, the path tables and the files, in that order
+
+		with Joliet, its supplementary volume descriptor comes after the PVD and boot volume descriptor,
+		and its directories and path tables follow those of the primary directory tree
+
+		everything is laid out before anything is written, so that the image can be written in order
 	*/
 
-	f, err := fsm.backend.Writable()
-	if err != nil {
-		return err
-	}
-
 	blocksize := int(fsm.blocksize)
 
-	// 1- blank out sectors 0-15
-	b := make([]byte, dataStartSector*fsm.blocksize)
-	n, err := f.WriteAt(b, 0)
-	if err != nil {
-		return fmt.Errorf("could not write blank system area: %v", err)
-	}
-	if n != len(b) {
-		return fmt.Errorf("only wrote %d bytes instead of expected %d to system area", n, len(b))
-	}
-
-	// 3- build out file tree
-	fileList, dirList, err := walkTree(fsm.Workspace())
-	if err != nil {
-		return fmt.Errorf("error walking tree: %v", err)
-	}
-
 	// starting point
 	root := dirList["."]
 	root.addProperties(1)
@@ -515,9 +752,25 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 		}
 	}
 
-	// convert sizes to required blocks for files
+	// find what needs to be written just once, before compressing it
+	if options.Deduplicate {
+		if err := deduplicate(fileList, options.ElTorito); err != nil {
+			return err
+		}
+	}
+
+	// compress what we can, which changes the sizes of the files
+	if options.Zisofs {
+		if err := compressZisofs(fileList, options.ElTorito); err != nil {
+			return err
+		}
+	}
+
+	// convert sizes to required blocks for files, other than those that already are in the image
 	for _, e := range fileList {
-		e.blocks = calculateBlocks(e.size, fsm.blocksize)
+		if !e.fixedLocation {
+			e.blocks = calculateBlocks(e.size, fsm.blocksize)
+		}
 	}
 
 	// we now have list of all of the files and directories and their properties, as well as children of every directory
@@ -528,13 +781,16 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 	dirs = append(dirs, subdirs...)
 
 	// calculate the sizes and locations of the directories from the flat list and assign blocks
-	rootLocation := uint32(dataStartSector + 2)
+	rootLocation := start + dataStartSector + 2
 	// if el torito was enabled, use one sector for boot volume entry
 	if options.ElTorito != nil {
 		rootLocation++
 	}
+	// and one for the Joliet supplementary volume descriptor
+	if jolietFS != nil {
+		rootLocation++
+	}
 	location := rootLocation
-
 	var (
 		catEntry *finalizeFileInfo
 		bootcat  []byte
@@ -553,7 +809,7 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 		shortname, extension := calculateShortnameExtension(path.Base(catname))
 		// break down the catalog basename from the parent dir
 		catSize := int64(len(bootcat))
-		now := time.Now()
+		now := options.now()
 		catEntry = &finalizeFileInfo{
 			content:    bootcat,
 			size:       catSize,
@@ -569,13 +825,18 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 		// make it the first file
 		files = append([]*finalizeFileInfo{catEntry}, files...)
 
-		// if we were not told to hide the catalog, add it to its parent
+		// if we were not told to hide the catalog, add it to its parent, in place of the catalog of any
+		// previous session
 		if !options.ElTorito.HideBootCatalog {
-			var parent *finalizeFileInfo
+			var parent, previous *finalizeFileInfo
 			parent, err = root.findEntry(path.Dir(catname))
 			if err != nil {
 				return fmt.Errorf("error finding parent for boot catalog %s: %v", catname, err)
 			}
+			if previous, _ = parent.findEntry(path.Base(catname)); previous != nil && previous.fixedLocation {
+				parent.removeChild(path.Base(catname))
+				files = removeFinalizeFileInfo(files, previous)
+			}
 			parent.addChild(catEntry)
 		}
 		for _, e := range options.ElTorito.Entries {
@@ -599,9 +860,39 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 			// save the child so we can add location late
 			e.size = uint32(child.size)
 			child.elToritoEntry = e
+			// a boot image of a previous session gets a boot table by being written again in this one
+			if child.fixedLocation && e.BootTable {
+				if child.zisofs != nil || len(child.extents) > 0 {
+					return fmt.Errorf("cannot write boot image %s of a previous session again with a boot table", e.BootFile)
+				}
+				child.fixedLocation = false
+				child.blocks = calculateBlocks(child.size, fsm.blocksize)
+			}
 		}
 	}
 
+	// with UDF, its structures go after the volume descriptors, and everything else after them
+	var (
+		bridge        *udf.Bridge
+		bridgeEntries map[*finalizeFileInfo]*udf.BridgeEntry
+	)
+	if options.UDF {
+		bridgeEntries = make(map[*finalizeFileInfo]*udf.BridgeEntry)
+		udfOptions := udf.FinalizeOptions{
+			Revision:         options.UDFRevision,
+			VolumeIdentifier: defaultVolumeIdentifier,
+			RecordingTime:    options.now(),
+		}
+		if options.VolumeIdentifier != "" {
+			udfOptions.VolumeIdentifier = options.VolumeIdentifier
+		}
+		bridge, err = udf.NewBridge(root.udfBridgeEntries(bridgeEntries), fsm.blocksize, rootLocation, udfOptions)
+		if err != nil {
+			return fmt.Errorf("could not lay out UDF file system: %v", err)
+		}
+		location = bridge.DataStart()
+	}
+
 	var size, ceBlocks int
 	for _, dir := range dirs {
 		dir.location = location
@@ -614,13 +905,29 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 		dir.continuationBlocks = uint32(ceBlocks)
 		location += dir.blocks + dir.continuationBlocks
 	}
+	if jolietFS != nil {
+		for _, dir := range dirs {
+			if err := dir.setJolietNames(); err != nil {
+				return err
+			}
+		}
+		for _, dir := range dirs {
+			dir.jolietLocation = location
+			size, _, err = dir.calculateDirectorySize(jolietFS)
+			if err != nil {
+				return fmt.Errorf("unable to calculate size of Joliet directory for %s: %v", dir.path, err)
+			}
+			dir.jolietSize = int64(size)
+			location += calculateBlocks(int64(size), int64(blocksize))
+		}
+	}
 
 	// we now have sorted list of block order, with sizes and number of blocks on each
 	// next assign the blocks to each, and then we can enter the data in the directory entries
 
 	// create the pathtables (L & M)
 	// with the list of directories, we can make a path table
-	pathTable := createPathTable(dirs)
+	pathTable := createPathTable(dirs, false)
 	// how big is the path table? we will take LSB for now, because they are the same size
 	pathTableLBytes := pathTable.toLBytes()
 	pathTableMBytes := pathTable.toMBytes()
@@ -635,6 +942,21 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 	pathTableMLocation := location
 	location += pathTableBlocks
 
+	var (
+		jolietPathTableLBytes, jolietPathTableMBytes       []byte
+		jolietPathTableLLocation, jolietPathTableMLocation uint32
+	)
+	if jolietFS != nil {
+		jolietPathTable := createPathTable(dirs, true)
+		jolietPathTableLBytes = jolietPathTable.toLBytes()
+		jolietPathTableMBytes = jolietPathTable.toMBytes()
+		jolietPathTableBlocks := calculateBlocks(int64(len(jolietPathTableLBytes)), int64(blocksize))
+		jolietPathTableLLocation = location
+		location += jolietPathTableBlocks
+		jolietPathTableMLocation = location
+		location += jolietPathTableBlocks
+	}
+
 	// if we asked for ElTorito, need to generate the boot catalog and save it
 	volIdentifier := defaultVolumeIdentifier
 	if options.VolumeIdentifier != "" {
@@ -642,12 +964,24 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 	}
 
 	for _, e := range files {
-		e.location = location
-		location += e.blocks
+		if !e.fixedLocation && e.duplicateOf == nil {
+			e.location = location
+			location += e.blocks
+		}
 		if e.elToritoEntry != nil {
 			e.elToritoEntry.location = e.location
 		}
 	}
+	// duplicates are where the files that they duplicate are, which may come after them
+	for _, e := range files {
+		if e.duplicateOf != nil {
+			e.location = e.duplicateOf.location
+		}
+	}
+
+	for fi, e := range bridgeEntries {
+		e.Location = fi.location
+	}
 
 	// now that we have all of the files with their locations, we can rebuild the boot catalog using the correct data
 	if catEntry != nil {
@@ -655,9 +989,136 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 		catEntry.content = bootcat
 	}
 
+	totalSize := location
+	// UDF has an anchor in the last block
+	if bridge != nil {
+		totalSize++
+	}
+
+	// a hybrid image has partition tables in its system area, and maybe some after the end of the volume
+	var systemArea, tail []byte
+	if options.Hybrid != nil {
+		systemArea, tail, err = options.Hybrid.tables(options.ElTorito, totalSize, fsm.blocksize, options.SourceDateEpoch)
+		if err != nil {
+			return fmt.Errorf("could not create partition tables of hybrid image: %v", err)
+		}
+	}
+
+	// everything is laid out, so we can write it all in order
+	sw := &sequentialWriter{w: w, offset: int64(start) * fsm.blocksize}
+
+	// blank out sectors 0-15, other than anything in the system area
+	if systemArea != nil {
+		if _, err := sw.Write(systemArea); err != nil {
+			return fmt.Errorf("could not write system area: %v", err)
+		}
+	}
+	if err := sw.pad(int64(start+dataStartSector) * fsm.blocksize); err != nil {
+		return fmt.Errorf("could not write blank system area: %v", err)
+	}
+
+	// create and write the primary volume descriptor, supplementary and boot, and volume descriptor set terminator
+	location = start + dataStartSector
+	now := options.now()
+	preparer := util.AppNameVersion
+	if options.PreparerIdentifier != "" {
+		preparer = options.PreparerIdentifier
+	}
+	creation, modification := options.CreationTime, options.ModificationTime
+	if creation.IsZero() {
+		creation = now
+	}
+	if modification.IsZero() {
+		modification = now
+	}
+	rootDE, err := root.toDirectoryEntry(fsm, true, false)
+	if err != nil {
+		return fmt.Errorf("could not convert root entry for primary volume descriptor to dirEntry: %v", err)
+	}
+
+	pvd := &primaryVolumeDescriptor{
+		systemIdentifier:           options.SystemIdentifier,
+		volumeIdentifier:           volIdentifier,
+		volumeSize:                 totalSize,
+		setSize:                    1,
+		sequenceNumber:             1,
+		blocksize:                  uint16(fsm.blocksize),
+		pathTableSize:              uint32(pathTableSize),
+		pathTableLLocation:         pathTableLLocation,
+		pathTableLOptionalLocation: 0,
+		pathTableMLocation:         pathTableMLocation,
+		pathTableMOptionalLocation: 0,
+		volumeSetIdentifier:        options.VolumeSetIdentifier,
+		publisherIdentifier:        options.PublisherIdentifier,
+		preparerIdentifier:         preparer,
+		applicationIdentifier:      options.ApplicationIdentifier,
+		copyrightFile:              options.CopyrightFile,     // 37 bytes
+		abstractFile:               options.AbstractFile,      // 37 bytes
+		bibliographicFile:          options.BibliographicFile, // 37 bytes
+		creation:                   creation,
+		modification:               modification,
+		expiration:                 options.ExpirationTime,
+		effective:                  options.EffectiveTime,
+		rootDirectoryEntry:         rootDE,
+	}
+	descriptors := [][]byte{pvd.toBytes()}
+
+	// do we have a boot sector?
+	if options.ElTorito != nil {
+		bvd := &bootVolumeDescriptor{location: catEntry.location}
+		descriptors = append(descriptors, bvd.toBytes())
+	}
+
+	if jolietFS != nil {
+		jolietRootDE, err := root.toDirectoryEntry(jolietFS, true, false)
+		if err != nil {
+			return fmt.Errorf("could not convert root entry for Joliet volume descriptor to dirEntry: %v", err)
+		}
+		// the identifiers are in UCS-2, so only half as many characters of them fit
+		svd := &supplementaryVolumeDescriptor{
+			systemIdentifier:      options.SystemIdentifier,
+			volumeIdentifier:      volIdentifier,
+			volumeSize:            uint64(totalSize) * uint64(fsm.blocksize),
+			escapeSequences:       jolietEscapeSequences[2],
+			setSize:               1,
+			sequenceNumber:        1,
+			blocksize:             uint16(fsm.blocksize),
+			pathTableSize:         uint32(len(jolietPathTableLBytes)),
+			pathTableLLocation:    jolietPathTableLLocation,
+			pathTableMLocation:    jolietPathTableMLocation,
+			volumeSetIdentifier:   options.VolumeSetIdentifier,
+			publisherIdentifier:   options.PublisherIdentifier,
+			preparerIdentifier:    preparer,
+			applicationIdentifier: options.ApplicationIdentifier,
+			copyrightFile:         options.CopyrightFile,
+			abstractFile:          options.AbstractFile,
+			bibliographicFile:     options.BibliographicFile,
+			creation:              creation,
+			modification:          modification,
+			expiration:            options.ExpirationTime,
+			effective:             options.EffectiveTime,
+			rootDirectoryEntry:    jolietRootDE,
+		}
+		descriptors = append(descriptors, svd.toBytes())
+	}
+	terminator := &terminatorVolumeDescriptor{}
+	descriptors = append(descriptors, terminator.toBytes())
+	for _, b := range descriptors {
+		if _, err := sw.WriteAt(b, int64(location)*int64(blocksize)); err != nil {
+			return fmt.Errorf("could not write volume descriptor: %v", err)
+		}
+		location++
+	}
+
+	// the UDF structures go between the volume descriptors and the directories
+	if bridge != nil {
+		if err := bridge.Write(sw, totalSize); err != nil {
+			return fmt.Errorf("could not write UDF file system: %v", err)
+		}
+	}
+
 	// now we can write each one out - dirs first then files
 	for _, e := range dirs {
-		writeAt := int64(e.location) * int64(blocksize)
 		var d *Directory
 		d, err = e.toDirectory(fsm)
 		if err != nil {
@@ -675,182 +1136,287 @@ func (fsm *FileSystem) Finalize(options FinalizeOptions) error {
 		if err != nil {
 			return fmt.Errorf("could not convert directory to bytes: %v", err)
 		}
-		for i, e := range p {
-			_, _ = f.WriteAt(e, writeAt+int64(i*blocksize))
+		// the first is the directory itself, any others are its continuation areas
+		if _, err := sw.WriteAt(p[0], int64(e.location)*int64(blocksize)); err != nil {
+			return fmt.Errorf("could not write directory %s: %v", e.path, err)
+		}
+		for i, b := range p[1:] {
+			if _, err := sw.WriteAt(b, int64(ceLocations[i])*int64(blocksize)); err != nil {
+				return fmt.Errorf("could not write continuation area of directory %s: %v", e.path, err)
+			}
 		}
 	}
 
-	// now write out the path tables, L & M
-	writeAt := int64(pathTableLLocation) * int64(blocksize)
-	_, _ = f.WriteAt(pathTableLBytes, writeAt)
-	writeAt = int64(pathTableMLocation) * int64(blocksize)
-	_, _ = f.WriteAt(pathTableMBytes, writeAt)
+	// the Joliet directories have no continuation areas, as they have no SUSP entries
+	if jolietFS != nil {
+		for _, e := range dirs {
+			var d *Directory
+			d, err = e.toDirectory(jolietFS)
+			if err != nil {
+				return fmt.Errorf("unable to convert entry to Joliet directory: %v", err)
+			}
+			var p [][]byte
+			p, err = d.entriesToBytes(nil)
+			if err != nil {
+				return fmt.Errorf("could not convert Joliet directory to bytes: %v", err)
+			}
+			if _, err := sw.WriteAt(p[0], int64(e.jolietLocation)*int64(blocksize)); err != nil {
+				return fmt.Errorf("could not write Joliet directory %s: %v", e.path, err)
+			}
+		}
+	}
 
-	var closeFiles []*os.File
-	defer func() {
-		for _, f := range closeFiles {
-			f.Close()
+	// now write out the path tables, L & M
+	pathTables := []struct {
+		b        []byte
+		location uint32
+	}{
+		{pathTableLBytes, pathTableLLocation},
+		{pathTableMBytes, pathTableMLocation},
+	}
+	if jolietFS != nil {
+		pathTables = append(pathTables, []struct {
+			b        []byte
+			location uint32
+		}{
+			{jolietPathTableLBytes, jolietPathTableLLocation},
+			{jolietPathTableMBytes, jolietPathTableMLocation},
+		}...)
+	}
+	for _, pt := range pathTables {
+		if _, err := sw.WriteAt(pt.b, int64(pt.location)*int64(blocksize)); err != nil {
+			return fmt.Errorf("could not write path table: %v", err)
 		}
-	}()
+	}
+
 	for _, e := range files {
-		var (
-			from             *os.File
-			copied           int
-			bootTableMinSize int
-		)
-		writeAt := int64(e.location) * int64(blocksize)
-		if e.content == nil {
-			// for file, just copy the data across
-			from, err = os.Open(path.Join(fsm.workspace, e.path))
+		if e.fixedLocation || e.duplicateOf != nil {
+			continue
+		}
+		var bootTable []byte
+		if e.elToritoEntry != nil && e.elToritoEntry.BootTable {
+			// the El Torito Boot Information Table goes into the file at byte 8, with a checksum of its contents
+			bootTable, err = e.generateBootTable(start + dataStartSector)
 			if err != nil {
-				return fmt.Errorf("failed to open file for reading %s: %v", e.path, err)
+				return fmt.Errorf("failed to generate boot table for %s: %v", e.path, err)
 			}
-			closeFiles = append(closeFiles, from)
-			if e.elToritoEntry != nil && e.elToritoEntry.BootTable {
-				// copy first 8 bytes, then insert the El Torito Boot Information Table, then the rest
-				var count int
+		}
+		if err := sw.pad(int64(e.location) * int64(blocksize)); err != nil {
+			return fmt.Errorf("could not pad before %s: %v", e.path, err)
+		}
+		copied, err := e.writeContents(sw, bootTable)
+		if err != nil {
+			return fmt.Errorf("failed to copy file to disk %s: %v", e.path, err)
+		}
+		if copied != e.Size() {
+			return fmt.Errorf("error copying file %s to disk, copied %d bytes, expected %d", e.path, copied, e.Size())
+		}
+	}
 
-				// first 8 bytes
-				count, err = copyFileData(from, f, 0, writeAt, elToritoBootTableOffset)
-				if err != nil {
-					return fmt.Errorf("failed to copy first bytes 0-8 of boot file to disk %s: %v", e.path, err)
-				}
-				copied += count
-				// insert El Torito Boot Information Table
-				bootTable, err := e.elToritoEntry.generateBootTable(dataStartSector, path.Join(fsm.workspace, e.path))
-				if err != nil {
-					return fmt.Errorf("failed to generate boot table for %s: %v", e.path, err)
-				}
-				count, err = f.WriteAt(bootTable, writeAt+elToritoBootTableOffset)
-				if err != nil {
-					return fmt.Errorf("failed to write 56 byte boot table to disk %s: %v", e.path, err)
-				}
-				copied += count
-				// file with boot table file must be a minimum of boot table size and the offset
-				bootTableMinSize = count
-				// remainder of file
-				count, err = copyFileData(from, f, 64, writeAt+64, 0)
-				if err != nil {
-					return fmt.Errorf("failed to copy bytes 64 to end of boot file to disk %s: %v", e.path, err)
-				}
-				copied += count
-			} else {
-				copied, err = copyFileData(from, f, 0, writeAt, 0)
-				if err != nil {
-					return fmt.Errorf("failed to copy file to disk %s: %v", e.path, err)
-				}
-			}
-			targetSize := e.Size()
-			if targetSize < int64(bootTableMinSize) {
-				targetSize = int64(bootTableMinSize)
-			}
-			if copied != int(targetSize) {
-				return fmt.Errorf("error copying file %s to disk, copied %d bytes, expected %d", e.path, copied, targetSize)
-			}
-		} else {
-			copied = len(e.content)
-			if _, err = f.WriteAt(e.content, writeAt); err != nil {
-				return fmt.Errorf("failed to write content of %s to disk: %v", e.path, err)
-			}
+	if bridge != nil {
+		if err := bridge.WriteAnchor(sw, totalSize); err != nil {
+			return fmt.Errorf("could not write UDF file system: %v", err)
 		}
-		// fill in
-		left := blocksize - (copied % blocksize)
-		if left > 0 {
-			b2 := make([]byte, left)
-			_, _ = f.WriteAt(b2, writeAt+int64(copied))
+	}
+	// fill in the rest of the last block
+	if err := sw.pad(int64(totalSize) * int64(blocksize)); err != nil {
+		return fmt.Errorf("could not write end of image: %v", err)
+	}
+	if tail != nil {
+		if _, err := sw.Write(tail); err != nil {
+			return fmt.Errorf("could not write partition tables after end of image: %v", err)
 		}
 	}
+	return nil
+}
 
-	totalSize := location
-	location = dataStartSector
-	// create and write the primary volume descriptor, supplementary and boot, and volume descriptor set terminator
-	now := time.Now()
-	rootDE, err := root.toDirectoryEntry(fsm, true, false)
+// generateBootTable generates the El Torito Boot Information Table for the file, which must be a boot image,
+// with the primary volume descriptor at pvdSector
+func (fi *finalizeFileInfo) generateBootTable(pvdSector uint32) ([]byte, error) {
+	r, err := fi.reader()
 	if err != nil {
-		return fmt.Errorf("could not convert root entry for primary volume descriptor to dirEntry: %v", err)
+		return nil, err
 	}
+	defer r.Close()
+	return fi.elToritoEntry.generateBootTable(pvdSector, r)
+}
 
-	pvd := &primaryVolumeDescriptor{
-		systemIdentifier:           "",
-		volumeIdentifier:           volIdentifier,
-		volumeSize:                 totalSize,
-		setSize:                    1,
-		sequenceNumber:             1,
-		blocksize:                  uint16(fsm.blocksize),
-		pathTableSize:              uint32(pathTableSize),
-		pathTableLLocation:         pathTableLLocation,
-		pathTableLOptionalLocation: 0,
-		pathTableMLocation:         pathTableMLocation,
-		pathTableMOptionalLocation: 0,
-		volumeSetIdentifier:        "",
-		publisherIdentifier:        "",
-		preparerIdentifier:         util.AppNameVersion,
-		applicationIdentifier:      "",
-		copyrightFile:              "", // 37 bytes
-		abstractFile:               "", // 37 bytes
-		bibliographicFile:          "", // 37 bytes
-		creation:                   now,
-		modification:               now,
-		expiration:                 now,
-		effective:                  now,
-		rootDirectoryEntry:         rootDE,
+// reader returns a reader of the contents of the file, from memory or from where they are
+func (fi *finalizeFileInfo) reader() (io.ReadCloser, error) {
+	if fi.content != nil {
+		return io.NopCloser(bytes.NewReader(fi.content)), nil
 	}
-	b = pvd.toBytes()
-	_, _ = f.WriteAt(b, int64(location)*int64(blocksize))
-	location++
+	if fi.open == nil {
+		return nil, fmt.Errorf("no contents for %s", fi.path)
+	}
+	return fi.open()
+}
 
-	// do we have a boot sector?
-	if options.ElTorito != nil {
-		bvd := &bootVolumeDescriptor{location: catEntry.location}
-		b = bvd.toBytes()
-		_, _ = f.WriteAt(b, int64(location)*int64(blocksize))
-		location++
+// writeContents writes the contents of the file to w. If bootTable is not nil, it replaces the 56 bytes of the
+// contents that follow the first 8. Returns the number of bytes written.
+func (fi *finalizeFileInfo) writeContents(w io.Writer, bootTable []byte) (int64, error) {
+	r, err := fi.reader()
+	if err != nil {
+		return 0, fmt.Errorf("failed to open file for reading %s: %v", fi.path, err)
 	}
-	terminator := &terminatorVolumeDescriptor{}
-	b = terminator.toBytes()
-	_, _ = f.WriteAt(b, int64(location)*int64(blocksize))
+	defer r.Close()
 
-	_ = os.RemoveAll(fsm.workspace)
+	if fi.zisofs != nil {
+		return fi.zisofs.writeTo(w, r)
+	}
 
-	// finish by setting as finalized
-	fsm.workspace = ""
-	return nil
+	var copied int64
+	if bootTable != nil {
+		if fi.size < elToritoBootTableOffset+int64(len(bootTable)) {
+			return 0, fmt.Errorf("file of %d bytes is too small for a boot table", fi.size)
+		}
+		// first 8 bytes
+		n, err := io.CopyN(w, r, elToritoBootTableOffset)
+		copied += n
+		if err != nil {
+			return copied, fmt.Errorf("failed to copy first bytes 0-8 of boot file: %v", err)
+		}
+		// insert El Torito Boot Information Table, instead of what was there
+		count, err := w.Write(bootTable)
+		copied += int64(count)
+		if err != nil {
+			return copied, fmt.Errorf("failed to write %d byte boot table: %v", len(bootTable), err)
+		}
+		if _, err := io.CopyN(io.Discard, r, int64(len(bootTable))); err != nil {
+			return copied, fmt.Errorf("failed to skip boot table bytes of boot file: %v", err)
+		}
+	}
+	// remainder of file
+	n, err := io.Copy(w, r)
+	copied += n
+	if err != nil {
+		return copied, err
+	}
+	return copied, nil
 }
 
-// copyFileData copy data from file `from` at offset `fromOffset` to file `to` at offset `toOffset`.
-// Copies `size` bytes. If `size` is 0, copies as many bytes as it can.
-func copyFileData(from backend.File, to backend.WritableFile, fromOffset, toOffset int64, size int) (int, error) {
-	buf := make([]byte, 2048)
-	copied := 0
-	for {
-		n, err := from.ReadAt(buf, fromOffset+int64(copied))
-		if err != nil && err != io.EOF {
-			return copied, err
+// compressZisofs compresses with zisofs those of files that get smaller for it, other than the boot images of et
+func compressZisofs(files []*finalizeFileInfo, et *ElTorito) error {
+	bootFiles := elToritoBootFiles(et)
+	for _, e := range files {
+		// relocated directories are placeholders whose contents do not matter, files of a previous session
+		// stay as they are, and duplicates are as their originals are
+		if !e.mode.IsRegular() || e.trueChild != nil || e.fixedLocation || e.duplicateOf != nil || e.size == 0 || e.size >= zisofsMaxSize || bootFiles[e.path] {
+			continue
+		}
+		r, err := e.reader()
+		if err != nil {
+			return fmt.Errorf("failed to open file for reading %s: %v", e.path, err)
+		}
+		z, err := newZisofsFile(r, e.size, zisofsDefaultBlockLog)
+		r.Close()
+		if err != nil {
+			return fmt.Errorf("could not compress %s with zisofs: %v", e.path, err)
 		}
+		if z.compressedSize() < e.size {
+			e.zisofs = z
+			e.size = z.compressedSize()
+		}
+	}
+	for _, e := range files {
+		if e.duplicateOf != nil {
+			e.zisofs, e.size = e.duplicateOf.zisofs, e.duplicateOf.size
+		}
+	}
+	return nil
+}
 
-		if size > 0 && n > (size-copied) {
-			n = size - copied
+// validateVolumeDescriptorOptions checks that the identifiers of options fit in the volume descriptors
+func validateVolumeDescriptorOptions(options FinalizeOptions) error {
+	identifiers := []struct {
+		name  string
+		value string
+		size  int
+	}{
+		{"volume identifier", options.VolumeIdentifier, 32},
+		{"system identifier", options.SystemIdentifier, 32},
+		{"volume set identifier", options.VolumeSetIdentifier, 128},
+		{"publisher identifier", options.PublisherIdentifier, 128},
+		{"preparer identifier", options.PreparerIdentifier, 128},
+		{"application identifier", options.ApplicationIdentifier, 128},
+		{"copyright file", options.CopyrightFile, 37},
+		{"abstract file", options.AbstractFile, 37},
+		{"bibliographic file", options.BibliographicFile, 37},
+	}
+	for _, id := range identifiers {
+		if len(id.value) > id.size {
+			return fmt.Errorf("%s %q is longer than the %d characters allowed", id.name, id.value, id.size)
 		}
+	}
+	return nil
+}
 
-		if n == 0 {
-			break
+// removeFinalizeFileInfo returns files without fi
+func removeFinalizeFileInfo(files []*finalizeFileInfo, fi *finalizeFileInfo) []*finalizeFileInfo {
+	ret := make([]*finalizeFileInfo, 0, len(files))
+	for _, e := range files {
+		if e != fi {
+			ret = append(ret, e)
 		}
+	}
+	return ret
+}
+
+// sequentialWriter writes to an io.Writer at the offsets it is given, which must come in ascending order,
+// filling in any gaps between them with zeroes. It is what allows an image to be written to an io.Writer.
+type sequentialWriter struct {
+	w      io.Writer
+	offset int64
+}
+
+// WriteAt writes b at offset off, which must not be before where the previous write ended
+func (s *sequentialWriter) WriteAt(b []byte, off int64) (int, error) {
+	if err := s.pad(off); err != nil {
+		return 0, err
+	}
+	return s.Write(b)
+}
+
+// Write writes b where the previous write ended
+func (s *sequentialWriter) Write(b []byte) (int, error) {
+	n, err := s.w.Write(b)
+	s.offset += int64(n)
+	return n, err
+}
 
-		if _, err := to.WriteAt(buf[:n], toOffset+int64(copied)); err != nil {
-			return copied, err
+// pad writes zeroes up to offset off
+func (s *sequentialWriter) pad(off int64) error {
+	if off < s.offset {
+		return fmt.Errorf("cannot write at %d, already written up to %d", off, s.offset)
+	}
+	if off == s.offset {
+		return nil
+	}
+	zeroes := make([]byte, minInt64(off-s.offset, 64*1024))
+	for s.offset < off {
+		if _, err := s.Write(zeroes[:minInt64(off-s.offset, int64(len(zeroes)))]); err != nil {
+			return err
 		}
-		copied += n
 	}
-	return copied, nil
+	return nil
 }
 
-// sort path table entries
-func sortFinalizeFileInfoPathTable(left, right *finalizeFileInfo) bool {
+// pathTableName is the directory identifier of fi in the path table, of the plain ISO9660 directory tree or of the
+// Joliet one
+func (fi *finalizeFileInfo) pathTableName(joliet bool) string {
+	if !joliet || fi.isRoot {
+		return fi.Name()
+	}
+	return string(ucs2StringToBytes(fi.jolietName()))
+}
+
+// sort path table entries, by the identifiers of the plain ISO9660 directory tree or of the Joliet one
+func sortFinalizeFileInfoPathTable(left, right *finalizeFileInfo, joliet bool) bool {
 	switch {
 	case left.parent == right.parent:
 		// same parents = same depth, just sort on name
-		lname := left.Name()
-		rname := right.Name()
+		lname := left.pathTableName(joliet)
+		rname := right.pathTableName(joliet)
 		maxLen := maxInt(len(lname), len(rname))
 		format := fmt.Sprintf("%%-%ds", maxLen)
 		return fmt.Sprintf(format, lname) < fmt.Sprintf(format, rname)
@@ -865,24 +1431,28 @@ func sortFinalizeFileInfoPathTable(left, right *finalizeFileInfo) bool {
 		return false
 	default:
 		// same depth, different parents, it depends on the sort order of the parents
-		return sortFinalizeFileInfoPathTable(left.parent, right.parent)
+		return sortFinalizeFileInfoPathTable(left.parent, right.parent, joliet)
 	}
 }
 
-// create a path table from a slice of *finalizeFileInfo that are directories
-func createPathTable(fi []*finalizeFileInfo) *pathTable {
+// create a path table from a slice of *finalizeFileInfo that are directories, either for the
+// plain ISO9660 directory tree or for the Joliet one
+func createPathTable(fi []*finalizeFileInfo, joliet bool) *pathTable {
 	// copy so we do not modify the original
 	fis := make([]*finalizeFileInfo, len(fi))
 	copy(fis, fi)
 	// sort via the rules
 	sort.Slice(fis, func(i, j int) bool {
-		return sortFinalizeFileInfoPathTable(fis[i], fis[j])
+		return sortFinalizeFileInfoPathTable(fis[i], fis[j], joliet)
 	})
 	indexMap := make(map[*finalizeFileInfo]int)
 	// now that it is sorted, create the ordered path table entries
 	entries := make([]*pathTableEntry, 0)
 	for i, e := range fis {
-		name := e.Name()
+		name, location := e.pathTableName(joliet), e.location
+		if joliet {
+			location = e.jolietLocation
+		}
 		nameSize := len(name)
 		size := 8 + uint16(nameSize)
 		if nameSize%2 != 0 {
@@ -899,7 +1469,7 @@ func createPathTable(fi []*finalizeFileInfo) *pathTable {
 			nameSize:      uint8(nameSize),
 			size:          size,
 			extAttrLength: 0,
-			location:      e.location,
+			location:      location,
 			parentIndex:   uint16(parentIndex),
 			dirname:       name,
 		}
@@ -952,8 +1522,6 @@ func walkTree(workspace string) ([]*finalizeFileInfo, map[string]*finalizeFileIn
 				dirList[parentDir] = parentDirInfo
 			}
 		} else {
-			// calculate blocks
-			entry.size = fi.Size()
 			entry.extension = extension
 			parentDirInfo.children = append(parentDirInfo.children, entry)
 			dirList[parentDir] = parentDirInfo
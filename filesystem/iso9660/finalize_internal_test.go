@@ -1,73 +1,11 @@
 package iso9660
 
 import (
-	"bytes"
-	"crypto/rand"
 	"fmt"
-	"io"
-	"os"
+	"strings"
 	"testing"
 )
 
-func TestCopyFileData(t *testing.T) {
-	// create an empty file as source
-	from, err := os.CreateTemp("", "iso9660_finalize_test_from")
-	if err != nil {
-		t.Fatal("error creating 'from' tmpfile", err)
-	}
-
-	defer os.Remove(from.Name()) // clean up
-
-	// create some random data
-	// 100KB is fine
-	blen := 1024 * 100
-	b := make([]byte, blen)
-	_, err = rand.Read(b)
-	if err != nil {
-		t.Fatal("error getting random bytes:", err)
-	}
-
-	if _, err = from.Write(b); err != nil {
-		t.Fatal("error writing random bytes to 'from' tmpfile", err)
-	}
-
-	// create a target file
-	to, err := os.CreateTemp("", "iso9660_finalize_test_to")
-	if err != nil {
-		t.Fatal("error creating 'to' tmpfile", err)
-	}
-	defer os.Remove(from.Name()) // clean up
-
-	copied, err := copyFileData(from, to, 0, 0, 0)
-	if err != nil {
-		t.Fatal("error copying data from/to", err)
-	}
-	expected := blen
-	if copied != expected {
-		t.Fatalf("copied %d bytes instead of expected %d", copied, blen)
-	}
-
-	_, err = to.Seek(0, io.SeekStart)
-	if err != nil {
-		t.Fatal("error resetting 'to' file", err)
-	}
-	c := make([]byte, blen)
-	if _, err = to.Read(c); err != nil {
-		t.Fatal("error reading 'to' tmpfile", err)
-	}
-
-	if !bytes.Equal(b, c) {
-		t.Fatalf("Mismatched content between 'from' and 'to' files, 'from' then 'to'\n%#x\n%#x", b, c)
-	}
-
-	if err := from.Close(); err != nil {
-		t.Fatal("error closing 'from' tmpfile", err)
-	}
-	if err := to.Close(); err != nil {
-		t.Fatal("error closing 'to' tmpfile", err)
-	}
-}
-
 func TestSortFinalizeFileInfoPathTable(t *testing.T) {
 	tests := []struct {
 		left  *finalizeFileInfo
@@ -82,7 +20,7 @@ func TestSortFinalizeFileInfoPathTable(t *testing.T) {
 		{&finalizeFileInfo{parent: &finalizeFileInfo{parent: nil, name: "ZZZ", shortname: "ZZZ"}, depth: 3, name: "ABC", shortname: "ABC"}, &finalizeFileInfo{parent: &finalizeFileInfo{parent: nil, name: "AAA", shortname: "AAA"}, depth: 3, name: "ABC", shortname: "ABC"}, false}, // different parents, same depth, should sort by parent
 	}
 	for i, tt := range tests {
-		result := sortFinalizeFileInfoPathTable(tt.left, tt.right)
+		result := sortFinalizeFileInfoPathTable(tt.left, tt.right, false)
 		if result != tt.less {
 			t.Errorf("%d: got %v expected %v", i, result, tt.less)
 		}
@@ -107,8 +45,28 @@ func TestCreatePathTable(t *testing.T) {
 			{nameSize: 5, size: 14, extAttrLength: 0, location: 32, parentIndex: 3, dirname: "SHORT"},
 		},
 	}
-	pt := createPathTable(input)
-	// createPathTable(fi []*finalizeFileInfo) *pathTable
+	pt := createPathTable(input, false)
+	// createPathTable(fi []*finalizeFileInfo, joliet bool) *pathTable
+	if !pt.equal(expected) {
+		t.Errorf("pathTable not as expected, actual then expected\n%#v\n%#v", pt.names(), expected.names())
+	}
+}
+
+func TestCreateJolietPathTable(t *testing.T) {
+	// the Joliet names sort the other way around from the ISO9660 ones
+	root := &finalizeFileInfo{name: "", depth: 1, jolietLocation: 30, isDir: true, isRoot: true}
+	first := &finalizeFileInfo{name: "b", shortname: "A", depth: 2, jolietLocation: 31, parent: root, isDir: true}
+	second := &finalizeFileInfo{name: "a", shortname: "B", depth: 2, jolietLocation: 32, parent: root, isDir: true}
+	sub := &finalizeFileInfo{name: "c", shortname: "C", depth: 3, jolietLocation: 33, parent: first, isDir: true}
+	expected := &pathTable{
+		records: []*pathTableEntry{
+			{nameSize: 0, size: 8, extAttrLength: 0, location: 30, parentIndex: 1, dirname: ""},
+			{nameSize: 2, size: 10, extAttrLength: 0, location: 32, parentIndex: 1, dirname: "\x00a"},
+			{nameSize: 2, size: 10, extAttrLength: 0, location: 31, parentIndex: 1, dirname: "\x00b"},
+			{nameSize: 2, size: 10, extAttrLength: 0, location: 33, parentIndex: 3, dirname: "\x00c"},
+		},
+	}
+	pt := createPathTable([]*finalizeFileInfo{sub, first, root, second}, true)
 	if !pt.equal(expected) {
 		t.Errorf("pathTable not as expected, actual then expected\n%#v\n%#v", pt.names(), expected.names())
 	}
@@ -202,3 +160,48 @@ func TestCollapseAndSortChildren(t *testing.T) {
 		t.Log(output)
 	}
 }
+
+func TestSetJolietNames(t *testing.T) {
+	long := strings.Repeat("a long name ", 6)
+	dir := &finalizeFileInfo{name: "dir", isDir: true}
+	for _, name := range []string{long + "one.txt", long + "two.txt", long[:64] + "three", long[:64], "short"} {
+		dir.children = append(dir.children, &finalizeFileInfo{name: name, path: "dir/" + name, parent: dir})
+	}
+	dir.children = append(dir.children, &finalizeFileInfo{name: long + "subdir", path: "dir/" + long + "subdir", isDir: true, parent: dir})
+	if err := dir.setJolietNames(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		long[:64-len("~1.txt")] + "~1.txt;1",
+		long[:64-len("~2.txt")] + "~2.txt;1",
+		long[:64-len("~1")] + "~1;1",
+		// not shortened, so it keeps its name
+		long[:64] + ";1",
+		"short;1",
+		long[:64-len("~2")] + "~2",
+	}
+	for i, c := range dir.children {
+		name := c.jolietName()
+		if name != expected[i] {
+			t.Errorf("mismatched Joliet name of %s, actual %s expected %s", c.name, name, expected[i])
+		}
+		if err := validateJolietFilename(name, c.isDir); err != nil {
+			t.Errorf("invalid Joliet name %s: %v", name, err)
+		}
+	}
+}
+
+func TestSetJolietNamesCaseInsensitive(t *testing.T) {
+	dir := &finalizeFileInfo{name: "dir", isDir: true}
+	for _, name := range []string{"Read:Me", "read_me"} {
+		dir.children = append(dir.children, &finalizeFileInfo{name: name, path: "dir/" + name, parent: dir})
+	}
+	if err := dir.setJolietNames(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, expected := range []string{"Read_Me~1;1", "read_me;1"} {
+		if name := dir.children[i].jolietName(); name != expected {
+			t.Errorf("mismatched Joliet name of %s, actual %s expected %s", dir.children[i].name, name, expected)
+		}
+	}
+}
@@ -6,13 +6,17 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/diskfs/go-diskfs/backend/file"
 	"github.com/diskfs/go-diskfs/filesystem"
 	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/diskfs/go-diskfs/filesystem/udf"
 	"github.com/diskfs/go-diskfs/partition/mbr"
 	"github.com/diskfs/go-diskfs/testhelper"
 )
@@ -107,6 +111,40 @@ func finalizeElTorito(t *testing.T, workspace string) {
 		t.Errorf("error opening file %s: %v", "/BOOT2.IMG", err)
 	}
 
+	// and the boot catalog we read back is the one we wrote
+	et, err := fs.ElTorito()
+	if err != nil {
+		t.Fatalf("error reading el torito boot catalog: %v", err)
+	}
+	if et == nil {
+		t.Fatalf("no el torito boot catalog")
+	}
+	if et.BootCatalog != "/BOOT.CAT" || et.HideBootCatalog || et.Platform != iso9660.EFI {
+		t.Errorf("mismatched boot catalog %s, hidden %v, platform %v", et.BootCatalog, et.HideBootCatalog, et.Platform)
+	}
+	expected := []struct {
+		platform iso9660.Platform
+		bootFile string
+	}{
+		// the initial entry is for the platform of the catalog, and the hidden boot file has no path
+		{iso9660.EFI, ""},
+		{iso9660.EFI, "/BOOT2.IMG"},
+	}
+	if len(et.Entries) != len(expected) {
+		t.Fatalf("mismatched number of el torito entries, actual %d expected %d", len(et.Entries), len(expected))
+	}
+	for i, e := range et.Entries {
+		if e.Platform != expected[i].platform || e.BootFile != expected[i].bootFile || e.HideBootFile != (expected[i].bootFile == "") {
+			t.Errorf("%d: mismatched platform %v, boot file %q, hidden %v", i, e.Platform, e.BootFile, e.HideBootFile)
+		}
+		if e.Emulation != iso9660.NoEmulation || e.SystemType != mbr.Fat32LBA || e.LoadSize != 5*1024*1024/512 || e.BootTable {
+			t.Errorf("%d: mismatched emulation %v, system type %v, load size %d, boot table %v", i, e.Emulation, e.SystemType, e.LoadSize, e.BootTable)
+		}
+		if e.Location() == 0 {
+			t.Errorf("%d: no location for boot image", i)
+		}
+	}
+
 	validateIso(t, f)
 
 	validateElTorito(t, f)
@@ -518,6 +556,695 @@ func TestFinalizeRockRidge(t *testing.T) {
 	})
 }
 
+func TestFinalizeRockRidgeInfo(t *testing.T) {
+	f, err := os.CreateTemp("", "iso_finalize_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	b := file.New(f, false)
+	fs, err := iso9660.Create(b, 0, 0, 2048, "")
+	if err != nil {
+		t.Fatalf("Failed to iso9660.Create: %v", err)
+	}
+	if err := fs.Mkdir("/dir"); err != nil {
+		t.Fatalf("Failed to iso9660.Mkdir: %v", err)
+	}
+	isofile, err := fs.OpenFile("/file", os.O_CREATE|os.O_RDWR)
+	if err != nil {
+		t.Fatalf("Failed to iso9660.OpenFile: %v", err)
+	}
+	if _, err := isofile.Write([]byte("contents\n")); err != nil {
+		t.Fatalf("error writing file: %v", err)
+	}
+	workspace := fs.Workspace()
+	if err := os.Chmod(filepath.Join(workspace, "file"), 0o640); err != nil {
+		t.Fatalf("error changing mode of file: %v", err)
+	}
+	links := map[string]string{
+		"/dir/absolute": "/file",
+		"/dir/relative": "../file",
+	}
+	for p, target := range links {
+		if err := os.Symlink(target, filepath.Join(workspace, p)); err != nil {
+			t.Fatalf("error creating symlink %s: %v", p, err)
+		}
+	}
+	if err := fs.Finalize(iso9660.FinalizeOptions{RockRidge: true}); err != nil {
+		t.Fatalf("unexpected error fs.Finalize({RockRidge: true}): %v", err)
+	}
+
+	fs, err = iso9660.Read(b, 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading the tmpfile as iso: %v", err)
+	}
+	for p, target := range links {
+		actual, err := fs.Readlink(p)
+		if err != nil {
+			t.Errorf("unexpected error reading link %s: %v", p, err)
+		}
+		if actual != target {
+			t.Errorf("mismatched target of %s, actual %q expected %q", p, actual, target)
+		}
+	}
+	if _, err := fs.Readlink("/file"); err == nil {
+		t.Errorf("unexpected nil error reading link of regular file")
+	}
+	if _, err := fs.Readlink("/dir/missing"); err == nil {
+		t.Errorf("unexpected nil error reading link of missing file")
+	}
+
+	entries, err := fs.ReadDir("/dir")
+	if err != nil {
+		t.Fatalf("error reading directory: %v", err)
+	}
+	if len(entries) != len(links) {
+		t.Fatalf("directory has %d entries instead of %d", len(entries), len(links))
+	}
+	for _, e := range entries {
+		if e.Mode()&os.ModeSymlink == 0 {
+			t.Errorf("%s has mode %v, which is not a symlink", e.Name(), e.Mode())
+		}
+		info, ok := e.Sys().(*iso9660.RockRidgeInfo)
+		if !ok {
+			t.Fatalf("%s has Sys() of %T instead of *iso9660.RockRidgeInfo", e.Name(), e.Sys())
+		}
+		if target := links["/dir/"+e.Name()]; info.LinkTarget != target {
+			t.Errorf("mismatched target of %s, actual %q expected %q", e.Name(), info.LinkTarget, target)
+		}
+	}
+
+	entries, err = fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("error reading root directory: %v", err)
+	}
+	for _, e := range entries {
+		switch e.Name() {
+		case "dir":
+			if !e.Mode().IsDir() {
+				t.Errorf("dir has mode %v, which is not a directory", e.Mode())
+			}
+		case "file":
+			if e.Mode() != 0o640 {
+				t.Errorf("file has mode %v instead of %v", e.Mode(), os.FileMode(0o640))
+			}
+			info, ok := e.Sys().(*iso9660.RockRidgeInfo)
+			if !ok {
+				t.Fatalf("file has Sys() of %T instead of *iso9660.RockRidgeInfo", e.Sys())
+			}
+			if info.ModTime.IsZero() || info.AccessTime.IsZero() || info.ChangeTime.IsZero() {
+				t.Errorf("file is missing times: %+v", info)
+			}
+		}
+	}
+}
+
+func TestFinalizeJoliet(t *testing.T) {
+	blocksize := int64(2048)
+	files := map[string]string{
+		"/Mixed Case Name.txt":           "mixed\n",
+		"/a directory/ünïcode file.md":   "unicode\n",
+		"/a directory/sub/deeper.tar.gz": "deeper\n",
+		"/time 12:00":                    "colon\n",
+	}
+	finalize := func(t *testing.T, options iso9660.FinalizeOptions) *iso9660.FileSystem {
+		t.Helper()
+		f, err := os.CreateTemp("", "iso_finalize_test")
+		if err != nil {
+			t.Fatalf("Failed to create tmpfile: %v", err)
+		}
+		t.Cleanup(func() {
+			f.Close()
+			os.Remove(f.Name())
+		})
+
+		b := file.New(f, false)
+		fs, err := iso9660.Create(b, 0, 0, blocksize, "")
+		if err != nil {
+			t.Fatalf("Failed to iso9660.Create: %v", err)
+		}
+		if err := fs.Mkdir("/a directory/sub"); err != nil {
+			t.Fatalf("Failed to iso9660.Mkdir: %v", err)
+		}
+		for filename, contents := range files {
+			isofile, err := fs.OpenFile(filename, os.O_CREATE|os.O_RDWR)
+			if err != nil {
+				t.Fatalf("Failed to iso9660.OpenFile(%s): %v", filename, err)
+			}
+			if _, err := isofile.Write([]byte(contents)); err != nil {
+				t.Fatalf("error writing to tmpfile %s: %v", filename, err)
+			}
+		}
+		if err := fs.Finalize(options); err != nil {
+			t.Fatalf("unexpected error fs.Finalize(%+v): %v", options, err)
+		}
+		validateIso(t, f)
+		fs, err = iso9660.Read(b, 0, 0, blocksize)
+		if err != nil {
+			t.Fatalf("error reading the tmpfile as iso: %v", err)
+		}
+		return fs
+	}
+	checkNames := func(t *testing.T, fs *iso9660.FileSystem, expected map[string]string) {
+		t.Helper()
+		for p, contents := range expected {
+			isofile, err := fs.OpenFile(p, os.O_RDONLY)
+			if err != nil {
+				t.Errorf("error opening file %s: %v", p, err)
+				continue
+			}
+			b, err := io.ReadAll(isofile)
+			if err != nil {
+				t.Errorf("error reading from file %s: %v", p, err)
+			}
+			if string(b) != contents {
+				t.Errorf("Mismatched content of %s, actual '%s' expected '%s'", p, b, contents)
+			}
+		}
+	}
+
+	t.Run("joliet", func(t *testing.T) {
+		fs := finalize(t, iso9660.FinalizeOptions{Joliet: true})
+		checkNames(t, fs, map[string]string{
+			"/Mixed Case Name.txt":           "mixed\n",
+			"/a directory/ünïcode file.md":   "unicode\n",
+			"/a directory/sub/deeper.tar.gz": "deeper\n",
+			// ':' is not allowed in Joliet names
+			"/time 12_00": "colon\n",
+		})
+		entries, err := fs.ReadDir("/a directory")
+		if err != nil {
+			t.Fatalf("error reading directory: %v", err)
+		}
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			names = append(names, e.Name())
+		}
+		if len(names) != 2 {
+			t.Errorf("unexpected entries %v", names)
+		}
+	})
+	t.Run("joliet with el torito", func(t *testing.T) {
+		fs := finalize(t, iso9660.FinalizeOptions{Joliet: true, ElTorito: &iso9660.ElTorito{
+			BootCatalog: "/boot.catalog",
+			Entries: []*iso9660.ElToritoEntry{
+				{Platform: iso9660.BIOS, Emulation: iso9660.NoEmulation, BootFile: "/Mixed Case Name.txt"},
+			},
+		}})
+		checkNames(t, fs, map[string]string{
+			"/Mixed Case Name.txt": "mixed\n",
+		})
+		if _, err := fs.OpenFile("/boot.catalog", os.O_RDONLY); err != nil {
+			t.Errorf("error opening boot catalog: %v", err)
+		}
+	})
+	t.Run("rock ridge preferred", func(t *testing.T) {
+		fs := finalize(t, iso9660.FinalizeOptions{Joliet: true, RockRidge: true})
+		checkNames(t, fs, files)
+	})
+	t.Run("no joliet", func(t *testing.T) {
+		fs := finalize(t, iso9660.FinalizeOptions{})
+		checkNames(t, fs, map[string]string{
+			"/MIXED_CASE_NAME.TXT": "mixed\n",
+		})
+		if et, err := fs.ElTorito(); et != nil || err != nil {
+			t.Errorf("unexpected el torito boot catalog %v, error %v", et, err)
+		}
+	})
+}
+
+func TestFinalizeJolietUniqueNames(t *testing.T) {
+	blocksize := int64(2048)
+	files := map[string]string{
+		"/time 12:00":   "colon\n",
+		"/time 12_00":   "underscore\n",
+		"/what?.txt":    "question\n",
+		"/what*.txt":    "asterisk\n",
+		"/dir:a/file":   "in directory\n",
+		"/dir_a/file":   "in other directory\n",
+		"/plain.txt":    "plain\n",
+		"/dir:a/sub:b":  "in directory\n",
+		"/dir:a/sub_b2": "in directory\n",
+	}
+	f, err := os.CreateTemp("", "iso_finalize_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	b := file.New(f, false)
+	fs, err := iso9660.Create(b, 0, 0, blocksize, "")
+	if err != nil {
+		t.Fatalf("Failed to iso9660.Create: %v", err)
+	}
+	for filename, contents := range files {
+		if err := fs.Mkdir(path.Dir(filename)); err != nil {
+			t.Fatalf("Failed to iso9660.Mkdir: %v", err)
+		}
+		isofile, err := fs.OpenFile(filename, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("Failed to iso9660.OpenFile(%s): %v", filename, err)
+		}
+		if _, err := isofile.Write([]byte(contents)); err != nil {
+			t.Fatalf("error writing to tmpfile %s: %v", filename, err)
+		}
+	}
+	if err := fs.Finalize(iso9660.FinalizeOptions{Joliet: true}); err != nil {
+		t.Fatalf("unexpected error fs.Finalize(): %v", err)
+	}
+	fs, err = iso9660.Read(b, 0, 0, blocksize)
+	if err != nil {
+		t.Fatalf("error reading the tmpfile as iso: %v", err)
+	}
+
+	// the names that had characters replaced for Joliet get numeric tails where they are the same as another, whether
+	// its name was replaced too or not
+	expected := map[string]string{
+		"/time 12_00~1":   "colon\n",
+		"/time 12_00":     "underscore\n",
+		"/what_.txt":      "asterisk\n",
+		"/what_~1.txt":    "question\n",
+		"/dir_a~1/file":   "in directory\n",
+		"/dir_a/file":     "in other directory\n",
+		"/plain.txt":      "plain\n",
+		"/dir_a~1/sub_b":  "in directory\n",
+		"/dir_a~1/sub_b2": "in directory\n",
+	}
+	for p, contents := range expected {
+		isofile, err := fs.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			t.Errorf("error opening file %s: %v", p, err)
+			continue
+		}
+		b, err := io.ReadAll(isofile)
+		if err != nil {
+			t.Errorf("error reading from file %s: %v", p, err)
+		}
+		if string(b) != contents {
+			t.Errorf("Mismatched content of %s, actual '%s' expected '%s'", p, b, contents)
+		}
+	}
+	for _, dir := range []string{"/", "/dir_a~1"} {
+		entries, err := fs.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("error reading directory %s: %v", dir, err)
+		}
+		seen := map[string]bool{}
+		for _, e := range entries {
+			if seen[e.Name()] {
+				t.Errorf("duplicate entry %s in %s", e.Name(), dir)
+			}
+			seen[e.Name()] = true
+		}
+	}
+}
+
+func TestFinalizeZisofs(t *testing.T) {
+	random := make([]byte, 10000)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("error getting random bytes: %v", err)
+	}
+	files := map[string][]byte{
+		"/text.txt": bytes.Repeat([]byte("compress me, please\n"), 10000),
+		"/zeroes":   make([]byte, 200000),
+		"/random":   random,
+		"/empty":    {},
+	}
+
+	f, err := os.CreateTemp("", "iso_finalize_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	b := file.New(f, false)
+	fs, err := iso9660.Create(b, 0, 0, 2048, "")
+	if err != nil {
+		t.Fatalf("Failed to iso9660.Create: %v", err)
+	}
+	for filename, contents := range files {
+		isofile, err := fs.OpenFile(filename, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("Failed to iso9660.OpenFile(%s): %v", filename, err)
+		}
+		if _, err := isofile.Write(contents); err != nil {
+			t.Fatalf("error writing to tmpfile %s: %v", filename, err)
+		}
+	}
+	for _, options := range []iso9660.FinalizeOptions{{Zisofs: true}, {RockRidge: true, UDF: true, Zisofs: true}} {
+		if err := fs.Finalize(options); err == nil {
+			t.Errorf("unexpected nil error fs.Finalize(%+v)", options)
+		}
+	}
+	if err := fs.Finalize(iso9660.FinalizeOptions{RockRidge: true, Zisofs: true}); err != nil {
+		t.Fatalf("unexpected error fs.Finalize({RockRidge: true, Zisofs: true}): %v", err)
+	}
+	// the compressed text and zeroes take far less space than they would otherwise
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatalf("error getting size of tmpfile: %v", err)
+	}
+	if fi.Size() > 100*2048 {
+		t.Errorf("image is %d bytes, and is not compressed", fi.Size())
+	}
+
+	fs, err = iso9660.Read(b, 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading the tmpfile as iso: %v", err)
+	}
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("error reading root directory: %v", err)
+	}
+	for _, e := range entries {
+		if contents, ok := files["/"+e.Name()]; ok && e.Size() != int64(len(contents)) {
+			t.Errorf("%s has size %d instead of %d", e.Name(), e.Size(), len(contents))
+		}
+	}
+	for filename, contents := range files {
+		isoFile, err := fs.OpenFile(filename, os.O_RDONLY)
+		if err != nil {
+			t.Fatalf("error opening file %s: %v", filename, err)
+		}
+		read, err := io.ReadAll(isoFile)
+		if err != nil {
+			t.Errorf("error reading from file %s: %v", filename, err)
+		}
+		if !bytes.Equal(read, contents) {
+			t.Errorf("mismatched content of %s", filename)
+		}
+	}
+
+	// read from the middle of a compressed file, across blocks
+	isoFile, err := fs.OpenFile("/text.txt", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	offset := int64(32768 - 100)
+	if _, err := isoFile.Seek(offset, io.SeekStart); err != nil {
+		t.Fatalf("error seeking: %v", err)
+	}
+	read := make([]byte, 1000)
+	if _, err := io.ReadFull(isoFile, read); err != nil {
+		t.Fatalf("error reading: %v", err)
+	}
+	if !bytes.Equal(read, files["/text.txt"][offset:offset+1000]) {
+		t.Errorf("mismatched content read from offset %d", offset)
+	}
+}
+
+func TestFinalizeVolumeDescriptor(t *testing.T) {
+	created := time.Date(2021, 2, 3, 4, 5, 6, 0, time.UTC)
+	options := iso9660.FinalizeOptions{
+		Joliet:                true,
+		VolumeIdentifier:      "MYVOLUME",
+		SystemIdentifier:      "LINUX",
+		VolumeSetIdentifier:   "MYSET",
+		PublisherIdentifier:   "the publisher",
+		PreparerIdentifier:    "the preparer",
+		ApplicationIdentifier: "the application",
+		CopyrightFile:         "COPYING",
+		AbstractFile:          "ABSTRACT",
+		BibliographicFile:     "BIBLIO",
+		CreationTime:          created,
+		ModificationTime:      created.Add(time.Hour),
+		EffectiveTime:         created.Add(2 * time.Hour),
+	}
+
+	f, err := os.CreateTemp("", "iso_finalize_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	b := file.New(f, false)
+	fs, err := iso9660.Create(b, 0, 0, 2048, "")
+	if err != nil {
+		t.Fatalf("Failed to iso9660.Create: %v", err)
+	}
+	if info := fs.PrimaryVolumeDescriptor(); info != nil {
+		t.Errorf("unexpected volume descriptor before finalizing: %+v", info)
+	}
+	tooLong := options
+	tooLong.CopyrightFile = strings.Repeat("C", 38)
+	if err := fs.Finalize(tooLong); err == nil {
+		t.Errorf("unexpected nil error with too long copyright file")
+	}
+	if err := fs.Finalize(options); err != nil {
+		t.Fatalf("unexpected error fs.Finalize(%+v): %v", options, err)
+	}
+
+	fs, err = iso9660.Read(b, 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading the tmpfile as iso: %v", err)
+	}
+	info := fs.PrimaryVolumeDescriptor()
+	if info == nil {
+		t.Fatalf("no primary volume descriptor")
+	}
+	expected := iso9660.VolumeDescriptorInfo{
+		SystemIdentifier:      options.SystemIdentifier,
+		VolumeIdentifier:      options.VolumeIdentifier,
+		VolumeSetIdentifier:   options.VolumeSetIdentifier,
+		PublisherIdentifier:   options.PublisherIdentifier,
+		PreparerIdentifier:    options.PreparerIdentifier,
+		ApplicationIdentifier: options.ApplicationIdentifier,
+		CopyrightFile:         options.CopyrightFile,
+		AbstractFile:          options.AbstractFile,
+		BibliographicFile:     options.BibliographicFile,
+		VolumeSize:            info.VolumeSize,
+		BlockSize:             2048,
+	}
+	times := []struct {
+		name             string
+		actual, expected time.Time
+	}{
+		{"creation", info.CreationTime, options.CreationTime},
+		{"modification", info.ModificationTime, options.ModificationTime},
+		{"expiration", info.ExpirationTime, options.ExpirationTime},
+		{"effective", info.EffectiveTime, options.EffectiveTime},
+	}
+	for _, tt := range times {
+		if !tt.actual.Equal(tt.expected) {
+			t.Errorf("mismatched %s time, actual %v expected %v", tt.name, tt.actual, tt.expected)
+		}
+	}
+	info.CreationTime, info.ModificationTime, info.ExpirationTime, info.EffectiveTime = time.Time{}, time.Time{}, time.Time{}, time.Time{}
+	if *info != expected {
+		t.Errorf("mismatched volume descriptor, actual %+v expected %+v", *info, expected)
+	}
+	if fi, err := f.Stat(); err != nil || int64(info.VolumeSize)*2048 != fi.Size() {
+		t.Errorf("volume size of %d blocks does not match image", info.VolumeSize)
+	}
+}
+
+func TestFinalizeDeduplicate(t *testing.T) {
+	contents := strings.Repeat("the same contents\n", 1000)
+	files := map[string]string{
+		"/a.txt":     contents,
+		"/link.txt":  contents,
+		"/copy.txt":  contents,
+		"/dir/c.txt": contents,
+		"/other.txt": strings.Repeat("other contents\n", 1000),
+		"/boot.img":  contents,
+	}
+	for _, options := range []iso9660.FinalizeOptions{
+		{RockRidge: true, Deduplicate: true},
+		{RockRidge: true, Deduplicate: true, Zisofs: true},
+	} {
+		t.Run(fmt.Sprintf("zisofs %v", options.Zisofs), func(t *testing.T) {
+			f, err := os.CreateTemp("", "iso_finalize_test")
+			if err != nil {
+				t.Fatalf("Failed to create tmpfile: %v", err)
+			}
+			defer func() {
+				f.Close()
+				os.Remove(f.Name())
+			}()
+			b := file.New(f, false)
+			fs, err := iso9660.Create(b, 0, 0, 2048, "")
+			if err != nil {
+				t.Fatalf("Failed to iso9660.Create: %v", err)
+			}
+			workspace := fs.Workspace()
+			if err := os.Mkdir(filepath.Join(workspace, "dir"), 0o755); err != nil {
+				t.Fatalf("error creating directory: %v", err)
+			}
+			for p, c := range files {
+				if p == "/link.txt" {
+					continue
+				}
+				if err := os.WriteFile(filepath.Join(workspace, p), []byte(c), 0o644); err != nil {
+					t.Fatalf("error writing %s: %v", p, err)
+				}
+			}
+			if err := os.Link(filepath.Join(workspace, "a.txt"), filepath.Join(workspace, "link.txt")); err != nil {
+				t.Fatalf("error creating hard link: %v", err)
+			}
+			options.ElTorito = &iso9660.ElTorito{
+				Entries: []*iso9660.ElToritoEntry{{Emulation: iso9660.NoEmulation, BootFile: "/boot.img", BootTable: true}},
+			}
+			if err := fs.Finalize(options); err != nil {
+				t.Fatalf("unexpected error fs.Finalize(%+v): %v", options, err)
+			}
+
+			fs, err = iso9660.Read(b, 0, 0, 2048)
+			if err != nil {
+				t.Fatalf("error reading the tmpfile as iso: %v", err)
+			}
+			locations := map[string]uint32{}
+			for p, c := range files {
+				isoFile, err := fs.OpenFile(p, os.O_RDONLY)
+				if err != nil {
+					t.Fatalf("error opening file %s: %v", p, err)
+				}
+				locations[p] = isoFile.(*iso9660.File).Location()
+				if p == "/boot.img" {
+					continue
+				}
+				actual, err := io.ReadAll(isoFile)
+				if err != nil {
+					t.Errorf("error reading file %s: %v", p, err)
+				}
+				if string(actual) != c {
+					t.Errorf("mismatched contents of %s", p)
+				}
+			}
+			for _, p := range []string{"/link.txt", "/copy.txt", "/dir/c.txt"} {
+				if locations[p] != locations["/a.txt"] {
+					t.Errorf("%s at block %d instead of that of /a.txt, %d", p, locations[p], locations["/a.txt"])
+				}
+			}
+			// the boot image gets a boot table, so it is on its own
+			for _, p := range []string{"/other.txt", "/boot.img"} {
+				if locations[p] == locations["/a.txt"] {
+					t.Errorf("%s at block %d, the same as /a.txt", p, locations[p])
+				}
+			}
+
+			entries, err := fs.ReadDir("/")
+			if err != nil {
+				t.Fatalf("error reading root directory: %v", err)
+			}
+			infos := map[string]*iso9660.RockRidgeInfo{}
+			for _, e := range entries {
+				info, ok := e.Sys().(*iso9660.RockRidgeInfo)
+				if !ok {
+					t.Fatalf("%s has Sys() of %T instead of *iso9660.RockRidgeInfo", e.Name(), e.Sys())
+				}
+				infos[e.Name()] = info
+			}
+			if infos["a.txt"].Nlink != 2 || infos["link.txt"].Nlink != 2 || infos["copy.txt"].Nlink != 1 {
+				t.Errorf("mismatched links, a.txt %d, link.txt %d, copy.txt %d", infos["a.txt"].Nlink, infos["link.txt"].Nlink, infos["copy.txt"].Nlink)
+			}
+			if infos["a.txt"].Serial != infos["link.txt"].Serial || infos["a.txt"].Serial == infos["copy.txt"].Serial {
+				t.Errorf("mismatched serial numbers, a.txt %d, link.txt %d, copy.txt %d", infos["a.txt"].Serial, infos["link.txt"].Serial, infos["copy.txt"].Serial)
+			}
+		})
+	}
+}
+
+func TestFinalizeUDFBridge(t *testing.T) {
+	blocksize := int64(2048)
+	files := map[string]string{
+		"/README.md":                     "readme\n",
+		"/a directory/ünïcode file.md":   "unicode\n",
+		"/a directory/sub/deeper.tar.gz": "deeper\n",
+		"/empty":                         "",
+	}
+	for _, revision := range []udf.Revision{udf.Revision102, udf.Revision201} {
+		t.Run(revision.String(), func(t *testing.T) {
+			f, err := os.CreateTemp("", "iso_finalize_test")
+			if err != nil {
+				t.Fatalf("Failed to create tmpfile: %v", err)
+			}
+			defer func() {
+				f.Close()
+				os.Remove(f.Name())
+			}()
+
+			b := file.New(f, false)
+			fs, err := iso9660.Create(b, 0, 0, blocksize, "")
+			if err != nil {
+				t.Fatalf("Failed to iso9660.Create: %v", err)
+			}
+			if err := fs.Mkdir("/a directory/sub"); err != nil {
+				t.Fatalf("Failed to iso9660.Mkdir: %v", err)
+			}
+			for filename, contents := range files {
+				isofile, err := fs.OpenFile(filename, os.O_CREATE|os.O_RDWR)
+				if err != nil {
+					t.Fatalf("Failed to iso9660.OpenFile(%s): %v", filename, err)
+				}
+				if _, err := isofile.Write([]byte(contents)); err != nil {
+					t.Fatalf("error writing to tmpfile %s: %v", filename, err)
+				}
+			}
+			options := iso9660.FinalizeOptions{
+				RockRidge:        true,
+				UDF:              true,
+				UDFRevision:      revision,
+				VolumeIdentifier: "BRIDGE",
+			}
+			if err := fs.Finalize(options); err != nil {
+				t.Fatalf("unexpected error fs.Finalize(%+v): %v", options, err)
+			}
+			validateIso(t, f)
+
+			isoFS, err := iso9660.Read(b, 0, 0, blocksize)
+			if err != nil {
+				t.Fatalf("error reading the tmpfile as iso: %v", err)
+			}
+			udfFS, err := udf.Read(b, 0, 0, 0)
+			if err != nil {
+				t.Fatalf("error reading the tmpfile as udf: %v", err)
+			}
+			if udfFS.Revision() != revision {
+				t.Errorf("mismatched UDF revision, actual %s expected %s", udfFS.Revision(), revision)
+			}
+			if label := udfFS.Label(); label != "BRIDGE" {
+				t.Errorf("mismatched UDF label, actual %q expected %q", label, "BRIDGE")
+			}
+			for name, readFS := range map[string]filesystem.FileSystem{"iso9660": isoFS, "udf": udfFS} {
+				for p, contents := range files {
+					fl, err := readFS.OpenFile(p, os.O_RDONLY)
+					if err != nil {
+						t.Errorf("%s: error opening file %s: %v", name, p, err)
+						continue
+					}
+					b, err := io.ReadAll(fl)
+					fl.Close()
+					if err != nil {
+						t.Errorf("%s: error reading from file %s: %v", name, p, err)
+					}
+					if string(b) != contents {
+						t.Errorf("%s: mismatched content of %s, actual '%s' expected '%s'", name, p, b, contents)
+					}
+				}
+			}
+			entries, err := udfFS.ReadDir("/a directory")
+			if err != nil {
+				t.Fatalf("error reading UDF directory: %v", err)
+			}
+			if len(entries) != 2 {
+				t.Errorf("unexpected %d entries in UDF directory, expected 2", len(entries))
+			}
+		})
+	}
+}
+
 //nolint:thelper // this is not a helper function
 func validateIso(t *testing.T, f *os.File) {
 	// only do this test if os.Getenv("TEST_IMAGE") contains a real image for integration testing
@@ -0,0 +1,198 @@
+package iso9660
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/fs"
+	"time"
+
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+	"github.com/google/uuid"
+)
+
+/*
+	A hybrid image boots both as an optical disc, by way of El Torito, and as a disk, such as when it is written to
+	a USB stick, as isohybrid and xorriso make them. The system area at the beginning of the image, which ISO9660
+	leaves to the system, gets a master boot record, and either an MBR partition table, or a GPT. The MBR boot code
+	comes from the caller, such as isohdpfx.bin of syslinux, which loads the BIOS El Torito boot image from where the
+	MBR says it is. UEFI firmware finds the EFI El Torito boot image as an EFI System Partition.
+*/
+
+const (
+	// hybridSectorSize is the size of the sectors of the disk that a hybrid image is
+	hybridSectorSize = 512
+	// hybridBootCodeSize is how much MBR boot code there can be, before where the boot image is
+	hybridBootCodeSize = 432
+	// hybridPartitionType is the type of the MBR partition of the whole image, as isohybrid has it
+	hybridPartitionType mbr.Type = 0x17
+	// hybridGPTBackupSectors are the sectors of the backup GPT, its partition array of 128 entries of 128 bytes
+	// and its header, at the end of the disk
+	hybridGPTBackupSectors = 128*128/hybridSectorSize + 1
+)
+
+// Hybrid options for an image that also boots as a disk
+type Hybrid struct {
+	// MBRBootCode x86 boot code for the master boot record, such as isohdpfx.bin of syslinux, of up to 432 bytes.
+	// After it goes the 512-byte sector of the first BIOS El Torito boot image, which it loads, so it requires one.
+	MBRBootCode []byte
+	// GPT write a GPT, with an EFI System Partition of the first EFI El Torito boot image, which it requires,
+	// rather than an MBR partition table. The backup GPT goes after the end of the volume.
+	GPT bool
+}
+
+// validate checks that the options for a hybrid image can be used with the El Torito entries et
+func (h *Hybrid) validate(et *ElTorito) error {
+	if et == nil {
+		return fmt.Errorf("hybrid image requires El Torito boot entries")
+	}
+	if len(h.MBRBootCode) > hybridBootCodeSize {
+		return fmt.Errorf("MBR boot code of %d bytes is more than the maximum of %d", len(h.MBRBootCode), hybridBootCodeSize)
+	}
+	if h.MBRBootCode != nil && et.entry(BIOS) == nil {
+		return fmt.Errorf("MBR boot code requires a BIOS El Torito boot entry")
+	}
+	if h.GPT && et.entry(EFI) == nil {
+		return fmt.Errorf("GPT of a hybrid image requires an EFI El Torito boot entry")
+	}
+	return nil
+}
+
+// tailBlocks returns the number of blocks of the image that go after the end of the volume
+func (h *Hybrid) tailBlocks(blocksize int64) uint32 {
+	if !h.GPT {
+		return 0
+	}
+	return uint32((hybridGPTBackupSectors*hybridSectorSize + blocksize - 1) / blocksize)
+}
+
+// tables returns the system area of a hybrid image of volumeSize blocks, whose El Torito entries are laid out,
+// and what goes after the volume. For a reproducible image with a sourceDateEpoch, the GUIDs of a GPT are made
+// from it, rather than at random.
+func (h *Hybrid) tables(et *ElTorito, volumeSize uint32, blocksize int64, sourceDateEpoch time.Time) (systemArea, tail []byte, err error) {
+	sectorsPerBlock := uint32(blocksize / hybridSectorSize)
+	tw := &tableWriter{
+		systemArea: make([]byte, systemAreaSize),
+		tail:       make([]byte, int64(h.tailBlocks(blocksize))*blocksize),
+		tailStart:  int64(volumeSize) * blocksize,
+	}
+	diskSize := tw.tailStart + int64(len(tw.tail))
+
+	copy(tw.systemArea, h.MBRBootCode)
+	if h.MBRBootCode != nil {
+		binary.LittleEndian.PutUint32(tw.systemArea[hybridBootCodeSize:], et.entry(BIOS).location*sectorsPerBlock)
+	}
+
+	efi := et.entry(EFI)
+	if h.GPT {
+		start := uint64(efi.location) * uint64(sectorsPerBlock)
+		table := &gpt.Table{
+			LogicalSectorSize:  hybridSectorSize,
+			PhysicalSectorSize: hybridSectorSize,
+			ProtectiveMBR:      true,
+			Partitions: []*gpt.Partition{
+				{
+					Start: start,
+					End:   start + uint64(hybridSectors(efi.size)) - 1,
+					Type:  gpt.EFISystemPartition,
+					Name:  "EFI System Partition",
+				},
+			},
+		}
+		if !sourceDateEpoch.IsZero() {
+			seed := fmt.Sprintf("%d/%d", sourceDateEpoch.Unix(), volumeSize)
+			table.GUID = uuid.NewSHA1(uuid.NameSpaceOID, []byte("disk/"+seed)).String()
+			table.Partitions[0].GUID = uuid.NewSHA1(uuid.NameSpaceOID, []byte("esp/"+seed)).String()
+		}
+		if err := table.Write(tw, diskSize); err != nil {
+			return nil, nil, fmt.Errorf("could not write GPT: %v", err)
+		}
+		return tw.systemArea, tw.tail, nil
+	}
+
+	table := &mbr.Table{
+		LogicalSectorSize:  hybridSectorSize,
+		PhysicalSectorSize: hybridSectorSize,
+		Partitions: []*mbr.Partition{
+			{
+				Bootable: true,
+				Type:     hybridPartitionType,
+				Size:     uint32(diskSize / hybridSectorSize),
+			},
+		},
+	}
+	if efi != nil {
+		table.Partitions = append(table.Partitions, &mbr.Partition{
+			Type:  mbr.EFISystem,
+			Start: efi.location * sectorsPerBlock,
+			Size:  hybridSectors(efi.size),
+		})
+	}
+	if err := table.Write(tw, diskSize); err != nil {
+		return nil, nil, fmt.Errorf("could not write MBR partition table: %v", err)
+	}
+	return tw.systemArea, tw.tail, nil
+}
+
+// hybridSectors returns the number of disk sectors of size bytes, at least one
+func hybridSectors(size uint32) uint32 {
+	sectors := (size + hybridSectorSize - 1) / hybridSectorSize
+	if sectors == 0 {
+		sectors = 1
+	}
+	return sectors
+}
+
+// entry returns the first El Torito entry for the platform p, if any
+func (et *ElTorito) entry(p Platform) *ElToritoEntry {
+	for i, e := range et.Entries {
+		// the platform of the first entry is that of the validation entry
+		platform := e.Platform
+		if i == 0 {
+			platform = et.Platform
+		}
+		if platform == p {
+			return e
+		}
+	}
+	return nil
+}
+
+// tableWriter is where the partition tables of a hybrid image are written, by their own Write, keeping what is
+// written to the system area and after the end of the volume. It can only be written to.
+type tableWriter struct {
+	systemArea []byte
+	tail       []byte
+	tailStart  int64
+}
+
+func (w *tableWriter) WriteAt(b []byte, off int64) (int, error) {
+	switch {
+	case off >= 0 && off+int64(len(b)) <= int64(len(w.systemArea)):
+		return copy(w.systemArea[off:], b), nil
+	case off >= w.tailStart && off+int64(len(b)) <= w.tailStart+int64(len(w.tail)):
+		return copy(w.tail[off-w.tailStart:], b), nil
+	default:
+		return 0, fmt.Errorf("cannot write %d bytes at %d, outside of the system area and the end of the image", len(b), off)
+	}
+}
+
+func (w *tableWriter) Stat() (fs.FileInfo, error) {
+	return nil, fmt.Errorf("cannot stat partition tables")
+}
+
+func (w *tableWriter) Read([]byte) (int, error) {
+	return 0, fmt.Errorf("cannot read partition tables")
+}
+
+func (w *tableWriter) ReadAt([]byte, int64) (int, error) {
+	return 0, fmt.Errorf("cannot read partition tables")
+}
+
+func (w *tableWriter) Seek(int64, int) (int64, error) {
+	return 0, fmt.Errorf("cannot seek partition tables")
+}
+
+func (w *tableWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,178 @@
+package iso9660_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+	"github.com/diskfs/go-diskfs/partition/gpt"
+	"github.com/diskfs/go-diskfs/partition/mbr"
+)
+
+// buildHybrid builds a hybrid image with a BIOS and an EFI boot image, and returns its file, the filesystem
+// read from it, and its El Torito entries
+func buildHybrid(t *testing.T, hybrid *iso9660.Hybrid) (*os.File, *iso9660.FileSystem, *iso9660.ElTorito) {
+	t.Helper()
+	builder, err := iso9660.NewBuilder(2048)
+	if err != nil {
+		t.Fatalf("unexpected error creating builder: %v", err)
+	}
+	if err := builder.AddFile("/README.md", []byte("readme\n")); err != nil {
+		t.Fatalf("unexpected error adding file: %v", err)
+	}
+	if err := builder.AddFile("/boot/bios.img", bytes.Repeat([]byte{0xb1}, 4096)); err != nil {
+		t.Fatalf("unexpected error adding boot image: %v", err)
+	}
+	if err := builder.AddFile("/boot/efi.img", bytes.Repeat([]byte{0xef}, 5000)); err != nil {
+		t.Fatalf("unexpected error adding boot image: %v", err)
+	}
+	options := iso9660.FinalizeOptions{
+		RockRidge: true,
+		ElTorito: &iso9660.ElTorito{
+			Platform: iso9660.BIOS,
+			Entries: []*iso9660.ElToritoEntry{
+				{Emulation: iso9660.NoEmulation, BootFile: "/boot/bios.img", LoadSize: 4},
+				{Platform: iso9660.EFI, Emulation: iso9660.NoEmulation, BootFile: "/boot/efi.img"},
+			},
+		},
+		Hybrid: hybrid,
+	}
+	f, err := os.CreateTemp("", "iso_hybrid_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	if err := builder.Build(f, options); err != nil {
+		t.Fatalf("unexpected error building image: %v", err)
+	}
+	fs, err := iso9660.Read(file.New(f, true), 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading the tmpfile as iso: %v", err)
+	}
+	isoFile, err := fs.OpenFile("/README.md", os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file: %v", err)
+	}
+	if b, err := io.ReadAll(isoFile); err != nil || string(b) != "readme\n" {
+		t.Errorf("mismatched content %q, error %v", b, err)
+	}
+	return f, fs, options.ElTorito
+}
+
+func TestFinalizeHybrid(t *testing.T) {
+	bootCode := bytes.Repeat([]byte{0x90}, 432)
+	t.Run("mbr", func(t *testing.T) {
+		f, fs, et := buildHybrid(t, &iso9660.Hybrid{MBRBootCode: bootCode})
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatalf("error getting size of image: %v", err)
+		}
+		if volumeSize := int64(fs.PrimaryVolumeDescriptor().VolumeSize) * 2048; fi.Size() != volumeSize {
+			t.Errorf("image of %d bytes instead of %d", fi.Size(), volumeSize)
+		}
+		b := make([]byte, 512)
+		if _, err := f.ReadAt(b, 0); err != nil {
+			t.Fatalf("error reading MBR: %v", err)
+		}
+		if !bytes.Equal(b[:432], bootCode) {
+			t.Errorf("mismatched boot code")
+		}
+		if lba, expected := binary.LittleEndian.Uint32(b[432:436]), et.Entries[0].Location()*4; lba != expected {
+			t.Errorf("boot image at sector %d instead of %d", lba, expected)
+		}
+
+		table, err := mbr.Read(file.New(f, true), 512, 512)
+		if err != nil {
+			t.Fatalf("error reading MBR partition table: %v", err)
+		}
+		image := table.Partitions[0]
+		if !image.Bootable || image.Start != 0 || int64(image.Size)*512 != fi.Size() {
+			t.Errorf("mismatched partition of image %+v", image)
+		}
+		esp := table.Partitions[1]
+		if esp.Type != mbr.EFISystem || esp.Start != et.Entries[1].Location()*4 || esp.Size != 10 {
+			t.Errorf("mismatched EFI system partition %+v", esp)
+		}
+		if table.Partitions[2].Type != mbr.Empty {
+			t.Errorf("unexpected partition %+v", table.Partitions[2])
+		}
+	})
+	t.Run("gpt", func(t *testing.T) {
+		f, fs, et := buildHybrid(t, &iso9660.Hybrid{MBRBootCode: bootCode, GPT: true})
+		fi, err := f.Stat()
+		if err != nil {
+			t.Fatalf("error getting size of image: %v", err)
+		}
+		// with the backup GPT after the end of the volume
+		if volumeSize := int64(fs.PrimaryVolumeDescriptor().VolumeSize) * 2048; fi.Size() != volumeSize+9*2048 {
+			t.Errorf("image of %d bytes instead of %d", fi.Size(), volumeSize+9*2048)
+		}
+		b := make([]byte, 432)
+		if _, err := f.ReadAt(b, 0); err != nil {
+			t.Fatalf("error reading MBR: %v", err)
+		}
+		if !bytes.Equal(b, bootCode) {
+			t.Errorf("mismatched boot code")
+		}
+
+		table, err := gpt.Read(file.New(f, true), 512, 512)
+		if err != nil {
+			t.Fatalf("error reading GPT: %v", err)
+		}
+		if !table.ProtectiveMBR {
+			t.Errorf("GPT without protective MBR")
+		}
+		if err := table.Verify(file.New(f, true), uint64(fi.Size())); err != nil {
+			t.Errorf("invalid GPT: %v", err)
+		}
+		if len(table.Partitions) != 1 {
+			t.Fatalf("%d partitions instead of 1", len(table.Partitions))
+		}
+		esp := table.Partitions[0]
+		start := uint64(et.Entries[1].Location()) * 4
+		if esp.Type != gpt.EFISystemPartition || esp.Start != start || esp.End != start+9 {
+			t.Errorf("mismatched EFI system partition %+v", esp)
+		}
+	})
+	t.Run("invalid", func(t *testing.T) {
+		tests := []struct {
+			name    string
+			options iso9660.FinalizeOptions
+		}{
+			{"without el torito", iso9660.FinalizeOptions{Hybrid: &iso9660.Hybrid{}}},
+			{"boot code too long", iso9660.FinalizeOptions{
+				ElTorito: &iso9660.ElTorito{Entries: []*iso9660.ElToritoEntry{{BootFile: "/boot.img"}}},
+				Hybrid:   &iso9660.Hybrid{MBRBootCode: make([]byte, 433)},
+			}},
+			{"boot code without bios entry", iso9660.FinalizeOptions{
+				ElTorito: &iso9660.ElTorito{Platform: iso9660.EFI, Entries: []*iso9660.ElToritoEntry{{BootFile: "/boot.img"}}},
+				Hybrid:   &iso9660.Hybrid{MBRBootCode: bootCode},
+			}},
+			{"gpt without efi entry", iso9660.FinalizeOptions{
+				ElTorito: &iso9660.ElTorito{Entries: []*iso9660.ElToritoEntry{{BootFile: "/boot.img"}}},
+				Hybrid:   &iso9660.Hybrid{GPT: true},
+			}},
+		}
+		for _, tt := range tests {
+			t.Run(tt.name, func(t *testing.T) {
+				builder, err := iso9660.NewBuilder(2048)
+				if err != nil {
+					t.Fatalf("unexpected error creating builder: %v", err)
+				}
+				if err := builder.AddFile("/boot.img", make([]byte, 2048)); err != nil {
+					t.Fatalf("unexpected error adding boot image: %v", err)
+				}
+				if err := builder.Build(io.Discard, tt.options); err == nil {
+					t.Errorf("unexpected nil error")
+				}
+			})
+		}
+	})
+}
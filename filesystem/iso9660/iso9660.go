@@ -32,6 +32,7 @@ type FileSystem struct {
 	suspEnabled    bool  // is the SUSP in use?
 	suspSkip       uint8 // how many bytes to skip in each directory record
 	suspExtensions []suspExtension
+	joliet         bool // is the directory tree in use the Joliet one?
 }
 
 // Equal compare if two filesystems are equal
@@ -164,6 +165,7 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 	terminated := false
 	var (
 		pvd *primaryVolumeDescriptor
+		jvd *supplementaryVolumeDescriptor
 		vd  volumeDescriptor
 	)
 	for i := 0; !terminated; i++ {
@@ -189,6 +191,11 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 		case volumeDescriptorPrimary:
 			vds = append(vds, vd)
 			pvd, _ = vd.(*primaryVolumeDescriptor)
+		case volumeDescriptorSupplementary:
+			vds = append(vds, vd)
+			if s, ok := vd.(*supplementaryVolumeDescriptor); ok && jvd == nil && isJoliet(s.escapeSequences) {
+				jvd = s
+			}
 		default:
 			vds = append(vds, vd)
 		}
@@ -201,16 +208,10 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 	)
 	if pvd != nil {
 		rootDirEntry = pvd.rootDirectoryEntry
-		pathTableBytes := make([]byte, pvd.pathTableSize)
-		pathTableLocation := pvd.pathTableLLocation * uint32(pvd.blocksize)
-		read, err = b.ReadAt(pathTableBytes, int64(pathTableLocation))
+		pt, err = readPathTable(b, pvd.pathTableLLocation, pvd.pathTableSize, pvd.blocksize)
 		if err != nil {
-			return nil, fmt.Errorf("unable to read path table of size %d at location %d: %v", pvd.pathTableSize, pathTableLocation, err)
+			return nil, err
 		}
-		if read != len(pathTableBytes) {
-			return nil, fmt.Errorf("read %d bytes of path table instead of expected %d at location %d", read, pvd.pathTableSize, pathTableLocation)
-		}
-		pt = parsePathTable(pathTableBytes)
 	}
 
 	// is system use enabled?
@@ -266,6 +267,21 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 		}
 	}
 
+	// Joliet names are preferred over the plain ISO9660 ones, but not over Rock Ridge
+	useJoliet := jvd != nil && len(suspHandlers) == 0
+	if useJoliet {
+		rootDirEntry = jvd.rootDirectoryEntry
+		pt, err = readPathTable(b, jvd.pathTableLLocation, jvd.pathTableSize, jvd.blocksize)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range pt.records {
+			e.dirname = bytesToUCS2String([]byte(e.dirname))
+		}
+		suspEnabled = false
+		skipBytes = 0
+	}
+
 	fs := &FileSystem{
 		workspace: "", // no workspace when we do nothing with it
 		start:     start,
@@ -274,6 +290,7 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 		volumes: volumeDescriptors{
 			descriptors: vds,
 			primary:     pvd,
+			joliet:      jvd,
 		},
 		blocksize:      blocksize,
 		pathTable:      pt,
@@ -281,11 +298,26 @@ func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error)
 		suspEnabled:    suspEnabled,
 		suspSkip:       skipBytes,
 		suspExtensions: suspHandlers,
+		joliet:         useJoliet,
 	}
 	rootDirEntry.filesystem = fs
 	return fs, nil
 }
 
+// readPathTable reads the L path table of the given size, at the given block
+func readPathTable(b backend.Storage, location, size uint32, blocksize uint16) (*pathTable, error) {
+	pathTableBytes := make([]byte, size)
+	pathTableLocation := int64(location) * int64(blocksize)
+	read, err := b.ReadAt(pathTableBytes, pathTableLocation)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read path table of size %d at location %d: %v", size, pathTableLocation, err)
+	}
+	if read != len(pathTableBytes) {
+		return nil, fmt.Errorf("read %d bytes of path table instead of expected %d at location %d", read, size, pathTableLocation)
+	}
+	return parsePathTable(pathTableBytes), nil
+}
+
 // interface guard
 var _ filesystem.FileSystem = (*FileSystem)(nil)
 
@@ -473,6 +505,33 @@ func (fsm *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
 	return f, nil
 }
 
+// Readlink returns the target of the symbolic link p. Only a filesystem with Rock Ridge extensions has symbolic
+// links.
+//
+// Will return an error if p does not exist or is not a symbolic link
+func (fsm *FileSystem) Readlink(p string) (string, error) {
+	if fsm.workspace != "" {
+		return os.Readlink(path.Join(fsm.workspace, p))
+	}
+	dir := path.Dir(p)
+	filename := path.Base(p)
+	entries, err := fsm.readDirectory(dir)
+	if err != nil {
+		return "", fmt.Errorf("could not read directory entries for %s: %v", dir, err)
+	}
+	for _, e := range entries {
+		if e.isSelf || e.isParent || e.Name() != filename {
+			continue
+		}
+		target, ok := e.ReadLink()
+		if !ok {
+			return "", fmt.Errorf("%s is not a symbolic link", p)
+		}
+		return target, nil
+	}
+	return "", fmt.Errorf("target file %s does not exist", p)
+}
+
 // Rename renames (moves) oldpath to newpath. If newpath already exists and is not a directory, Rename replaces it.
 func (fsm *FileSystem) Rename(oldpath, newpath string) error {
 	if fsm.workspace == "" {
@@ -493,7 +552,6 @@ func (fsm *FileSystem) readDirectory(p string) ([]*directoryEntry, error) {
 	var (
 		location, size uint32
 		err            error
-		n              int
 	)
 
 	// try from path table, then walk the directory tree, unless we were told explicitly not to
@@ -509,20 +567,8 @@ func (fsm *FileSystem) readDirectory(p string) ([]*directoryEntry, error) {
 		location = fsm.pathTable.getLocation(p)
 	}
 
-	// if we found it, read the first directory entry to get the size
-	if location != 0 {
-		// we need 4 bytes to read the size of the directory; it is at offset 10 from beginning
-		dirb := make([]byte, 4)
-		n, err = fsm.backend.ReadAt(dirb, int64(location)*fsm.blocksize+10)
-		if err != nil {
-			return nil, fmt.Errorf("could not read directory %s: %v", p, err)
-		}
-		if n != len(dirb) {
-			return nil, fmt.Errorf("read %d bytes instead of expected %d", n, len(dirb))
-		}
-		// convert to uint32
-		size = binary.LittleEndian.Uint32(dirb)
-	} else {
+	// if we found it, the size is read from the first directory entry
+	if location == 0 {
 		// if we could not find the location in the path table, try reading directly from the disk
 		//   it is slow, but this is how Unix does it, since many iso creators *do* create illegitimate disks
 		location, size, err = fsm.rootDir.getLocation(p)
@@ -537,18 +583,40 @@ func (fsm *FileSystem) readDirectory(p string) ([]*directoryEntry, error) {
 	}
 
 	// we have a location, let's read the directories from it
+	entries, err := fsm.readDirectoryAt(location, size)
+	if err != nil {
+		return nil, fmt.Errorf("directory %s: %v", p, err)
+	}
+	return entries, nil
+}
+
+// readDirectoryAt reads the entries of the directory of size bytes at block location. If size is 0, it is read
+// from the first entry of the directory, which is the directory itself.
+func (fsm *FileSystem) readDirectoryAt(location, size uint32) ([]*directoryEntry, error) {
+	if size == 0 {
+		// we need 4 bytes to read the size of the directory; it is at offset 10 from beginning
+		dirb := make([]byte, 4)
+		n, err := fsm.backend.ReadAt(dirb, int64(location)*fsm.blocksize+10)
+		if err != nil {
+			return nil, fmt.Errorf("could not read size of directory: %v", err)
+		}
+		if n != len(dirb) {
+			return nil, fmt.Errorf("read %d bytes instead of expected %d", n, len(dirb))
+		}
+		size = binary.LittleEndian.Uint32(dirb)
+	}
 	b := make([]byte, size)
-	n, err = fsm.backend.ReadAt(b, int64(location)*fsm.blocksize)
+	n, err := fsm.backend.ReadAt(b, int64(location)*fsm.blocksize)
 	if err != nil {
-		return nil, fmt.Errorf("could not read directory entries for %s: %v", p, err)
+		return nil, fmt.Errorf("could not read directory entries: %v", err)
 	}
 	if n != int(size) {
-		return nil, fmt.Errorf("reading directory %s returned %d bytes read instead of expected %d", p, n, size)
+		return nil, fmt.Errorf("read %d bytes of directory entries instead of expected %d", n, size)
 	}
 	// parse the entries
 	entries, err := parseDirEntries(b, fsm)
 	if err != nil {
-		return nil, fmt.Errorf("could not parse directory entries for %s: %v", p, err)
+		return nil, fmt.Errorf("could not parse directory entries: %v", err)
 	}
 	return entries, nil
 }
@@ -569,6 +637,15 @@ func (fsm *FileSystem) Label() string {
 	return fsm.volumes.primary.volumeIdentifier
 }
 
+// PrimaryVolumeDescriptor returns what the primary volume descriptor says about the filesystem, or nil if it does
+// not have one, as it does not until it is finalized
+func (fsm *FileSystem) PrimaryVolumeDescriptor() *VolumeDescriptorInfo {
+	if fsm.volumes.primary == nil {
+		return nil
+	}
+	return fsm.volumes.primary.info()
+}
+
 func (fsm *FileSystem) SetLabel(string) error {
 	return fmt.Errorf("ISO9660 filesystem is read-only")
 }
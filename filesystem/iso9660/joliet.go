@@ -0,0 +1,144 @@
+package iso9660
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	// jolietMaxNameLength is the maximum number of UCS-2 characters in a Joliet file or directory name,
+	// not including the ";1" version of files
+	jolietMaxNameLength = 64
+	// jolietInvalidCharacters are the characters Joliet does not allow in names, besides control characters
+	jolietInvalidCharacters = `*/:;?\`
+	// jolietMaxExtensionLength is the longest extension that is kept after the numeric tail of a name that is the
+	// same as another once shortened
+	jolietMaxExtensionLength = 16
+)
+
+// jolietEscapeSequences are the escape sequences of a supplementary volume descriptor that mark it as Joliet,
+// for UCS-2 levels 1, 2 and 3 respectively. We always write level 3.
+var jolietEscapeSequences = [][]byte{
+	{0x25, 0x2f, 0x40},
+	{0x25, 0x2f, 0x43},
+	{0x25, 0x2f, 0x45},
+}
+
+// isJoliet reports whether the escape sequences of a supplementary volume descriptor are those of Joliet
+func isJoliet(escapeSequences []byte) bool {
+	for _, e := range jolietEscapeSequences {
+		if bytes.HasPrefix(escapeSequences, e) {
+			return true
+		}
+	}
+	return false
+}
+
+// jolietName is the name of the entry in the Joliet directory tree. It is the name of the file in the workspace,
+// with characters that Joliet does not allow replaced by '_' and shortened to the maximum Joliet allows, and a
+// numeric tail if that makes it the same as another in its directory.
+func (fi *finalizeFileInfo) jolietName() string {
+	if fi.isRoot {
+		return fi.name
+	}
+	name := fi.jolietUnique
+	if name == "" {
+		name, _ = fi.jolietBaseName()
+	}
+	if !fi.isDir {
+		name += ";1"
+	}
+	return name
+}
+
+// jolietBaseName is the name of the file in the workspace, with characters that Joliet does not allow replaced by
+// '_' and shortened to the maximum Joliet allows, and whether that changed it
+func (fi *finalizeFileInfo) jolietBaseName() (name string, changed bool) {
+	r := []rune(fi.name)
+	if len(r) > jolietMaxNameLength {
+		r = r[:jolietMaxNameLength]
+		changed = true
+	}
+	replaced := jolietReplaceInvalid(r)
+	return string(r), replaced || changed
+}
+
+// jolietReplaceInvalid replaces the characters that Joliet does not allow in r by '_', and reports whether there
+// were any
+func jolietReplaceInvalid(r []rune) bool {
+	var replaced bool
+	for i, c := range r {
+		if c < 0x20 || c > 0xffff || strings.ContainsRune(jolietInvalidCharacters, c) {
+			r[i] = '_'
+			replaced = true
+		}
+	}
+	return replaced
+}
+
+// setJolietNames makes the Joliet names of the children of the directory fi unique. A name that is changed to be
+// valid for Joliet, and so is the same as another, gets a numeric tail, ~1, ~2 and so on, in place of its end,
+// before its extension, as FAT short names do. Names that did not need changing are kept as they are.
+// Names are compared case-insensitively, as they are on the systems that read Joliet.
+func (fi *finalizeFileInfo) setJolietNames() error {
+	used := make(map[string]bool, len(fi.children))
+	for _, c := range fi.children {
+		if name, changed := c.jolietBaseName(); !changed {
+			used[strings.ToLower(name)] = true
+		}
+	}
+	for _, c := range fi.children {
+		name, changed := c.jolietBaseName()
+		if !changed {
+			continue
+		}
+		if !used[strings.ToLower(name)] {
+			used[strings.ToLower(name)] = true
+			continue
+		}
+		// the extension is that of the whole name, which might be cut off
+		base, ext := []rune(name), []rune{}
+		if i := strings.LastIndexByte(c.name, '.'); i > 0 && !c.isDir {
+			if e := []rune(c.name[i:]); len(e) <= jolietMaxExtensionLength {
+				jolietReplaceInvalid(e)
+				base, ext = []rune(c.name[:i]), e
+				jolietReplaceInvalid(base)
+			}
+		}
+		unique := ""
+		for n := 1; unique == "" || used[strings.ToLower(unique)]; n++ {
+			tail := []rune("~" + strconv.Itoa(n))
+			if len(tail)+len(ext) >= jolietMaxNameLength {
+				return fmt.Errorf("unable to make a unique Joliet name for %s", c.path)
+			}
+			b := base
+			if len(b)+len(tail)+len(ext) > jolietMaxNameLength {
+				b = b[:jolietMaxNameLength-len(tail)-len(ext)]
+			}
+			unique = string(b) + string(tail) + string(ext)
+		}
+		used[strings.ToLower(unique)] = true
+		c.jolietUnique = unique
+	}
+	return nil
+}
+
+// validateJolietFilename validates a filename that goes into the Joliet directory tree
+func validateJolietFilename(s string, isDir bool) error {
+	name := s
+	if !isDir {
+		name = strings.TrimSuffix(s, ";1")
+	}
+	r := []rune(name)
+	if len(r) == 0 || len(r) > jolietMaxNameLength {
+		return fmt.Errorf("name must be of 1 to %d characters", jolietMaxNameLength)
+	}
+	for _, c := range r {
+		if c < 0x20 || c > 0xffff || strings.ContainsRune(jolietInvalidCharacters, c) {
+			return fmt.Errorf("name must not include control characters, characters beyond UCS-2 or any of %s", jolietInvalidCharacters)
+		}
+	}
+	return nil
+}
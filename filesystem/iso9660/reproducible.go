@@ -0,0 +1,83 @@
+package iso9660
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// sourceDateEpochEnv is the environment variable with the time that reproducible builds use instead of now
+const sourceDateEpochEnv = "SOURCE_DATE_EPOCH"
+
+// SourceDateEpochFromEnv returns the time in the SOURCE_DATE_EPOCH environment variable, in seconds since the Unix
+// epoch, for FinalizeOptions.SourceDateEpoch. It returns the zero time if the variable is not set.
+func SourceDateEpochFromEnv() (time.Time, error) {
+	value, ok := os.LookupEnv(sourceDateEpochEnv)
+	if !ok || value == "" {
+		return time.Time{}, nil
+	}
+	seconds, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s %q: %v", sourceDateEpochEnv, value, err)
+	}
+	return time.Unix(seconds, 0).UTC(), nil
+}
+
+// now returns the time that the image is made, which is SourceDateEpoch for a reproducible image
+func (o FinalizeOptions) now() time.Time {
+	if o.SourceDateEpoch.IsZero() {
+		return time.Now()
+	}
+	return o.SourceDateEpoch
+}
+
+// normalizeFileInfo makes what is in files and dirs the same however they came to be, as options ask: with
+// SourceDateEpoch, their times are clamped to it, the children of directories are sorted by name, and their serial
+// numbers follow that order, and with NormalizeOwners, they all are owned by user and group 0.
+func normalizeFileInfo(files []*finalizeFileInfo, dirs map[string]*finalizeFileInfo, options FinalizeOptions) {
+	normalize := func(e *finalizeFileInfo) {
+		if epoch := options.SourceDateEpoch; !epoch.IsZero() {
+			for _, t := range []*time.Time{&e.modTime, &e.accessTime, &e.changeTime} {
+				if t.After(epoch) {
+					*t = epoch
+				}
+			}
+		}
+		if options.NormalizeOwners {
+			e.uid, e.gid = 0, 0
+		}
+	}
+	for _, e := range files {
+		normalize(e)
+	}
+	for _, e := range dirs {
+		normalize(e)
+		if !options.SourceDateEpoch.IsZero() {
+			sortChildren(e.children)
+		}
+	}
+	if root := dirs["."]; root != nil && !options.SourceDateEpoch.IsZero() {
+		serial := uint64(1)
+		var number func(e *finalizeFileInfo)
+		number = func(e *finalizeFileInfo) {
+			e.serial = serial
+			serial++
+			for _, c := range e.children {
+				number(c)
+			}
+		}
+		number(root)
+	}
+}
+
+// sortChildren sorts the children of a directory by their names, first as they are in the directory records
+func sortChildren(children []*finalizeFileInfo) {
+	sort.Slice(children, func(i, j int) bool {
+		if a, b := children[i].Name(), children[j].Name(); a != b {
+			return a < b
+		}
+		return children[i].name < children[j].name
+	})
+}
@@ -0,0 +1,142 @@
+package iso9660_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+)
+
+// buildReproducible builds an image of the same files, added in the given order
+func buildReproducible(t *testing.T, order []string, options iso9660.FinalizeOptions) []byte {
+	t.Helper()
+	builder, err := iso9660.NewBuilder(2048)
+	if err != nil {
+		t.Fatalf("unexpected error creating builder: %v", err)
+	}
+	for _, p := range order {
+		if err := builder.AddFile(p, bytes.Repeat([]byte(p), 100)); err != nil {
+			t.Fatalf("unexpected error adding file %s: %v", p, err)
+		}
+	}
+	options.ElTorito = &iso9660.ElTorito{
+		Platform: iso9660.BIOS,
+		Entries: []*iso9660.ElToritoEntry{
+			{Emulation: iso9660.NoEmulation, BootFile: "/boot/bios.img", BootTable: true},
+			{Platform: iso9660.EFI, Emulation: iso9660.NoEmulation, BootFile: "/boot/efi.img"},
+		},
+	}
+	var buf bytes.Buffer
+	if err := builder.Build(writerOnly{&buf}, options); err != nil {
+		t.Fatalf("unexpected error building image: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestFinalizeReproducible(t *testing.T) {
+	epoch := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	options := iso9660.FinalizeOptions{
+		RockRidge:       true,
+		Joliet:          true,
+		UDF:             true,
+		Hybrid:          &iso9660.Hybrid{GPT: true},
+		SourceDateEpoch: epoch,
+		NormalizeOwners: true,
+	}
+	order := []string{"/boot/bios.img", "/boot/efi.img", "/b.txt", "/a.txt", "/dir/long file name.txt", "/dir/long file name 2.txt"}
+	first := buildReproducible(t, order, options)
+	// with the files added in another order
+	reversed := make([]string, 0, len(order))
+	for i := len(order) - 1; i >= 0; i-- {
+		reversed = append(reversed, order[i])
+	}
+	second := buildReproducible(t, reversed, options)
+	if !bytes.Equal(first, second) {
+		t.Errorf("images of the same files are not the same")
+	}
+
+	f, err := os.CreateTemp("", "iso_reproducible_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	if _, err := f.Write(first); err != nil {
+		t.Fatalf("Failed to write tmpfile: %v", err)
+	}
+	fs, err := iso9660.Read(file.New(f, true), 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading the tmpfile as iso: %v", err)
+	}
+	if info := fs.PrimaryVolumeDescriptor(); !info.CreationTime.Equal(epoch) || !info.ModificationTime.Equal(epoch) {
+		t.Errorf("volume created %v and modified %v, instead of at %v", info.CreationTime, info.ModificationTime, epoch)
+	}
+	entries, err := fs.ReadDir("/")
+	if err != nil {
+		t.Fatalf("error reading root directory: %v", err)
+	}
+	for _, e := range entries {
+		info, ok := e.Sys().(*iso9660.RockRidgeInfo)
+		if !ok {
+			t.Fatalf("%s has Sys() of %T instead of *iso9660.RockRidgeInfo", e.Name(), e.Sys())
+		}
+		if !info.ModTime.Equal(epoch) || info.UID != 0 || info.GID != 0 {
+			t.Errorf("%s modified %v by %d:%d, instead of at %v by 0:0", e.Name(), info.ModTime, info.UID, info.GID, epoch)
+		}
+	}
+}
+
+func TestEFIBootImageReproducible(t *testing.T) {
+	dir, _ := createEFIBootDir(t)
+	epoch := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	build := func() []byte {
+		t.Helper()
+		builder, err := iso9660.NewBuilder(2048)
+		if err != nil {
+			t.Fatalf("unexpected error creating builder: %v", err)
+		}
+		options := iso9660.FinalizeOptions{SourceDateEpoch: epoch}
+		if err := builder.AddEFIBootImage(&options, "/boot/efiboot.img", dir); err != nil {
+			t.Fatalf("unexpected error adding EFI boot image: %v", err)
+		}
+		var buf bytes.Buffer
+		if err := builder.Build(writerOnly{&buf}, options); err != nil {
+			t.Fatalf("unexpected error building image: %v", err)
+		}
+		return buf.Bytes()
+	}
+	first := build()
+	// so that anything taken from the time the image is made differs
+	time.Sleep(20 * time.Millisecond)
+	second := build()
+	if !bytes.Equal(first, second) {
+		t.Errorf("images with the same EFI boot image are not the same")
+	}
+}
+
+func TestSourceDateEpochFromEnv(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected time.Time
+		err      bool
+	}{
+		{"", time.Time{}, false},
+		{"1577934245", time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), false},
+		{"yesterday", time.Time{}, true},
+	}
+	for _, tt := range tests {
+		t.Setenv("SOURCE_DATE_EPOCH", tt.value)
+		actual, err := iso9660.SourceDateEpochFromEnv()
+		if (err != nil) != tt.err {
+			t.Errorf("%q: mismatched error %v", tt.value, err)
+		}
+		if !actual.Equal(tt.expected) {
+			t.Errorf("%q: mismatched time, actual %v expected %v", tt.value, actual, tt.expected)
+		}
+	}
+}
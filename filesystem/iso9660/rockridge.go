@@ -22,6 +22,36 @@ const (
 	rockRidge112                         = "IEEE_P1282"
 )
 
+// RockRidgeInfo is the POSIX information that Rock Ridge keeps about a file. It is what Sys() returns for the
+// os.FileInfo of a file of an ISO9660 filesystem with Rock Ridge extensions, similar to
+// https://golang.org/pkg/syscall/#Stat_t
+type RockRidgeInfo struct {
+	// Mode the file mode bits, including the type of the file and its setuid, setgid and sticky bits
+	Mode os.FileMode
+	// Nlink number of hard links to the file
+	Nlink uint32
+	// UID user id of the owner of the file
+	UID uint32
+	// GID group id of the owner of the file
+	GID uint32
+	// Serial file serial number, i.e. inode number, which Rock Ridge 1.12 has, but 1.10 does not
+	Serial uint64
+	// DevMajor major device number, for a block or character device
+	DevMajor uint32
+	// DevMinor minor device number, for a block or character device
+	DevMinor uint32
+	// LinkTarget target of a symbolic link
+	LinkTarget string
+	// ModTime time the file was last modified, if recorded
+	ModTime time.Time
+	// AccessTime time the file was last accessed, if recorded
+	AccessTime time.Time
+	// ChangeTime time the attributes of the file were last changed, if recorded
+	ChangeTime time.Time
+	// CreationTime time the file was created, if recorded
+	CreationTime time.Time
+}
+
 // rockRidgeExtension implements suspExtension interface
 type rockRidgeExtension struct {
 	version    string
@@ -69,6 +99,8 @@ func (r *rockRidgeExtension) Process(signature string, b []byte) (directoryEntry
 		entry, err = r.parseTimestamps(b)
 	case rockRidgeSignatureSparseFile:
 		entry, err = r.parseSparseFile(b)
+	case rockRidgeSignatureZisofs:
+		entry, err = r.parseZisofs(b)
 	default:
 		return nil, ErrSuspNoHandler
 	}
@@ -95,7 +127,7 @@ func (r *rockRidgeExtension) GetFilename(de *directoryEntry) (string, error) {
 	return name, nil
 }
 func (r *rockRidgeExtension) GetFileExtensions(ffi *finalizeFileInfo, isSelf, isParent bool) ([]directoryEntrySystemUseExtension, error) {
-	// we always do PX, TF, NM, SL order
+	// we always do PX, PN, TF, NM, SL, ZF order
 	ret := []directoryEntrySystemUseExtension{}
 
 	// PX
@@ -109,6 +141,10 @@ func (r *rockRidgeExtension) GetFileExtensions(ffi *finalizeFileInfo, isSelf, is
 		length:    r.pxLength,
 		serial:    ffi.serial,
 	})
+	// PN
+	if ffi.Mode()&os.ModeDevice == os.ModeDevice {
+		ret = append(ret, rockRidgePosixDeviceNumber{high: ffi.devMajor, low: ffi.devMinor})
+	}
 	// TF
 	tf := rockRidgeTimestamps{longForm: false, stamps: []rockRidgeTimestamp{
 		{timestampType: rockRidgeTimestampModify, time: mtime},
@@ -126,6 +162,10 @@ func (r *rockRidgeExtension) GetFileExtensions(ffi *finalizeFileInfo, isSelf, is
 		// need the target if it is a symlink
 		ret = append(ret, rockRidgeSymlink{continued: false, name: ffi.LinkTarget()})
 	}
+	// ZF
+	if ffi.zisofs != nil && !isSelf && !isParent {
+		ret = append(ret, ffi.zisofs.extension())
+	}
 
 	return ret, nil
 }
@@ -210,6 +250,7 @@ func (r *rockRidgeExtension) Relocate(dirs map[string]*finalizeFileInfo) ([]*fin
 				replacer.content = content
 				replacer.trueChild = e
 				children = append(children, replacer)
+				files = append(files, replacer)
 			}
 			e.trueParent.children = children
 			// cycle down and update the depth for all children
@@ -263,6 +304,21 @@ func getRockRidgeExtension(id string) *rockRidgeExtension {
 	return ret
 }
 
+// the bits of the file mode of a PX entry, which are those of POSIX
+const (
+	rockRidgeModeSetuid      uint32 = 0o4000
+	rockRidgeModeSetgid      uint32 = 0o2000
+	rockRidgeModeSticky      uint32 = 0o1000
+	rockRidgeModeType        uint32 = 0o170000
+	rockRidgeModeSocket      uint32 = 0o140000
+	rockRidgeModeSymlink     uint32 = 0o120000
+	rockRidgeModeRegular     uint32 = 0o100000
+	rockRidgeModeBlockDevice uint32 = 0o60000
+	rockRidgeModeDir         uint32 = 0o40000
+	rockRidgeModeCharDevice  uint32 = 0o20000
+	rockRidgeModeNamedPipe   uint32 = 0o10000
+)
+
 // rockRidgePosixAttributes
 type rockRidgePosixAttributes struct {
 	mode         os.FileMode
@@ -296,40 +352,44 @@ func (d rockRidgePosixAttributes) Data() []byte {
 	m := d.mode
 	// get Unix permission bits - golang and Rock Ridge use the same ones
 	modes |= uint32(m & 0o777)
-	// get setuid and setgid
-	modes |= uint32(m & os.ModeSetuid)
-	modes |= uint32(m & os.ModeSetgid)
-	// save swapped text mode seems to have no parallel
-	if d.saveSwapText {
-		modes |= 0o1000
+	// get setuid and setgid, which golang keeps elsewhere
+	if m&os.ModeSetuid == os.ModeSetuid {
+		modes |= rockRidgeModeSetuid
+	}
+	if m&os.ModeSetgid == os.ModeSetgid {
+		modes |= rockRidgeModeSetgid
+	}
+	// save swapped text mode is what the sticky bit used to be
+	if d.saveSwapText || m&os.ModeSticky == os.ModeSticky {
+		modes |= rockRidgeModeSticky
 	}
 	// the rest of the modes do not use the same bits on Rock Ridge and on golang
 	if m&os.ModeSocket == os.ModeSocket {
-		modes |= 0o140000
+		modes |= rockRidgeModeSocket
 		regular = false
 	}
 	if m&os.ModeSymlink == os.ModeSymlink {
-		modes |= 0o120000
+		modes |= rockRidgeModeSymlink
 		regular = false
 	}
 	if m&os.ModeDevice == os.ModeDevice {
 		regular = false
 		if m&os.ModeCharDevice == os.ModeCharDevice {
-			modes |= 0o20000
+			modes |= rockRidgeModeCharDevice
 		} else {
-			modes |= 0o60000
+			modes |= rockRidgeModeBlockDevice
 		}
 	}
 	if m&os.ModeDir == os.ModeDir {
-		modes |= 0o40000
+		modes |= rockRidgeModeDir
 		regular = false
 	}
 	if m&os.ModeNamedPipe == os.ModeNamedPipe {
-		modes |= 0o10000
+		modes |= rockRidgeModeNamedPipe
 		regular = false
 	}
 	if regular {
-		modes |= 0o100000
+		modes |= rockRidgeModeRegular
 	}
 
 	binary.LittleEndian.PutUint32(ret[0:4], modes)
@@ -381,27 +441,32 @@ func (r *rockRidgeExtension) parsePosixAttributes(b []byte) (directoryEntrySyste
 	var m uint32
 	// get Unix permission bits - golang and Rock Ridge use the same ones
 	m |= (modes & 0o777)
-	// get setuid and setgid
-	m |= (modes & uint32(os.ModeSetuid))
-	m |= (modes & uint32(os.ModeSetgid))
-	// save swapped text mode seems to have no parallel
+	// get setuid and setgid, which golang keeps elsewhere
+	if modes&rockRidgeModeSetuid != 0 {
+		m |= uint32(os.ModeSetuid)
+	}
+	if modes&rockRidgeModeSetgid != 0 {
+		m |= uint32(os.ModeSetgid)
+	}
+	// save swapped text mode is what the sticky bit used to be
 	var saveSwapText bool
-	if modes&0o01000 != 0 {
+	if modes&rockRidgeModeSticky != 0 {
 		saveSwapText = true
+		m |= uint32(os.ModeSticky)
 	}
 	// the rest of the modes do not use the same bits on Rock Ridge and on golang, and are exclusive
-	switch {
-	case modes&0o140000 == 0o140000:
+	switch modes & rockRidgeModeType {
+	case rockRidgeModeSocket:
 		m |= uint32(os.ModeSocket)
-	case modes&0o120000 == 0o120000:
+	case rockRidgeModeSymlink:
 		m |= uint32(os.ModeSymlink)
-	case modes&0o20000 == 0o20000:
+	case rockRidgeModeCharDevice:
 		m |= uint32(os.ModeCharDevice | os.ModeDevice)
-	case modes&0o60000 == 0o60000:
+	case rockRidgeModeBlockDevice:
 		m |= uint32(os.ModeDevice)
-	case modes&0o40000 == 0o40000:
+	case rockRidgeModeDir:
 		m |= uint32(os.ModeDir)
-	case modes&0o10000 == 0o10000:
+	case rockRidgeModeNamedPipe:
 		m |= uint32(os.ModeNamedPipe)
 	}
 
@@ -463,6 +528,15 @@ func (d rockRidgePosixDeviceNumber) Merge([]directoryEntrySystemUseExtension) di
 	return nil
 }
 
+// device returns the major and minor numbers of the device, the way that Linux reads them: a high of 0 means that
+// low is a 16-bit device number, with the major in the upper 8 bits, as old versions of mkisofs wrote it.
+func (d rockRidgePosixDeviceNumber) device() (major, minor uint32) {
+	if d.high == 0 && d.low&^0xff != 0 {
+		return d.low >> 8, d.low & 0xff
+	}
+	return d.high, d.low
+}
+
 func (r *rockRidgeExtension) parsePosixDeviceNumber(b []byte) (directoryEntrySystemUseExtension, error) {
 	targetSize := 20
 	if len(b) != targetSize {
@@ -491,6 +565,7 @@ func (r *rockRidgeExtension) parsePosixDeviceNumber(b []byte) (directoryEntrySys
 // Bytes(), when called, will provide as many consecutive symlink bytes as needed
 type rockRidgeSymlink struct {
 	continued bool // if this is continuted in another rockRidgeSymlink entry
+	partial   bool // if the last component of the name is continued in the next rockRidgeSymlink entry
 	name      string
 }
 
@@ -580,10 +655,15 @@ func (d rockRidgeSymlink) Continuable() bool {
 func (d rockRidgeSymlink) Merge(links []directoryEntrySystemUseExtension) directoryEntrySystemUseExtension {
 	for _, e := range links {
 		if l, ok := e.(rockRidgeSymlink); ok {
+			// components are separated, unless one was split across entries
+			if !d.partial && d.name != "" && d.name != "/" {
+				d.name += "/"
+			}
 			d.name += l.name
+			d.continued = l.continued
+			d.partial = l.partial
 		}
 	}
-	d.continued = false
 	return d
 }
 
@@ -598,29 +678,46 @@ func (r *rockRidgeExtension) parseSymlink(b []byte) (directoryEntrySystemUseExte
 		//nolint:staticcheck // "Rock Ridge" is a proper noun
 		return nil, fmt.Errorf("Rock Ridge SL extension must be version 1, was %d", version)
 	}
-	continued := b[4] == 1
-	name := ""
+	continued := b[4]&0x1 == 0x1
+	var (
+		name    string
+		partial bool
+	)
 	for i := 5; i < len(b); {
 		// make it easier to work with
 		b2 := b[i:]
+		if len(b2) < 2 || len(b2) < 2+int(b2[1]) {
+			//nolint:staticcheck // "Rock Ridge" is a proper noun
+			return nil, fmt.Errorf("Rock Ridge SL extension component at byte %d goes beyond its end", i)
+		}
 		// find out how many bytes we will read
 		flags := b2[0]
 		size := b2[1]
+		var component string
 		switch {
-		case flags&0x1 == 0x1:
-			name += "."
-		case flags&0x2 == 0x2:
-			name += ".."
-		case flags&0x3 == 0x3:
+		case flags&0x8 == 0x8:
 			name = "/"
-		case size > 0:
-			name += "/" + string(b2[2:2+size])
+		case flags&0x4 == 0x4:
+			component = ".."
+		case flags&0x2 == 0x2:
+			component = "."
+		default:
+			component = string(b2[2 : 2+size])
+		}
+		if component != "" {
+			// components are separated, unless the previous one continues in this one
+			if !partial && name != "" && name != "/" {
+				name += "/"
+			}
+			name += component
+			partial = flags&0x1 == 0x1
 		}
 
 		i += 2 + int(size)
 	}
 	return rockRidgeSymlink{
 		continued: continued,
+		partial:   partial,
 		name:      name,
 	}, nil
 }
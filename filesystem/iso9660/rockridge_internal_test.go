@@ -72,8 +72,9 @@ func TestRockRidgeSymlinkMerge(t *testing.T) {
 		continuation []directoryEntrySystemUseExtension
 		result       rockRidgeSymlink
 	}{
-		{rockRidgeSymlink{name: "/a/b", continued: true}, []directoryEntrySystemUseExtension{rockRidgeSymlink{name: "/c/d", continued: true}, rockRidgeSymlink{name: "/e/f", continued: false}}, rockRidgeSymlink{name: "/a/b/c/d/e/f", continued: false}},
-		{rockRidgeSymlink{name: "/a/b", continued: true}, []directoryEntrySystemUseExtension{rockRidgeSymlink{name: "/c/d", continued: false}}, rockRidgeSymlink{name: "/a/b/c/d", continued: false}},
+		{rockRidgeSymlink{name: "/a/b", continued: true}, []directoryEntrySystemUseExtension{rockRidgeSymlink{name: "c/d", continued: true}, rockRidgeSymlink{name: "e/f", continued: false}}, rockRidgeSymlink{name: "/a/b/c/d/e/f", continued: false}},
+		{rockRidgeSymlink{name: "/a/b", continued: true}, []directoryEntrySystemUseExtension{rockRidgeSymlink{name: "c/d", continued: false}}, rockRidgeSymlink{name: "/a/b/c/d", continued: false}},
+		{rockRidgeSymlink{name: "../a/lo", continued: true, partial: true}, []directoryEntrySystemUseExtension{rockRidgeSymlink{name: "ng/b", continued: false}}, rockRidgeSymlink{name: "../a/long/b", continued: false}},
 		{rockRidgeSymlink{name: "/a/b", continued: false}, nil, rockRidgeSymlink{name: "/a/b", continued: false}},
 	}
 	for _, tt := range tests {
@@ -298,3 +299,59 @@ func TestGetExtensions(t *testing.T) {
 		})
 	}
 }
+
+func TestRockRidgeSymlinkBytes(t *testing.T) {
+	rr := getRockRidgeExtension(rockRidge112)
+	for _, target := range []string{"/a/b/c", "/", "../x/y", "./z", "rel", "a/../b"} {
+		ext, err := rr.parseSymlink(rockRidgeSymlink{name: target}.Bytes())
+		if err != nil {
+			t.Errorf("%s: unexpected error parsing symlink: %v", target, err)
+			continue
+		}
+		if sl, ok := ext.(rockRidgeSymlink); !ok || sl.name != target || sl.continued {
+			t.Errorf("%s: mismatched symlink, actual %+v", target, ext)
+		}
+	}
+}
+
+func TestRockRidgePosixAttributesMode(t *testing.T) {
+	rr := getRockRidgeExtension(rockRidge112)
+	modes := []os.FileMode{
+		0o644,
+		0o755 | os.ModeSetuid | os.ModeSetgid,
+		0o1777 | os.ModeDir | os.ModeSticky,
+		0o777 | os.ModeSymlink,
+		0o660 | os.ModeDevice,
+		0o620 | os.ModeDevice | os.ModeCharDevice,
+		0o644 | os.ModeNamedPipe,
+		0o755 | os.ModeSocket,
+	}
+	for _, mode := range modes {
+		// the sticky bit is among the permissions here, as golang does it elsewhere
+		px := rockRidgePosixAttributes{mode: mode &^ 0o1000, linkCount: 1, length: rr.pxLength}
+		ext, err := rr.parsePosixAttributes(px.Bytes())
+		if err != nil {
+			t.Errorf("%v: unexpected error parsing PX: %v", mode, err)
+			continue
+		}
+		if actual := ext.(rockRidgePosixAttributes).mode; actual != mode&^0o1000 {
+			t.Errorf("mismatched mode, actual %v expected %v", actual, mode&^0o1000)
+		}
+	}
+}
+
+func TestRockRidgePosixDeviceNumber(t *testing.T) {
+	tests := []struct {
+		pn           rockRidgePosixDeviceNumber
+		major, minor uint32
+	}{
+		{rockRidgePosixDeviceNumber{high: 8, low: 1}, 8, 1},
+		{rockRidgePosixDeviceNumber{high: 0, low: 0x0801}, 8, 1},
+		{rockRidgePosixDeviceNumber{high: 0, low: 3}, 0, 3},
+	}
+	for _, tt := range tests {
+		if major, minor := tt.pn.device(); major != tt.major || minor != tt.minor {
+			t.Errorf("%+v: mismatched device %d:%d, expected %d:%d", tt.pn, major, minor, tt.major, tt.minor)
+		}
+	}
+}
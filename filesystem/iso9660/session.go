@@ -0,0 +1,204 @@
+package iso9660
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+/*
+	A multisession image has more than one session, each with its own volume descriptors and directory tree,
+	written one after the other. Each new session has a tree of everything that is in the image, which can point
+	at the contents of files of any session before it, so that adding to an image does not copy what is in it
+	already, and a file that is hidden or replaced keeps its space. All locations are from the beginning of the
+	image, not of the session.
+
+	On write-once media, the last session is found from the table of contents of the disc. An image file has no
+	such table, so like growisofs does for images and rewritable media, we copy the volume descriptors of the new
+	session over those at the beginning of the image, which then opens to the new session.
+*/
+
+// sessionAlignment is the number of blocks that the start of each session is rounded up to, as growisofs does
+const sessionAlignment = 16
+
+// NewSession starts a new session of the image, to append after its last one. It returns a Builder that starts
+// out with all of the files and directories of the last session, whose contents stay where they are in the image.
+// Files and directories can be added to it or removed from it, and it is written out with AppendSession, or with
+// its Build to go on a multisession disc.
+//
+// The files of the last session keep their names, and with Rock Ridge, their other properties. Nothing else
+// carries over to the new session from FinalizeOptions, such as El Torito, which has to be given again to Build
+// or AppendSession. A boot image that has a boot table is written again in the new session, to update it.
+func (fsm *FileSystem) NewSession() (*Builder, error) {
+	if fsm.workspace != "" {
+		return nil, fmt.Errorf("cannot start a new session of a filesystem that is not finalized")
+	}
+	pvd := fsm.volumes.primary
+	if pvd == nil || fsm.rootDir == nil {
+		return nil, fmt.Errorf("cannot start a new session of a filesystem without a primary volume descriptor")
+	}
+	b, err := NewBuilder(fsm.blocksize)
+	if err != nil {
+		return nil, err
+	}
+	b.previous = pvd
+
+	// the session begins after the end of the volume, or of the image, if it has anything else after it; like all
+	// locations, from the start of the filesystem
+	end := int64(pvd.volumeSize)
+	if fi, err := fsm.backend.Stat(); err == nil {
+		end = maxInt64(end, (fi.Size()-fsm.start+fsm.blocksize-1)/fsm.blocksize)
+	}
+	end = (end + sessionAlignment - 1) / sessionAlignment * sessionAlignment
+	if end >= MaxBlocks {
+		return nil, fmt.Errorf("no room for a new session after block %d", end)
+	}
+	b.start = uint32(end)
+
+	if err := b.addPreviousSession(fsm, ".", fsm.rootDir.location, fsm.rootDir.size); err != nil {
+		return nil, fmt.Errorf("could not read last session: %v", err)
+	}
+	return b, nil
+}
+
+// AppendSession writes the new session of b after the last session of the image, and has the image open to it from
+// then on, by copying its volume descriptors over those at the beginning of the image. b must come from NewSession
+// of the same filesystem, since its last session. The new session needs no more volume descriptors than there
+// already are, so it should have the same El Torito and Joliet options as the image had. The filesystem then is
+// that of the new session.
+//
+// A UDF file system of a bridge image is not updated, and stays as it was.
+func (fsm *FileSystem) AppendSession(b *Builder, options FinalizeOptions) error {
+	if b.previous == nil || b.previous != fsm.volumes.primary {
+		return fmt.Errorf("cannot append a session that is not a new session after the last one of this filesystem")
+	}
+	// the primary volume descriptor and terminator, and any boot and Joliet ones
+	descriptors := 2
+	if options.ElTorito != nil {
+		descriptors++
+	}
+	if options.Joliet {
+		descriptors++
+	}
+	if previous := len(fsm.volumes.descriptors) + 1; descriptors > previous {
+		return fmt.Errorf("new session needs %d volume descriptors, more than the %d of the image", descriptors, previous)
+	}
+
+	f, err := fsm.backend.Writable()
+	if err != nil {
+		return err
+	}
+	if err := b.Build(io.NewOffsetWriter(f, fsm.start+b.SessionStart()), options); err != nil {
+		return err
+	}
+
+	// copy the volume descriptors of the new session to the beginning of the image
+	vds := make([]byte, int64(descriptors)*volumeDescriptorSize)
+	if _, err := f.ReadAt(vds, fsm.start+b.SessionStart()+dataStartSector*fsm.blocksize); err != nil {
+		return fmt.Errorf("could not read volume descriptors of new session: %v", err)
+	}
+	if _, err := f.WriteAt(vds, fsm.start+systemAreaSize); err != nil {
+		return fmt.Errorf("could not write volume descriptors of new session: %v", err)
+	}
+
+	session, err := Read(fsm.backend, fsm.size, fsm.start, fsm.blocksize)
+	if err != nil {
+		return fmt.Errorf("could not read new session: %v", err)
+	}
+	*fsm = *session
+	return nil
+}
+
+// addPreviousSession adds everything in the directory p of size bytes at block location of fsm, and under it
+func (b *Builder) addPreviousSession(fsm *FileSystem, p string, location, size uint32) error {
+	entries, err := fsm.readDirectoryAt(location, size)
+	if err != nil {
+		return fmt.Errorf("directory %s: %v", p, err)
+	}
+	for _, de := range entries {
+		if de.isParent {
+			continue
+		}
+		if de.isSelf {
+			if p == "." {
+				setPreviousSessionProperties(b.dirs["."], de)
+			}
+			continue
+		}
+		target := path.Join(p, de.Name())
+
+		// a directory that Rock Ridge relocated is where it is in the tree, not where it was moved to
+		var childLocation uint32
+		if fsm.suspEnabled {
+			for _, e := range fsm.suspExtensions {
+				if childLocation = e.GetDirectoryLocation(de); childLocation != 0 {
+					break
+				}
+			}
+		}
+		if de.IsDir() || childLocation != 0 {
+			dir := newBuilderEntry(true)
+			setPreviousSessionProperties(dir, de)
+			dir.mode |= os.ModeDir
+			if err := b.add(target, dir); err != nil {
+				return err
+			}
+			dirLocation, dirSize := de.location, de.size
+			if childLocation != 0 {
+				dirLocation, dirSize = childLocation, 0
+			}
+			if err := b.addPreviousSession(fsm, target, dirLocation, dirSize); err != nil {
+				return err
+			}
+			continue
+		}
+
+		e := newBuilderEntry(false)
+		setPreviousSessionProperties(e, de)
+		e.fixedLocation = true
+		e.location = de.location
+		e.extents = de.extents
+		e.size = de.storedSize()
+		if zf, ok := de.zisofs(); ok {
+			// it already is compressed, so all that is needed is its ZF entry
+			e.zisofs = &zisofsFile{size: int64(zf.size), blockLog: zf.blockLog}
+		}
+		if e.mode.IsRegular() {
+			de := de
+			e.open = func() (io.ReadCloser, error) {
+				return &File{directoryEntry: de}, nil
+			}
+		} else {
+			e.content = []byte{}
+		}
+		if err := b.add(target, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setPreviousSessionProperties sets the properties of e to those of de, of a previous session
+func setPreviousSessionProperties(e *finalizeFileInfo, de *directoryEntry) {
+	e.mode = de.Mode()
+	e.modTime = de.ModTime()
+	e.accessTime = e.modTime
+	e.changeTime = e.modTime
+	info := de.rockRidgeInfo()
+	if info == nil {
+		return
+	}
+	if !info.AccessTime.IsZero() {
+		e.accessTime = info.AccessTime
+	}
+	if !info.ChangeTime.IsZero() {
+		e.changeTime = info.ChangeTime
+	}
+	if info.Nlink != 0 {
+		e.nlink = info.Nlink
+	}
+	e.uid, e.gid = info.UID, info.GID
+	e.linkTarget = info.LinkTarget
+	e.devMajor, e.devMinor = info.DevMajor, info.DevMinor
+}
@@ -0,0 +1,244 @@
+package iso9660_test
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem/iso9660"
+)
+
+// createSessionTestImage creates an image with a first session of the given files, and returns its file
+func createSessionTestImage(t *testing.T, files map[string]string, options iso9660.FinalizeOptions) *os.File {
+	t.Helper()
+	f, err := os.CreateTemp("", "iso_session_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	t.Cleanup(func() {
+		f.Close()
+		os.Remove(f.Name())
+	})
+	fs, err := iso9660.Create(file.New(f, false), 0, 0, 2048, "")
+	if err != nil {
+		t.Fatalf("Failed to iso9660.Create: %v", err)
+	}
+	for p, contents := range files {
+		if err := os.MkdirAll(filepath.Join(fs.Workspace(), filepath.Dir(p)), 0o755); err != nil {
+			t.Fatalf("Failed to create directory for %s: %v", p, err)
+		}
+		if err := os.WriteFile(filepath.Join(fs.Workspace(), p), []byte(contents), 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", p, err)
+		}
+	}
+	if options.RockRidge {
+		if err := os.Symlink("a.txt", filepath.Join(fs.Workspace(), "link")); err != nil {
+			t.Fatalf("Failed to create symlink: %v", err)
+		}
+	}
+	if err := fs.Finalize(options); err != nil {
+		t.Fatalf("unexpected error fs.Finalize(%+v): %v", options, err)
+	}
+	return f
+}
+
+// fileLocation returns where the contents of the file p are in the image
+func fileLocation(t *testing.T, fs *iso9660.FileSystem, p string) uint32 {
+	t.Helper()
+	f, err := fs.OpenFile(p, os.O_RDONLY)
+	if err != nil {
+		t.Fatalf("error opening file %s: %v", p, err)
+	}
+	return f.(*iso9660.File).Location()
+}
+
+// checkSessionFiles checks that fs has exactly the given files in their directories
+func checkSessionFiles(t *testing.T, fs *iso9660.FileSystem, files map[string]string) {
+	t.Helper()
+	dirs := map[string]bool{}
+	for p := range files {
+		dirs[path.Dir(p)] = true
+	}
+	count := 0
+	for dir := range dirs {
+		entries, err := fs.ReadDir(dir)
+		if err != nil {
+			t.Fatalf("error reading directory %s: %v", dir, err)
+		}
+		for _, e := range entries {
+			if !e.IsDir() && e.Mode().IsRegular() {
+				count++
+			}
+		}
+	}
+	if count != len(files) {
+		t.Errorf("%d files instead of %d", count, len(files))
+	}
+	for p, contents := range files {
+		f, err := fs.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			t.Errorf("error opening file %s: %v", p, err)
+			continue
+		}
+		b, err := io.ReadAll(f)
+		if err != nil {
+			t.Errorf("error reading file %s: %v", p, err)
+		}
+		if string(b) != contents {
+			t.Errorf("mismatched content of %s, actual %q expected %q", p, b, contents)
+		}
+	}
+}
+
+// addToSession makes the changes to a new session of fs that the tests expect
+func addToSession(t *testing.T, fs *iso9660.FileSystem) *iso9660.Builder {
+	t.Helper()
+	session, err := fs.NewSession()
+	if err != nil {
+		t.Fatalf("unexpected error starting new session: %v", err)
+	}
+	if session.SessionStart()%(16*2048) != 0 {
+		t.Errorf("session starts at %d, which is not aligned", session.SessionStart())
+	}
+	if err := session.AddFile("/new.txt", []byte("new file\n")); err != nil {
+		t.Fatalf("unexpected error adding file: %v", err)
+	}
+	if err := session.AddFile("/dir/c.txt", []byte("c\n")); err != nil {
+		t.Fatalf("unexpected error adding file: %v", err)
+	}
+	if err := session.AddFile("/a.txt", []byte("again")); err == nil {
+		t.Errorf("unexpected nil error adding file that exists")
+	}
+	if err := session.Remove("/gone.txt"); err != nil {
+		t.Fatalf("unexpected error removing file: %v", err)
+	}
+	if err := session.Remove("/replaced.txt"); err != nil {
+		t.Fatalf("unexpected error removing file: %v", err)
+	}
+	if err := session.AddFile("/replaced.txt", []byte("replaced\n")); err != nil {
+		t.Fatalf("unexpected error replacing file: %v", err)
+	}
+	if err := session.Remove("/missing"); err == nil {
+		t.Errorf("unexpected nil error removing missing file")
+	}
+	return session
+}
+
+var (
+	sessionTestFiles = map[string]string{
+		"/a.txt":        "a\n",
+		"/gone.txt":     "gone\n",
+		"/replaced.txt": "before\n",
+		"/dir/b.txt":    "b\n",
+	}
+	sessionTestAppendedFiles = map[string]string{
+		"/a.txt":        "a\n",
+		"/replaced.txt": "replaced\n",
+		"/new.txt":      "new file\n",
+		"/dir/b.txt":    "b\n",
+		"/dir/c.txt":    "c\n",
+	}
+)
+
+func TestAppendSession(t *testing.T) {
+	options := iso9660.FinalizeOptions{RockRidge: true, Joliet: true}
+	f := createSessionTestImage(t, sessionTestFiles, options)
+	b := file.New(f, false)
+	fs, err := iso9660.Read(b, 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading the tmpfile as iso: %v", err)
+	}
+	location := fileLocation(t, fs, "/a.txt")
+
+	session := addToSession(t, fs)
+	withBoot := iso9660.FinalizeOptions{RockRidge: true, Joliet: true, ElTorito: &iso9660.ElTorito{}}
+	if err := fs.AppendSession(session, withBoot); err == nil {
+		t.Errorf("unexpected nil error appending session with more volume descriptors than the image")
+	}
+	other, err := fs.NewSession()
+	if err != nil {
+		t.Fatalf("unexpected error starting new session: %v", err)
+	}
+	session = addToSession(t, fs)
+	if err := fs.AppendSession(session, options); err != nil {
+		t.Fatalf("unexpected error appending session: %v", err)
+	}
+	if err := fs.AppendSession(other, options); err == nil {
+		t.Errorf("unexpected nil error appending session of the image as it was")
+	}
+	checkSessionFiles(t, fs, sessionTestAppendedFiles)
+
+	// the image opens to the new session, which kept the contents of the files of the first one where they were
+	fs, err = iso9660.Read(b, 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading the tmpfile as iso: %v", err)
+	}
+	checkSessionFiles(t, fs, sessionTestAppendedFiles)
+	if actual := fileLocation(t, fs, "/a.txt"); actual != location {
+		t.Errorf("file of first session moved from block %d to %d", location, actual)
+	}
+	if actual := fileLocation(t, fs, "/new.txt"); int64(actual)*2048 < session.SessionStart() {
+		t.Errorf("file of new session at block %d, before the session", actual)
+	}
+	target, err := fs.Readlink("/link")
+	if err != nil {
+		t.Errorf("unexpected error reading link: %v", err)
+	}
+	if target != "a.txt" {
+		t.Errorf("mismatched link target, actual %q expected %q", target, "a.txt")
+	}
+
+	// and a session can be appended to that again
+	session, err = fs.NewSession()
+	if err != nil {
+		t.Fatalf("unexpected error starting third session: %v", err)
+	}
+	if err := session.Remove("/dir"); err != nil {
+		t.Fatalf("unexpected error removing directory: %v", err)
+	}
+	if err := fs.AppendSession(session, options); err != nil {
+		t.Fatalf("unexpected error appending third session: %v", err)
+	}
+	if _, err := fs.ReadDir("/dir"); err == nil {
+		t.Errorf("unexpected nil error reading removed directory")
+	}
+	checkSessionFiles(t, fs, map[string]string{
+		"/a.txt":        "a\n",
+		"/replaced.txt": "replaced\n",
+		"/new.txt":      "new file\n",
+	})
+}
+
+func TestBuildSession(t *testing.T) {
+	options := iso9660.FinalizeOptions{Joliet: true}
+	f := createSessionTestImage(t, sessionTestFiles, options)
+	b := file.New(f, false)
+	fs, err := iso9660.Read(b, 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading the tmpfile as iso: %v", err)
+	}
+	session := addToSession(t, fs)
+	var buf bytes.Buffer
+	if err := session.Build(writerOnly{&buf}, options); err != nil {
+		t.Fatalf("unexpected error building session: %v", err)
+	}
+	// as it would be on a multisession disc, without the volume descriptors at the beginning being changed
+	if _, err := f.WriteAt(buf.Bytes(), session.SessionStart()); err != nil {
+		t.Fatalf("error writing session: %v", err)
+	}
+
+	fs, err = iso9660.Read(b, 0, 0, 2048)
+	if err != nil {
+		t.Fatalf("error reading first session: %v", err)
+	}
+	checkSessionFiles(t, fs, sessionTestFiles)
+	fs, err = iso9660.Read(b, 0, session.SessionStart(), 2048)
+	if err != nil {
+		t.Fatalf("error reading new session: %v", err)
+	}
+	checkSessionFiles(t, fs, sessionTestAppendedFiles)
+}
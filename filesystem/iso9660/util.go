@@ -71,3 +71,24 @@ func maxInt(x, y int) int {
 	}
 	return x
 }
+
+// minInt64 returns the smaller of x or y.
+func minInt64(x, y int64) int64 {
+	if x > y {
+		return y
+	}
+	return x
+}
+
+// maxInt64 returns the larger of x or y.
+func maxInt64(x, y int64) int64 {
+	if x < y {
+		return y
+	}
+	return x
+}
+
+// roundUpInt64 returns x rounded up to a multiple of m.
+func roundUpInt64(x, m int64) int64 {
+	return (x + m - 1) / m * m
+}
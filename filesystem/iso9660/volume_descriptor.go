@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -24,6 +25,27 @@ const (
 	bootSystemIdentifier        = "EL TORITO SPECIFICATION"
 )
 
+// VolumeDescriptorInfo is what the primary volume descriptor of a filesystem says about it, with the spaces that
+// pad its identifiers trimmed. A time that is not set is zero.
+type VolumeDescriptorInfo struct {
+	SystemIdentifier      string
+	VolumeIdentifier      string
+	VolumeSetIdentifier   string
+	PublisherIdentifier   string
+	PreparerIdentifier    string
+	ApplicationIdentifier string
+	CopyrightFile         string
+	AbstractFile          string
+	BibliographicFile     string
+	CreationTime          time.Time
+	ModificationTime      time.Time
+	ExpirationTime        time.Time
+	EffectiveTime         time.Time
+	// VolumeSize is the size of the volume in blocks of BlockSize bytes
+	VolumeSize uint32
+	BlockSize  uint16
+}
+
 // volumeDescriptor interface for any given type of volume descriptor
 type volumeDescriptor interface {
 	Type() volumeDescriptorType
@@ -97,6 +119,7 @@ type partitionVolumeDescriptor struct {
 type volumeDescriptors struct {
 	descriptors []volumeDescriptor
 	primary     *primaryVolumeDescriptor
+	joliet      *supplementaryVolumeDescriptor
 }
 
 func (v *volumeDescriptors) equal(a *volumeDescriptors) bool {
@@ -125,8 +148,8 @@ func (v *primaryVolumeDescriptor) equal(a volumeDescriptor) bool {
 func (v *primaryVolumeDescriptor) toBytes() []byte {
 	b := volumeDescriptorFirstBytes(volumeDescriptorPrimary)
 
-	copy(b[8:40], v.systemIdentifier)
-	copy(b[40:72], v.volumeIdentifier)
+	copyPadded(b[8:40], v.systemIdentifier)
+	copyPadded(b[40:72], v.volumeIdentifier)
 	binary.LittleEndian.PutUint32(b[80:84], v.volumeSize)
 	binary.BigEndian.PutUint32(b[84:88], v.volumeSize)
 	binary.LittleEndian.PutUint16(b[120:122], v.setSize)
@@ -150,13 +173,13 @@ func (v *primaryVolumeDescriptor) toBytes() []byte {
 	}
 	copy(b[156:156+34], rootDirEntry)
 
-	copy(b[190:190+128], v.volumeSetIdentifier)
-	copy(b[318:318+128], v.publisherIdentifier)
-	copy(b[446:446+128], v.preparerIdentifier)
-	copy(b[574:574+128], v.applicationIdentifier)
-	copy(b[702:702+37], v.copyrightFile)
-	copy(b[739:739+37], v.abstractFile)
-	copy(b[776:776+37], v.bibliographicFile)
+	copyPadded(b[190:190+128], v.volumeSetIdentifier)
+	copyPadded(b[318:318+128], v.publisherIdentifier)
+	copyPadded(b[446:446+128], v.preparerIdentifier)
+	copyPadded(b[574:574+128], v.applicationIdentifier)
+	copyPadded(b[702:702+37], v.copyrightFile)
+	copyPadded(b[739:739+37], v.abstractFile)
+	copyPadded(b[776:776+37], v.bibliographicFile)
 	copy(b[813:813+17], timeToDecBytes(v.creation))
 	copy(b[830:830+17], timeToDecBytes(v.modification))
 	copy(b[847:847+17], timeToDecBytes(v.expiration))
@@ -222,30 +245,22 @@ func volumeDescriptorFromBytes(b []byte) (volumeDescriptor, error) {
 func parsePrimaryVolumeDescriptor(b []byte) (*primaryVolumeDescriptor, error) {
 	blocksize := binary.LittleEndian.Uint16(b[128:130])
 
-	creation, err := decBytesToTime(b[813 : 813+17])
+	// any of the dates can be not set, such as expiration for never
+	creation, err := decBytesToVolumeTime(b[813 : 813+17])
 	if err != nil {
 		return nil, fmt.Errorf("unable to convert creation date/time from bytes: %v", err)
 	}
-	modification, err := decBytesToTime(b[830 : 830+17])
+	modification, err := decBytesToVolumeTime(b[830 : 830+17])
 	if err != nil {
 		return nil, fmt.Errorf("unable to convert modification date/time from bytes: %v", err)
 	}
-	// expiration can be never
-	nullBytes := []byte{48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 0}
-	var expiration, effective time.Time
-	expirationBytes := b[847 : 847+17]
-	effectiveBytes := b[864 : 864+17]
-	if !bytes.Equal(expirationBytes, nullBytes) {
-		expiration, err = decBytesToTime(expirationBytes)
-		if err != nil {
-			return nil, fmt.Errorf("unable to convert expiration date/time from bytes: %v", err)
-		}
+	expiration, err := decBytesToVolumeTime(b[847 : 847+17])
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert expiration date/time from bytes: %v", err)
 	}
-	if !bytes.Equal(effectiveBytes, nullBytes) {
-		effective, err = decBytesToTime(effectiveBytes)
-		if err != nil {
-			return nil, fmt.Errorf("unable to convert effective date/time from bytes: %v", err)
-		}
+	effective, err := decBytesToVolumeTime(b[864 : 864+17])
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert effective date/time from bytes: %v", err)
 	}
 
 	rootDirEntry, err := dirEntryFromBytes(b[156:156+34], nil)
@@ -280,6 +295,30 @@ func parsePrimaryVolumeDescriptor(b []byte) (*primaryVolumeDescriptor, error) {
 	}, nil
 }
 
+// info returns what the primary volume descriptor says about the filesystem
+func (v *primaryVolumeDescriptor) info() *VolumeDescriptorInfo {
+	trim := func(s string) string {
+		return strings.TrimRight(s, " \x00")
+	}
+	return &VolumeDescriptorInfo{
+		SystemIdentifier:      trim(v.systemIdentifier),
+		VolumeIdentifier:      trim(v.volumeIdentifier),
+		VolumeSetIdentifier:   trim(v.volumeSetIdentifier),
+		PublisherIdentifier:   trim(v.publisherIdentifier),
+		PreparerIdentifier:    trim(v.preparerIdentifier),
+		ApplicationIdentifier: trim(v.applicationIdentifier),
+		CopyrightFile:         trim(v.copyrightFile),
+		AbstractFile:          trim(v.abstractFile),
+		BibliographicFile:     trim(v.bibliographicFile),
+		CreationTime:          v.creation,
+		ModificationTime:      v.modification,
+		ExpirationTime:        v.expiration,
+		EffectiveTime:         v.effective,
+		VolumeSize:            v.volumeSize,
+		BlockSize:             v.blocksize,
+	}
+}
+
 // terminatorVolumeDescriptor
 func (v *terminatorVolumeDescriptor) Type() volumeDescriptorType {
 	return volumeDescriptorTerminator
@@ -323,30 +362,22 @@ func parseSupplementaryVolumeDescriptor(b []byte) (*supplementaryVolumeDescripto
 	volumesize := binary.LittleEndian.Uint32(b[80:84])
 	volumesizeBytes := uint64(blocksize) * uint64(volumesize)
 
-	creation, err := decBytesToTime(b[813 : 813+17])
+	// any of the dates can be not set, such as expiration for never
+	creation, err := decBytesToVolumeTime(b[813 : 813+17])
 	if err != nil {
 		return nil, fmt.Errorf("unable to convert creation date/time from bytes: %v", err)
 	}
-	modification, err := decBytesToTime(b[830 : 830+17])
+	modification, err := decBytesToVolumeTime(b[830 : 830+17])
 	if err != nil {
 		return nil, fmt.Errorf("unable to convert modification date/time from bytes: %v", err)
 	}
-	// expiration can be never
-	nullBytes := []byte{48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 0}
-	var expiration, effective time.Time
-	expirationBytes := b[847 : 847+17]
-	effectiveBytes := b[864 : 864+17]
-	if !bytes.Equal(expirationBytes, nullBytes) {
-		expiration, err = decBytesToTime(expirationBytes)
-		if err != nil {
-			return nil, fmt.Errorf("unable to convert expiration date/time from bytes: %v", err)
-		}
+	expiration, err := decBytesToVolumeTime(b[847 : 847+17])
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert expiration date/time from bytes: %v", err)
 	}
-	if !bytes.Equal(effectiveBytes, nullBytes) {
-		effective, err = decBytesToTime(effectiveBytes)
-		if err != nil {
-			return nil, fmt.Errorf("unable to convert effective date/time from bytes: %v", err)
-		}
+	effective, err := decBytesToVolumeTime(b[864 : 864+17])
+	if err != nil {
+		return nil, fmt.Errorf("unable to convert effective date/time from bytes: %v", err)
 	}
 
 	// no susp extensions for the dir entry in the volume descriptor
@@ -355,10 +386,19 @@ func parseSupplementaryVolumeDescriptor(b []byte) (*supplementaryVolumeDescripto
 		return nil, fmt.Errorf("unable to read root directory entry: %v", err)
 	}
 
+	// the escape sequences are padded with zeroes; for Joliet, the identifiers are in UCS-2 as well
+	escapeSequences := bytes.TrimRight(b[88:120], "\x00")
+	systemIdentifier, volumeIdentifier := string(b[8:40]), string(b[40:72])
+	if isJoliet(escapeSequences) {
+		systemIdentifier, volumeIdentifier = bytesToUCS2String(b[8:40]), bytesToUCS2String(b[40:72])
+	}
+
 	return &supplementaryVolumeDescriptor{
-		systemIdentifier:           string(b[8:40]),
-		volumeIdentifier:           string(b[40:72]),
+		volumeFlags:                b[7],
+		systemIdentifier:           systemIdentifier,
+		volumeIdentifier:           volumeIdentifier,
 		volumeSize:                 volumesizeBytes,
+		escapeSequences:            escapeSequences,
 		setSize:                    binary.LittleEndian.Uint16(b[120:122]),
 		sequenceNumber:             binary.LittleEndian.Uint16(b[124:126]),
 		blocksize:                  blocksize,
@@ -390,11 +430,18 @@ func (v *supplementaryVolumeDescriptor) equal(a volumeDescriptor) bool {
 func (v *supplementaryVolumeDescriptor) toBytes() []byte {
 	b := volumeDescriptorFirstBytes(volumeDescriptorSupplementary)
 
-	copy(b[8:40], v.systemIdentifier)
-	copy(b[40:72], v.volumeIdentifier)
+	b[7] = v.volumeFlags
+	if isJoliet(v.escapeSequences) {
+		copyUCS2Padded(b[8:40], v.systemIdentifier)
+		copyUCS2Padded(b[40:72], v.volumeIdentifier)
+	} else {
+		copy(b[8:40], v.systemIdentifier)
+		copy(b[40:72], v.volumeIdentifier)
+	}
 	blockcount := uint32(v.volumeSize / uint64(v.blocksize))
 	binary.LittleEndian.PutUint32(b[80:84], blockcount)
 	binary.BigEndian.PutUint32(b[84:88], blockcount)
+	copy(b[88:120], v.escapeSequences)
 	binary.LittleEndian.PutUint16(b[120:122], v.setSize)
 	binary.BigEndian.PutUint16(b[122:124], v.setSize)
 	binary.LittleEndian.PutUint16(b[124:126], v.sequenceNumber)
@@ -416,18 +463,21 @@ func (v *supplementaryVolumeDescriptor) toBytes() []byte {
 	}
 	copy(b[156:156+34], rootDirEntry)
 
-	copy(b[190:190+128], ucs2StringToBytes(v.volumeSetIdentifier))
-	copy(b[318:318+128], ucs2StringToBytes(v.publisherIdentifier))
-	copy(b[446:446+128], ucs2StringToBytes(v.preparerIdentifier))
-	copy(b[574:574+128], ucs2StringToBytes(v.applicationIdentifier))
-	copy(b[702:702+37], ucs2StringToBytes(v.copyrightFile))
-	copy(b[739:739+37], ucs2StringToBytes(v.abstractFile))
-	copy(b[776:776+37], ucs2StringToBytes(v.bibliographicFile))
+	copyUCS2Padded(b[190:190+128], v.volumeSetIdentifier)
+	copyUCS2Padded(b[318:318+128], v.publisherIdentifier)
+	copyUCS2Padded(b[446:446+128], v.preparerIdentifier)
+	copyUCS2Padded(b[574:574+128], v.applicationIdentifier)
+	copyUCS2Padded(b[702:702+37], v.copyrightFile)
+	copyUCS2Padded(b[739:739+37], v.abstractFile)
+	copyUCS2Padded(b[776:776+37], v.bibliographicFile)
 	copy(b[813:813+17], timeToDecBytes(v.creation))
 	copy(b[830:830+17], timeToDecBytes(v.modification))
 	copy(b[847:847+17], timeToDecBytes(v.expiration))
 	copy(b[864:864+17], timeToDecBytes(v.effective))
 
+	// set by the standard, as for the primary volume descriptor
+	b[881] = 1
+
 	return b
 }
 
@@ -456,6 +506,21 @@ func volumeDescriptorFirstBytes(t volumeDescriptorType) []byte {
 	return b
 }
 
+// isUnsetDecBytes reports whether a volume descriptor date/time is not set, which is all '0' digits
+// with a zero offset, although some mastering tools leave the whole field zeroed instead
+func isUnsetDecBytes(b []byte) bool {
+	nullBytes := []byte{48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 0}
+	return bytes.Equal(b, nullBytes) || bytes.Equal(b, make([]byte, len(b)))
+}
+
+// decBytesToVolumeTime converts a volume descriptor date/time, which is zero if it is not set
+func decBytesToVolumeTime(b []byte) (time.Time, error) {
+	if isUnsetDecBytes(b) {
+		return time.Time{}, nil
+	}
+	return decBytesToTime(b)
+}
+
 func decBytesToTime(b []byte) (time.Time, error) {
 	year := string(b[0:4])
 	month := string(b[4:6])
@@ -481,7 +546,34 @@ func decBytesToTime(b []byte) (time.Time, error) {
 	}
 	return time.Parse(format, fmt.Sprintf("%s-%s-%sT%s:%s:%s.%s%s", year, month, date, hour, minute, second, csec, offsetString))
 }
+
+// copyPadded copies s to b, and pads the rest of b with spaces, as the identifiers of a volume descriptor are
+func copyPadded(b []byte, s string) {
+	n := copy(b, s)
+	for i := n; i < len(b); i++ {
+		b[i] = ' '
+	}
+}
+
+// copyUCS2Padded copies as many whole characters of s as fit to b in UCS-2, and pads the rest of b with UCS-2
+// spaces, leaving the last byte of a field of an odd size 0, as Joliet volume descriptors have them
+func copyUCS2Padded(b []byte, s string) {
+	// each character takes 2 bytes, so copying to an even number of bytes copies whole ones
+	n := copy(b[:len(b)&^1], ucs2StringToBytes(s))
+	for ; n+1 < len(b); n += 2 {
+		b[n] = 0
+		b[n+1] = ' '
+	}
+	if n < len(b) {
+		b[n] = 0
+	}
+}
+
+// timeToDecBytes converts t to a volume descriptor date/time, which is not set if t is zero
 func timeToDecBytes(t time.Time) []byte {
+	if t.IsZero() {
+		return []byte{48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 48, 0}
+	}
 	year := strconv.Itoa(t.Year())
 	month := strconv.Itoa(int(t.Month()))
 	date := strconv.Itoa(t.Day())
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 	"time"
 
@@ -286,3 +287,87 @@ func TestPrimaryVolumeDescriptorType(t *testing.T) {
 		t.Errorf("Primary Volume Descriptor type was %v instead of expected %v", pvd.Type(), volumeDescriptorPrimary)
 	}
 }
+
+func TestParseJolietSupplementaryVolumeDescriptor(t *testing.T) {
+	b, err := os.ReadFile(volRecordsFile)
+	if err != nil {
+		t.Fatalf("error reading data from volrecords test fixture %s: %v", volRecordsFile, err)
+	}
+	// sector 2 is the Joliet supplementary volume descriptor
+	validBytes := b[2*2048 : 2*2048+volumeDescriptorSize]
+	svd, err := parseSupplementaryVolumeDescriptor(validBytes)
+	if err != nil {
+		t.Fatalf("error parsing supplementary volume descriptor: %v", err)
+	}
+	if !isJoliet(svd.escapeSequences) {
+		t.Errorf("escape sequences % x not recognized as Joliet", svd.escapeSequences)
+	}
+	if !bytes.Equal(svd.escapeSequences, jolietEscapeSequences[2]) {
+		t.Errorf("escape sequences % x, expected % x", svd.escapeSequences, jolietEscapeSequences[2])
+	}
+	if svd.volumeIdentifier != "Ubuntu-Server 18" {
+		t.Errorf("volume identifier %q, expected %q", svd.volumeIdentifier, "Ubuntu-Server 18")
+	}
+	out := svd.toBytes()
+	if !bytes.Equal(out[7:120], validBytes[7:120]) {
+		t.Errorf("Mismatched identifiers and escape sequences, actual vs expected\n% x\n% x", out[7:120], validBytes[7:120])
+	}
+}
+
+func TestJolietSupplementaryVolumeDescriptorIdentifiers(t *testing.T) {
+	// the longest identifiers that fit, and ones that are cut short to them on a whole character
+	long := func(n int) string {
+		return strings.Repeat("é", n-1) + "漢"
+	}
+	b, err := os.ReadFile(volRecordsFile)
+	if err != nil {
+		t.Fatalf("error reading data from volrecords test fixture %s: %v", volRecordsFile, err)
+	}
+	svd, err := parseSupplementaryVolumeDescriptor(b[2*2048 : 2*2048+volumeDescriptorSize])
+	if err != nil {
+		t.Fatalf("error parsing supplementary volume descriptor: %v", err)
+	}
+	for _, extra := range []int{0, 1} {
+		svd.systemIdentifier = long(16 + extra)
+		svd.volumeIdentifier = long(16 + extra)
+		svd.volumeSetIdentifier = long(64 + extra)
+		svd.publisherIdentifier = long(64 + extra)
+		svd.preparerIdentifier = long(64 + extra)
+		svd.applicationIdentifier = long(64 + extra)
+		svd.copyrightFile = long(18 + extra)
+		svd.abstractFile = long(18 + extra)
+		svd.bibliographicFile = long(18 + extra)
+		b := svd.toBytes()
+		if b[702+36] != 0 || b[739+36] != 0 || b[776+36] != 0 {
+			t.Errorf("last byte of the file identifiers is not 0")
+		}
+		parsed, err := parseSupplementaryVolumeDescriptor(b)
+		if err != nil {
+			t.Fatalf("error parsing supplementary volume descriptor: %v", err)
+		}
+		expected := map[int]string{16: long(16), 64: long(64), 18: long(18)}
+		if extra != 0 {
+			// the last character does not fit
+			expected = map[int]string{16: strings.Repeat("é", 16), 64: strings.Repeat("é", 64), 18: strings.Repeat("é", 18)}
+		}
+		for _, id := range []struct {
+			name   string
+			value  string
+			length int
+		}{
+			{"system identifier", parsed.systemIdentifier, 16},
+			{"volume identifier", parsed.volumeIdentifier, 16},
+			{"volume set identifier", parsed.volumeSetIdentifier, 64},
+			{"publisher identifier", parsed.publisherIdentifier, 64},
+			{"preparer identifier", parsed.preparerIdentifier, 64},
+			{"application identifier", parsed.applicationIdentifier, 64},
+			{"copyright file", parsed.copyrightFile, 18},
+			{"abstract file", parsed.abstractFile, 18},
+			{"bibliographic file", parsed.bibliographicFile, 18},
+		} {
+			if value := strings.TrimRight(id.value, " \x00"); value != expected[id.length] {
+				t.Errorf("%d extra: mismatched %s, actual %q expected %q", extra, id.name, value, expected[id.length])
+			}
+		}
+	}
+}
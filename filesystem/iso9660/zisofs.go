@@ -0,0 +1,300 @@
+package iso9660
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+/*
+	zisofs compresses each file on its own, in blocks that are compressed with zlib, so that any part of the file
+	can be read without decompressing all of it before. A compressed file begins with a header:
+	 - 8 bytes of magic
+	 - 4 bytes of the uncompressed size, little-endian
+	 - 1 byte of the size of the header in units of 4 bytes, i.e. 4
+	 - 1 byte of the log2 of the size of the blocks
+	 - 2 reserved bytes
+	followed by one more 4-byte little-endian pointer than there are blocks, each the offset in the file of where
+	a block begins, with the last one where the last block ends. A block of length 0 is all zeroes.
+
+	The file is marked as compressed by a Rock Ridge ZF entry, which repeats the uncompressed size, so that it can
+	be listed without reading the file.
+*/
+
+const (
+	rockRidgeSignatureZisofs = "ZF"
+	zisofsAlgorithm          = "pz"
+	zisofsHeaderSize         = 16
+	// zisofsDefaultBlockLog is the log2 of the size of the blocks that we compress, i.e. 32 KB, as mkzftree does
+	zisofsDefaultBlockLog = 15
+	// zisofsMaxSize files of this size or larger cannot be compressed, as their size would not fit in the header
+	zisofsMaxSize = math.MaxUint32
+)
+
+var zisofsMagic = []byte{0x37, 0xe4, 0x53, 0x96, 0xc9, 0xdb, 0xd6, 0x07}
+
+// rockRidgeZisofs is the ZF entry of a file that is compressed with zisofs
+type rockRidgeZisofs struct {
+	algorithm  string
+	headerSize uint8 // in units of 4 bytes
+	blockLog   uint8
+	size       uint32 // uncompressed size
+}
+
+func (d rockRidgeZisofs) Equal(o directoryEntrySystemUseExtension) bool {
+	t, ok := o.(rockRidgeZisofs)
+	return ok && t == d
+}
+func (d rockRidgeZisofs) Signature() string {
+	return rockRidgeSignatureZisofs
+}
+func (d rockRidgeZisofs) Length() int {
+	return 16
+}
+func (d rockRidgeZisofs) Version() uint8 {
+	return 1
+}
+func (d rockRidgeZisofs) Data() []byte {
+	ret := make([]byte, 12)
+	copy(ret[0:2], d.algorithm)
+	ret[2] = d.headerSize
+	ret[3] = d.blockLog
+	binary.LittleEndian.PutUint32(ret[4:8], d.size)
+	binary.BigEndian.PutUint32(ret[8:12], d.size)
+	return ret
+}
+func (d rockRidgeZisofs) Bytes() []byte {
+	ret := make([]byte, 4)
+	copy(ret[0:2], rockRidgeSignatureZisofs)
+	ret[2] = uint8(d.Length())
+	ret[3] = d.Version()
+	ret = append(ret, d.Data()...)
+	return ret
+}
+func (d rockRidgeZisofs) Continuable() bool {
+	return false
+}
+func (d rockRidgeZisofs) Merge([]directoryEntrySystemUseExtension) directoryEntrySystemUseExtension {
+	return nil
+}
+
+func (r *rockRidgeExtension) parseZisofs(b []byte) (directoryEntrySystemUseExtension, error) {
+	targetSize := 16
+	if len(b) != targetSize {
+		//nolint:staticcheck // "Rock Ridge" is a proper noun
+		return nil, fmt.Errorf("Rock Ridge ZF extension must be %d bytes, but received %d", targetSize, len(b))
+	}
+	size := b[2]
+	if size != uint8(targetSize) {
+		//nolint:staticcheck // "Rock Ridge" is a proper noun
+		return nil, fmt.Errorf("Rock Ridge ZF extension must be %d bytes, but byte 2 indicated %d", targetSize, size)
+	}
+	version := b[3]
+	if version != 1 {
+		//nolint:staticcheck // "Rock Ridge" is a proper noun
+		return nil, fmt.Errorf("Rock Ridge ZF extension must be version 1, was %d", version)
+	}
+	return rockRidgeZisofs{
+		algorithm:  string(b[4:6]),
+		headerSize: b[6],
+		blockLog:   b[7],
+		size:       binary.LittleEndian.Uint32(b[8:12]),
+	}, nil
+}
+
+// zisofsFile is a file as it is compressed with zisofs
+type zisofsFile struct {
+	size     int64 // uncompressed size
+	blockLog uint8
+	// pointers where each block begins in the compressed file, and where the last one ends; nil for a file of a
+	// previous session, which already is compressed and written
+	pointers []uint32
+}
+
+// newZisofsFile compresses the size bytes of r to find out how big each block of it is when compressed. Nothing
+// of what is compressed is kept, other than its size, so that compressing it again as it is written takes no
+// memory or space other than for a block at a time.
+func newZisofsFile(r io.Reader, size int64, blockLog uint8) (*zisofsFile, error) {
+	if size >= zisofsMaxSize {
+		return nil, fmt.Errorf("file of %d bytes is too large for zisofs", size)
+	}
+	blocks := (size + 1<<blockLog - 1) >> blockLog
+	z := &zisofsFile{
+		size:     size,
+		blockLog: blockLog,
+		pointers: make([]uint32, 0, blocks+1),
+	}
+	offset := int64(zisofsHeaderSize + 4*(blocks+1))
+	z.pointers = append(z.pointers, uint32(offset))
+	err := z.compress(r, func(_ int64, b []byte) error {
+		offset += int64(len(b))
+		if offset >= zisofsMaxSize {
+			return fmt.Errorf("compressed file is too large for zisofs")
+		}
+		z.pointers = append(z.pointers, uint32(offset))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return z, nil
+}
+
+// compressedSize the size of the file when it is compressed, including its header
+func (z *zisofsFile) compressedSize() int64 {
+	return int64(z.pointers[len(z.pointers)-1])
+}
+
+// extension the ZF entry for the file
+func (z *zisofsFile) extension() rockRidgeZisofs {
+	return rockRidgeZisofs{
+		algorithm:  zisofsAlgorithm,
+		headerSize: zisofsHeaderSize / 4,
+		blockLog:   z.blockLog,
+		size:       uint32(z.size),
+	}
+}
+
+// compress reads the uncompressed contents of the file from r, and calls f with each block as it is compressed.
+// A block of zeroes is not compressed at all, and is passed as an empty slice.
+func (z *zisofsFile) compress(r io.Reader, f func(block int64, b []byte) error) error {
+	var (
+		blocksize = int64(1) << z.blockLog
+		in        = make([]byte, blocksize)
+		out       bytes.Buffer
+		zw        = zlib.NewWriter(&out)
+	)
+	for block := int64(0); block*blocksize < z.size; block++ {
+		n := minInt64(blocksize, z.size-block*blocksize)
+		if _, err := io.ReadFull(r, in[:n]); err != nil {
+			return fmt.Errorf("could not read block %d: %v", block, err)
+		}
+		out.Reset()
+		if !isZeroes(in[:n]) {
+			zw.Reset(&out)
+			if _, err := zw.Write(in[:n]); err != nil {
+				return fmt.Errorf("could not compress block %d: %v", block, err)
+			}
+			if err := zw.Close(); err != nil {
+				return fmt.Errorf("could not compress block %d: %v", block, err)
+			}
+		}
+		if err := f(block, out.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeTo compresses the contents of the file from r again, and writes it with its header to w. Returns the
+// number of bytes written.
+func (z *zisofsFile) writeTo(w io.Writer, r io.Reader) (int64, error) {
+	header := make([]byte, zisofsHeaderSize+4*len(z.pointers))
+	copy(header, zisofsMagic)
+	binary.LittleEndian.PutUint32(header[8:12], uint32(z.size))
+	header[12] = zisofsHeaderSize / 4
+	header[13] = z.blockLog
+	for i, p := range z.pointers {
+		binary.LittleEndian.PutUint32(header[zisofsHeaderSize+4*i:], p)
+	}
+	n, err := w.Write(header)
+	written := int64(n)
+	if err != nil {
+		return written, err
+	}
+	err = z.compress(r, func(block int64, b []byte) error {
+		if expected := z.pointers[block+1] - z.pointers[block]; uint32(len(b)) != expected {
+			return fmt.Errorf("block %d compressed to %d bytes instead of %d as before", block, len(b), expected)
+		}
+		n, err := w.Write(b)
+		written += int64(n)
+		return err
+	})
+	return written, err
+}
+
+// isZeroes whether b is all zeroes
+func isZeroes(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// zisofs returns the ZF entry of the file, if it is compressed with zisofs
+func (de *directoryEntry) zisofs() (rockRidgeZisofs, bool) {
+	for _, ext := range de.extensions {
+		if zf, ok := ext.(rockRidgeZisofs); ok && zf.algorithm == zisofsAlgorithm {
+			return zf, true
+		}
+	}
+	return rockRidgeZisofs{}, false
+}
+
+// readZisofs reads from the file at its offset, decompressing the blocks that it reads from
+func (fl *File) readZisofs(b []byte, zf rockRidgeZisofs) (int, error) {
+	size := int64(zf.size)
+	read := 0
+	for read < len(b) && fl.offset < size {
+		block := fl.offset >> zf.blockLog
+		data, err := fl.zisofsBlock(zf, block)
+		if err != nil {
+			return read, err
+		}
+		n := copy(b[read:], data[fl.offset-block<<zf.blockLog:])
+		read += n
+		fl.offset += int64(n)
+	}
+	if fl.offset >= size {
+		return read, io.EOF
+	}
+	return read, nil
+}
+
+// zisofsBlock returns the given block of the file, decompressed. It keeps the last block that it decompressed,
+// as reads usually are of less than a block.
+func (fl *File) zisofsBlock(zf rockRidgeZisofs, block int64) ([]byte, error) {
+	if fl.zisofsData != nil && fl.zisofsIndex == block {
+		return fl.zisofsData, nil
+	}
+	if zf.blockLog < 15 || zf.blockLog > 17 {
+		return nil, fmt.Errorf("unsupported zisofs block size of 2^%d", zf.blockLog)
+	}
+	blocksize := int64(1) << zf.blockLog
+	length := minInt64(blocksize, int64(zf.size)-block*blocksize)
+
+	// where the block begins and ends in the compressed file
+	pointers := make([]byte, 8)
+	if _, err := fl.readStored(pointers, int64(zf.headerSize)*4+block*4); err != nil {
+		return nil, fmt.Errorf("could not read zisofs block pointers: %v", err)
+	}
+	start := binary.LittleEndian.Uint32(pointers[0:4])
+	end := binary.LittleEndian.Uint32(pointers[4:8])
+	if end < start || int64(end) > fl.storedSize() {
+		return nil, fmt.Errorf("invalid zisofs block %d, from %d to %d", block, start, end)
+	}
+
+	data := make([]byte, length)
+	// an empty block is all zeroes
+	if end > start {
+		compressed := make([]byte, end-start)
+		if _, err := fl.readStored(compressed, int64(start)); err != nil {
+			return nil, fmt.Errorf("could not read zisofs block %d: %v", block, err)
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			return nil, fmt.Errorf("could not decompress zisofs block %d: %v", block, err)
+		}
+		if _, err := io.ReadFull(zr, data); err != nil {
+			return nil, fmt.Errorf("could not decompress zisofs block %d: %v", block, err)
+		}
+	}
+	fl.zisofsData = data
+	fl.zisofsIndex = block
+	return data, nil
+}
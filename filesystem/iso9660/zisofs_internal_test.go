@@ -0,0 +1,91 @@
+package iso9660
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestZisofsFile(t *testing.T) {
+	// a compressible block, a block of zeroes, and a partial block
+	data := []byte(strings.Repeat("zisofs ", 1<<15/7+1))[:1<<15]
+	data = append(data, make([]byte, 1<<15)...)
+	data = append(data, []byte("the end")...)
+
+	z, err := newZisofsFile(bytes.NewReader(data), int64(len(data)), zisofsDefaultBlockLog)
+	if err != nil {
+		t.Fatalf("unexpected error compressing: %v", err)
+	}
+	if len(z.pointers) != 4 {
+		t.Fatalf("%d pointers instead of 4", len(z.pointers))
+	}
+	if z.pointers[2] != z.pointers[1] {
+		t.Errorf("block of zeroes is %d bytes instead of empty", z.pointers[2]-z.pointers[1])
+	}
+	var buf bytes.Buffer
+	n, err := z.writeTo(&buf, bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error writing: %v", err)
+	}
+	if n != z.compressedSize() || int64(buf.Len()) != n {
+		t.Fatalf("wrote %d bytes, buffer has %d, expected %d", n, buf.Len(), z.compressedSize())
+	}
+
+	b := buf.Bytes()
+	if !bytes.Equal(b[:8], zisofsMagic) {
+		t.Errorf("mismatched magic % x", b[:8])
+	}
+	if size := binary.LittleEndian.Uint32(b[8:12]); size != uint32(len(data)) {
+		t.Errorf("header has size %d instead of %d", size, len(data))
+	}
+	if b[12] != 4 || b[13] != zisofsDefaultBlockLog {
+		t.Errorf("header has header size %d and block size 2^%d", b[12], b[13])
+	}
+	var decompressed []byte
+	for i := 0; i < 3; i++ {
+		start := binary.LittleEndian.Uint32(b[16+4*i:])
+		end := binary.LittleEndian.Uint32(b[16+4*i+4:])
+		if start == end {
+			decompressed = append(decompressed, make([]byte, 1<<15)...)
+			continue
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(b[start:end]))
+		if err != nil {
+			t.Fatalf("block %d: unexpected error decompressing: %v", i, err)
+		}
+		block, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatalf("block %d: unexpected error decompressing: %v", i, err)
+		}
+		decompressed = append(decompressed, block...)
+	}
+	if !bytes.Equal(decompressed, data) {
+		t.Errorf("mismatched decompressed data")
+	}
+
+	t.Run("changed contents", func(t *testing.T) {
+		changed := append([]byte{}, data...)
+		copy(changed, "something else entirely")
+		if _, err := z.writeTo(io.Discard, bytes.NewReader(changed)); err == nil {
+			t.Errorf("unexpected nil error")
+		}
+	})
+}
+
+func TestRockRidgeZisofs(t *testing.T) {
+	zf := rockRidgeZisofs{algorithm: zisofsAlgorithm, headerSize: 4, blockLog: 15, size: 123456}
+	b := zf.Bytes()
+	if len(b) != zf.Length() {
+		t.Fatalf("%d bytes instead of %d", len(b), zf.Length())
+	}
+	parsed, err := getRockRidgeExtension(rockRidge112).parseZisofs(b)
+	if err != nil {
+		t.Fatalf("unexpected error parsing: %v", err)
+	}
+	if !parsed.Equal(zf) {
+		t.Errorf("mismatched ZF, actual %+v expected %+v", parsed, zf)
+	}
+}
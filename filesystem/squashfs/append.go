@@ -0,0 +1,312 @@
+package squashfs
+
+import (
+	"fmt"
+	"io"
+	iofs "io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// Append opens the squashfs filesystem in b to add files to it, and replace files in it, as mksquashfs does when it
+// appends to an existing image. The arguments are those of Read.
+//
+// What is made in the workspace, with Mkdir and OpenFile, or copied to it with AddFS, goes in the filesystem when
+// it is finalized. It replaces whatever is at the same path, other than a directory that is in both, whose contents
+// are merged, and which keeps its properties in the filesystem. The data and fragment blocks of the files that
+// are kept are not read or compressed again, but stay where they are, and those that are hard links to each other
+// stay so. The data and fragments of the new files go after them, in place of the old inode, directory, fragment,
+// export and id tables, with new tables after that.
+//
+// Until it is finalized, ReadDir and OpenFile only see the workspace. The blocksize is that of the filesystem, and
+// the compression, unless FinalizeOptions give one of the same kind, is that of the filesystem, with its options.
+func Append(b backend.Storage, size, start, blocksize int64) (*FileSystem, error) {
+	fs, err := Read(b, size, start, blocksize)
+	if err != nil {
+		return nil, err
+	}
+	if fs.superblock.compressorOptions && fs.compressor != nil {
+		options, _, err := fs.readMetaBlock(b, fs.compressor, superblockSize)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read compressor options: %v", err)
+		}
+		if err := fs.compressor.loadOptions(options); err != nil {
+			return nil, fmt.Errorf("unable to load compressor options: %v", err)
+		}
+	}
+
+	// create a temporary working area for what is added, as Create does
+	tmpdir, err := os.MkdirTemp("", "diskfs_squashfs")
+	if err != nil {
+		return nil, fmt.Errorf("could not create working directory: %v", err)
+	}
+	fs.workspace = tmpdir
+	fs.appending = true
+	return fs, nil
+}
+
+// AddFS copies all of the directories and regular files in fsys to the workspace, with their permissions and
+// modification times, replacing any already there
+func (fs *FileSystem) AddFS(fsys iofs.FS) error {
+	if fs.workspace == "" {
+		return filesystem.ErrReadonlyFilesystem
+	}
+	// the times of directories are set after their contents are copied, which changes them
+	var dirs []string
+	dirTimes := map[string]iofs.FileInfo{}
+	err := iofs.WalkDir(fsys, ".", func(p string, d iofs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("could not get file info for %s: %v", p, err)
+		}
+		target := filepath.Join(fs.workspace, filepath.FromSlash(p))
+		switch {
+		case d.IsDir():
+			if err := os.MkdirAll(target, 0o755); err != nil {
+				return fmt.Errorf("could not create directory %s: %v", p, err)
+			}
+			dirs = append(dirs, target)
+			dirTimes[target] = fi
+			return os.Chmod(target, fi.Mode().Perm())
+		case d.Type().IsRegular():
+			if err := copyFromFS(fsys, p, target, fi.Mode().Perm()); err != nil {
+				return fmt.Errorf("could not copy %s: %v", p, err)
+			}
+			return os.Chtimes(target, fi.ModTime(), fi.ModTime())
+		default:
+			return fmt.Errorf("%s is neither a regular file nor a directory, which cannot be added", p)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		fi := dirTimes[dirs[i]]
+		if err := os.Chtimes(dirs[i], fi.ModTime(), fi.ModTime()); err != nil {
+			return fmt.Errorf("could not set times of directory %s: %v", fi.Name(), err)
+		}
+	}
+	return nil
+}
+
+// copyFromFS copies the file p in fsys to target on the host, with the permissions perm
+func copyFromFS(fsys iofs.FS, p, target string, perm os.FileMode) error {
+	in, err := fsys.Open(p)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, perm)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+	return out.Chmod(perm)
+}
+
+// appendTree merges the tree of the workspace, whose root is the first of fileList, as walkTree returns it, into
+// that of the filesystem, and returns all of them in the same order
+func (fs *FileSystem) appendTree(fileList []*finalizeFileInfo) ([]*finalizeFileInfo, error) {
+	root, err := fs.readTree()
+	if err != nil {
+		return nil, fmt.Errorf("error reading existing tree: %v", err)
+	}
+	mergeTree(root, fileList[0])
+	list := flattenTree(root)
+	linkExisting(list)
+	return list, nil
+}
+
+// readTree reads the tree of the filesystem, with where the data and fragment of each file already are
+func (fs *FileSystem) readTree() (*finalizeFileInfo, error) {
+	header := fs.rootDir.getHeader()
+	xattrs := map[string]string{}
+	if xattrIndex, has := fs.rootDir.getBody().xattrIndex(); has {
+		var err error
+		if xattrs, err = fs.xattrs.find(int(xattrIndex)); err != nil {
+			return nil, fmt.Errorf("error reading xattrs for root directory: %v", err)
+		}
+	}
+	root := &finalizeFileInfo{
+		path:     ".",
+		name:     ".",
+		isDir:    true,
+		isRoot:   true,
+		modTime:  header.modTime,
+		mode:     os.ModeDir | header.mode&os.ModePerm,
+		fileType: fileDirectory,
+		xattrs:   xattrs,
+		uid:      fs.uidsGids[header.uidIdx],
+		gid:      fs.uidsGids[header.gidIdx],
+		existing: true,
+	}
+	if err := fs.readTreeChildren(root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// readTreeChildren reads the children of the directory dir in the filesystem, and theirs
+func (fs *FileSystem) readTreeChildren(dir *finalizeFileInfo) error {
+	entries, err := fs.readDirectory(path.Join("/", dir.path))
+	if err != nil {
+		return err
+	}
+	dir.children = make([]*finalizeFileInfo, 0, len(entries))
+	for _, de := range entries {
+		e, err := existingFileInfo(path.Join(dir.path, de.Name()), de)
+		if err != nil {
+			return err
+		}
+		if e.isDir {
+			if err := fs.readTreeChildren(e); err != nil {
+				return err
+			}
+		}
+		dir.children = append(dir.children, e)
+	}
+	return nil
+}
+
+// existingFileInfo returns the finalizeFileInfo of the entry de, at p, in the filesystem
+func existingFileInfo(p string, de *directoryEntry) (*finalizeFileInfo, error) {
+	e := &finalizeFileInfo{
+		path:          p,
+		name:          de.Name(),
+		isDir:         de.IsDir(),
+		modTime:       de.ModTime(),
+		mode:          de.Mode(),
+		size:          de.Size(),
+		xattrs:        de.Xattrs(),
+		uid:           de.UID(),
+		gid:           de.GID(),
+		existing:      true,
+		existingIndex: de.inode.index(),
+	}
+	switch body := de.inode.getBody().(type) {
+	case *basicDirectory:
+		e.fileType, e.links = fileDirectory, body.links
+	case *extendedDirectory:
+		e.fileType, e.links = fileDirectory, body.links
+	case *basicFile:
+		ef := body.toExtended()
+		e.setData(&ef)
+	case *extendedFile:
+		e.setData(body)
+	case *basicSymlink:
+		e.fileType, e.links, e.target = fileSymlink, body.links, body.target
+	case *extendedSymlink:
+		e.fileType, e.links, e.target = fileSymlink, body.links, body.target
+	case *basicDevice:
+		e.links, e.major, e.minor = body.links, body.major, body.minor
+		e.fileType = fileBlock
+		if de.inode.inodeType() == inodeBasicChar {
+			e.fileType = fileChar
+		}
+	case *extendedDevice:
+		e.links, e.major, e.minor = body.links, body.major, body.minor
+		e.fileType = fileBlock
+		if de.inode.inodeType() == inodeExtendedChar {
+			e.fileType = fileChar
+		}
+	case *basicIPC:
+		e.links = body.links
+		e.fileType = fileFifo
+		if de.inode.inodeType() == inodeBasicSocket {
+			e.fileType = fileSocket
+		}
+	case *extendedIPC:
+		e.links = body.links
+		e.fileType = fileFifo
+		if de.inode.inodeType() == inodeExtendedSocket {
+			e.fileType = fileSocket
+		}
+	default:
+		return nil, fmt.Errorf("unsupported inode of type %d at %s", de.inode.inodeType(), p)
+	}
+	return e, nil
+}
+
+// setData sets where the data and fragment of a regular file already are, from its inode
+func (fi *finalizeFileInfo) setData(ef *extendedFile) {
+	fi.fileType = fileRegular
+	fi.links = ef.links
+	fi.dataLocation = int64(ef.blocksStart)
+	fi.blocks = ef.blockSizes
+	if ef.fragmentBlockIndex != 0xffffffff {
+		fi.fragment = &fragmentRef{
+			block:  ef.fragmentBlockIndex,
+			offset: ef.fragmentOffset,
+		}
+	}
+}
+
+// linkExisting makes the existing files in fileList that are the same inode in the filesystem hard links to the
+// first of them, and counts their links again, as those that were replaced are no longer there. The hard links of
+// the workspace are as walkTree found them, which is in the same order.
+func linkExisting(fileList []*finalizeFileInfo) {
+	first := map[uint32]*finalizeFileInfo{}
+	for _, e := range fileList {
+		if !e.existing || e.isDir {
+			continue
+		}
+		e.links = 1
+		if f, ok := first[e.existingIndex]; ok {
+			e.hardLink = f
+		} else {
+			first[e.existingIndex] = e
+		}
+	}
+	countHardLinks(fileList)
+}
+
+// mergeTree merges the children of the directory from, in the workspace, into those of the directory to, in the
+// filesystem, replacing those with the same name, other than directories in both, which are merged in turn
+func mergeTree(to, from *finalizeFileInfo) {
+	index := make(map[string]int, len(to.children))
+	for i, c := range to.children {
+		index[c.name] = i
+	}
+	for _, c := range from.children {
+		i, ok := index[c.name]
+		switch {
+		case !ok:
+			index[c.name] = len(to.children)
+			to.children = append(to.children, c)
+		case c.isDir && to.children[i].isDir:
+			mergeTree(to.children[i], c)
+		default:
+			to.children[i] = c
+		}
+	}
+	sort.Slice(to.children, func(i, j int) bool {
+		return to.children[i].name < to.children[j].name
+	})
+}
+
+// flattenTree returns the directory e, and everything under it, in the order that walkTree does, with the links
+// of each directory counted from its subdirectories
+func flattenTree(e *finalizeFileInfo) []*finalizeFileInfo {
+	list := []*finalizeFileInfo{e}
+	e.links = 2
+	for _, c := range e.children {
+		if c.isDir {
+			e.links++
+			list = append(list, flattenTree(c)...)
+		} else {
+			list = append(list, c)
+		}
+	}
+	return list
+}
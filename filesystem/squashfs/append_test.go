@@ -0,0 +1,239 @@
+package squashfs_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem/squashfs"
+)
+
+// writeSquashfsFiles writes the files to fs, making the directories they are in
+func writeSquashfsFiles(t *testing.T, fs *squashfs.FileSystem, files map[string][]byte) {
+	t.Helper()
+	for p, contents := range files {
+		if err := fs.Mkdir(p[:bytes.LastIndexByte([]byte(p), '/')+1]); err != nil {
+			t.Fatalf("Failed to squashfs.Mkdir for %s: %v", p, err)
+		}
+		sqsfile, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("Failed to squashfs.OpenFile(%s): %v", p, err)
+		}
+		if _, err := sqsfile.Write(contents); err != nil {
+			t.Fatalf("error writing to %s: %v", p, err)
+		}
+	}
+}
+
+func TestAppend(t *testing.T) {
+	blocksize := int64(4096)
+	f, err := os.CreateTemp("", "squashfs_append_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	b := file.New(f, false)
+
+	base := map[string][]byte{
+		"/README.MD":        []byte("readme\n"),
+		"/etc/hostname":     []byte("base\n"),
+		"/etc/large":        bytes.Repeat([]byte("large file in the base image\n"), 1000),
+		"/usr/bin/tool":     []byte(testRandomString(3 * int(blocksize))),
+		"/usr/share/README": []byte("share\n"),
+	}
+	fs, err := squashfs.Create(b, 0, 0, blocksize)
+	if err != nil {
+		t.Fatalf("Failed to squashfs.Create: %v", err)
+	}
+	writeSquashfsFiles(t, fs, base)
+	if err := fs.Finalize(squashfs.FinalizeOptions{Compression: &squashfs.CompressorGzip{CompressionLevel: 6}}); err != nil {
+		t.Fatalf("unexpected error fs.Finalize(): %v", err)
+	}
+	original, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("error reading image: %v", err)
+	}
+	// the inode table starts after the data and fragments
+	dataEnd := binary.LittleEndian.Uint64(original[64:72])
+
+	fs, err = squashfs.Append(b, 0, 0, blocksize)
+	if err != nil {
+		t.Fatalf("Failed to squashfs.Append: %v", err)
+	}
+	overlay := map[string][]byte{
+		"/etc/hostname":   []byte("overlay\n"),
+		"/etc/new.conf":   []byte("new\n"),
+		"/opt/app/binary": []byte(testRandomString(2*int(blocksize) + 100)),
+	}
+	writeSquashfsFiles(t, fs, overlay)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	fsys := fstest.MapFS{
+		"usr/share/README":  {Data: []byte("replaced share\n"), Mode: 0o600, ModTime: modTime},
+		"usr/share/doc/new": {Data: []byte("doc\n"), Mode: 0o644, ModTime: modTime},
+	}
+	if err := fs.AddFS(fsys); err != nil {
+		t.Fatalf("unexpected error fs.AddFS(): %v", err)
+	}
+	if err := fs.Finalize(squashfs.FinalizeOptions{}); err != nil {
+		t.Fatalf("unexpected error fs.Finalize() appending: %v", err)
+	}
+
+	appended, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatalf("error reading appended image: %v", err)
+	}
+	if !bytes.Equal(appended[96:dataEnd], original[96:dataEnd]) {
+		t.Errorf("data and fragments of the base image changed in appending to it")
+	}
+
+	expected := map[string][]byte{}
+	for p, contents := range base {
+		expected[p] = contents
+	}
+	for p, contents := range overlay {
+		expected[p] = contents
+	}
+	for p, file := range fsys {
+		expected["/"+p] = file.Data
+	}
+	fs, err = squashfs.Read(b, 0, 0, blocksize)
+	if err != nil {
+		t.Fatalf("error reading the appended image as squashfs: %v", err)
+	}
+	for p, contents := range expected {
+		sqsfile, err := fs.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			t.Errorf("error opening file %s: %v", p, err)
+			continue
+		}
+		actual, err := io.ReadAll(sqsfile)
+		if err != nil {
+			t.Errorf("error reading file %s: %v", p, err)
+		}
+		if !bytes.Equal(actual, contents) {
+			t.Errorf("mismatched contents of %s, actual %d bytes expected %d", p, len(actual), len(contents))
+		}
+	}
+	entries, err := fs.ReadDir("/usr/share")
+	if err != nil {
+		t.Fatalf("error reading directory /usr/share: %v", err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+		if e.Name() == "README" && (e.Mode().Perm() != 0o600 || !e.ModTime().Equal(modTime)) {
+			t.Errorf("mismatched mode %v or time %v of replaced file", e.Mode(), e.ModTime())
+		}
+	}
+	if len(names) != 2 || names[0] != "README" || names[1] != "doc" {
+		t.Errorf("mismatched entries of /usr/share %v", names)
+	}
+
+	t.Run("mismatched compression", func(t *testing.T) {
+		fs, err := squashfs.Append(b, 0, 0, blocksize)
+		if err != nil {
+			t.Fatalf("Failed to squashfs.Append: %v", err)
+		}
+		defer fs.Close()
+		if err := fs.Finalize(squashfs.FinalizeOptions{Compression: &squashfs.CompressorXz{}}); err == nil {
+			t.Errorf("unexpected nil error appending with other compression")
+		}
+	})
+}
+
+func TestAppendHardLinks(t *testing.T) {
+	blocksize := int64(4096)
+	f, err := os.CreateTemp("", "squashfs_append_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	b := file.New(f, false)
+	// inodes returns the number of inodes in the superblock of the image
+	inodes := func() uint32 {
+		sb := make([]byte, 8)
+		if _, err := f.ReadAt(sb, 0); err != nil {
+			t.Fatalf("error reading superblock: %v", err)
+		}
+		return binary.LittleEndian.Uint32(sb[4:8])
+	}
+
+	linked := []byte(testRandomString(int(blocksize) + 100))
+	fs, err := squashfs.Create(b, 0, 0, blocksize)
+	if err != nil {
+		t.Fatalf("Failed to squashfs.Create: %v", err)
+	}
+	writeSquashfsFiles(t, fs, map[string][]byte{"/a/file": linked, "/other": []byte("other\n")})
+	for _, p := range []string{"b/link", "c/third"} {
+		if err := os.MkdirAll(filepath.Join(fs.Workspace(), filepath.Dir(p)), 0o755); err != nil {
+			t.Fatalf("error making directory for %s: %v", p, err)
+		}
+		if err := os.Link(filepath.Join(fs.Workspace(), "a", "file"), filepath.Join(fs.Workspace(), p)); err != nil {
+			t.Fatalf("error linking %s: %v", p, err)
+		}
+	}
+	if err := fs.Finalize(squashfs.FinalizeOptions{Compression: &squashfs.CompressorGzip{CompressionLevel: 6}}); err != nil {
+		t.Fatalf("unexpected error fs.Finalize(): %v", err)
+	}
+	// the root, a, b and c, the linked file, and other
+	if n := inodes(); n != 6 {
+		t.Errorf("mismatched inodes of image with hard links, actual %d expected %d", n, 6)
+	}
+
+	fs, err = squashfs.Append(b, 0, 0, blocksize)
+	if err != nil {
+		t.Fatalf("Failed to squashfs.Append: %v", err)
+	}
+	overlay := map[string][]byte{
+		"/c/third": []byte("replaced\n"),
+		"/new":     []byte("new\n"),
+	}
+	writeSquashfsFiles(t, fs, overlay)
+	if err := fs.Finalize(squashfs.FinalizeOptions{}); err != nil {
+		t.Fatalf("unexpected error fs.Finalize() appending: %v", err)
+	}
+	// the links to the file that are left are still one inode, and the replaced one and new one are two more
+	if n := inodes(); n != 8 {
+		t.Errorf("mismatched inodes of appended image, actual %d expected %d", n, 8)
+	}
+
+	expected := map[string][]byte{
+		"/a/file": linked,
+		"/b/link": linked,
+		"/other":  []byte("other\n"),
+	}
+	for p, contents := range overlay {
+		expected[p] = contents
+	}
+	fs, err = squashfs.Read(b, 0, 0, blocksize)
+	if err != nil {
+		t.Fatalf("error reading the appended image as squashfs: %v", err)
+	}
+	for p, contents := range expected {
+		sqsfile, err := fs.OpenFile(p, os.O_RDONLY)
+		if err != nil {
+			t.Errorf("error opening file %s: %v", p, err)
+			continue
+		}
+		actual, err := io.ReadAll(sqsfile)
+		sqsfile.Close()
+		if err != nil {
+			t.Errorf("error reading file %s: %v", p, err)
+		}
+		if !bytes.Equal(actual, contents) {
+			t.Errorf("mismatched contents of %s, actual %d bytes expected %d", p, len(actual), len(contents))
+		}
+	}
+}
@@ -359,6 +359,110 @@ func (c *CompressorZstd) decompress(in []byte) ([]byte, error) {
 	return p, nil
 }
 
+// LzoAlgorithm the LZO algorithm that a squashfs is compressed with
+type LzoAlgorithm uint32
+
+// lzo algorithms, all of which compress to LZO1X
+const (
+	LzoAlgorithmLzo1x1    LzoAlgorithm = 0
+	LzoAlgorithmLzo1x1_11 LzoAlgorithm = 1
+	LzoAlgorithmLzo1x1_12 LzoAlgorithm = 2
+	LzoAlgorithmLzo1x1_15 LzoAlgorithm = 3
+	LzoAlgorithmLzo1x999  LzoAlgorithm = 4
+)
+
+const (
+	lzoMinLevel     uint32 = 1
+	lzoMaxLevel     uint32 = 9
+	lzoDefaultLevel uint32 = 8
+)
+
+// lzo1x1HashBits is the size of the dictionary of each of the lzo1x_1 algorithms
+var lzo1x1HashBits = map[LzoAlgorithm]int{
+	LzoAlgorithmLzo1x1:    lzoHashBits,
+	LzoAlgorithmLzo1x1_11: 11,
+	LzoAlgorithmLzo1x1_12: 12,
+	LzoAlgorithmLzo1x1_15: 15,
+}
+
+// CompressorLzo lzo compression. Data is compressed to LZO1X by a compressor of our own, which does not make the
+// same bytes as liblzo does, but searches for matches as Algorithm does: the lzo1x_1 ones take the match at the last
+// position with the same hash, in a dictionary of their size, and LzoAlgorithmLzo1x999 looks for the longest match,
+// the further the higher CompressionLevel is, from 1 to 9, or 8 if it is 0. The CompressionLevel of any Algorithm
+// other than LzoAlgorithmLzo1x999 is 0.
+type CompressorLzo struct {
+	Algorithm        LzoAlgorithm
+	CompressionLevel uint32
+}
+
+// level returns the compression level that is used, which is the default for LzoAlgorithmLzo1x999 if it is 0
+func (c *CompressorLzo) level() uint32 {
+	if c.Algorithm == LzoAlgorithmLzo1x999 && c.CompressionLevel == 0 {
+		return lzoDefaultLevel
+	}
+	return c.CompressionLevel
+}
+
+// search returns how to search for matches for Algorithm at its compression level
+func (c *CompressorLzo) search() (lzoSearch, error) {
+	level := c.level()
+	switch {
+	case c.Algorithm > LzoAlgorithmLzo1x999:
+		return lzoSearch{}, fmt.Errorf("unknown lzo algorithm %d", c.Algorithm)
+	case c.Algorithm == LzoAlgorithmLzo1x999 && (level < lzoMinLevel || level > lzoMaxLevel):
+		return lzoSearch{}, fmt.Errorf("lzo compression level requested %d, must be at least %d and not more than %d", level, lzoMinLevel, lzoMaxLevel)
+	case c.Algorithm != LzoAlgorithmLzo1x999 && level != 0:
+		return lzoSearch{}, fmt.Errorf("lzo compression level %d given for algorithm %d, which only has level 0", level, c.Algorithm)
+	case c.Algorithm == LzoAlgorithmLzo1x999:
+		return lzoSearch{hashBits: lzoHashBits, chainLength: lzoChainLengths[level], lazy: level > 1}, nil
+	default:
+		return lzoSearch{hashBits: lzo1x1HashBits[c.Algorithm], chainLength: 1}, nil
+	}
+}
+
+func (c *CompressorLzo) compress(in []byte) ([]byte, error) {
+	search, err := c.search()
+	if err != nil {
+		return nil, err
+	}
+	return lzo1xCompress(in, search), nil
+}
+func (c *CompressorLzo) decompress(in []byte) ([]byte, error) {
+	p, err := lzo1xDecompress(in)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing lzo: %w", err)
+	}
+	return p, nil
+}
+func (c *CompressorLzo) loadOptions(b []byte) error {
+	expected := 8
+	if len(b) != expected {
+		return fmt.Errorf("cannot parse lzo options, received %d bytes expected %d", len(b), expected)
+	}
+	loaded := CompressorLzo{
+		Algorithm:        LzoAlgorithm(binary.LittleEndian.Uint32(b[0:4])),
+		CompressionLevel: binary.LittleEndian.Uint32(b[4:8]),
+	}
+	// a level of 0 is the default only when compressing, and is not one that can be recorded
+	if loaded.Algorithm == LzoAlgorithmLzo1x999 && loaded.CompressionLevel == 0 {
+		return fmt.Errorf("lzo compression level requested 0, must be at least %d and not more than %d", lzoMinLevel, lzoMaxLevel)
+	}
+	if _, err := loaded.search(); err != nil {
+		return err
+	}
+	*c = loaded
+	return nil
+}
+func (c *CompressorLzo) optionsBytes() []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b[0:4], uint32(c.Algorithm))
+	binary.LittleEndian.PutUint32(b[4:8], c.level())
+	return b
+}
+func (c *CompressorLzo) flavour() compression {
+	return compressionLzo
+}
+
 func newCompressor(flavour compression) (Compressor, error) {
 	var c Compressor
 	switch flavour {
@@ -369,7 +473,7 @@ func newCompressor(flavour compression) (Compressor, error) {
 	case compressionLzma:
 		c = &CompressorLzma{}
 	case compressionLzo:
-		return nil, fmt.Errorf("LZO compression not yet supported")
+		c = &CompressorLzo{}
 	case compressionXz:
 		c = &CompressorXz{}
 	case compressionLz4:
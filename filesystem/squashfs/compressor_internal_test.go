@@ -16,7 +16,7 @@ func TestNewCompressor(t *testing.T) {
 	}{
 		{compressionGzip, &CompressorGzip{}, nil},
 		{compressionLzma, &CompressorLzma{}, nil},
-		{compressionLzo, nil, fmt.Errorf("LZO compression not yet supported")},
+		{compressionLzo, &CompressorLzo{}, nil},
 		{compressionXz, &CompressorXz{}, nil},
 		{compressionLz4, &CompressorLz4{}, nil},
 		{compressionZstd, &CompressorZstd{}, nil},
@@ -169,3 +169,51 @@ func TestCompressionZstd(t *testing.T) {
 	c := CompressorZstd{}
 	testCompressAndDecompress(t, &c, compressed)
 }
+func TestCompressionLzo(t *testing.T) {
+	// nothing in the data repeats, so it is a run of literals
+	compressed := append(append([]byte{0x75}, testCompressUncompressed...), 0x11, 0x00, 0x00)
+	c := CompressorLzo{Algorithm: LzoAlgorithmLzo1x999, CompressionLevel: 9}
+	testCompressAndDecompress(t, &c, compressed)
+}
+
+func TestCompressorLzoOptions(t *testing.T) {
+	tests := []struct {
+		b   []byte
+		c   *CompressorLzo
+		err error
+	}{
+		{[]byte{4, 0, 0, 0, 8, 0, 0, 0}, &CompressorLzo{Algorithm: LzoAlgorithmLzo1x999, CompressionLevel: 8}, nil},
+		{[]byte{0, 0, 0, 0, 0, 0, 0, 0}, &CompressorLzo{Algorithm: LzoAlgorithmLzo1x1}, nil},
+		{[]byte{3, 0, 0, 0, 0, 0, 0, 0}, &CompressorLzo{Algorithm: LzoAlgorithmLzo1x1_15}, nil},
+		{[]byte{4, 0, 0, 0, 10, 0, 0, 0}, nil, fmt.Errorf("lzo compression level requested 10")},
+		{[]byte{4, 0, 0, 0, 0, 0, 0, 0}, nil, fmt.Errorf("lzo compression level requested 0")},
+		{[]byte{1, 0, 0, 0, 8, 0, 0, 0}, nil, fmt.Errorf("lzo compression level 8 given for algorithm 1")},
+		{[]byte{5, 0, 0, 0, 0, 0, 0, 0}, nil, fmt.Errorf("unknown lzo algorithm 5")},
+		{[]byte{4, 0, 0, 0}, nil, fmt.Errorf("cannot parse lzo options")},
+	}
+	for i, tt := range tests {
+		c := &CompressorLzo{}
+		err := c.loadOptions(tt.b)
+		switch {
+		case (err == nil && tt.err != nil) || (err != nil && tt.err == nil) || (err != nil && tt.err != nil && !strings.HasPrefix(err.Error(), tt.err.Error())):
+			t.Errorf("%d: mismatched error, actual then expected", i)
+			t.Logf("%v", err)
+			t.Logf("%v", tt.err)
+		case err == nil && *c != *tt.c:
+			t.Errorf("%d: mismatched options, actual %+v expected %+v", i, c, tt.c)
+		case err == nil && !bytes.Equal(c.optionsBytes(), tt.b):
+			t.Errorf("%d: mismatched options bytes % x", i, c.optionsBytes())
+		}
+	}
+
+	// the level that is used is recorded, rather than 0 for the default
+	c := &CompressorLzo{Algorithm: LzoAlgorithmLzo1x999}
+	if b, expected := c.optionsBytes(), []byte{4, 0, 0, 0, 8, 0, 0, 0}; !bytes.Equal(b, expected) {
+		t.Errorf("mismatched options bytes of the default level, actual % x expected % x", b, expected)
+	}
+	for _, c := range []*CompressorLzo{{Algorithm: LzoAlgorithmLzo1x1, CompressionLevel: 8}, {Algorithm: LzoAlgorithmLzo1x999, CompressionLevel: 10}} {
+		if _, err := c.compress([]byte("data")); err == nil {
+			t.Errorf("unexpected nil error compressing with %+v", c)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package squashfs
+
+import "fmt"
+
+// compressJob is a block to compress, and what it is compressed to
+type compressJob struct {
+	in         []byte
+	out        []byte
+	compressed bool
+	err        error
+	// file is the file that a data block is of, and first whether it is the first block of the file, or, with no
+	// block, just marks where its data would go
+	file  *finalizeFileInfo
+	first bool
+	done  chan struct{}
+}
+
+// compress compresses the block with c, keeping it as it is if that does not make it smaller
+func (j *compressJob) compress(c Compressor) {
+	j.out = j.in
+	if c == nil || len(j.in) == 0 {
+		return
+	}
+	out, err := c.compress(j.in)
+	if err != nil {
+		j.err = fmt.Errorf("error compressing block: %v", err)
+		return
+	}
+	if len(out) < len(j.in) {
+		j.out = out
+		j.compressed = true
+	}
+}
+
+// compressQueue compresses blocks with up to workers goroutines at a time, and hands them to write in the order
+// that they were added, so that what is written is the same however many workers there are. It holds no more
+// than twice as many blocks as there are workers.
+type compressQueue struct {
+	c       Compressor
+	jobs    chan *compressJob
+	pending []*compressJob
+	limit   int
+	write   func(*compressJob) error
+}
+
+// newCompressQueue returns a compressQueue, which must be stopped once, of blocks compressed with c, or left as they
+// are if it is nil, with up to workers at a time. With fewer than 2 workers, blocks are compressed as they are added.
+func newCompressQueue(c Compressor, workers int, write func(*compressJob) error) *compressQueue {
+	q := &compressQueue{
+		c:     c,
+		limit: 1,
+		write: write,
+	}
+	if workers > 1 {
+		q.limit = 2 * workers
+		q.jobs = make(chan *compressJob, workers)
+		for i := 0; i < workers; i++ {
+			go func() {
+				for j := range q.jobs {
+					j.compress(q.c)
+					close(j.done)
+				}
+			}()
+		}
+	}
+	return q
+}
+
+// add adds the block of j to be compressed, first writing those before it, in order, until there is room for it
+func (q *compressQueue) add(j *compressJob) error {
+	for len(q.pending) >= q.limit {
+		if err := q.writeNext(); err != nil {
+			return err
+		}
+	}
+	j.done = make(chan struct{})
+	if q.jobs == nil {
+		j.compress(q.c)
+		close(j.done)
+	} else {
+		q.jobs <- j
+	}
+	q.pending = append(q.pending, j)
+	return nil
+}
+
+// writeNext waits for the first block to be compressed, and writes it
+func (q *compressQueue) writeNext() error {
+	j := q.pending[0]
+	q.pending = q.pending[1:]
+	<-j.done
+	if j.err != nil {
+		return j.err
+	}
+	return q.write(j)
+}
+
+// flush writes all of the blocks that are left, in order
+func (q *compressQueue) flush() error {
+	for len(q.pending) > 0 {
+		if err := q.writeNext(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stop stops the workers, once they are done with the blocks they have
+func (q *compressQueue) stop() {
+	if q.jobs != nil {
+		close(q.jobs)
+	}
+}
@@ -0,0 +1,120 @@
+package squashfs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestCompressQueue(t *testing.T) {
+	var blocks [][]byte
+	for i := 0; i < 100; i++ {
+		blocks = append(blocks, bytes.Repeat([]byte(fmt.Sprintf("block %d ", i)), 100*(i%7+1)))
+	}
+	blocks = append(blocks, []byte("x"))
+	c := &CompressorGzip{CompressionLevel: 6}
+	for _, workers := range []int{0, 1, 3, 8} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			var (
+				written [][]byte
+				q       *compressQueue
+			)
+			q = newCompressQueue(c, workers, func(j *compressJob) error {
+				if len(q.pending) > q.limit {
+					t.Errorf("%d blocks pending, more than %d", len(q.pending), q.limit)
+				}
+				out := j.out
+				if j.compressed {
+					var err error
+					if out, err = c.decompress(j.out); err != nil {
+						return err
+					}
+				}
+				written = append(written, out)
+				return nil
+			})
+			defer q.stop()
+			for _, b := range blocks {
+				if err := q.add(&compressJob{in: b}); err != nil {
+					t.Fatalf("unexpected error adding block: %v", err)
+				}
+			}
+			if err := q.flush(); err != nil {
+				t.Fatalf("unexpected error flushing: %v", err)
+			}
+			if len(written) != len(blocks) {
+				t.Fatalf("wrote %d blocks instead of %d", len(written), len(blocks))
+			}
+			for i := range blocks {
+				if !bytes.Equal(written[i], blocks[i]) {
+					t.Errorf("block %d mismatched, %d bytes instead of %d", i, len(written[i]), len(blocks[i]))
+				}
+			}
+		})
+	}
+}
+
+func TestMetadataWriter(t *testing.T) {
+	var metadata []byte
+	for i := 0; len(metadata) < 5*int(metadataBlockSize)+100; i++ {
+		metadata = append(metadata, bytes.Repeat([]byte(fmt.Sprintf("entry %d ", i)), i%13+1)...)
+	}
+	c := &CompressorGzip{CompressionLevel: 6}
+	for _, workers := range []int{0, 3} {
+		t.Run(fmt.Sprintf("%d workers", workers), func(t *testing.T) {
+			f, err := os.CreateTemp("", "squashfs_metadata_test")
+			if err != nil {
+				t.Fatalf("Failed to create tmpfile: %v", err)
+			}
+			defer func() {
+				f.Close()
+				os.Remove(f.Name())
+			}()
+			w := newMetadataWriter(f, c, workers, 100)
+			defer w.stop()
+			// written in pieces that do not line up with the blocks
+			for i := 0; i < len(metadata); i += 1000 {
+				end := i + 1000
+				if end > len(metadata) {
+					end = len(metadata)
+				}
+				if _, err := w.Write(metadata[i:end]); err != nil {
+					t.Fatalf("unexpected error writing: %v", err)
+				}
+				if len(w.buf) >= int(metadataBlockSize) {
+					t.Errorf("%d bytes held, a full block or more", len(w.buf))
+				}
+			}
+			written, err := w.flush()
+			if err != nil {
+				t.Fatalf("unexpected error flushing: %v", err)
+			}
+
+			b := make([]byte, written)
+			if _, err := f.ReadAt(b, 100); err != nil {
+				t.Fatalf("error reading metadata blocks: %v", err)
+			}
+			var actual []byte
+			for i := 0; i < len(b); {
+				header := binary.LittleEndian.Uint16(b[i : i+2])
+				size := int(header &^ (1 << 15))
+				block := b[i+2 : i+2+size]
+				if header&(1<<15) == 0 {
+					if block, err = c.decompress(block); err != nil {
+						t.Fatalf("error decompressing block at %d: %v", i, err)
+					}
+				}
+				if i+2+size < len(b) && len(block) != int(metadataBlockSize) {
+					t.Errorf("block at %d of %d bytes is not the last, but is not full", i, len(block))
+				}
+				actual = append(actual, block...)
+				i += 2 + size
+			}
+			if !bytes.Equal(actual, metadata) {
+				t.Errorf("mismatched metadata, %d bytes instead of %d", len(actual), len(metadata))
+			}
+		})
+	}
+}
@@ -1,6 +1,7 @@
 package squashfs
 
 import (
+	"crypto/sha256"
 	"encoding/binary"
 	"fmt"
 	"io"
@@ -47,15 +48,23 @@ type FinalizeOptions struct {
 	NoCompressXattrs bool
 	// NoFragments do not use fragments, but rather dedicated data blocks for all files. Defaults to false, i.e. use fragments
 	NoFragments bool
+	// NoDuplicates do not detect files with the same contents, but rather write the data of each of them. Defaults to
+	// false, i.e. files with the same contents as one before them share its data blocks and fragment
+	NoDuplicates bool
 	// NoPad do not pad filesystem so it is a multiple of 4K. Defaults to false, i.e. pad it
 	NoPad bool
 	// FileUID set all files to be owned by the UID provided, default is to leave as in filesystem
 	FileUID *uint32
 	// FileGID set all files to be owned by the GID provided, default is to leave as in filesystem
 	FileGID *uint32
+	// Workers how many data, fragment, inode and directory blocks to compress at a time, each in a goroutine of
+	// its own. The filesystem is the same however many there are. Defaults to 0, i.e. one at a time
+	Workers int
 }
 
 // Finalize finalize a read-only filesystem by writing it out to a read-only format
+//
+// Files of the workspace that are hard links to each other are written as one inode, which all of them are.
 func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	if fs.workspace == "" {
 		return fmt.Errorf("cannot finalize an already finalized filesystem")
@@ -92,6 +101,15 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	}
 
 	blocksize := int(fs.blocksize)
+	if fs.appending {
+		// the data already there is compressed as the filesystem is
+		switch {
+		case options.Compression == nil:
+			options.Compression = fs.compressor
+		case fs.compressor == nil || options.Compression.flavour() != fs.compressor.flavour():
+			return fmt.Errorf("cannot append with compression %d to a filesystem compressed with %d", options.Compression.flavour(), fs.superblock.compression)
+		}
+	}
 	comp := compressionNone
 	if options.Compression != nil {
 		comp = options.Compression.flavour()
@@ -104,6 +122,12 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	if err != nil {
 		return fmt.Errorf("error walking tree: %v", err)
 	}
+	if fs.appending {
+		// this must be done before anything is written over the tables of the filesystem
+		if fileList, err = fs.appendTree(fileList); err != nil {
+			return err
+		}
+	}
 
 	// location holds where we are writing in our file
 	var (
@@ -111,7 +135,11 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 		b        []byte
 	)
 	location += superblockSize
-	if options.Compression != nil {
+	switch {
+	case fs.appending:
+		// new data goes after the data and fragments already there, which end where the inode table starts
+		location = int64(fs.superblock.inodeTableStart)
+	case options.Compression != nil:
 		b = options.Compression.optionsBytes()
 		if len(b) > 0 {
 			_, _ = f.WriteAt(b, location)
@@ -119,6 +147,13 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 		}
 	}
 
+	// find the files whose data is already in the filesystem
+	if !options.NoDuplicates {
+		if err := findDuplicates(fileList, fs.workspace); err != nil {
+			return fmt.Errorf("error finding duplicate files: %v", err)
+		}
+	}
+
 	// next write the file blocks
 	compressor := options.Compression
 	if options.NoCompressData {
@@ -127,7 +162,7 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 
 	// write file data blocks
 	//
-	dataWritten, err := writeDataBlocks(fileList, f, fs.workspace, blocksize, compressor, location)
+	dataWritten, err := writeDataBlocks(fileList, f, fs.workspace, blocksize, compressor, options.Workers, location)
 	if err != nil {
 		return fmt.Errorf("error writing file data blocks: %v", err)
 	}
@@ -137,11 +172,30 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	// write file fragments
 	//
 	fragmentBlockStart := location
-	fragmentBlocks, _, err := writeFragmentBlocks(fileList, f, fs.workspace, blocksize, options, fragmentBlockStart)
+	var fragmentBlocks []fragmentBlock
+	if fs.appending {
+		// the fragment blocks already there keep their indexes
+		for _, fe := range fs.fragments {
+			fragmentBlocks = append(fragmentBlocks, fragmentBlock{
+				size:       fe.size,
+				compressed: fe.compressed,
+				location:   int64(fe.start),
+			})
+		}
+	}
+	newFragmentBlocks, _, err := writeFragmentBlocks(fileList, f, fs.workspace, blocksize, options, fragmentBlockStart, uint32(len(fragmentBlocks)))
 	if err != nil {
 		return fmt.Errorf("error writing file fragment blocks: %v", err)
 	}
-	location += int64(len(fragmentBlocks) * blocksize)
+	location += int64(len(newFragmentBlocks) * blocksize)
+	fragmentBlocks = append(fragmentBlocks, newFragmentBlocks...)
+
+	// duplicate files share the data blocks and fragment of the files they duplicate
+	for _, e := range fileList {
+		if d := e.duplicate; d != nil {
+			e.dataLocation, e.blocks, e.startBlock, e.fragment = d.dataLocation, d.blocks, d.startBlock, d.fragment
+		}
+	}
 
 	// extract extended attributes, and save them for later; these are written at the very end
 	// this must be done *before* creating inodes, as inodes reference these
@@ -218,14 +272,14 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 	}
 
 	// write the inodes to the file
-	inodesWritten, inodeTableLocation, err := writeInodes(fileList, f, compressor, location)
+	inodesWritten, inodeTableLocation, err := writeInodes(fileList, f, compressor, options.Workers, location)
 	if err != nil {
 		return fmt.Errorf("error writing inode data blocks: %v", err)
 	}
 	location += int64(inodesWritten)
 
 	// write directory data
-	dirsWritten, dirTableLocation, err := writeDirectories(directories, f, compressor, location)
+	dirsWritten, dirTableLocation, err := writeDirectories(directories, f, compressor, options.Workers, location)
 	if err != nil {
 		return fmt.Errorf("error writing directory data blocks: %v", err)
 	}
@@ -321,10 +375,17 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 		options.NoCompressFragments = true
 		options.NoCompressXattrs = true
 	}
+	// hard links share the inode of the file they link to
+	var inodeCount uint32
+	for _, e := range fileList {
+		if e.hardLink == nil {
+			inodeCount++
+		}
+	}
 	sb := &superblock{
 		blocksize:           uint32(blocksize),
 		compression:         comp,
-		inodes:              uint32(len(fileList)),
+		inodes:              inodeCount,
 		xattrTableStart:     xAttrsLocation,
 		fragmentCount:       uint32(len(fragmentBlocks)),
 		modTime:             time.Now(),
@@ -344,8 +405,10 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 			uncompressedFragments: options.NoCompressFragments,
 			uncompressedXattrs:    options.NoCompressXattrs,
 			noFragments:           options.NoFragments,
+			dedup:                 !options.NoDuplicates,
 			noXattrs:              !options.Xattrs,
 			exportable:            !options.NonExportable,
+			compressorOptions:     fs.appending && fs.superblock.compressorOptions,
 		},
 	}
 
@@ -357,63 +420,10 @@ func (fs *FileSystem) Finalize(options FinalizeOptions) error {
 
 	// finish by setting as finalized
 	fs.workspace = ""
+	fs.appending = false
 	return nil
 }
 
-func copyFileData(from backend.File, to backend.WritableFile, fromOffset, toOffset, blocksize int64, c Compressor) (raw, compressed int, blocks []*blockData, err error) {
-	buf := make([]byte, blocksize)
-	blocks = make([]*blockData, 0)
-	for {
-		n, err := from.ReadAt(buf, fromOffset+int64(raw))
-		if err != nil && err != io.EOF {
-			return raw, compressed, nil, err
-		}
-		if n != len(buf) {
-			break
-		}
-		raw += len(buf)
-
-		// compress the block if needed
-		isCompressed := false
-		if c != nil {
-			out, err := c.compress(buf)
-			if err != nil {
-				return 0, 0, nil, fmt.Errorf("error compressing block: %v", err)
-			}
-			if len(out) < len(buf) {
-				isCompressed = true
-				buf = out
-			}
-		}
-		blocks = append(blocks, &blockData{size: uint32(len(buf)), compressed: isCompressed})
-		if _, err := to.WriteAt(buf[:n], toOffset+int64(compressed)); err != nil {
-			return raw, compressed, blocks, err
-		}
-		compressed += len(buf)
-	}
-	return raw, compressed, blocks, nil
-}
-
-// finalizeFragment write fragment data out to the archive, compressing if relevant.
-// Returns the total amount written, whether compressed, and any error.
-func finalizeFragment(buf []byte, to backend.WritableFile, toOffset int64, c Compressor) (raw int, compressed bool, err error) {
-	// compress the block if needed
-	if c != nil {
-		out, err := c.compress(buf)
-		if err != nil {
-			return 0, compressed, fmt.Errorf("error compressing fragment block: %v", err)
-		}
-		if len(out) < len(buf) {
-			buf = out
-			compressed = true
-		}
-	}
-	if _, err := to.WriteAt(buf, toOffset); err != nil {
-		return 0, compressed, err
-	}
-	return len(buf), compressed, nil
-}
-
 // walkTree walks the tree and returns a slice of files and directories.
 // We do files and directories differently, since they need to be processed
 // differently on disk (file data and fragments vs directory table), and
@@ -423,6 +433,17 @@ func walkTree(workspace string) ([]*finalizeFileInfo, error) {
 	dirMap := make(map[string]*finalizeFileInfo)
 	fileList := make([]*finalizeFileInfo, 0)
 	var entry *finalizeFileInfo
+	// hard links to the same file have the same size and modification time, so only files with the same ones are
+	// compared to find them
+	type linkKey struct {
+		size    int64
+		modTime int64
+	}
+	type linkedFile struct {
+		fi    os.FileInfo
+		entry *finalizeFileInfo
+	}
+	linked := map[linkKey][]linkedFile{}
 	err := filepath.WalkDir(workspace, func(actualPath string, d iofs.DirEntry, err error) error {
 		if err != nil {
 			return err
@@ -495,6 +516,16 @@ func walkTree(workspace string) ([]*finalizeFileInfo, error) {
 		} else {
 			// calculate blocks
 			entry.size = fi.Size()
+			key := linkKey{size: fi.Size(), modTime: fi.ModTime().UnixNano()}
+			for _, l := range linked[key] {
+				if os.SameFile(l.fi, fi) {
+					entry.hardLink = l.entry
+					break
+				}
+			}
+			if entry.hardLink == nil {
+				linked[key] = append(linked[key], linkedFile{fi: fi, entry: entry})
+			}
 		}
 		if !isRoot {
 			parentDirInfo.children = append(parentDirInfo.children, entry)
@@ -506,10 +537,24 @@ func walkTree(workspace string) ([]*finalizeFileInfo, error) {
 	if err != nil {
 		return nil, err
 	}
+	countHardLinks(fileList)
 
 	return fileList, nil
 }
 
+// countHardLinks sets the links of each file that others are hard links to to how many of them there are in fileList
+func countHardLinks(fileList []*finalizeFileInfo) {
+	links := map[*finalizeFileInfo]uint32{}
+	for _, e := range fileList {
+		if e.hardLink != nil {
+			links[e.hardLink]++
+		}
+	}
+	for e, n := range links {
+		e.links = n + 1
+	}
+}
+
 func getTableIdx(m map[uint32]uint16, index uint32) uint16 {
 	for k, v := range m {
 		if k == index {
@@ -521,105 +566,185 @@ func getTableIdx(m map[uint32]uint16, index uint32) uint16 {
 	return m[index]
 }
 
-func writeFileDataBlocks(e *finalizeFileInfo, to backend.WritableFile, ws string, startBlock uint64, blocksize int, compressor Compressor, location int64) (blockCount, compressed int, err error) {
+// addFileDataBlocks adds the whole blocks of the file e in the workspace ws to q. What is left of it goes in a fragment.
+func addFileDataBlocks(q *compressQueue, e *finalizeFileInfo, ws string, blocksize int) error {
+	count := int(e.Size() / int64(blocksize))
+	if count == 0 {
+		return q.add(&compressJob{file: e, first: true})
+	}
 	from, err := os.Open(path.Join(ws, e.path))
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to open file for reading %s: %v", e.path, err)
+		return fmt.Errorf("failed to open file for reading %s: %v", e.path, err)
 	}
 	defer from.Close()
-	raw, compressed, blocks, err := copyFileData(from, to, 0, location, int64(blocksize), compressor)
-	if err != nil {
-		return 0, 0, fmt.Errorf("error copying file %s: %v", e.Name(), err)
-	}
-	if raw%blocksize != 0 {
-		return 0, 0, fmt.Errorf("copying file %s copied %d which is not a multiple of blocksize %d", e.Name(), raw, blocksize)
+	for i := 0; i < count; i++ {
+		buf := make([]byte, blocksize)
+		n, err := from.ReadAt(buf, int64(i*blocksize))
+		if err != nil && err != io.EOF {
+			return fmt.Errorf("error reading block %d of file %s: %v", i, e.Name(), err)
+		}
+		if n != len(buf) {
+			return fmt.Errorf("failed reading block %d of file %s, only read %d", i, e.Name(), n)
+		}
+		if err := q.add(&compressJob{in: buf, file: e, first: i == 0}); err != nil {
+			return err
+		}
 	}
-	// save the information we need for usage later in inodes to find the file data
-	e.dataLocation = location
-	e.blocks = blocks
-	e.startBlock = startBlock
-
-	// how many blocks did we write?
-	blockCount = raw / blocksize
-
-	return blockCount, compressed, nil
+	return nil
 }
 
 func writeMetadataBlock(buf []byte, to backend.WritableFile, c Compressor, location int64) (int, error) {
 	// compress the block if needed
-	isCompressed := false
-	if c != nil {
-		out, err := c.compress(buf)
-		if err != nil {
-			return 0, fmt.Errorf("error compressing block: %v", err)
-		}
-		if len(out) < len(buf) {
-			isCompressed = true
-			buf = out
-		}
+	j := &compressJob{in: buf}
+	j.compress(c)
+	if j.err != nil {
+		return 0, j.err
 	}
+	return writeCompressedMetadataBlock(j, to, location)
+}
+
+// writeCompressedMetadataBlock writes the metadata block of j, once it is compressed, with its header
+func writeCompressedMetadataBlock(j *compressJob, to backend.WritableFile, location int64) (int, error) {
 	// the 2-byte (16-bit) header gives the block size
 	// the top bit is set if uncompressed
-	size := uint16(len(buf))
-	if !isCompressed {
+	size := uint16(len(j.out))
+	if !j.compressed {
 		size |= 1 << 15
 	}
 	header := make([]byte, 2)
 	binary.LittleEndian.PutUint16(header, size)
-	buf = append(header, buf...)
+	buf := append(header, j.out...)
 	if _, err := to.WriteAt(buf, location); err != nil {
 		return 0, err
 	}
 	return len(buf), nil
 }
 
-func writeDataBlocks(fileList []*finalizeFileInfo, f backend.WritableFile, ws string, blocksize int, compressor Compressor, location int64) (int, error) {
-	allBlocks := 0
-	allWritten := 0
+// metadataWriter writes what is written to it as metadata blocks of 8KB each, handing each to be compressed as soon
+// as it is full, with up to workers of them at a time, so that no more of a table than that is held at once
+type metadataWriter struct {
+	q       *compressQueue
+	buf     []byte
+	written int
+}
+
+// newMetadataWriter returns a metadataWriter, which must be stopped once, that writes metadata blocks to to from location
+func newMetadataWriter(to backend.WritableFile, c Compressor, workers int, location int64) *metadataWriter {
+	m := &metadataWriter{}
+	m.q = newCompressQueue(c, workers, func(j *compressJob) error {
+		written, err := writeCompressedMetadataBlock(j, to, location+int64(m.written))
+		m.written += written
+		return err
+	})
+	return m
+}
+
+// Write adds b to the metadata, and the blocks that it fills to be compressed and written
+func (m *metadataWriter) Write(b []byte) (int, error) {
+	m.buf = append(m.buf, b...)
+	maxSize := int(metadataBlockSize)
+	for len(m.buf) >= maxSize {
+		if err := m.q.add(&compressJob{in: m.buf[:maxSize:maxSize]}); err != nil {
+			return 0, err
+		}
+		m.buf = append([]byte(nil), m.buf[maxSize:]...)
+	}
+	return len(b), nil
+}
+
+// flush writes what is left of the metadata as the last block, and returns the number of bytes written
+func (m *metadataWriter) flush() (int, error) {
+	if len(m.buf) > 0 {
+		if err := m.q.add(&compressJob{in: m.buf}); err != nil {
+			return m.written, err
+		}
+		m.buf = nil
+	}
+	if err := m.q.flush(); err != nil {
+		return m.written, err
+	}
+	return m.written, nil
+}
+
+// stop stops the compression of the blocks
+func (m *metadataWriter) stop() {
+	m.q.stop()
+}
+
+// writeDataBlocks writes the whole blocks of all of the regular files that are not already in the filesystem,
+// compressing up to workers of them at a time. Returns the number of bytes written.
+func writeDataBlocks(fileList []*finalizeFileInfo, f backend.WritableFile, ws string, blocksize int, compressor Compressor, workers int, location int64) (int, error) {
+	var (
+		allBlocks  uint64
+		allWritten int
+	)
+	q := newCompressQueue(compressor, workers, func(j *compressJob) error {
+		e := j.file
+		// save the information we need for usage later in inodes to find the file data
+		if j.first {
+			e.dataLocation = location + int64(allWritten)
+			e.blocks = make([]*blockData, 0)
+			e.startBlock = allBlocks
+		}
+		if j.in == nil {
+			return nil
+		}
+		if _, err := f.WriteAt(j.out, location+int64(allWritten)); err != nil {
+			return fmt.Errorf("error writing data for %s to file: %v", e.path, err)
+		}
+		e.blocks = append(e.blocks, &blockData{size: uint32(len(j.out)), compressed: j.compressed})
+		allBlocks++
+		allWritten += len(j.out)
+		return nil
+	})
+	defer q.stop()
 	for _, e := range fileList {
-		// only copy data for normal files
-		if e.fileType != fileRegular {
+		// only copy data for normal files, that is not already there
+		if e.fileType != fileRegular || e.existing || e.duplicate != nil || e.hardLink != nil {
 			continue
 		}
-
-		blocks, written, err := writeFileDataBlocks(e, f, ws, uint64(allBlocks), blocksize, compressor, location)
-		if err != nil {
-			return allWritten, fmt.Errorf("error writing data for %s to file: %v", e.path, err)
+		if err := addFileDataBlocks(q, e, ws, blocksize); err != nil {
+			return allWritten, err
 		}
-		allBlocks += blocks
-		allWritten += written
-		location += int64(written)
+	}
+	if err := q.flush(); err != nil {
+		return allWritten, err
 	}
 	return allWritten, nil
 }
 
-// writeFragmentBlocks writes all of the fragment blocks to the archive. Returns slice of blocks written, the total bytes written, any error
-func writeFragmentBlocks(fileList []*finalizeFileInfo, f backend.WritableFile, ws string, blocksize int, options FinalizeOptions, location int64) ([]fragmentBlock, int64, error) {
+// writeFragmentBlocks writes all of the fragment blocks to the archive, the first of which has the index fragmentBlockIndex,
+// compressing up to options.Workers of them at a time. Returns slice of blocks written, the total bytes written, any error
+func writeFragmentBlocks(fileList []*finalizeFileInfo, f backend.WritableFile, ws string, blocksize int, options FinalizeOptions, location int64, fragmentBlockIndex uint32) ([]fragmentBlock, int64, error) {
 	compressor := options.Compression
 	if options.NoCompressFragments {
 		compressor = nil
 	}
 	fragmentData := make([]byte, 0)
 	var (
-		allWritten         int64
-		fragmentBlockIndex uint32
-		fragmentBlocks     []fragmentBlock
+		allWritten     int64
+		fragmentBlocks []fragmentBlock
 	)
-	fileCloseList := make([]*os.File, 0)
-	defer func() {
-		for _, f := range fileCloseList {
-			f.Close()
+	firstIndex := fragmentBlockIndex
+	q := newCompressQueue(compressor, options.Workers, func(j *compressJob) error {
+		if _, err := f.WriteAt(j.out, location); err != nil {
+			return fmt.Errorf("error writing fragment block %d: %v", firstIndex+uint32(len(fragmentBlocks)), err)
 		}
-	}()
+		fragmentBlocks = append(fragmentBlocks, fragmentBlock{
+			size:       uint32(len(j.out)),
+			compressed: j.compressed,
+			location:   location,
+		})
+		location += int64(blocksize)
+		allWritten += int64(len(j.out))
+		return nil
+	})
+	defer q.stop()
 	for _, e := range fileList {
-		// only copy data for regular files
-		if e.fileType != fileRegular {
+		// only copy data for regular files, whose fragment is not already there
+		if e.fileType != fileRegular || e.existing || e.duplicate != nil || e.hardLink != nil {
 			continue
 		}
-		var (
-			written int64
-			err     error
-		)
 
 		// how much is there to put in a fragment?
 		remainder := e.Size() % int64(blocksize)
@@ -629,16 +754,9 @@ func writeFragmentBlocks(fileList []*finalizeFileInfo, f backend.WritableFile, w
 
 		// would adding this data cause us to write?
 		if len(fragmentData)+int(remainder) > blocksize {
-			written, compressed, err := finalizeFragment(fragmentData, f, location, compressor)
-			if err != nil {
-				return fragmentBlocks, 0, fmt.Errorf("error writing fragment block %d: %v", fragmentBlockIndex, err)
+			if err := q.add(&compressJob{in: fragmentData}); err != nil {
+				return fragmentBlocks, 0, err
 			}
-			fragmentBlocks = append(fragmentBlocks, fragmentBlock{
-				size:       uint32(written),
-				compressed: compressed,
-				location:   location,
-			})
-			location += int64(blocksize)
 			// increment as all writes will be to next block block
 			fragmentBlockIndex++
 			fragmentData = make([]byte, 0)
@@ -649,114 +767,132 @@ func writeFragmentBlocks(fileList []*finalizeFileInfo, f backend.WritableFile, w
 			offset: uint32(len(fragmentData)),
 		}
 		// save the fragment data from the file
-
-		from, err := os.Open(path.Join(ws, e.path))
+		buf, err := readFragmentData(e, ws, remainder)
 		if err != nil {
-			return fragmentBlocks, 0, fmt.Errorf("failed to open file for reading %s: %v", e.path, err)
-		}
-		fileCloseList = append(fileCloseList, from)
-		buf := make([]byte, remainder)
-		n, err := from.ReadAt(buf, e.Size()-remainder)
-		if err != nil && err != io.EOF {
-			return fragmentBlocks, 0, fmt.Errorf("error reading final %d bytes from file %s: %v", remainder, e.Name(), err)
-		}
-		if n != len(buf) {
-			return fragmentBlocks, 0, fmt.Errorf("failed reading final %d bytes from file %s, only read %d", remainder, e.Name(), n)
+			return fragmentBlocks, 0, err
 		}
-		from.Close()
 		fragmentData = append(fragmentData, buf...)
-
-		allWritten += written
-		if written > 0 {
-			fragmentBlockIndex++
-		}
 	}
 
 	// write remaining fragment data
 	if len(fragmentData) > 0 {
-		written, compressed, err := finalizeFragment(fragmentData, f, location, compressor)
-		if err != nil {
-			return fragmentBlocks, 0, fmt.Errorf("error writing fragment block %d: %v", fragmentBlockIndex, err)
+		if err := q.add(&compressJob{in: fragmentData}); err != nil {
+			return fragmentBlocks, 0, err
 		}
-		fragmentBlocks = append(fragmentBlocks, fragmentBlock{
-			size:       uint32(written),
-			compressed: compressed,
-			location:   location,
-		})
-		// increment as all writes will be to next block block
-		allWritten += int64(written)
+	}
+	if err := q.flush(); err != nil {
+		return fragmentBlocks, 0, err
 	}
 	return fragmentBlocks, allWritten, nil
 }
 
-func writeInodes(files []*finalizeFileInfo, f backend.WritableFile, compressor Compressor, location int64) (inodesWritten int, finalLocation uint64, err error) {
+// findDuplicates marks each regular file in the workspace ws with the same contents as one before it in fileList as a
+// duplicate of that one, as mksquashfs does. Only files of the same size as another have their contents hashed.
+func findDuplicates(fileList []*finalizeFileInfo, ws string) error {
+	type contents struct {
+		size int64
+		hash [sha256.Size]byte
+	}
 	var (
-		buf             []byte
-		maxSize         = int(metadataBlockSize)
-		initialLocation = location
+		candidate = func(e *finalizeFileInfo) bool {
+			return e.fileType == fileRegular && !e.existing && e.hardLink == nil && e.Size() > 0
+		}
+		sizes = map[int64]int{}
+		files = map[contents]*finalizeFileInfo{}
 	)
-	for _, e := range files {
-		// keep writing until we run out, or we hit 8KB
-		buf = append(buf, e.inode.toBytes()...)
-		if len(buf) > maxSize {
-			written, err := writeMetadataBlock(buf[:maxSize], f, compressor, location)
-			if err != nil {
-				return inodesWritten, 0, err
-			}
-			// count all we have written
-			inodesWritten += written
-			// increment for next write
-			location += int64(written)
-			// truncate all except what we wrote
-			buf = buf[maxSize:]
+	for _, e := range fileList {
+		if candidate(e) {
+			sizes[e.Size()]++
 		}
 	}
-	// was there anything left?
-	if len(buf) > 0 {
-		written, err := writeMetadataBlock(buf, f, compressor, location)
+	for _, e := range fileList {
+		if !candidate(e) || sizes[e.Size()] < 2 {
+			continue
+		}
+		hash, err := hashFile(path.Join(ws, e.path))
 		if err != nil {
-			return inodesWritten, 0, err
+			return fmt.Errorf("error hashing file %s: %v", e.path, err)
+		}
+		key := contents{size: e.Size(), hash: hash}
+		if d, ok := files[key]; ok {
+			e.duplicate = d
+		} else {
+			files[key] = e
 		}
-		inodesWritten += written
 	}
-	return inodesWritten, uint64(initialLocation), nil
+	return nil
 }
 
-// writeDirectories write all directories out to disk. Assumes it already has been optimized.
-func writeDirectories(dirs []*finalizeFileInfo, f backend.WritableFile, compressor Compressor, location int64) (directoriesWritten int, finalLocation uint64, err error) {
-	var (
-		buf             []byte
-		maxSize         = int(metadataBlockSize)
-		initialLocation = location
-	)
+// hashFile returns the SHA-256 hash of the contents of the file at p
+func hashFile(p string) (hash [sha256.Size]byte, err error) {
+	f, err := os.Open(p)
+	if err != nil {
+		return hash, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return hash, err
+	}
+	copy(hash[:], h.Sum(nil))
+	return hash, nil
+}
+
+// readFragmentData reads the final remainder bytes of the file e in the workspace ws, which go in a fragment
+func readFragmentData(e *finalizeFileInfo, ws string, remainder int64) ([]byte, error) {
+	from, err := os.Open(path.Join(ws, e.path))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file for reading %s: %v", e.path, err)
+	}
+	defer from.Close()
+	buf := make([]byte, remainder)
+	n, err := from.ReadAt(buf, e.Size()-remainder)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("error reading final %d bytes from file %s: %v", remainder, e.Name(), err)
+	}
+	if n != len(buf) {
+		return nil, fmt.Errorf("failed reading final %d bytes from file %s, only read %d", remainder, e.Name(), n)
+	}
+	return buf, nil
+}
+
+// writeInodes writes all of the inodes, compressing up to workers metadata blocks at a time
+func writeInodes(files []*finalizeFileInfo, f backend.WritableFile, compressor Compressor, workers int, location int64) (inodesWritten int, finalLocation uint64, err error) {
+	w := newMetadataWriter(f, compressor, workers, location)
+	defer w.stop()
+	for _, e := range files {
+		if e.hardLink != nil {
+			continue
+		}
+		if _, err := w.Write(e.inode.toBytes()); err != nil {
+			return 0, 0, err
+		}
+	}
+	inodesWritten, err = w.flush()
+	if err != nil {
+		return inodesWritten, 0, err
+	}
+	return inodesWritten, uint64(location), nil
+}
+
+// writeDirectories write all directories out to disk, compressing up to workers metadata blocks at a time.
+// Assumes it already has been optimized.
+func writeDirectories(dirs []*finalizeFileInfo, f backend.WritableFile, compressor Compressor, workers int, location int64) (directoriesWritten int, finalLocation uint64, err error) {
+	w := newMetadataWriter(f, compressor, workers, location)
+	defer w.stop()
 	for i, d := range dirs {
 		if d.directory == nil {
 			return 0, 0, fmt.Errorf("empty directory info for position %d", i)
 		}
-		// keep writing until we run out, or we hit metadata maxSize of 8KB
-		buf = append(buf, d.directory.toBytes(d.directory.inodeIndex)...)
-		if len(buf) > maxSize {
-			written, err := writeMetadataBlock(buf[:maxSize], f, compressor, location)
-			if err != nil {
-				return directoriesWritten, 0, err
-			}
-			// count all we have written
-			directoriesWritten += written
-			// increment for next write
-			location += int64(written)
-			// truncate all except what we wrote
-			buf = buf[maxSize:]
+		if _, err := w.Write(d.directory.toBytes(d.directory.inodeIndex)); err != nil {
+			return 0, 0, err
 		}
 	}
-	// was there anything left?
-	if len(buf) > 0 {
-		written, err := writeMetadataBlock(buf, f, compressor, location)
-		if err != nil {
-			return directoriesWritten, 0, err
-		}
-		directoriesWritten += written
+	directoriesWritten, err = w.flush()
+	if err != nil {
+		return directoriesWritten, 0, err
 	}
-	return directoriesWritten, uint64(initialLocation), nil
+	return directoriesWritten, uint64(location), nil
 }
 
 // writeFragmentTable write the fragment table
@@ -841,6 +977,9 @@ func writeExportTable(files []*finalizeFileInfo, f backend.WritableFile, compres
 		buf          []byte
 	)
 	for _, e := range files {
+		if e.hardLink != nil {
+			continue
+		}
 		entry := make([]byte, 8)
 		binary.LittleEndian.PutUint32(entry[2:6], e.inodeLocation.block)
 		binary.LittleEndian.PutUint16(entry[6:8], e.inodeLocation.offset)
@@ -1089,6 +1228,11 @@ func createInodes(fileList []*finalizeFileInfo, idtable map[uint32]uint16, optio
 	// need to keep track of directory position in directory table
 	// build our inodes for our files - must include all file types
 	for _, e := range fileList {
+		// a hard link is the same inode as the file it links to
+		if e.hardLink != nil {
+			e.inode = e.hardLink.inode
+			continue
+		}
 		var (
 			in     inodeBody
 			inodeT inodeType
@@ -1143,9 +1287,12 @@ func createInodes(fileList []*finalizeFileInfo, idtable map[uint32]uint16, optio
 				- it has extended attributes
 				- it has hard links
 			*/
-			target, err := os.Readlink(e.path)
-			if err != nil {
-				return fmt.Errorf("unable to read target for symlink at %s: %v", e.path, err)
+			target := e.target
+			if !e.existing {
+				var err error
+				if target, err = os.Readlink(e.path); err != nil {
+					return fmt.Errorf("unable to read target for symlink at %s: %v", e.path, err)
+				}
 			}
 			if len(e.xattrs) > 0 {
 				in = &extendedSymlink{
@@ -1187,7 +1334,7 @@ func createInodes(fileList []*finalizeFileInfo, idtable map[uint32]uint16, optio
 				inodeT = inodeBasicDirectory
 			}
 		case fileBlock:
-			major, minor, err := getDeviceNumbers(e.path)
+			major, minor, err := e.deviceNumbers()
 			if err != nil {
 				return fmt.Errorf("unable to read major/minor device numbers for block device at %s: %v", e.path, err)
 			}
@@ -1212,7 +1359,7 @@ func createInodes(fileList []*finalizeFileInfo, idtable map[uint32]uint16, optio
 				inodeT = inodeBasicBlock
 			}
 		case fileChar:
-			major, minor, err := getDeviceNumbers(e.path)
+			major, minor, err := e.deviceNumbers()
 			if err != nil {
 				return fmt.Errorf("unable to read major/minor device numbers for char device at %s: %v", e.path, err)
 			}
@@ -1421,6 +1568,10 @@ func updateInodeLocations(files []*finalizeFileInfo) {
 
 	// get block position for each inode
 	for _, f := range files {
+		if f.hardLink != nil {
+			f.inodeLocation = f.hardLink.inodeLocation
+			continue
+		}
 		b := f.inode.toBytes()
 		block, offset := uint32(pos/metadataBlockSize), uint16(pos%metadataBlockSize)
 		blockPos := block * (standardMetadataBlocksize + 2)
@@ -5,8 +5,11 @@ import (
 	"crypto/rand"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	"github.com/diskfs/go-diskfs/backend/file"
 	"github.com/diskfs/go-diskfs/filesystem"
@@ -189,3 +192,247 @@ func validateSquashfs(t *testing.T, f *os.File) {
 		t.Log(outString)
 	}
 }
+
+func TestFinalizeSquashfsLzo(t *testing.T) {
+	blocksize := int64(4096)
+	random := make([]byte, 64*1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("error getting random bytes: %v", err)
+	}
+	fileContents := map[string][]byte{
+		"/README.MD":  []byte("readme\n"),
+		"/TEXT":       bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 2000),
+		"/RANDOM":     random,
+		"/RANDOM.TWO": append(append([]byte{}, random...), random...),
+	}
+	// the compression options after the superblock are of the algorithm and the level that is used
+	tests := []struct {
+		name    string
+		c       *squashfs.CompressorLzo
+		options []byte
+	}{
+		{"default", &squashfs.CompressorLzo{}, []byte{0, 0, 0, 0, 0, 0, 0, 0}},
+		{"lzo1x_1_15", &squashfs.CompressorLzo{Algorithm: squashfs.LzoAlgorithmLzo1x1_15}, []byte{3, 0, 0, 0, 0, 0, 0, 0}},
+		{"lzo1x_999 default level", &squashfs.CompressorLzo{Algorithm: squashfs.LzoAlgorithmLzo1x999}, []byte{4, 0, 0, 0, 8, 0, 0, 0}},
+		{"lzo1x_999 level 9", &squashfs.CompressorLzo{Algorithm: squashfs.LzoAlgorithmLzo1x999, CompressionLevel: 9}, []byte{4, 0, 0, 0, 9, 0, 0, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := os.CreateTemp("", "squashfs_finalize_lzo_test")
+			if err != nil {
+				t.Fatalf("Failed to create tmpfile: %v", err)
+			}
+			defer func() {
+				f.Close()
+				os.Remove(f.Name())
+			}()
+
+			b := file.New(f, false)
+			fs, err := squashfs.Create(b, 0, 0, blocksize)
+			if err != nil {
+				t.Fatalf("Failed to squashfs.Create: %v", err)
+			}
+			for p, contents := range fileContents {
+				sqsfile, err := fs.OpenFile(p, os.O_CREATE|os.O_RDWR)
+				if err != nil {
+					t.Fatalf("Failed to squashfs.OpenFile(%s): %v", p, err)
+				}
+				if _, err := sqsfile.Write(contents); err != nil {
+					t.Fatalf("error writing to %s: %v", p, err)
+				}
+			}
+
+			if err := fs.Finalize(squashfs.FinalizeOptions{Compression: tt.c}); err != nil {
+				t.Fatalf("unexpected error fs.Finalize(): %v", err)
+			}
+			options := make([]byte, len(tt.options))
+			if _, err := f.ReadAt(options, 96); err != nil {
+				t.Fatalf("error reading compression options: %v", err)
+			}
+			if !bytes.Equal(options, tt.options) {
+				t.Errorf("mismatched compression options, actual % x expected % x", options, tt.options)
+			}
+
+			fs, err = squashfs.Read(b, 0, 0, blocksize)
+			if err != nil {
+				t.Fatalf("error reading the tmpfile as squashfs: %v", err)
+			}
+			for p, expected := range fileContents {
+				sqsfile, err := fs.OpenFile(p, os.O_RDONLY)
+				if err != nil {
+					t.Errorf("error opening file %s: %v", p, err)
+					continue
+				}
+				actual, err := io.ReadAll(sqsfile)
+				if err != nil {
+					t.Errorf("error reading file %s: %v", p, err)
+				}
+				if !bytes.Equal(actual, expected) {
+					t.Errorf("mismatched contents of %s, %d bytes instead of %d", p, len(actual), len(expected))
+				}
+			}
+
+			validateSquashfs(t, f)
+		})
+	}
+}
+
+func TestFinalizeSquashfsWorkers(t *testing.T) {
+	blocksize := int64(4096)
+	modTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	random := make([]byte, 64*1024)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("error getting random bytes: %v", err)
+	}
+	fsys := fstest.MapFS{
+		".":      {Mode: fs.ModeDir | 0o755, ModTime: modTime},
+		"text":   {Data: bytes.Repeat([]byte("the quick brown fox jumps over the lazy dog\n"), 2000), Mode: 0o644, ModTime: modTime},
+		"random": {Data: random, Mode: 0o644, ModTime: modTime},
+		"many":   {Mode: fs.ModeDir | 0o755, ModTime: modTime},
+	}
+	for i := 0; i < 50; i++ {
+		fsys[fmt.Sprintf("many/file_with_a_long_name_%03d", i)] = &fstest.MapFile{Data: []byte(fmt.Sprintf("file %d\n", i)), Mode: 0o644, ModTime: modTime}
+	}
+
+	finalize := func(workers int) []byte {
+		f, err := os.CreateTemp("", "squashfs_finalize_workers_test")
+		if err != nil {
+			t.Fatalf("Failed to create tmpfile: %v", err)
+		}
+		defer func() {
+			f.Close()
+			os.Remove(f.Name())
+		}()
+		b := file.New(f, false)
+		sqs, err := squashfs.Create(b, 0, 0, blocksize)
+		if err != nil {
+			t.Fatalf("Failed to squashfs.Create: %v", err)
+		}
+		if err := sqs.AddFS(fsys); err != nil {
+			t.Fatalf("unexpected error fs.AddFS(): %v", err)
+		}
+		options := squashfs.FinalizeOptions{
+			Compression: &squashfs.CompressorGzip{CompressionLevel: 6},
+		}
+		if err := sqs.Finalize(options); err != nil {
+			t.Fatalf("unexpected error fs.Finalize() with %d workers: %v", workers, err)
+		}
+		sqs, err = squashfs.Read(b, 0, 0, blocksize)
+		if err != nil {
+			t.Fatalf("error reading the tmpfile as squashfs: %v", err)
+		}
+		for p, file := range fsys {
+			if file.Mode.IsDir() {
+				continue
+			}
+			sqsfile, err := sqs.OpenFile("/"+p, os.O_RDONLY)
+			if err != nil {
+				t.Fatalf("error opening file %s: %v", p, err)
+			}
+			actual, err := io.ReadAll(sqsfile)
+			if err != nil {
+				t.Fatalf("error reading file %s: %v", p, err)
+			}
+			if !bytes.Equal(actual, file.Data) {
+				t.Fatalf("mismatched contents of %s with %d workers, %d bytes instead of %d", p, workers, len(actual), len(file.Data))
+			}
+		}
+		image, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatalf("error reading image: %v", err)
+		}
+		// the time that it was made
+		copy(image[8:12], []byte{0, 0, 0, 0})
+		return image
+	}
+
+	expected := finalize(1)
+	for _, workers := range []int{2, 4, 16} {
+		if actual := finalize(workers); !bytes.Equal(actual, expected) {
+			t.Errorf("mismatched image with %d workers, %d bytes instead of %d", workers, len(actual), len(expected))
+		}
+	}
+}
+
+func TestFinalizeSquashfsDuplicates(t *testing.T) {
+	blocksize := int64(4096)
+	random := make([]byte, 5*blocksize+100)
+	if _, err := rand.Read(random); err != nil {
+		t.Fatalf("error getting random bytes: %v", err)
+	}
+	small := make([]byte, 1000)
+	if _, err := rand.Read(small); err != nil {
+		t.Fatalf("error getting random bytes: %v", err)
+	}
+	// the same size as random, but not the same contents
+	other := append([]byte{}, random...)
+	other[len(other)-1]++
+	fileContents := map[string][]byte{
+		"/random":       random,
+		"/dup/random":   random,
+		"/dup/random.2": random,
+		"/other":        other,
+		"/small":        small,
+		"/dup/small":    small,
+		"/empty":        {},
+		"/dup/empty":    {},
+	}
+
+	finalize := func(noDuplicates bool) []byte {
+		f, err := os.CreateTemp("", "squashfs_finalize_duplicates_test")
+		if err != nil {
+			t.Fatalf("Failed to create tmpfile: %v", err)
+		}
+		defer func() {
+			f.Close()
+			os.Remove(f.Name())
+		}()
+		b := file.New(f, false)
+		fs, err := squashfs.Create(b, 0, 0, blocksize)
+		if err != nil {
+			t.Fatalf("Failed to squashfs.Create: %v", err)
+		}
+		writeSquashfsFiles(t, fs, fileContents)
+		options := squashfs.FinalizeOptions{
+			Compression:  &squashfs.CompressorGzip{CompressionLevel: 6},
+			NoDuplicates: noDuplicates,
+			NoPad:        true,
+		}
+		if err := fs.Finalize(options); err != nil {
+			t.Fatalf("unexpected error fs.Finalize(): %v", err)
+		}
+		fs, err = squashfs.Read(b, 0, 0, blocksize)
+		if err != nil {
+			t.Fatalf("error reading the tmpfile as squashfs: %v", err)
+		}
+		for p, expected := range fileContents {
+			sqsfile, err := fs.OpenFile(p, os.O_RDONLY)
+			if err != nil {
+				t.Fatalf("error opening file %s: %v", p, err)
+			}
+			actual, err := io.ReadAll(sqsfile)
+			if err != nil {
+				t.Fatalf("error reading file %s: %v", p, err)
+			}
+			if !bytes.Equal(actual, expected) {
+				t.Errorf("mismatched contents of %s, %d bytes instead of %d", p, len(actual), len(expected))
+			}
+		}
+		image, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatalf("error reading image: %v", err)
+		}
+		return image
+	}
+
+	deduplicated := finalize(false)
+	duplicated := finalize(true)
+	// the flag for duplicates in the superblock
+	if deduplicated[24]&0x40 == 0 || duplicated[24]&0x40 != 0 {
+		t.Errorf("mismatched flags %#x with duplicates detected and %#x without", deduplicated[24], duplicated[24])
+	}
+	// random data does not compress, so the data blocks of the two duplicates of it take that much more
+	if saved, blocks := len(duplicated)-len(deduplicated), 2*5*int(blocksize); saved < blocks {
+		t.Errorf("detecting duplicates saved %d bytes instead of at least %d", saved, blocks)
+	}
+}
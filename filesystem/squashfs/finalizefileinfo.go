@@ -44,6 +44,17 @@ type finalizeFileInfo struct {
 	gid               uint32
 	directory         *directory
 	directoryLocation blockPosition
+	major             uint32
+	minor             uint32
+	// existing whether it is in the filesystem being appended to, rather than the workspace, so that its data and
+	// fragment, and whatever else is not in the workspace, are where its inode was
+	existing bool
+	// duplicate is the file before it with the same contents, whose data blocks and fragment it shares
+	duplicate *finalizeFileInfo
+	// hardLink is the file before it that is a hard link to the same file, whose inode it shares
+	hardLink *finalizeFileInfo
+	// existingIndex is the index of the inode of an existing file in the filesystem, by which its hard links are found
+	existingIndex uint32
 }
 
 func (fi *finalizeFileInfo) Name() string {
@@ -65,6 +76,14 @@ func (fi *finalizeFileInfo) Sys() interface{} {
 	return nil
 }
 
+// deviceNumbers returns the major and minor numbers of a block or char device
+func (fi *finalizeFileInfo) deviceNumbers() (major, minor uint32, err error) {
+	if fi.existing {
+		return fi.major, fi.minor, nil
+	}
+	return getDeviceNumbers(fi.path)
+}
+
 // add depth to all children
 func (fi *finalizeFileInfo) addProperties(depth int) {
 	fi.depth = depth
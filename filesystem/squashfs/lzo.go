@@ -0,0 +1,353 @@
+package squashfs
+
+import (
+	"errors"
+	"fmt"
+)
+
+/*
+	LZO1X, as squashfs compresses with it, is a stream of instructions, each of which copies a match from what
+	is already decompressed, followed by up to 3 literal bytes, whose count is in the 2 lowest bits of the
+	instruction, or copies a run of 4 or more literal bytes. It ends with an instruction copying from 16 KB back,
+	with no more to it, 0x11 0x00 0x00.
+
+	  0000DDSS  2 bytes from up to 1 KB back, after 1 to 3 literals, or 3 bytes from 2 to 3 KB back, after a
+	            run of literals, and a byte HHHHHHHH: distance (H << 2) + D + 1, or + 2049
+	  0000LLLL  after a match with no literals, a run of literals of 3 + L, or of 18 plus an extended length
+	  0001HLLL  2 + L bytes, or 9 plus an extended length, from 16 to 48 KB back, and DDDDDDDD DDDDDDSS,
+	            little endian: distance 16384 + (H << 14) + D
+	  001LLLLL  2 + L bytes, or 33 plus an extended length, from up to 16 KB back, and DDDDDDDD DDDDDDSS,
+	            little endian: distance D + 1
+	  LLLDDDSS  L + 1 bytes, 3 to 8 of them, from up to 2 KB back, and a byte HHHHHHHH: distance (H << 3) + D + 1
+
+	An extended length is as many 0 bytes, each of which adds 255 to it, as there are, and the byte after them.
+	The first byte of a stream from 18 on is a run of that less 17 literals.
+*/
+
+const (
+	// lzoM1MaxDistance is the furthest back that a 2 byte match goes
+	lzoM1MaxDistance = 0x400
+	// lzoM2MaxDistance is the furthest back that a match of 3 to 8 bytes in 2 bytes goes
+	lzoM2MaxDistance = 0x800
+	// lzoM2MaxLength is the longest match in 2 bytes
+	lzoM2MaxLength = 8
+	// lzoMXMaxDistance is the furthest back that a 3 byte match after a run of literals goes
+	lzoMXMaxDistance = lzoM1MaxDistance + lzoM2MaxDistance
+	// lzoM3MaxDistance is the furthest back that a match in 3 bytes goes without the high bit of the distance
+	lzoM3MaxDistance = 0x4000
+	// lzoM3MaxLength is the longest match of up to 16 KB back without an extended length
+	lzoM3MaxLength = 33
+	// lzoM4MaxDistance is the furthest back that a match goes
+	lzoM4MaxDistance = 0xbfff
+	// lzoM4MaxLength is the longest match of 16 to 48 KB back without an extended length
+	lzoM4MaxLength = 9
+	// lzoMinLength is the shortest match that is compressed
+	lzoMinLength = 3
+	// lzoFirstLiteralsMax is the longest run of literals at the beginning of a stream in its first byte
+	lzoFirstLiteralsMax = 238
+	// lzoNiceLength is how long a match is for the search for a longer one to stop
+	lzoNiceLength = 2048
+
+	// lzoHashBits is the size of the table of hash chains, as that of the dictionary of lzo1x_1
+	lzoHashBits = 14
+)
+
+var (
+	errLzoInputOverrun      = errors.New("lzo: compressed data ends before the end of the stream")
+	errLzoLookbehindOverrun = errors.New("lzo: match goes back before the start of the data")
+)
+
+// lzoChainLengths is how many earlier positions with the same hash are searched for a match at each compression level
+var lzoChainLengths = [...]int{0, 4, 8, 16, 32, 64, 128, 256, 1024, 4096}
+
+// lzoSearch is how lzo1xCompress searches for matches
+type lzoSearch struct {
+	// hashBits is the size of the table of hash chains
+	hashBits int
+	// chainLength is how many earlier positions with the same hash are tried
+	chainLength int
+	// lazy takes a longer match at the next position instead of the one found
+	lazy bool
+}
+
+// lzo1xDecompress decompresses an LZO1X stream, as any of the LZO1X algorithms compress it
+func lzo1xDecompress(in []byte) ([]byte, error) {
+	out := make([]byte, 0, 4*len(in))
+	ip := 0
+	// state is the number of literals after the last instruction, 4 for a run of literals
+	state := 0
+
+	next := func() (int, error) {
+		if ip >= len(in) {
+			return 0, errLzoInputOverrun
+		}
+		b := int(in[ip])
+		ip++
+		return b, nil
+	}
+	extendedLength := func(base int) (int, error) {
+		length := base
+		for {
+			b, err := next()
+			if err != nil {
+				return 0, err
+			}
+			if b != 0 {
+				return length + b, nil
+			}
+			length += 255
+		}
+	}
+	literals := func(count int) error {
+		if ip+count > len(in) {
+			return errLzoInputOverrun
+		}
+		out = append(out, in[ip:ip+count]...)
+		ip += count
+		return nil
+	}
+
+	if len(in) > 0 && in[0] > 17 {
+		count := int(in[0]) - 17
+		ip++
+		if err := literals(count); err != nil {
+			return nil, err
+		}
+		state = count
+		if count >= 4 {
+			state = 4
+		}
+	}
+
+	for {
+		t, err := next()
+		if err != nil {
+			return nil, err
+		}
+		var length, distance int
+		switch {
+		case t < 16 && state == 0:
+			// a run of literals
+			length = t
+			if length == 0 {
+				if length, err = extendedLength(15); err != nil {
+					return nil, err
+				}
+			}
+			if err := literals(length + 3); err != nil {
+				return nil, err
+			}
+			state = 4
+			continue
+		case t < 16:
+			h, err := next()
+			if err != nil {
+				return nil, err
+			}
+			distance = (h << 2) + (t >> 2) + 1
+			length = 2
+			if state == 4 {
+				distance += lzoM2MaxDistance
+				length = 3
+			}
+			state = t & 3
+		case t >= 64:
+			h, err := next()
+			if err != nil {
+				return nil, err
+			}
+			distance = (h << 3) + ((t >> 2) & 7) + 1
+			length = (t >> 5) + 1
+			state = t & 3
+		default:
+			// 16 to 63, with a distance in the next 2 bytes
+			var lengthBits, lengthMax int
+			if t >= 32 {
+				lengthBits, lengthMax = t&31, 31
+			} else {
+				lengthBits, lengthMax = t&7, 7
+			}
+			length = lengthBits
+			if length == 0 {
+				if length, err = extendedLength(lengthMax); err != nil {
+					return nil, err
+				}
+			}
+			length += 2
+			if ip+2 > len(in) {
+				return nil, errLzoInputOverrun
+			}
+			d := int(in[ip]) | int(in[ip+1])<<8
+			ip += 2
+			if t >= 32 {
+				distance = (d >> 2) + 1
+			} else {
+				distance = (t&8)<<11 + (d >> 2)
+				if distance == 0 {
+					// the end of the stream
+					if ip != len(in) {
+						return nil, fmt.Errorf("lzo: %d bytes after the end of the stream", len(in)-ip)
+					}
+					return out, nil
+				}
+				distance += lzoM3MaxDistance
+			}
+			state = d & 3
+		}
+
+		if distance > len(out) {
+			return nil, errLzoLookbehindOverrun
+		}
+		// the match may overlap what it copies, so it is copied a byte at a time
+		from := len(out) - distance
+		for i := 0; i < length; i++ {
+			out = append(out, out[from+i])
+		}
+		if err := literals(state); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// lzo1xCompress compresses in to an LZO1X stream with a parser of its own: it looks for the longest match at each
+// position in hash chains of the positions before it, as far down the chains as search has it, and may lazily take a
+// longer match at the next position instead. The stream decompresses as any LZO1X one does, but is not the one that
+// liblzo makes of the same data.
+func lzo1xCompress(in []byte, search lzoSearch) []byte {
+	out := make([]byte, 0, len(in)+len(in)/16+64+3)
+	chainLength := search.chainLength
+
+	head := make([]int32, 1<<search.hashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, len(in))
+	hash := func(i int) uint32 {
+		v := uint32(in[i]) | uint32(in[i+1])<<8 | uint32(in[i+2])<<16
+		return (v * 2654435761) >> (32 - search.hashBits)
+	}
+	inserted := 0
+	// insert adds the positions up to end to the hash chains
+	insert := func(end int) {
+		for ; inserted < end && inserted+lzoMinLength <= len(in); inserted++ {
+			h := hash(inserted)
+			prev[inserted] = head[h]
+			head[h] = int32(inserted)
+		}
+	}
+	// find returns the longest match at i that is worth coding after lit literals
+	find := func(i, lit int) (length, distance int) {
+		if i+lzoMinLength > len(in) {
+			return 0, 0
+		}
+		insert(i)
+		maxLength := len(in) - i
+		for j, n := int(head[hash(i)]), 0; j >= 0 && i-j <= lzoM4MaxDistance && n < chainLength; j, n = int(prev[j]), n+1 {
+			if in[j+length] != in[i+length] && length > 0 {
+				continue
+			}
+			l := 0
+			for l < maxLength && in[j+l] == in[i+l] {
+				l++
+			}
+			if l < lzoMinLength || l <= length || !lzoWorthCoding(l, i-j, lit) {
+				continue
+			}
+			length, distance = l, i-j
+			if length >= lzoNiceLength || length == maxLength {
+				break
+			}
+		}
+		return length, distance
+	}
+
+	litStart := 0
+	for i := 0; i+lzoMinLength <= len(in); {
+		length, distance := find(i, i-litStart)
+		if length == 0 {
+			i++
+			continue
+		}
+		// a longer match at the next position is better than this one
+		if search.lazy && i+1+lzoMinLength <= len(in) {
+			if nextLength, _ := find(i+1, i+1-litStart); nextLength > length+1 {
+				i++
+				continue
+			}
+		}
+		out = lzoStoreRun(out, in[litStart:i])
+		out = lzoCodeMatch(out, length, distance, i-litStart)
+		i += length
+		litStart = i
+	}
+	out = lzoStoreRun(out, in[litStart:])
+	return append(out, 0x11, 0x00, 0x00)
+}
+
+// lzoWorthCoding reports whether a match of length from distance back after lit literals is shorter coded than as
+// literals
+func lzoWorthCoding(length, distance, lit int) bool {
+	if length == lzoMinLength && distance > lzoM2MaxDistance {
+		return lit >= 4 && distance <= lzoMXMaxDistance
+	}
+	return true
+}
+
+// lzoStoreRun appends a run of literals to out, whose count goes in the last instruction if it is at most 3
+func lzoStoreRun(out, literals []byte) []byte {
+	t := len(literals)
+	switch {
+	case t == 0:
+		return out
+	case len(out) == 0 && t <= lzoFirstLiteralsMax:
+		out = append(out, byte(t+17))
+	case t <= 3:
+		out[len(out)-2] |= byte(t)
+	case t <= 18:
+		out = append(out, byte(t-3))
+	default:
+		out = append(out, 0)
+		out = lzoExtendedLength(out, t-18)
+	}
+	return append(out, literals...)
+}
+
+// lzoCodeMatch appends a match of length from distance back, after lit literals, to out
+func lzoCodeMatch(out []byte, length, distance, lit int) []byte {
+	switch {
+	case length <= lzoM2MaxLength && distance <= lzoM2MaxDistance:
+		d := distance - 1
+		return append(out, byte((length-1)<<5|(d&7)<<2), byte(d>>3))
+	case length == lzoMinLength && distance <= lzoMXMaxDistance && lit >= 4:
+		d := distance - 1 - lzoM2MaxDistance
+		return append(out, byte((d&3)<<2), byte(d>>2))
+	case distance <= lzoM3MaxDistance:
+		d := distance - 1
+		if length <= lzoM3MaxLength {
+			out = append(out, byte(32|(length-2)))
+		} else {
+			out = append(out, 32)
+			out = lzoExtendedLength(out, length-lzoM3MaxLength)
+		}
+		return append(out, byte(d<<2), byte(d>>6))
+	default:
+		d := distance - lzoM3MaxDistance
+		k := byte((d & 0x4000) >> 11)
+		if length <= lzoM4MaxLength {
+			out = append(out, 16|k|byte(length-2))
+		} else {
+			out = append(out, 16|k)
+			out = lzoExtendedLength(out, length-lzoM4MaxLength)
+		}
+		return append(out, byte(d<<2), byte(d>>6))
+	}
+}
+
+// lzoExtendedLength appends an extended length of n, which is more than 0, to out
+func lzoExtendedLength(out []byte, n int) []byte {
+	for n > 255 {
+		n -= 255
+		out = append(out, 0)
+	}
+	return append(out, byte(n))
+}
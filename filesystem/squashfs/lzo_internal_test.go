@@ -0,0 +1,87 @@
+package squashfs
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestLzo1xDecompress(t *testing.T) {
+	tests := []struct {
+		name       string
+		compressed []byte
+		expected   []byte
+		err        error
+	}{
+		{"empty", []byte{0x11, 0x00, 0x00}, []byte{}, nil},
+		// 3 literals, then a match of 9 bytes 3 back
+		{"match", []byte{0x14, 'a', 'b', 'c', 0x27, 0x08, 0x00, 0x11, 0x00, 0x00}, []byte("abcabcabcabc"), nil},
+		// 3 literals, a match of 4 bytes 2 back with 2 literals after it
+		{"short match", []byte{0x14, 'a', 'b', 'c', 0x66, 0x00, 'x', 'y', 0x11, 0x00, 0x00}, []byte("abcbcbcxy"), nil},
+		{"truncated", []byte{0x14, 'a', 'b'}, nil, errLzoInputOverrun},
+		{"no end", []byte{0x14, 'a', 'b', 'c'}, nil, errLzoInputOverrun},
+		{"too far back", []byte{0x14, 'a', 'b', 'c', 0x27, 0x10, 0x00, 0x11, 0x00, 0x00}, nil, errLzoLookbehindOverrun},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out, err := lzo1xDecompress(tt.compressed)
+			switch {
+			case err != tt.err:
+				t.Errorf("mismatched error, actual %v expected %v", err, tt.err)
+			case err == nil && !bytes.Equal(out, tt.expected):
+				t.Errorf("mismatched decompressed, actual %q expected %q", out, tt.expected)
+			}
+		})
+	}
+}
+
+func TestLzo1xCompress(t *testing.T) {
+	random := make([]byte, 128*1024)
+	//nolint:gosec // it does not need to be secure, just the same every time
+	rand.New(rand.NewSource(1)).Read(random)
+	// matches of every distance up to beyond the furthest back that LZO goes
+	var far bytes.Buffer
+	for far.Len() < 256*1024 {
+		far.Write(random[:100+far.Len()%997])
+		far.Write(random[far.Len()%50000 : far.Len()%50000+far.Len()%61])
+	}
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"empty", []byte{}},
+		{"short", []byte("ab")},
+		{"text", []byte(strings.Repeat("the quick brown fox jumps over the lazy dog, ", 200))},
+		{"zeros", make([]byte, 128*1024)},
+		{"random", random},
+		{"far", far.Bytes()},
+	}
+	compressors := []CompressorLzo{
+		{Algorithm: LzoAlgorithmLzo1x1},
+		{Algorithm: LzoAlgorithmLzo1x1_11},
+		{Algorithm: LzoAlgorithmLzo1x1_15},
+		{Algorithm: LzoAlgorithmLzo1x999, CompressionLevel: 1},
+		{Algorithm: LzoAlgorithmLzo1x999},
+		{Algorithm: LzoAlgorithmLzo1x999, CompressionLevel: 9},
+	}
+	for _, tt := range tests {
+		for _, c := range compressors {
+			search, err := c.search()
+			if err != nil {
+				t.Fatalf("unexpected error for %+v: %v", c, err)
+			}
+			compressed := lzo1xCompress(tt.in, search)
+			out, err := lzo1xDecompress(compressed)
+			switch {
+			case err != nil:
+				t.Errorf("%s with %+v: unexpected error: %v", tt.name, c, err)
+			case !bytes.Equal(out, tt.in):
+				t.Errorf("%s with %+v: mismatched round trip of %d bytes to %d", tt.name, c, len(tt.in), len(out))
+			}
+			if tt.name == "zeros" && len(compressed) > len(tt.in)/100 {
+				t.Errorf("%s with %+v: compressed %d bytes to %d", tt.name, c, len(tt.in), len(compressed))
+			}
+		}
+	}
+}
@@ -34,6 +34,8 @@ type FileSystem struct {
 	xattrs     *xAttrTable
 	rootDir    inode
 	cache      *lru
+	// appending whether the workspace is to be appended to the filesystem when it is finalized
+	appending bool
 }
 
 // Equal compare if two filesystems are equal
@@ -0,0 +1,98 @@
+package udf
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"time"
+)
+
+// BridgeEntry is a file or directory of an image where UDF shares the contents of files with another filesystem,
+// such as an ISO9660/UDF bridge image. The other filesystem lays out and writes the contents; the UDF structures
+// only point to them.
+type BridgeEntry struct {
+	// Name is the name of the entry in its parent; it is ignored for the root
+	Name    string
+	IsDir   bool
+	Mode    os.FileMode
+	ModTime time.Time
+	// Size of the contents of a file
+	Size int64
+	// Location is the block, from the start of the image, of the contents of a file, which must be contiguous
+	// and from Bridge.DataStart() on. It only needs to be set by the time of Bridge.Write.
+	Location uint32
+	Children []*BridgeEntry
+}
+
+// Bridge is the UDF part of an image that it shares with another filesystem. The UDF partition starts right after
+// the anchor at block 256, with the UDF file entries and directories; everything of the other filesystem that
+// is to be visible from UDF must come after them, from DataStart() on.
+type Bridge struct {
+	layout  *layout
+	entries map[*BridgeEntry]*finalizeFileInfo
+}
+
+// NewBridge lays out the UDF structures for the tree under root. blocksize is the block size of the image, and
+// volumeRecognitionLocation the block right after the last volume descriptor of the other filesystem, where the
+// UDF volume recognition sequence goes.
+func NewBridge(root *BridgeEntry, blocksize int64, volumeRecognitionLocation uint32, options FinalizeOptions) (*Bridge, error) {
+	if err := validateBlocksize(blocksize); err != nil {
+		return nil, err
+	}
+	br := &Bridge{entries: make(map[*BridgeEntry]*finalizeFileInfo)}
+	var convert func(e *BridgeEntry, p string) *finalizeFileInfo
+	convert = func(e *BridgeEntry, p string) *finalizeFileInfo {
+		fi := &finalizeFileInfo{
+			path:       p,
+			name:       e.Name,
+			isDir:      e.IsDir,
+			mode:       e.Mode,
+			modTime:    e.ModTime,
+			accessTime: e.ModTime,
+		}
+		if !e.IsDir {
+			fi.size = e.Size
+		}
+		br.entries[e] = fi
+		for _, c := range e.Children {
+			fi.children = append(fi.children, convert(c, p+"/"+c.Name))
+		}
+		return fi
+	}
+	rootInfo := convert(root, "")
+	rootInfo.name = ""
+	l, err := newLayout(rootInfo, blocksize, int64(volumeRecognitionLocation)*blocksize, options, options.recordingTime())
+	if err != nil {
+		return nil, err
+	}
+	br.layout = l
+	return br, nil
+}
+
+// DataStart is the first block after the UDF structures at the start of the partition
+func (br *Bridge) DataStart() uint32 {
+	return br.layout.dataStart()
+}
+
+// Write writes the UDF structures but for the closing anchor to w, for an image of totalBlocks blocks, the last of
+// which is kept for the closing anchor of UDF. Everything that the UDF structures take, up to DataStart(), must not
+// be written to by the other filesystem, except for its own volume descriptors before the volume recognition
+// sequence. The structures are written in the order of where they go, so w may be one that only writes in order.
+func (br *Bridge) Write(w io.WriterAt, totalBlocks uint32) error {
+	l := br.layout
+	for e, fi := range br.entries {
+		if e.IsDir || e.Size == 0 {
+			continue
+		}
+		if e.Location < l.dataStart() {
+			return fmt.Errorf("contents of %s at block %d are before the start %d of the data in the UDF partition", fi.path, e.Location, l.dataStart())
+		}
+		fi.location = e.Location - l.partitionStart
+	}
+	return l.write(w, 0, totalBlocks)
+}
+
+// WriteAnchor writes the closing anchor of UDF to w, in the last of the totalBlocks blocks of the image
+func (br *Bridge) WriteAnchor(w io.WriterAt, totalBlocks uint32) error {
+	return br.layout.writeClosingAnchor(w, 0, totalBlocks)
+}
@@ -0,0 +1,124 @@
+package udf
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// directoryEntry is an entry of a directory: the name from its file identifier descriptor, and the file entry
+// it points to. It fulfills os.FileInfo.
+type directoryEntry struct {
+	name      string
+	icb       longAllocationDescriptor
+	fileEntry *fileEntry
+}
+
+func (de *directoryEntry) Name() string {
+	return de.name
+}
+func (de *directoryEntry) Size() int64 {
+	return int64(de.fileEntry.informationLength)
+}
+func (de *directoryEntry) Mode() os.FileMode {
+	return de.fileEntry.mode()
+}
+func (de *directoryEntry) ModTime() time.Time {
+	return de.fileEntry.modificationTime
+}
+func (de *directoryEntry) IsDir() bool {
+	return de.fileEntry.fileType == fileTypeDirectory
+}
+func (de *directoryEntry) Sys() interface{} {
+	return nil
+}
+
+// contents reads all of the contents of an entry
+func (fs *FileSystem) contents(de *directoryEntry) ([]byte, error) {
+	fe := de.fileEntry
+	if fe.allocationType == allocationEmbedded {
+		if fe.informationLength > uint64(len(fe.allocationDescriptors)) {
+			return nil, fmt.Errorf("embedded contents of %d bytes are shorter than the %d bytes of the file", len(fe.allocationDescriptors), fe.informationLength)
+		}
+		return fe.allocationDescriptors[:fe.informationLength], nil
+	}
+	extents, err := fs.fileExtents(fe, de.icb.partition)
+	if err != nil {
+		return nil, err
+	}
+	return fs.readExtents(extents)
+}
+
+// readDirectoryEntries reads the entries of a directory, without its parent
+func (fs *FileSystem) readDirectoryEntries(dir *directoryEntry) ([]*directoryEntry, error) {
+	b, err := fs.contents(dir)
+	if err != nil {
+		return nil, err
+	}
+	fids, err := parseFileIdentifiers(b)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]*directoryEntry, 0, len(fids))
+	for _, fid := range fids {
+		if fid.isParent() || fid.isDeleted() {
+			continue
+		}
+		fe, err := fs.readFileEntry(fid.icb)
+		if err != nil {
+			return nil, fmt.Errorf("could not read file entry of %s: %w", fid.name, err)
+		}
+		entries = append(entries, &directoryEntry{
+			name:      fid.name,
+			icb:       fid.icb,
+			fileEntry: fe,
+		})
+	}
+	return entries, nil
+}
+
+// findEntry finds the entry at path p
+func (fs *FileSystem) findEntry(p string) (*directoryEntry, error) {
+	fe, err := fs.readFileEntry(fs.rootICB)
+	if err != nil {
+		return nil, fmt.Errorf("could not read root directory: %w", err)
+	}
+	current := &directoryEntry{name: "/", icb: fs.rootICB, fileEntry: fe}
+	for _, part := range strings.Split(p, "/") {
+		if part == "" || part == "." {
+			continue
+		}
+		if !current.IsDir() {
+			return nil, fmt.Errorf("%s is not a directory", current.name)
+		}
+		entries, err := fs.readDirectoryEntries(current)
+		if err != nil {
+			return nil, fmt.Errorf("could not read directory %s: %w", current.name, err)
+		}
+		var next *directoryEntry
+		for _, e := range entries {
+			if e.name == part {
+				next = e
+				break
+			}
+		}
+		if next == nil {
+			return nil, fmt.Errorf("target file %s does not exist", p)
+		}
+		current = next
+	}
+	return current, nil
+}
+
+// readDirectory reads the entries of the directory at path p
+func (fs *FileSystem) readDirectory(p string) ([]*directoryEntry, error) {
+	dir, err := fs.findEntry(p)
+	if err != nil {
+		return nil, err
+	}
+	if !dir.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", p)
+	}
+	return fs.readDirectoryEntries(dir)
+}
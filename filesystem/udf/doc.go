@@ -0,0 +1,14 @@
+// Package udf provides utilities to interact with, manipulate and create a UDF (Universal Disk Format) filesystem
+// on a block device or a disk image.
+//
+// Reading supports UDF revisions 1.02 through 2.60, including the metadata partition of 2.50 and later, but not
+// the virtual allocation table of sequentially recorded media. Writing creates read-only images of revision
+// 1.02 or 2.01, either on their own, with Create and Finalize, or alongside ISO9660 in a bridge image, by way of
+// the FinalizeOptions of github.com/diskfs/go-diskfs/filesystem/iso9660.
+//
+// Reference documentation
+//
+//	ECMA-167 https://www.ecma-international.org/publications-and-standards/standards/ecma-167/
+//	UDF 2.60 http://www.osta.org/specs/pdf/udf260.pdf
+//	UDF 1.02 http://www.osta.org/specs/pdf/udf102.pdf
+package udf
@@ -0,0 +1,138 @@
+package udf
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+// File represents a single file in a UDF filesystem
+//
+//	it is NOT used when working in a workspace, where we just use the underlying OS
+type File struct {
+	*directoryEntry
+	filesystem *FileSystem
+	// extents are where the contents are, unless they are embedded in the file entry
+	extents  []fileExtent
+	embedded []byte
+	offset   int64
+	closed   bool
+}
+
+// openEntry opens the file of an entry for reading
+func (fs *FileSystem) openEntry(de *directoryEntry) (*File, error) {
+	f := &File{directoryEntry: de, filesystem: fs}
+	if de.fileEntry.allocationType == allocationEmbedded {
+		contents, err := fs.contents(de)
+		if err != nil {
+			return nil, err
+		}
+		f.embedded = contents
+		return f, nil
+	}
+	extents, err := fs.fileExtents(de.fileEntry, de.icb.partition)
+	if err != nil {
+		return nil, fmt.Errorf("could not find contents of %s: %w", de.name, err)
+	}
+	f.extents = extents
+	return f, nil
+}
+
+// Read reads up to len(b) bytes from the File.
+// It returns the number of bytes read and any error encountered.
+// At end of file, Read returns 0, io.EOF
+// reads from the last known offset in the file from last read or write
+// use Seek() to set at a particular point
+func (fl *File) Read(b []byte) (int, error) {
+	if fl == nil || fl.closed {
+		return 0, os.ErrClosed
+	}
+	size := fl.Size()
+	if fl.offset >= size {
+		return 0, io.EOF
+	}
+	if int64(len(b)) > size-fl.offset {
+		b = b[:size-fl.offset]
+	}
+	if fl.embedded != nil {
+		n := copy(b, fl.embedded[fl.offset:])
+		fl.offset += int64(n)
+		return n, nil
+	}
+
+	read := 0
+	var start int64
+	for _, e := range fl.extents {
+		if read == len(b) {
+			break
+		}
+		end := start + e.length
+		if fl.offset >= end {
+			start = end
+			continue
+		}
+		pos := fl.offset - start
+		n := e.length - pos
+		if n > int64(len(b)-read) {
+			n = int64(len(b) - read)
+		}
+		chunk := b[read : read+int(n)]
+		if e.offset < 0 {
+			for i := range chunk {
+				chunk[i] = 0
+			}
+		} else {
+			count, err := fl.filesystem.backend.ReadAt(chunk, e.offset+pos)
+			if err != nil && err != io.EOF {
+				return read, err
+			}
+			if count != len(chunk) {
+				return read + count, fmt.Errorf("read %d bytes instead of expected %d", count, len(chunk))
+			}
+		}
+		read += int(n)
+		fl.offset += n
+		start = end
+	}
+	if read == 0 {
+		// the extents end before the file does
+		return 0, io.ErrUnexpectedEOF
+	}
+	return read, nil
+}
+
+// Write writes len(b) bytes to the File.
+//
+//	you cannot write to a finalized UDF filesystem, so this returns an error
+func (fl *File) Write(_ []byte) (int, error) {
+	return 0, filesystem.ErrReadonlyFilesystem
+}
+
+// Seek set the offset to a particular point in the file
+func (fl *File) Seek(offset int64, whence int) (int64, error) {
+	if fl == nil || fl.closed {
+		return 0, os.ErrClosed
+	}
+	newOffset := int64(0)
+	switch whence {
+	case io.SeekStart:
+		newOffset = offset
+	case io.SeekEnd:
+		newOffset = fl.Size() + offset
+	case io.SeekCurrent:
+		newOffset = fl.offset + offset
+	}
+	if newOffset < 0 {
+		return fl.offset, fmt.Errorf("cannot set offset %d before start of file", offset)
+	}
+	fl.offset = newOffset
+	return fl.offset, nil
+}
+
+// Close close the file
+func (fl *File) Close() error {
+	fl.closed = true
+	return nil
+}
@@ -0,0 +1,278 @@
+package udf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"time"
+)
+
+type fileType uint8
+
+// file types of the ICB tag of a file entry
+const (
+	fileTypeDirectory      fileType = 4
+	fileTypeRegular        fileType = 5
+	fileTypeBlockDevice    fileType = 6
+	fileTypeCharDevice     fileType = 7
+	fileTypeFIFO           fileType = 9
+	fileTypeSocket         fileType = 10
+	fileTypeSymlink        fileType = 12
+	fileTypeRealTime       fileType = 249
+	fileTypeMetadata       fileType = 250
+	fileTypeMetadataMirror fileType = 251
+)
+
+// allocation descriptor types, in the flags of the ICB tag
+const (
+	allocationShort    uint8 = 0
+	allocationLong     uint8 = 1
+	allocationExtended uint8 = 2
+	allocationEmbedded uint8 = 3
+)
+
+// extent types, in the top two bits of the extent length of an allocation descriptor
+const (
+	extentRecorded            uint8 = 0
+	extentAllocatedUnrecorded uint8 = 1
+	extentUnallocated         uint8 = 2
+	extentNextDescriptors     uint8 = 3
+)
+
+const (
+	fileEntryHeaderSize         = 176
+	extendedFileEntryHeaderSize = 216
+	shortAllocationSize         = 8
+	longAllocationSize          = 16
+	extendedAllocationSize      = 20
+	// icbStrategy4 is the strategy of an ICB that is a single direct entry, the only one we write
+	icbStrategy4 uint16 = 4
+	// icbStrategy4096 is the strategy of an ICB whose entry may be followed by an indirect entry to the next one
+	icbStrategy4096 uint16 = 4096
+	// extentLengthMask masks the extent type out of the extent length of an allocation descriptor
+	extentLengthMask uint32 = 0x3fffffff
+)
+
+// longAllocationDescriptor is a long_ad: an extent in any partition of the logical volume
+type longAllocationDescriptor struct {
+	// length in bytes, including the extent type in the top two bits
+	length    uint32
+	location  uint32
+	partition uint16
+	// uniqueID is the lower 32 bits of the unique ID of the file entry pointed to, which UDF 2.00 and later
+	// record in the implementation use of the ICB of a file identifier descriptor
+	uniqueID uint32
+}
+
+func parseLongAllocationDescriptor(b []byte) longAllocationDescriptor {
+	return longAllocationDescriptor{
+		length:    binary.LittleEndian.Uint32(b[0:4]),
+		location:  binary.LittleEndian.Uint32(b[4:8]),
+		partition: binary.LittleEndian.Uint16(b[8:10]),
+		uniqueID:  binary.LittleEndian.Uint32(b[12:16]),
+	}
+}
+
+func (l longAllocationDescriptor) toBytes() []byte {
+	b := make([]byte, longAllocationSize)
+	binary.LittleEndian.PutUint32(b[0:4], l.length)
+	binary.LittleEndian.PutUint32(b[4:8], l.location)
+	binary.LittleEndian.PutUint16(b[8:10], l.partition)
+	binary.LittleEndian.PutUint32(b[12:16], l.uniqueID)
+	return b
+}
+
+// allocationDescriptor is an allocation descriptor of any of the types, with the partition filled in
+type allocationDescriptor struct {
+	extentType uint8
+	length     uint32
+	location   uint32
+	partition  uint16
+}
+
+// parseAllocationDescriptors parses the allocation descriptors of the given type. Short ones are in partition,
+// the partition of the ICB they belong to. Parsing stops at a descriptor of length 0, or at one that points to
+// the next extent of allocation descriptors, which is the last one returned.
+func parseAllocationDescriptors(b []byte, allocationType uint8, partition uint16) ([]allocationDescriptor, error) {
+	var size int
+	switch allocationType {
+	case allocationShort:
+		size = shortAllocationSize
+	case allocationLong:
+		size = longAllocationSize
+	case allocationExtended:
+		size = extendedAllocationSize
+	default:
+		return nil, fmt.Errorf("unknown allocation descriptor type %d", allocationType)
+	}
+	ads := make([]allocationDescriptor, 0, len(b)/size)
+	for i := 0; i+size <= len(b); i += size {
+		length := binary.LittleEndian.Uint32(b[i : i+4])
+		ad := allocationDescriptor{
+			extentType: uint8(length >> 30),
+			length:     length & extentLengthMask,
+			partition:  partition,
+		}
+		switch allocationType {
+		case allocationShort:
+			ad.location = binary.LittleEndian.Uint32(b[i+4 : i+8])
+		case allocationLong:
+			ad.location = binary.LittleEndian.Uint32(b[i+4 : i+8])
+			ad.partition = binary.LittleEndian.Uint16(b[i+8 : i+10])
+		case allocationExtended:
+			ad.location = binary.LittleEndian.Uint32(b[i+12 : i+16])
+			ad.partition = binary.LittleEndian.Uint16(b[i+16 : i+18])
+		}
+		if ad.length == 0 {
+			break
+		}
+		ads = append(ads, ad)
+		if ad.extentType == extentNextDescriptors {
+			break
+		}
+	}
+	return ads, nil
+}
+
+// shortAllocationDescriptors returns the short allocation descriptors for size bytes of contiguous contents
+// starting at block location of the partition, in as many extents as they need
+func shortAllocationDescriptors(location uint32, size, blocksize int64) []byte {
+	// an extent must be a whole number of blocks short of 1GB, except for the last one of a file
+	maxExtent := (int64(extentLengthMask) / blocksize) * blocksize
+	b := make([]byte, 0, shortAllocationSize*((size+maxExtent-1)/maxExtent))
+	for size > 0 {
+		length := size
+		if length > maxExtent {
+			length = maxExtent
+		}
+		ad := make([]byte, shortAllocationSize)
+		binary.LittleEndian.PutUint32(ad[0:4], uint32(length))
+		binary.LittleEndian.PutUint32(ad[4:8], location)
+		b = append(b, ad...)
+		size -= length
+		location += uint32(length / blocksize)
+	}
+	return b
+}
+
+// fileEntry is a file entry, or an extended file entry, which describes a file with its attributes and where
+// its contents are
+type fileEntry struct {
+	extended              bool
+	strategy              uint16
+	fileType              fileType
+	allocationType        uint8
+	uid                   uint32
+	gid                   uint32
+	permissions           uint32
+	linkCount             uint16
+	informationLength     uint64
+	logicalBlocksRecorded uint64
+	accessTime            time.Time
+	modificationTime      time.Time
+	creationTime          time.Time
+	attributeTime         time.Time
+	uniqueID              uint64
+	// allocationDescriptors are the raw allocation descriptors, or the contents of the file if they are embedded
+	allocationDescriptors []byte
+}
+
+// parseFileEntry parses a file entry or extended file entry, whose tag identifier says which of the two it is
+func parseFileEntry(b []byte, identifier tagIdentifier) (*fileEntry, error) {
+	fe := &fileEntry{
+		extended:       identifier == tagExtendedFileEntry,
+		strategy:       binary.LittleEndian.Uint16(b[20:22]),
+		fileType:       fileType(b[27]),
+		allocationType: uint8(binary.LittleEndian.Uint16(b[34:36]) & 0x7),
+		uid:            binary.LittleEndian.Uint32(b[36:40]),
+		gid:            binary.LittleEndian.Uint32(b[40:44]),
+		permissions:    binary.LittleEndian.Uint32(b[44:48]),
+		linkCount:      binary.LittleEndian.Uint16(b[48:50]),
+	}
+	fe.informationLength = binary.LittleEndian.Uint64(b[56:64])
+	var headerSize int
+	if fe.extended {
+		fe.logicalBlocksRecorded = binary.LittleEndian.Uint64(b[72:80])
+		fe.accessTime = timestampToTime(b[80:92])
+		fe.modificationTime = timestampToTime(b[92:104])
+		fe.creationTime = timestampToTime(b[104:116])
+		fe.attributeTime = timestampToTime(b[116:128])
+		fe.uniqueID = binary.LittleEndian.Uint64(b[200:208])
+		headerSize = extendedFileEntryHeaderSize
+	} else {
+		fe.logicalBlocksRecorded = binary.LittleEndian.Uint64(b[64:72])
+		fe.accessTime = timestampToTime(b[72:84])
+		fe.modificationTime = timestampToTime(b[84:96])
+		fe.attributeTime = timestampToTime(b[96:108])
+		fe.creationTime = fe.modificationTime
+		fe.uniqueID = binary.LittleEndian.Uint64(b[160:168])
+		headerSize = fileEntryHeaderSize
+	}
+	extendedAttributesLength := int(binary.LittleEndian.Uint32(b[headerSize-8 : headerSize-4]))
+	allocationDescriptorsLength := int(binary.LittleEndian.Uint32(b[headerSize-4 : headerSize]))
+	start := headerSize + extendedAttributesLength
+	if start+allocationDescriptorsLength > len(b) {
+		return nil, fmt.Errorf("extended attributes of %d bytes and allocation descriptors of %d bytes are beyond the file entry", extendedAttributesLength, allocationDescriptorsLength)
+	}
+	fe.allocationDescriptors = b[start : start+allocationDescriptorsLength]
+	return fe, nil
+}
+
+// toBytes for a file entry always writes a plain file entry, which all revisions of UDF allow
+func (fe *fileEntry) toBytes(t *descriptorTag, blocksize int) []byte {
+	b := make([]byte, fileEntryHeaderSize+len(fe.allocationDescriptors))
+	// ICB tag
+	binary.LittleEndian.PutUint16(b[20:22], icbStrategy4)
+	binary.LittleEndian.PutUint16(b[24:26], 1)
+	b[27] = byte(fe.fileType)
+	binary.LittleEndian.PutUint16(b[34:36], uint16(fe.allocationType))
+
+	binary.LittleEndian.PutUint32(b[36:40], fe.uid)
+	binary.LittleEndian.PutUint32(b[40:44], fe.gid)
+	binary.LittleEndian.PutUint32(b[44:48], fe.permissions)
+	binary.LittleEndian.PutUint16(b[48:50], fe.linkCount)
+	binary.LittleEndian.PutUint64(b[56:64], fe.informationLength)
+	binary.LittleEndian.PutUint64(b[64:72], fe.logicalBlocksRecorded)
+	copy(b[72:84], timeToTimestamp(fe.accessTime))
+	copy(b[84:96], timeToTimestamp(fe.modificationTime))
+	copy(b[96:108], timeToTimestamp(fe.attributeTime))
+	// checkpoint
+	binary.LittleEndian.PutUint32(b[108:112], 1)
+	copy(b[128:160], regidToBytes(implementationIdentity, nil))
+	binary.LittleEndian.PutUint64(b[160:168], fe.uniqueID)
+	binary.LittleEndian.PutUint32(b[172:176], uint32(len(fe.allocationDescriptors)))
+	copy(b[176:], fe.allocationDescriptors)
+	t.writeTo(b)
+	if len(b) < blocksize {
+		b = append(b, make([]byte, blocksize-len(b))...)
+	}
+	return b
+}
+
+// mode converts the file type and permissions of a file entry to a file mode. UDF has the same read, write
+// and execute bits as unix, but for each of other, group and owner it has 5 bits instead of 3.
+func (fe *fileEntry) mode() os.FileMode {
+	p := fe.permissions
+	mode := os.FileMode((p>>10)&7<<6 | (p>>5)&7<<3 | p&7)
+	switch fe.fileType {
+	case fileTypeDirectory:
+		mode |= os.ModeDir
+	case fileTypeSymlink:
+		mode |= os.ModeSymlink
+	case fileTypeBlockDevice:
+		mode |= os.ModeDevice
+	case fileTypeCharDevice:
+		mode |= os.ModeDevice | os.ModeCharDevice
+	case fileTypeFIFO:
+		mode |= os.ModeNamedPipe
+	case fileTypeSocket:
+		mode |= os.ModeSocket
+	}
+	return mode
+}
+
+// permissionsFromMode converts the permission bits of a file mode to those of a file entry
+func permissionsFromMode(mode os.FileMode) uint32 {
+	m := uint32(mode.Perm())
+	return (m>>6)&7<<10 | (m>>3)&7<<5 | m&7
+}
@@ -0,0 +1,92 @@
+package udf
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// file characteristics of a file identifier descriptor
+const (
+	fileCharacteristicHidden    uint8 = 0x01
+	fileCharacteristicDirectory uint8 = 0x02
+	fileCharacteristicDeleted   uint8 = 0x04
+	fileCharacteristicParent    uint8 = 0x08
+
+	fileIdentifierHeaderSize = 38
+	// maxFileIdentifierLength is the most bytes the compressed name of a file may take
+	maxFileIdentifierLength = 255
+)
+
+// fileIdentifier is a file identifier descriptor: an entry of a directory, which names a file and points to
+// its file entry
+type fileIdentifier struct {
+	characteristics uint8
+	icb             longAllocationDescriptor
+	name            string
+}
+
+func (f *fileIdentifier) isDirectory() bool {
+	return f.characteristics&fileCharacteristicDirectory != 0
+}
+func (f *fileIdentifier) isDeleted() bool { return f.characteristics&fileCharacteristicDeleted != 0 }
+func (f *fileIdentifier) isParent() bool  { return f.characteristics&fileCharacteristicParent != 0 }
+
+// parseFileIdentifiers parses the file identifier descriptors that make up the contents of a directory
+func parseFileIdentifiers(b []byte) ([]*fileIdentifier, error) {
+	var fids []*fileIdentifier
+	for offset := 0; offset+fileIdentifierHeaderSize <= len(b); {
+		d := b[offset:]
+		if binary.LittleEndian.Uint16(d[0:2]) == 0 {
+			// an unrecorded rest of the block
+			break
+		}
+		nameLength := int(d[19])
+		implementationUseLength := int(binary.LittleEndian.Uint16(d[36:38]))
+		size := fileIdentifierSize(implementationUseLength, nameLength)
+		// some writers leave out the padding of the last one
+		if unpadded := fileIdentifierHeaderSize + implementationUseLength + nameLength; size > len(d) && unpadded <= len(d) {
+			size = len(d)
+		}
+		if size > len(d) {
+			return nil, fmt.Errorf("file identifier descriptor at offset %d of %d bytes is beyond the directory", offset, size)
+		}
+		tag, err := parseDescriptorTag(d[:size])
+		if err != nil {
+			return nil, fmt.Errorf("invalid file identifier descriptor at offset %d: %v", offset, err)
+		}
+		if tag.identifier != tagFileIdentifierDescriptor {
+			return nil, fmt.Errorf("descriptor at offset %d is of type %d instead of a file identifier descriptor", offset, tag.identifier)
+		}
+		nameStart := fileIdentifierHeaderSize + implementationUseLength
+		fids = append(fids, &fileIdentifier{
+			characteristics: d[18],
+			icb:             parseLongAllocationDescriptor(d[20:36]),
+			name:            decodeCS0(d[nameStart : nameStart+nameLength]),
+		})
+		offset += size
+	}
+	return fids, nil
+}
+
+// fileIdentifierSize is the size of a file identifier descriptor, which is padded to a multiple of 4 bytes
+func fileIdentifierSize(implementationUseLength, nameLength int) int {
+	size := fileIdentifierHeaderSize + implementationUseLength + nameLength
+	return (size + 3) &^ 3
+}
+
+// toBytes for a file identifier descriptor; the name of a parent entry is empty
+func (f *fileIdentifier) toBytes(t *descriptorTag) []byte {
+	var name []byte
+	if !f.isParent() {
+		name = encodeCS0(f.name)
+	}
+	b := make([]byte, fileIdentifierSize(0, len(name)))
+	// file version number
+	binary.LittleEndian.PutUint16(b[16:18], 1)
+	b[18] = f.characteristics
+	b[19] = byte(len(name))
+	copy(b[20:36], f.icb.toBytes())
+	copy(b[fileIdentifierHeaderSize:], name)
+	t.writeTo(b)
+	return b
+}
@@ -0,0 +1,34 @@
+package udf
+
+import (
+	"encoding/binary"
+	"time"
+)
+
+// fileSetDescriptor is the descriptor at the start of the partition that leads to the root directory
+type fileSetDescriptor struct {
+	recordingTime           time.Time
+	logicalVolumeIdentifier string
+	fileSetIdentifier       string
+	revision                Revision
+	rootDirectoryICB        longAllocationDescriptor
+}
+
+func (f *fileSetDescriptor) toBytes(t *descriptorTag) []byte {
+	b := make([]byte, 512)
+	copy(b[16:28], timeToTimestamp(f.recordingTime))
+	// interchange level and maximum
+	binary.LittleEndian.PutUint16(b[28:30], 3)
+	binary.LittleEndian.PutUint16(b[30:32], 3)
+	// character set lists have just CS0
+	binary.LittleEndian.PutUint32(b[32:36], 1)
+	binary.LittleEndian.PutUint32(b[36:40], 1)
+	copy(b[48:112], charspecToBytes())
+	copy(b[112:240], dstringToBytes(f.logicalVolumeIdentifier, 128))
+	copy(b[240:304], charspecToBytes())
+	copy(b[304:336], dstringToBytes(f.fileSetIdentifier, 32))
+	copy(b[400:416], f.rootDirectoryICB.toBytes())
+	copy(b[416:448], regidToBytes(domainIdentifier, udfSuffix(f.revision)))
+	t.writeTo(b)
+	return b
+}
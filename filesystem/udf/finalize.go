@@ -0,0 +1,551 @@
+package udf
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/diskfs/go-diskfs/backend"
+)
+
+const (
+	defaultVolumeIdentifier = "UDFIMAGE"
+	// firstUniqueID is the unique ID of the first file entry after the root directory, as UDF 2.00 and later keep
+	// 1 to 15 for themselves
+	firstUniqueID uint64 = 16
+	// partitionMetadataStart is the first block in the partition for file entries and directories, after the file
+	// set descriptor and the terminating descriptor of its sequence
+	partitionMetadataStart uint32 = 2
+)
+
+// FinalizeOptions options to pass to finalize
+type FinalizeOptions struct {
+	// Revision of UDF to write, either Revision102 or Revision201. Defaults to Revision201.
+	Revision Revision
+	// VolumeIdentifier custom volume name, defaults to "UDFIMAGE"
+	VolumeIdentifier string
+	// RecordingTime when the volume is recorded, defaults to now
+	RecordingTime time.Time
+}
+
+// recordingTime returns when the volume is recorded
+func (o FinalizeOptions) recordingTime() time.Time {
+	if o.RecordingTime.IsZero() {
+		return time.Now()
+	}
+	return o.RecordingTime
+}
+
+// finalizeFileInfo is a file or directory to write
+type finalizeFileInfo struct {
+	path       string
+	name       string
+	isDir      bool
+	size       int64
+	mode       os.FileMode
+	modTime    time.Time
+	accessTime time.Time
+	uniqueID   uint64
+	// entryLocation is the block of the file entry in the partition, and location the first block of the contents
+	entryLocation uint32
+	location      uint32
+	parent        *finalizeFileInfo
+	children      []*finalizeFileInfo
+	// directory is the contents of a directory, its file identifier descriptors
+	directory []byte
+}
+
+// layout is where all of the structures of a volume go. Everything but the contents of files is laid out when it
+// is made; the contents of files can go anywhere in the partition from dataStart() on.
+type layout struct {
+	revision         Revision
+	blocksize        int64
+	volumeIdentifier string
+	recordingTime    time.Time
+	// entries are all of the files and directories, with the root first
+	entries []*finalizeFileInfo
+	// volumeRecognitionStart is the offset in bytes of the volume recognition sequence
+	volumeRecognitionStart int64
+	mainSequence           uint32
+	reserveSequence        uint32
+	integritySequence      uint32
+	partitionStart         uint32
+	// metadataBlocks are the blocks at the start of the partition for the file set descriptor, the file entries
+	// and the directories
+	metadataBlocks uint32
+	nextUniqueID   uint64
+	files          uint32
+	directories    uint32
+}
+
+// newLayout lays out a volume for the tree under root, whose volume recognition sequence starts at the given
+// offset in bytes
+func newLayout(root *finalizeFileInfo, blocksize, volumeRecognitionStart int64, options FinalizeOptions, now time.Time) (*layout, error) {
+	l := &layout{
+		revision:               options.Revision,
+		blocksize:              blocksize,
+		volumeIdentifier:       options.VolumeIdentifier,
+		recordingTime:          now,
+		volumeRecognitionStart: volumeRecognitionStart,
+		partitionStart:         anchorLocation + 1,
+	}
+	switch l.revision {
+	case 0:
+		l.revision = Revision201
+	case Revision102, Revision201:
+	default:
+		return nil, fmt.Errorf("cannot write UDF revision %s, only %s and %s", l.revision, Revision102, Revision201)
+	}
+	if l.volumeIdentifier == "" {
+		l.volumeIdentifier = defaultVolumeIdentifier
+	}
+
+	// the volume descriptor sequences go after the volume recognition sequence, on a 16 block boundary
+	step := int64(maxInt(volumeStructureDescriptorSize, int(blocksize)))
+	vrsEnd := uint32((volumeRecognitionStart + 3*step + blocksize - 1) / blocksize)
+	l.mainSequence = (vrsEnd + volumeDescriptorSequenceBlocks - 1) / volumeDescriptorSequenceBlocks * volumeDescriptorSequenceBlocks
+	l.reserveSequence = l.mainSequence + volumeDescriptorSequenceBlocks
+	l.integritySequence = l.reserveSequence + volumeDescriptorSequenceBlocks
+	if l.integritySequence+2 > anchorLocation {
+		return nil, fmt.Errorf("volume recognition sequence at %d leaves no room for volume descriptors before block %d", volumeRecognitionStart, anchorLocation)
+	}
+
+	// all of the entries, depth first, each directory with its children sorted by name
+	var collect func(fi *finalizeFileInfo) error
+	collect = func(fi *finalizeFileInfo) error {
+		l.entries = append(l.entries, fi)
+		sort.Slice(fi.children, func(i, j int) bool {
+			return fi.children[i].name < fi.children[j].name
+		})
+		for _, c := range fi.children {
+			if err := validateCS0(c.name, maxFileIdentifierLength); err != nil {
+				return fmt.Errorf("invalid name for %s: %w", c.path, err)
+			}
+			c.parent = fi
+			if err := collect(c); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	root.parent = root
+	if err := collect(root); err != nil {
+		return nil, err
+	}
+
+	// one block for each file entry, then the directories
+	location := partitionMetadataStart
+	l.nextUniqueID = firstUniqueID
+	for _, e := range l.entries {
+		e.entryLocation = location
+		location++
+		if e == root {
+			e.uniqueID = 0
+		} else {
+			e.uniqueID = l.nextUniqueID
+			l.nextUniqueID++
+		}
+		if e.isDir {
+			l.directories++
+		} else {
+			l.files++
+		}
+	}
+	for _, e := range l.entries {
+		if !e.isDir {
+			continue
+		}
+		e.location = location
+		e.directory = l.directoryContents(e)
+		e.size = int64(len(e.directory))
+		location += blocks(e.size, blocksize)
+	}
+	l.metadataBlocks = location
+	return l, nil
+}
+
+// dataStart is the block of the volume from which on the contents of files go
+func (l *layout) dataStart() uint32 {
+	return l.partitionStart + l.metadataBlocks
+}
+
+// directoryContents returns the file identifier descriptors of a directory, whose location already is set
+func (l *layout) directoryContents(dir *finalizeFileInfo) []byte {
+	version := l.revision.descriptorVersion()
+	fid := func(e *finalizeFileInfo, characteristics uint8) *fileIdentifier {
+		if e.isDir {
+			characteristics |= fileCharacteristicDirectory
+		}
+		icb := longAllocationDescriptor{length: uint32(l.blocksize), location: e.entryLocation}
+		if l.revision >= Revision200 {
+			icb.uniqueID = uint32(e.uniqueID)
+		}
+		return &fileIdentifier{characteristics: characteristics, icb: icb, name: e.name}
+	}
+	fids := []*fileIdentifier{fid(dir.parent, fileCharacteristicParent)}
+	for _, c := range dir.children {
+		fids = append(fids, fid(c, 0))
+	}
+	var b []byte
+	for _, f := range fids {
+		// the tag location is the block each one starts in
+		t := &descriptorTag{
+			identifier: tagFileIdentifierDescriptor,
+			version:    version,
+			serial:     tagSerialNumber,
+			location:   dir.location + uint32(int64(len(b))/l.blocksize),
+		}
+		b = append(b, f.toBytes(t)...)
+	}
+	return b
+}
+
+// fileEntry returns the file entry of a file or directory whose contents are laid out
+func (l *layout) fileEntry(e *finalizeFileInfo) (*fileEntry, error) {
+	fe := &fileEntry{
+		fileType:              fileTypeRegular,
+		allocationType:        allocationShort,
+		permissions:           permissionsFromMode(e.mode),
+		linkCount:             1,
+		informationLength:     uint64(e.size),
+		logicalBlocksRecorded: uint64(blocks(e.size, l.blocksize)),
+		accessTime:            e.accessTime,
+		modificationTime:      e.modTime,
+		attributeTime:         e.modTime,
+		uniqueID:              e.uniqueID,
+		allocationDescriptors: shortAllocationDescriptors(e.location, e.size, l.blocksize),
+	}
+	if e.isDir {
+		fe.fileType = fileTypeDirectory
+		// a directory is pointed to by its entry in its parent, and by the parent entry of each subdirectory
+		for _, c := range e.children {
+			if c.isDir {
+				fe.linkCount++
+			}
+		}
+	}
+	if fileEntryHeaderSize+len(fe.allocationDescriptors) > int(l.blocksize) {
+		return nil, fmt.Errorf("%s of %d bytes needs more allocation descriptors than fit in a file entry", e.path, e.size)
+	}
+	return fe, nil
+}
+
+// tag returns the tag of a descriptor of the revision of the volume
+func (l *layout) tag(identifier tagIdentifier, location uint32) *descriptorTag {
+	return &descriptorTag{identifier: identifier, version: l.revision.descriptorVersion(), serial: tagSerialNumber, location: location}
+}
+
+// writeBlock writes b to the given block of the volume, padded to a whole block
+func (l *layout) writeBlock(w io.WriterAt, start int64, b []byte, location uint32) error {
+	block := make([]byte, l.blocksize)
+	copy(block, b)
+	if _, err := w.WriteAt(block, start+int64(location)*l.blocksize); err != nil {
+		return fmt.Errorf("could not write block %d: %w", location, err)
+	}
+	return nil
+}
+
+// anchor returns the anchor volume descriptor pointer
+func (l *layout) anchor() *anchorVolumeDescriptorPointer {
+	return &anchorVolumeDescriptorPointer{
+		mainVolumeDescriptorSequence:    extentDescriptor{length: volumeDescriptorSequenceBlocks * uint32(l.blocksize), location: l.mainSequence},
+		reserveVolumeDescriptorSequence: extentDescriptor{length: volumeDescriptorSequenceBlocks * uint32(l.blocksize), location: l.reserveSequence},
+	}
+}
+
+// writeClosingAnchor writes the anchor in the last block of a volume of totalBlocks blocks to w at offset start
+func (l *layout) writeClosingAnchor(w io.WriterAt, start int64, totalBlocks uint32) error {
+	location := totalBlocks - 1
+	return l.writeBlock(w, start, l.anchor().toBytes(l.tag(tagAnchorVolumeDescriptorPointer, location)), location)
+}
+
+// write writes all of the structures of the volume but the contents of files, which already must be laid out, and
+// the closing anchor, to w at offset start, in the order of where they go. The volume is of totalBlocks blocks, the
+// last of which is for the closing anchor.
+func (l *layout) write(w io.WriterAt, start int64, totalBlocks uint32) error {
+	bs := l.blocksize
+	tag := l.tag
+	writeBlock := func(b []byte, location uint32) error {
+		return l.writeBlock(w, start, b, location)
+	}
+	if totalBlocks <= l.dataStart() {
+		return fmt.Errorf("volume of %d blocks is too small for its metadata, which ends at block %d", totalBlocks, l.dataStart())
+	}
+	partitionLength := totalBlocks - 1 - l.partitionStart
+
+	// volume recognition sequence
+	step := int64(maxInt(volumeStructureDescriptorSize, int(bs)))
+	nsr := nsr02Identifier
+	contents := partitionContentsNSR02
+	if l.revision >= Revision200 {
+		nsr = nsr03Identifier
+		contents = partitionContentsNSR03
+	}
+	for i, identifier := range [][]byte{beginningExtendedAreaIdentifier, nsr, terminatingExtendedAreaIdentifier} {
+		b := make([]byte, step)
+		copy(b, volumeStructureDescriptor(identifier))
+		if _, err := w.WriteAt(b, start+l.volumeRecognitionStart+int64(i)*step); err != nil {
+			return fmt.Errorf("could not write volume recognition sequence: %w", err)
+		}
+	}
+
+	// main and reserve volume descriptor sequences, which are the same but for where the tags say they are
+	volumeSetIdentifier := fmt.Sprintf("%016X%s", uint64(l.recordingTime.UnixNano()), l.volumeIdentifier)
+	for _, sequence := range []uint32{l.mainSequence, l.reserveSequence} {
+		pvd := &primaryVolumeDescriptor{
+			sequenceNumber:      1,
+			volumeIdentifier:    l.volumeIdentifier,
+			volumeSetIdentifier: volumeSetIdentifier,
+			recordingTime:       l.recordingTime,
+		}
+		iuvd := &implementationUseVolumeDescriptor{
+			sequenceNumber:          2,
+			revision:                l.revision,
+			logicalVolumeIdentifier: l.volumeIdentifier,
+		}
+		pd := &partitionDescriptor{
+			sequenceNumber:   3,
+			number:           0,
+			contents:         contents,
+			accessType:       partitionAccessReadOnly,
+			startingLocation: l.partitionStart,
+			length:           partitionLength,
+		}
+		lvd := &logicalVolumeDescriptor{
+			sequenceNumber:          4,
+			logicalVolumeIdentifier: l.volumeIdentifier,
+			logicalBlockSize:        uint32(bs),
+			revision:                l.revision,
+			fileSetDescriptor:       longAllocationDescriptor{length: 2 * uint32(bs), location: 0},
+			integritySequence:       extentDescriptor{length: 2 * uint32(bs), location: l.integritySequence},
+		}
+		usd := &unallocatedSpaceDescriptor{sequenceNumber: 5}
+		descriptors := [][]byte{
+			pvd.toBytes(tag(tagPrimaryVolumeDescriptor, sequence)),
+			iuvd.toBytes(tag(tagImplementationUseVolumeDescriptor, sequence+1)),
+			pd.toBytes(tag(tagPartitionDescriptor, sequence+2)),
+			lvd.toBytes(tag(tagLogicalVolumeDescriptor, sequence+3)),
+			usd.toBytes(tag(tagUnallocatedSpaceDescriptor, sequence+4)),
+			(&terminatingDescriptor{}).toBytes(tag(tagTerminatingDescriptor, sequence+5)),
+		}
+		for i, b := range descriptors {
+			if err := writeBlock(b, sequence+uint32(i)); err != nil {
+				return err
+			}
+		}
+	}
+
+	// logical volume integrity sequence
+	lvid := &logicalVolumeIntegrityDescriptor{
+		recordingTime:       l.recordingTime,
+		nextUniqueID:        l.nextUniqueID,
+		partitionSize:       partitionLength,
+		numberOfFiles:       l.files,
+		numberOfDirectories: l.directories,
+		revision:            l.revision,
+	}
+	if err := writeBlock(lvid.toBytes(tag(tagLogicalVolumeIntegrityDescriptor, l.integritySequence)), l.integritySequence); err != nil {
+		return err
+	}
+	if err := writeBlock((&terminatingDescriptor{}).toBytes(tag(tagTerminatingDescriptor, l.integritySequence+1)), l.integritySequence+1); err != nil {
+		return err
+	}
+
+	// the anchor at block 256; the other one is in the last block
+	if err := writeBlock(l.anchor().toBytes(tag(tagAnchorVolumeDescriptorPointer, anchorLocation)), anchorLocation); err != nil {
+		return err
+	}
+
+	// the partition: file set descriptor, file entries and directories, with tags relative to the partition
+	writePartitionBlock := func(b []byte, location uint32) error {
+		return writeBlock(b, l.partitionStart+location)
+	}
+	root := l.entries[0]
+	fsd := &fileSetDescriptor{
+		recordingTime:           l.recordingTime,
+		logicalVolumeIdentifier: l.volumeIdentifier,
+		fileSetIdentifier:       l.volumeIdentifier,
+		revision:                l.revision,
+		rootDirectoryICB:        longAllocationDescriptor{length: uint32(bs), location: root.entryLocation},
+	}
+	if err := writePartitionBlock(fsd.toBytes(tag(tagFileSetDescriptor, 0)), 0); err != nil {
+		return err
+	}
+	if err := writePartitionBlock((&terminatingDescriptor{}).toBytes(tag(tagTerminatingDescriptor, 1)), 1); err != nil {
+		return err
+	}
+	for _, e := range l.entries {
+		fe, err := l.fileEntry(e)
+		if err != nil {
+			return err
+		}
+		if err := writePartitionBlock(fe.toBytes(tag(tagFileEntry, e.entryLocation), int(bs)), e.entryLocation); err != nil {
+			return err
+		}
+	}
+	for _, e := range l.entries {
+		if !e.isDir {
+			continue
+		}
+		b := make([]byte, int64(blocks(e.size, bs))*bs)
+		copy(b, e.directory)
+		if _, err := w.WriteAt(b, start+int64(l.partitionStart+e.location)*bs); err != nil {
+			return fmt.Errorf("could not write directory %s: %w", e.path, err)
+		}
+	}
+	return nil
+}
+
+// Finalize finalize a read-only filesystem by writing it out to a read-only format
+func (fs *FileSystem) Finalize(options FinalizeOptions) error {
+	if fs.workspace == "" {
+		return fmt.Errorf("cannot finalize an already finalized filesystem")
+	}
+	root, err := walkTree(fs.workspace)
+	if err != nil {
+		return fmt.Errorf("error walking tree: %w", err)
+	}
+	l, err := newLayout(root, fs.blocksize, volumeRecognitionStart, options, options.recordingTime())
+	if err != nil {
+		return err
+	}
+
+	// the contents of files follow the metadata, in the same order
+	location := l.metadataBlocks
+	for _, e := range l.entries {
+		if e.isDir {
+			continue
+		}
+		e.location = location
+		location += blocks(e.size, fs.blocksize)
+	}
+	// and the closing anchor follows them
+	totalBlocks := l.partitionStart + location + 1
+	if fs.size > 0 && int64(totalBlocks)*fs.blocksize > fs.size {
+		return fmt.Errorf("filesystem of %d bytes is too small for its contents, which need %d", fs.size, int64(totalBlocks)*fs.blocksize)
+	}
+
+	w, err := fs.backend.Writable()
+	if err != nil {
+		return err
+	}
+	// blank out everything before the partition, which is mostly unused
+	if _, err := w.WriteAt(make([]byte, int64(l.partitionStart)*fs.blocksize), fs.start); err != nil {
+		return fmt.Errorf("could not write blank system area: %w", err)
+	}
+	for _, e := range l.entries {
+		if e.isDir {
+			continue
+		}
+		if err := copyFile(w, path.Join(fs.workspace, e.path), fs.start+int64(l.partitionStart+e.location)*fs.blocksize, e.size, fs.blocksize); err != nil {
+			return fmt.Errorf("failed to copy file %s to disk: %w", e.path, err)
+		}
+	}
+	if err := l.write(w, fs.start, totalBlocks); err != nil {
+		return err
+	}
+	if err := l.writeClosingAnchor(w, fs.start, totalBlocks); err != nil {
+		return err
+	}
+
+	_ = os.RemoveAll(fs.workspace)
+	fs.workspace = ""
+	return nil
+}
+
+// copyFile copies size bytes of the file at p to w at offset, and pads them to whole blocks
+func copyFile(w backend.WritableFile, p string, offset, size, blocksize int64) error {
+	from, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	buf := make([]byte, 1024*1024)
+	var copied int64
+	for copied < size {
+		n, err := from.Read(buf)
+		if n > 0 {
+			if int64(n) > size-copied {
+				n = int(size - copied)
+			}
+			if _, werr := w.WriteAt(buf[:n], offset+copied); werr != nil {
+				return werr
+			}
+			copied += int64(n)
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if copied != size {
+		return fmt.Errorf("copied %d bytes, expected %d", copied, size)
+	}
+	if left := int64(blocks(size, blocksize))*blocksize - size; left > 0 {
+		if _, err := w.WriteAt(make([]byte, left), offset+size); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// walkTree reads the tree of files and directories in the workspace
+func walkTree(workspace string) (*finalizeFileInfo, error) {
+	dirs := make(map[string]*finalizeFileInfo)
+	var root *finalizeFileInfo
+	err := filepath.WalkDir(workspace, func(actualPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("error walking path %s: %w", actualPath, err)
+		}
+		fp := strings.TrimPrefix(actualPath, workspace)
+		fp = strings.TrimPrefix(filepath.ToSlash(fp), "/")
+		if fp == "" {
+			fp = "."
+		}
+		info, err := d.Info()
+		if err != nil {
+			return fmt.Errorf("could not get file info for %s: %w", fp, err)
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			return fmt.Errorf("%s is neither a regular file nor a directory, which is all UDF images support", fp)
+		}
+		entry := &finalizeFileInfo{
+			path:       fp,
+			name:       info.Name(),
+			isDir:      info.IsDir(),
+			size:       info.Size(),
+			mode:       info.Mode(),
+			modTime:    info.ModTime(),
+			accessTime: info.ModTime(),
+		}
+		if fp == "." {
+			entry.name = ""
+			root = entry
+		} else {
+			parent := dirs[path.Dir(fp)]
+			parent.children = append(parent.children, entry)
+		}
+		if entry.isDir {
+			entry.size = 0
+			dirs[fp] = entry
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+// blocks returns how many blocks size bytes take
+func blocks(size, blocksize int64) uint32 {
+	return uint32((size + blocksize - 1) / blocksize)
+}
@@ -0,0 +1,91 @@
+package udf
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/diskfs/go-diskfs/filesystem/ext4/crc"
+)
+
+const (
+	tagSize = 16
+	// tagSerialNumber is the serial number we give all descriptors; it only matters when a volume is rewritten
+	tagSerialNumber uint16 = 1
+)
+
+type tagIdentifier uint16
+
+// identifiers of the descriptors of ECMA-167, parts 3 and 4
+const (
+	tagPrimaryVolumeDescriptor           tagIdentifier = 1
+	tagAnchorVolumeDescriptorPointer     tagIdentifier = 2
+	tagVolumeDescriptorPointer           tagIdentifier = 3
+	tagImplementationUseVolumeDescriptor tagIdentifier = 4
+	tagPartitionDescriptor               tagIdentifier = 5
+	tagLogicalVolumeDescriptor           tagIdentifier = 6
+	tagUnallocatedSpaceDescriptor        tagIdentifier = 7
+	tagTerminatingDescriptor             tagIdentifier = 8
+	tagLogicalVolumeIntegrityDescriptor  tagIdentifier = 9
+	tagFileSetDescriptor                 tagIdentifier = 256
+	tagFileIdentifierDescriptor          tagIdentifier = 257
+	tagAllocationExtentDescriptor        tagIdentifier = 258
+	tagIndirectEntry                     tagIdentifier = 259
+	tagFileEntry                         tagIdentifier = 261
+	tagExtendedFileEntry                 tagIdentifier = 266
+)
+
+// descriptorTag is the tag at the start of every descriptor
+type descriptorTag struct {
+	identifier tagIdentifier
+	version    uint16
+	serial     uint16
+	// location is the block the descriptor is recorded in; relative to the partition for those in one
+	location uint32
+}
+
+// parseDescriptorTag parses and checks the tag at the start of a descriptor, including the CRC of as much of
+// the rest of the descriptor as the tag says it covers
+func parseDescriptorTag(b []byte) (*descriptorTag, error) {
+	if len(b) < tagSize {
+		return nil, fmt.Errorf("descriptor of %d bytes is too short for its tag", len(b))
+	}
+	var checksum byte
+	for i := 0; i < tagSize; i++ {
+		if i != 4 {
+			checksum += b[i]
+		}
+	}
+	if checksum != b[4] {
+		return nil, fmt.Errorf("descriptor tag checksum %#02x does not match calculated %#02x", b[4], checksum)
+	}
+	crcLength := int(binary.LittleEndian.Uint16(b[10:12]))
+	if tagSize+crcLength > len(b) {
+		return nil, fmt.Errorf("descriptor CRC length %d is beyond the %d bytes of the descriptor", crcLength, len(b))
+	}
+	if expected, actual := binary.LittleEndian.Uint16(b[8:10]), crc.CRC16(0, b[tagSize:tagSize+crcLength]); expected != actual {
+		return nil, fmt.Errorf("descriptor CRC %#04x does not match calculated %#04x", expected, actual)
+	}
+	return &descriptorTag{
+		identifier: tagIdentifier(binary.LittleEndian.Uint16(b[0:2])),
+		version:    binary.LittleEndian.Uint16(b[2:4]),
+		serial:     binary.LittleEndian.Uint16(b[6:8]),
+		location:   binary.LittleEndian.Uint32(b[12:16]),
+	}, nil
+}
+
+// writeTo fills in the tag at the start of the descriptor b, with a CRC of all of the rest of it
+func (t *descriptorTag) writeTo(b []byte) {
+	binary.LittleEndian.PutUint16(b[0:2], uint16(t.identifier))
+	binary.LittleEndian.PutUint16(b[2:4], t.version)
+	b[4] = 0
+	b[5] = 0
+	binary.LittleEndian.PutUint16(b[6:8], t.serial)
+	binary.LittleEndian.PutUint16(b[8:10], crc.CRC16(0, b[tagSize:]))
+	binary.LittleEndian.PutUint16(b[10:12], uint16(len(b)-tagSize))
+	binary.LittleEndian.PutUint32(b[12:16], t.location)
+	var checksum byte
+	for i := 0; i < tagSize; i++ {
+		checksum += b[i]
+	}
+	b[4] = checksum
+}
@@ -0,0 +1,45 @@
+package udf
+
+import (
+	"testing"
+)
+
+func TestDescriptorTag(t *testing.T) {
+	tag := &descriptorTag{
+		identifier: tagFileSetDescriptor,
+		version:    3,
+		serial:     tagSerialNumber,
+		location:   42,
+	}
+	b := make([]byte, 512)
+	copy(b[tagSize:], "some descriptor contents")
+	tag.writeTo(b)
+
+	parsed, err := parseDescriptorTag(b)
+	if err != nil {
+		t.Fatalf("unexpected error parsing tag: %v", err)
+	}
+	if *parsed != *tag {
+		t.Errorf("mismatched tag, actual %+v expected %+v", parsed, tag)
+	}
+
+	t.Run("bad checksum", func(t *testing.T) {
+		bad := append([]byte{}, b...)
+		bad[4]++
+		if _, err := parseDescriptorTag(bad); err == nil {
+			t.Errorf("unexpected nil error")
+		}
+	})
+	t.Run("bad crc", func(t *testing.T) {
+		bad := append([]byte{}, b...)
+		bad[tagSize]++
+		if _, err := parseDescriptorTag(bad); err == nil {
+			t.Errorf("unexpected nil error")
+		}
+	})
+	t.Run("short", func(t *testing.T) {
+		if _, err := parseDescriptorTag(b[:256]); err == nil {
+			t.Errorf("unexpected nil error")
+		}
+	})
+}
@@ -0,0 +1,689 @@
+package udf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/diskfs/go-diskfs/backend"
+	"github.com/diskfs/go-diskfs/filesystem"
+)
+
+const (
+	defaultBlocksize int64 = 2048
+	// maxVolumeDescriptorPointers is how many volume descriptor pointers we follow in a volume descriptor sequence
+	// before deciding they go around in circles
+	maxVolumeDescriptorPointers = 16
+	// maxVolumeRecognitionDescriptors is how many descriptors of the volume recognition sequence we look at
+	maxVolumeRecognitionDescriptors = 32
+)
+
+// Revision is a revision of UDF, as a binary coded decimal, e.g. 0x0201 for 2.01
+type Revision uint16
+
+const (
+	// Revision102 is UDF 1.02, which is what most DVD video and ISO9660/UDF bridge images use
+	Revision102 Revision = 0x0102
+	// Revision150 is UDF 1.50
+	Revision150 Revision = 0x0150
+	// Revision200 is UDF 2.00
+	Revision200 Revision = 0x0200
+	// Revision201 is UDF 2.01, the default for images we write
+	Revision201 Revision = 0x0201
+	// Revision250 is UDF 2.50, which adds the metadata partition
+	Revision250 Revision = 0x0250
+	// Revision260 is UDF 2.60
+	Revision260 Revision = 0x0260
+)
+
+func (r Revision) String() string {
+	return fmt.Sprintf("%x.%02x", uint16(r)>>8, uint16(r)&0xff)
+}
+
+// descriptorVersion is the version of the descriptors of the revision: 2 before UDF 2.00, 3 from then on
+func (r Revision) descriptorVersion() uint16 {
+	if r >= Revision200 {
+		return 3
+	}
+	return 2
+}
+
+// FileSystem implements the FileSystem interface
+type FileSystem struct {
+	workspace string
+	size      int64
+	start     int64
+	backend   backend.Storage
+	blocksize int64
+	volumes   volumeDescriptors
+	// partitions map the partition reference numbers of the logical volume to where they are
+	partitions []*partitionMapping
+	rootICB    longAllocationDescriptor
+}
+
+// partitionMapping is where the blocks of a partition of the logical volume are
+type partitionMapping struct {
+	// start is the first block of the physical partition
+	start uint32
+	// metadata is the extents of the metadata file in the physical partition, for a metadata partition, whose
+	// blocks are those of the metadata file
+	metadata []allocationDescriptor
+}
+
+// fileExtent is a part of the contents of a file, at an offset of the backend, or -1 for one that is not
+// recorded and reads as zeros
+type fileExtent struct {
+	offset int64
+	length int64
+}
+
+// Equal compare if two filesystems are equal
+func (fs *FileSystem) Equal(a *FileSystem) bool {
+	return fs.backend == a.backend && fs.size == a.size && fs.start == a.start && fs.blocksize == a.blocksize
+}
+
+// Workspace get the workspace path
+func (fs *FileSystem) Workspace() string {
+	return fs.workspace
+}
+
+// Revision returns the revision of UDF of the logical volume, or 0 for a filesystem that is not finalized
+func (fs *FileSystem) Revision() Revision {
+	if fs.volumes.logical == nil {
+		return 0
+	}
+	return fs.volumes.logical.revision
+}
+
+// Create creates a UDF filesystem in a given directory
+//
+// requires the backend.Storage where to create the filesystem, size is the size of the filesystem in bytes,
+// start is how far in bytes from the beginning of the backend.Storage to create the filesystem,
+// and blocksize is is the logical blocksize to use for creating the filesystem
+//
+// As with ISO9660, the files and directories are created in a workspace directory, and only written out
+// as UDF when calling Finalize. If workspace is empty, a temporary directory is used.
+//
+// If the provided blocksize is 0, it will use the default of 2 KB.
+func Create(b backend.Storage, size, start, blocksize int64, workspace string) (*FileSystem, error) {
+	if blocksize == 0 {
+		blocksize = defaultBlocksize
+	}
+	if err := validateBlocksize(blocksize); err != nil {
+		return nil, err
+	}
+
+	var workdir string
+	if workspace != "" {
+		info, err := os.Stat(workspace)
+		if err != nil {
+			return nil, fmt.Errorf("could not stat working directory: %w", err)
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("provided workspace is not a directory: %s", workspace)
+		}
+		workdir = workspace
+	} else {
+		var err error
+		workdir, err = os.MkdirTemp("", "diskfs_udf")
+		if err != nil {
+			return nil, fmt.Errorf("could not create working directory: %w", err)
+		}
+	}
+
+	return &FileSystem{
+		workspace: filepath.Clean(workdir),
+		start:     start,
+		size:      size,
+		backend:   b,
+		blocksize: blocksize,
+	}, nil
+}
+
+// Read reads a filesystem from a given disk.
+//
+// requires the backend.Storage where to read the filesystem, size is the size of the filesystem in bytes,
+// start is how far in bytes from the beginning of the backend.Storage the filesystem is expected to begin,
+// and blocksize is is the logical blocksize of the filesystem
+//
+// If the provided blocksize is 0, the blocksizes UDF allows are tried in turn, starting with 2 KB.
+func Read(b backend.Storage, size, start, blocksize int64) (*FileSystem, error) {
+	blocksizes := []int64{blocksize}
+	if blocksize == 0 {
+		blocksizes = []int64{2048, 512, 1024, 4096}
+	}
+	if err := validateBlocksize(blocksizes[0]); err != nil {
+		return nil, err
+	}
+
+	// the volume recognition sequence has a descriptor of 2KB or of a block, whichever is larger, so we read it
+	// with the smallest step and look at each in turn
+	vrs := make([]byte, maxVolumeRecognitionDescriptors*volumeStructureDescriptorSize)
+	n, err := b.ReadAt(vrs, start+volumeRecognitionStart)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("could not read volume recognition sequence: %w", err)
+	}
+	vrs = vrs[:n]
+
+	var (
+		fs     *FileSystem
+		anchor *anchorVolumeDescriptorPointer
+	)
+	for _, bs := range blocksizes {
+		if !hasVolumeRecognitionSequence(vrs, maxInt(volumeStructureDescriptorSize, int(bs))) {
+			continue
+		}
+		candidate := &FileSystem{
+			size:      size,
+			start:     start,
+			backend:   b,
+			blocksize: bs,
+		}
+		if anchor, err = candidate.readAnchor(); err == nil {
+			fs = candidate
+			break
+		}
+	}
+	if fs == nil {
+		return nil, fmt.Errorf("no UDF volume found")
+	}
+
+	fs.volumes, err = fs.readVolumeDescriptorSequence(anchor.mainVolumeDescriptorSequence)
+	if err != nil {
+		var reserveErr error
+		fs.volumes, reserveErr = fs.readVolumeDescriptorSequence(anchor.reserveVolumeDescriptorSequence)
+		if reserveErr != nil {
+			return nil, fmt.Errorf("could not read main volume descriptor sequence (%v) or reserve one: %w", err, reserveErr)
+		}
+	}
+	lvd := fs.volumes.logical
+	if int64(lvd.logicalBlockSize) != fs.blocksize {
+		return nil, fmt.Errorf("logical block size %d differs from the block size %d of the volume", lvd.logicalBlockSize, fs.blocksize)
+	}
+	if err := fs.mapPartitions(); err != nil {
+		return nil, err
+	}
+
+	// the file set descriptor has the root directory
+	fsd, err := fs.readDescriptor(lvd.fileSetDescriptor.partition, lvd.fileSetDescriptor.location, tagFileSetDescriptor)
+	if err != nil {
+		return nil, fmt.Errorf("could not read file set descriptor: %w", err)
+	}
+	fs.rootICB = parseLongAllocationDescriptor(fsd[400:416])
+	return fs, nil
+}
+
+// readAnchor reads the anchor volume descriptor pointer, from block 256 or else from the end of the volume
+func (fs *FileSystem) readAnchor() (*anchorVolumeDescriptorPointer, error) {
+	locations := []uint32{anchorLocation}
+	if blocks := fs.size / fs.blocksize; blocks > int64(anchorLocation) {
+		locations = append(locations, uint32(blocks-1), uint32(blocks-1-int64(anchorLocation)))
+	}
+	var err error
+	for _, location := range locations {
+		var b []byte
+		b, err = fs.readVolumeDescriptor(location, tagAnchorVolumeDescriptorPointer)
+		if err == nil {
+			return parseAnchorVolumeDescriptorPointer(b), nil
+		}
+	}
+	return nil, fmt.Errorf("no anchor volume descriptor pointer found: %w", err)
+}
+
+// readBlocks reads count blocks starting at block location of the volume
+func (fs *FileSystem) readBlocks(location uint32, count int) ([]byte, error) {
+	b := make([]byte, int64(count)*fs.blocksize)
+	n, err := fs.backend.ReadAt(b, fs.start+int64(location)*fs.blocksize)
+	if err != nil && err != io.EOF {
+		return nil, fmt.Errorf("could not read block %d: %w", location, err)
+	}
+	if n != len(b) {
+		return nil, fmt.Errorf("read %d bytes at block %d instead of expected %d", n, location, len(b))
+	}
+	return b, nil
+}
+
+// readVolumeDescriptor reads the descriptor in block location of the volume, and checks it is of the expected type
+func (fs *FileSystem) readVolumeDescriptor(location uint32, expected tagIdentifier) ([]byte, error) {
+	b, err := fs.readBlocks(location, 1)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := parseDescriptorTag(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor at block %d: %w", location, err)
+	}
+	if tag.identifier != expected {
+		return nil, fmt.Errorf("descriptor at block %d is of type %d instead of %d", location, tag.identifier, expected)
+	}
+	return b, nil
+}
+
+// readVolumeDescriptorSequence reads the prevailing descriptors of a volume descriptor sequence
+func (fs *FileSystem) readVolumeDescriptorSequence(extent extentDescriptor) (volumeDescriptors, error) {
+	vds := volumeDescriptors{partitions: make(map[uint16]*partitionDescriptor)}
+	pointers := 0
+	blocks := uint32(int64(extent.length) / fs.blocksize)
+sequence:
+	for i := uint32(0); i < blocks; i++ {
+		b, err := fs.readBlocks(extent.location+i, 1)
+		if err != nil {
+			return vds, err
+		}
+		tag, err := parseDescriptorTag(b)
+		if err != nil {
+			// an unrecorded block ends the sequence as well
+			break
+		}
+		switch tag.identifier {
+		case tagPrimaryVolumeDescriptor:
+			if pvd := parsePrimaryVolumeDescriptor(b); vds.primary == nil || pvd.sequenceNumber > vds.primary.sequenceNumber {
+				vds.primary = pvd
+			}
+		case tagLogicalVolumeDescriptor:
+			lvd, err := parseLogicalVolumeDescriptor(b)
+			if err != nil {
+				return vds, fmt.Errorf("invalid logical volume descriptor at block %d: %w", extent.location+i, err)
+			}
+			if vds.logical == nil || lvd.sequenceNumber > vds.logical.sequenceNumber {
+				vds.logical = lvd
+			}
+		case tagPartitionDescriptor:
+			pd := parsePartitionDescriptor(b)
+			if current, ok := vds.partitions[pd.number]; !ok || pd.sequenceNumber > current.sequenceNumber {
+				vds.partitions[pd.number] = pd
+			}
+		case tagVolumeDescriptorPointer:
+			pointers++
+			if pointers > maxVolumeDescriptorPointers {
+				return vds, fmt.Errorf("more than %d volume descriptor pointers", maxVolumeDescriptorPointers)
+			}
+			extent = parseExtentDescriptor(b[20:28])
+			blocks = uint32(int64(extent.length) / fs.blocksize)
+			// the loop moves on to the first block of the next extent
+			i = ^uint32(0)
+		case tagTerminatingDescriptor:
+			break sequence
+		}
+	}
+	switch {
+	case vds.primary == nil:
+		return vds, fmt.Errorf("no primary volume descriptor in volume descriptor sequence")
+	case vds.logical == nil:
+		return vds, fmt.Errorf("no logical volume descriptor in volume descriptor sequence")
+	}
+	return vds, nil
+}
+
+// mapPartitions works out where the partitions of the logical volume are
+func (fs *FileSystem) mapPartitions() error {
+	maps := fs.volumes.logical.partitionMaps
+	fs.partitions = make([]*partitionMapping, 0, len(maps))
+	for i, m := range maps {
+		pd, ok := fs.volumes.partitions[m.partitionNumber]
+		if !ok {
+			return fmt.Errorf("partition map %d is of partition %d, which has no partition descriptor", i, m.partitionNumber)
+		}
+		mapping := &partitionMapping{start: pd.startingLocation}
+		switch {
+		case m.mapType == 1, m.identifier == partitionMapSparable:
+			// sparing only matters for damaged rewritable media, so a sparable partition is read as it is
+		case m.identifier == partitionMapMetadata:
+			metadata, err := fs.readMetadataFile(mapping.start, m.metadataFileLocation)
+			if err != nil {
+				var mirrorErr error
+				metadata, mirrorErr = fs.readMetadataFile(mapping.start, m.metadataMirrorFileLocation)
+				if mirrorErr != nil {
+					return fmt.Errorf("could not read metadata file (%v) or its mirror: %w", err, mirrorErr)
+				}
+			}
+			mapping.metadata = metadata
+		case m.identifier == partitionMapVirtual:
+			return fmt.Errorf("virtual partitions of sequentially recorded media are not supported")
+		default:
+			return fmt.Errorf("partition map %d is of unknown type %q", i, m.identifier)
+		}
+		fs.partitions = append(fs.partitions, mapping)
+	}
+	return nil
+}
+
+// readMetadataFile reads the extents of the metadata file, whose file entry is at block location of the
+// physical partition that starts at block start
+func (fs *FileSystem) readMetadataFile(start, location uint32) ([]allocationDescriptor, error) {
+	b, err := fs.readBlocks(start+location, 1)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := parseDescriptorTag(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid metadata file entry: %w", err)
+	}
+	if tag.identifier != tagFileEntry && tag.identifier != tagExtendedFileEntry {
+		return nil, fmt.Errorf("metadata file entry is of type %d", tag.identifier)
+	}
+	fe, err := parseFileEntry(b, tag.identifier)
+	if err != nil {
+		return nil, err
+	}
+	// the extents are in the physical partition, and a metadata file has no further extents of them
+	return parseAllocationDescriptors(fe.allocationDescriptors, fe.allocationType, 0)
+}
+
+// resolveExtent returns where the length bytes that start at block location of a partition are
+func (fs *FileSystem) resolveExtent(partition uint16, location uint32, length int64) ([]fileExtent, error) {
+	if int(partition) >= len(fs.partitions) {
+		return nil, fmt.Errorf("partition reference %d is beyond the %d partitions of the logical volume", partition, len(fs.partitions))
+	}
+	p := fs.partitions[partition]
+	if p.metadata == nil {
+		return []fileExtent{{offset: fs.start + int64(p.start+location)*fs.blocksize, length: length}}, nil
+	}
+	// blocks of a metadata partition are those of the metadata file
+	var extents []fileExtent
+	pos := int64(location) * fs.blocksize
+	for _, ad := range p.metadata {
+		if length <= 0 {
+			break
+		}
+		adLength := int64(ad.length)
+		if pos >= adLength {
+			pos -= adLength
+			continue
+		}
+		n := adLength - pos
+		if n > length {
+			n = length
+		}
+		extents = append(extents, fileExtent{offset: fs.start + int64(p.start+ad.location)*fs.blocksize + pos, length: n})
+		length -= n
+		pos = 0
+	}
+	if length > 0 {
+		return nil, fmt.Errorf("block %d of metadata partition %d is beyond its metadata file", location, partition)
+	}
+	return extents, nil
+}
+
+// readExtents reads the contents of extents
+func (fs *FileSystem) readExtents(extents []fileExtent) ([]byte, error) {
+	var size int64
+	for _, e := range extents {
+		size += e.length
+	}
+	b := make([]byte, size)
+	var pos int64
+	for _, e := range extents {
+		if e.offset >= 0 {
+			n, err := fs.backend.ReadAt(b[pos:pos+e.length], e.offset)
+			if err != nil && err != io.EOF {
+				return nil, fmt.Errorf("could not read %d bytes at %d: %w", e.length, e.offset, err)
+			}
+			if int64(n) != e.length {
+				return nil, fmt.Errorf("read %d bytes at %d instead of expected %d", n, e.offset, e.length)
+			}
+		}
+		pos += e.length
+	}
+	return b, nil
+}
+
+// readDescriptor reads the descriptor in block location of a partition, and checks it is of the expected type
+func (fs *FileSystem) readDescriptor(partition uint16, location uint32, expected tagIdentifier) ([]byte, error) {
+	extents, err := fs.resolveExtent(partition, location, fs.blocksize)
+	if err != nil {
+		return nil, err
+	}
+	b, err := fs.readExtents(extents)
+	if err != nil {
+		return nil, err
+	}
+	tag, err := parseDescriptorTag(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor at block %d of partition %d: %w", location, partition, err)
+	}
+	if tag.identifier != expected {
+		return nil, fmt.Errorf("descriptor at block %d of partition %d is of type %d instead of %d", location, partition, tag.identifier, expected)
+	}
+	return b, nil
+}
+
+// readFileEntry reads the file entry of an ICB, following an indirect entry if there is one
+func (fs *FileSystem) readFileEntry(icb longAllocationDescriptor) (*fileEntry, error) {
+	for i := 0; i < maxVolumeDescriptorPointers; i++ {
+		extents, err := fs.resolveExtent(icb.partition, icb.location, fs.blocksize)
+		if err != nil {
+			return nil, err
+		}
+		b, err := fs.readExtents(extents)
+		if err != nil {
+			return nil, err
+		}
+		tag, err := parseDescriptorTag(b)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file entry at block %d of partition %d: %w", icb.location, icb.partition, err)
+		}
+		switch tag.identifier {
+		case tagFileEntry, tagExtendedFileEntry:
+			return parseFileEntry(b, tag.identifier)
+		case tagIndirectEntry:
+			icb = parseLongAllocationDescriptor(b[36:52])
+		default:
+			return nil, fmt.Errorf("descriptor at block %d of partition %d is of type %d instead of a file entry", icb.location, icb.partition, tag.identifier)
+		}
+	}
+	return nil, fmt.Errorf("more than %d indirect entries", maxVolumeDescriptorPointers)
+}
+
+// fileExtents returns where the contents of a file are; fe is in partition
+func (fs *FileSystem) fileExtents(fe *fileEntry, partition uint16) ([]fileExtent, error) {
+	var extents []fileExtent
+	remaining := int64(fe.informationLength)
+	b := fe.allocationDescriptors
+	for i := 0; remaining > 0; i++ {
+		if i > maxVolumeDescriptorPointers*1024 {
+			return nil, fmt.Errorf("too many allocation extent descriptors")
+		}
+		ads, err := parseAllocationDescriptors(b, fe.allocationType, partition)
+		if err != nil {
+			return nil, err
+		}
+		b = nil
+		for _, ad := range ads {
+			length := int64(ad.length)
+			switch ad.extentType {
+			case extentNextDescriptors:
+				aed, err := fs.readDescriptor(ad.partition, ad.location, tagAllocationExtentDescriptor)
+				if err != nil {
+					return nil, fmt.Errorf("could not read allocation extent descriptor: %w", err)
+				}
+				adLength := int(binary.LittleEndian.Uint32(aed[20:24]))
+				if 24+adLength > len(aed) {
+					return nil, fmt.Errorf("allocation descriptors of %d bytes are beyond their allocation extent descriptor", adLength)
+				}
+				b = aed[24 : 24+adLength]
+				continue
+			case extentRecorded:
+				if length > remaining {
+					length = remaining
+				}
+				resolved, err := fs.resolveExtent(ad.partition, ad.location, length)
+				if err != nil {
+					return nil, err
+				}
+				extents = append(extents, resolved...)
+			default:
+				if length > remaining {
+					length = remaining
+				}
+				extents = append(extents, fileExtent{offset: -1, length: length})
+			}
+			remaining -= length
+		}
+		if b == nil {
+			break
+		}
+	}
+	return extents, nil
+}
+
+func validateBlocksize(blocksize int64) error {
+	switch blocksize {
+	case 512, 1024, 2048, 4096:
+		return nil
+	default:
+		return fmt.Errorf("blocksize for UDF must be one of 512, 1024, 2048, 4096")
+	}
+}
+
+// interface guard
+var _ filesystem.FileSystem = (*FileSystem)(nil)
+
+// Close removes the workspace of a filesystem that was not finalized
+func (fs *FileSystem) Close() error {
+	if fs.workspace != "" {
+		return os.RemoveAll(fs.workspace)
+	}
+	return nil
+}
+
+// Type returns the type code for the filesystem. Always returns filesystem.TypeUDF
+func (fs *FileSystem) Type() filesystem.Type {
+	return filesystem.TypeUDF
+}
+
+// Mkdir make a directory at the given path. It is equivalent to `mkdir -p`, i.e. idempotent, in that:
+//
+// * It will make the entire tree path if it does not exist
+// * It will not return an error if the path already exists
+//
+// if readonly and not in workspace, will return an error
+func (fs *FileSystem) Mkdir(p string) error {
+	if fs.workspace == "" {
+		return filesystem.ErrReadonlyFilesystem
+	}
+	if err := os.MkdirAll(path.Join(fs.workspace, p), 0o755); err != nil {
+		return fmt.Errorf("could not create directory %s: %w", p, err)
+	}
+	return nil
+}
+
+// Mknod is not supported
+func (fs *FileSystem) Mknod(_ string, _ uint32, _ int) error {
+	return filesystem.ErrNotSupported
+}
+
+// Link is not supported
+func (fs *FileSystem) Link(_, _ string) error {
+	return filesystem.ErrNotSupported
+}
+
+// Symlink is not supported
+func (fs *FileSystem) Symlink(_, _ string) error {
+	return filesystem.ErrNotSupported
+}
+
+// Chmod changes the mode of the named file in the workspace
+func (fs *FileSystem) Chmod(name string, mode os.FileMode) error {
+	if fs.workspace == "" {
+		return filesystem.ErrReadonlyFilesystem
+	}
+	return os.Chmod(path.Join(fs.workspace, name), mode)
+}
+
+// Chown is not supported
+func (fs *FileSystem) Chown(_ string, _, _ int) error {
+	return filesystem.ErrNotSupported
+}
+
+// ReadDir return the contents of a given directory in a given filesystem.
+//
+// Returns a slice of os.FileInfo with all of the entries in the directory.
+//
+// Will return an error if the directory does not exist or is a regular file and not a directory
+func (fs *FileSystem) ReadDir(p string) ([]os.FileInfo, error) {
+	if fs.workspace != "" {
+		dirEntries, err := os.ReadDir(path.Join(fs.workspace, p))
+		if err != nil {
+			return nil, fmt.Errorf("could not read directory %s: %w", p, err)
+		}
+		fi := make([]os.FileInfo, 0, len(dirEntries))
+		for _, e := range dirEntries {
+			info, err := e.Info()
+			if err != nil {
+				return nil, fmt.Errorf("could not read directory %s: %w", p, err)
+			}
+			fi = append(fi, info)
+		}
+		return fi, nil
+	}
+	entries, err := fs.readDirectory(p)
+	if err != nil {
+		return nil, fmt.Errorf("error reading directory %s: %w", p, err)
+	}
+	fi := make([]os.FileInfo, 0, len(entries))
+	for _, e := range entries {
+		fi = append(fi, e)
+	}
+	return fi, nil
+}
+
+// OpenFile returns an io.ReadWriter from which you can read the contents of a file
+// or write contents to the file
+//
+// accepts normal os.OpenFile flags
+//
+// returns an error if the file does not exist
+func (fs *FileSystem) OpenFile(p string, flag int) (filesystem.File, error) {
+	if fs.workspace != "" {
+		f, err := os.OpenFile(path.Join(fs.workspace, p), flag, 0o644)
+		if err != nil {
+			return nil, fmt.Errorf("target file %s does not exist: %w", p, err)
+		}
+		return f, nil
+	}
+	writeMode := flag&os.O_WRONLY != 0 || flag&os.O_RDWR != 0 || flag&os.O_APPEND != 0 || flag&os.O_CREATE != 0 || flag&os.O_TRUNC != 0 || flag&os.O_EXCL != 0
+	if writeMode {
+		return nil, filesystem.ErrReadonlyFilesystem
+	}
+	entry, err := fs.findEntry(p)
+	if err != nil {
+		return nil, err
+	}
+	if entry.IsDir() {
+		return nil, fmt.Errorf("cannot open directory %s as file", p)
+	}
+	return fs.openEntry(entry)
+}
+
+// Rename renames (moves) oldpath to newpath in the workspace
+func (fs *FileSystem) Rename(oldpath, newpath string) error {
+	if fs.workspace == "" {
+		return filesystem.ErrReadonlyFilesystem
+	}
+	return os.Rename(path.Join(fs.workspace, oldpath), path.Join(fs.workspace, newpath))
+}
+
+// Remove removes the named file or (empty) directory from the workspace
+func (fs *FileSystem) Remove(p string) error {
+	if fs.workspace == "" {
+		return filesystem.ErrReadonlyFilesystem
+	}
+	return os.Remove(path.Join(fs.workspace, p))
+}
+
+// Label returns the logical volume identifier
+func (fs *FileSystem) Label() string {
+	if fs.volumes.logical == nil {
+		return ""
+	}
+	return fs.volumes.logical.logicalVolumeIdentifier
+}
+
+// SetLabel is not supported; the label is set by FinalizeOptions.VolumeIdentifier
+func (fs *FileSystem) SetLabel(string) error {
+	return fmt.Errorf("UDF filesystem is read-only")
+}
@@ -0,0 +1,184 @@
+package udf
+
+import (
+	"encoding/binary"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+)
+
+// toMetadataPartition turns the image of a finalized volume into one whose partition is reached through the
+// metadata file of a UDF 2.50 metadata partition. The blocks of the partition are moved beyond the end of the
+// image, in two extents in the opposite order, followed by the metadata file entry and its mirror. It returns
+// the blocks of the metadata file entry and of its mirror.
+func toMetadataPartition(t *testing.T, f *os.File, fs *FileSystem) (mainBlock, mirrorBlock int64) {
+	t.Helper()
+	img, err := io.ReadAll(io.NewSectionReader(f, 0, 1<<40))
+	if err != nil {
+		t.Fatalf("error reading image: %v", err)
+	}
+	bs := fs.blocksize
+	pd := fs.volumes.partitions[0]
+	start, n := int64(pd.startingLocation), int64(pd.length)
+	end := int64(len(img)) / bs
+	// where the moved partition goes and where the metadata file entries go, relative to the partition
+	moved := end - start
+	entry := moved + n
+	half := n / 2
+
+	partition := make([]byte, n*bs)
+	copy(partition, img[start*bs:(start+n)*bs])
+	for i := start * bs; i < (start+n)*bs; i++ {
+		img[i] = 0
+	}
+	img = append(img, partition[half*bs:]...)
+	img = append(img, partition[:half*bs]...)
+
+	ads := make([]byte, 2*shortAllocationSize)
+	binary.LittleEndian.PutUint32(ads[0:4], uint32(half*bs))
+	binary.LittleEndian.PutUint32(ads[4:8], uint32(moved+n-half))
+	binary.LittleEndian.PutUint32(ads[8:12], uint32((n-half)*bs))
+	binary.LittleEndian.PutUint32(ads[12:16], uint32(moved))
+	for i, ft := range []fileType{fileTypeMetadata, fileTypeMetadataMirror} {
+		fe := &fileEntry{fileType: ft, allocationType: allocationShort, informationLength: uint64(n * bs), allocationDescriptors: ads}
+		b := make([]byte, bs)
+		copy(b, fe.toBytes(&descriptorTag{identifier: tagFileEntry, version: 3, serial: tagSerialNumber, location: uint32(entry) + uint32(i)}, int(bs)))
+		img = append(img, b...)
+	}
+
+	// the logical volume descriptors of both sequences get a metadata partition map, for partition reference 0,
+	// and a type 1 one for the physical partition
+	for i := int64(0); i < start; i++ {
+		b := img[i*bs : (i+1)*bs]
+		tag, err := parseDescriptorTag(b)
+		if err != nil || tag.identifier != tagLogicalVolumeDescriptor {
+			continue
+		}
+		lvd, err := parseLogicalVolumeDescriptor(b)
+		if err != nil {
+			t.Fatalf("error parsing logical volume descriptor: %v", err)
+		}
+		lvd.revision = Revision250
+		tag.version = Revision250.descriptorVersion()
+		d := make([]byte, 440+64+6)
+		copy(d, lvd.toBytes(tag)[:440])
+		binary.LittleEndian.PutUint32(d[264:268], 64+6)
+		binary.LittleEndian.PutUint32(d[268:272], 2)
+		m := d[440:]
+		m[0] = 2
+		m[1] = 64
+		copy(m[4:36], regidToBytes(partitionMapMetadata, udfSuffix(Revision250)))
+		binary.LittleEndian.PutUint16(m[36:38], 1)
+		binary.LittleEndian.PutUint16(m[38:40], pd.number)
+		binary.LittleEndian.PutUint32(m[40:44], uint32(entry))
+		binary.LittleEndian.PutUint32(m[44:48], uint32(entry+1))
+		binary.LittleEndian.PutUint32(m[48:52], 0xffffffff)
+		m[64] = 1
+		m[65] = 6
+		binary.LittleEndian.PutUint16(m[66:68], 1)
+		binary.LittleEndian.PutUint16(m[68:70], pd.number)
+		tag.writeTo(d)
+		for j := range b {
+			b[j] = 0
+		}
+		copy(b, d)
+	}
+
+	if _, err := f.WriteAt(img, 0); err != nil {
+		t.Fatalf("error writing image: %v", err)
+	}
+	return start + entry, start + entry + 1
+}
+
+func TestReadMetadataPartition(t *testing.T) {
+	files := map[string]string{
+		"/README.md":         "readme\n",
+		"/a directory/short": "short\n",
+		"/a directory/long":  strings.Repeat("0123456789", 1000),
+	}
+	f, err := os.CreateTemp("", "udf_metadata_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+
+	b := file.New(f, false)
+	fs, err := Create(b, 0, 0, 2048, "")
+	if err != nil {
+		t.Fatalf("Failed to Create: %v", err)
+	}
+	if err := fs.Mkdir("/a directory"); err != nil {
+		t.Fatalf("Failed to Mkdir: %v", err)
+	}
+	for filename, contents := range files {
+		udffile, err := fs.OpenFile(filename, os.O_CREATE|os.O_RDWR)
+		if err != nil {
+			t.Fatalf("Failed to OpenFile(%s): %v", filename, err)
+		}
+		_, err = udffile.Write([]byte(contents))
+		udffile.Close()
+		if err != nil {
+			t.Fatalf("error writing to %s: %v", filename, err)
+		}
+	}
+	if err := fs.Finalize(FinalizeOptions{Revision: Revision201}); err != nil {
+		t.Fatalf("unexpected error Finalize: %v", err)
+	}
+	fs, err = Read(b, 0, 0, 0)
+	if err != nil {
+		t.Fatalf("error reading the tmpfile as udf: %v", err)
+	}
+	mainBlock, mirrorBlock := toMetadataPartition(t, f, fs)
+
+	check := func(t *testing.T) {
+		t.Helper()
+		fs, err := Read(b, 0, 0, 0)
+		if err != nil {
+			t.Fatalf("error reading the tmpfile as udf: %v", err)
+		}
+		if fs.Revision() != Revision250 {
+			t.Errorf("mismatched revision, actual %s expected %s", fs.Revision(), Revision250)
+		}
+		if len(fs.partitions) != 2 || len(fs.partitions[0].metadata) != 2 || fs.partitions[1].metadata != nil {
+			t.Fatalf("partition reference 0 is not the metadata partition of 2 extents")
+		}
+		for p, contents := range files {
+			udffile, err := fs.OpenFile(p, os.O_RDONLY)
+			if err != nil {
+				t.Errorf("error opening file %s: %v", p, err)
+				continue
+			}
+			b, err := io.ReadAll(udffile)
+			udffile.Close()
+			if err != nil {
+				t.Errorf("error reading from file %s: %v", p, err)
+			}
+			if string(b) != contents {
+				t.Errorf("mismatched content of %s, actual %d bytes expected %d", p, len(b), len(contents))
+			}
+		}
+	}
+	blank := make([]byte, fs.blocksize)
+
+	t.Run("metadata file", check)
+	t.Run("mirror", func(t *testing.T) {
+		if _, err := f.WriteAt(blank, mainBlock*fs.blocksize); err != nil {
+			t.Fatalf("error blanking the metadata file entry: %v", err)
+		}
+		check(t)
+	})
+	t.Run("neither", func(t *testing.T) {
+		if _, err := f.WriteAt(blank, mirrorBlock*fs.blocksize); err != nil {
+			t.Fatalf("error blanking the metadata mirror file entry: %v", err)
+		}
+		if _, err := Read(b, 0, 0, 0); err == nil {
+			t.Errorf("unexpected nil error reading without metadata file")
+		}
+	})
+}
@@ -0,0 +1,172 @@
+package udf_test
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/diskfs/go-diskfs/backend/file"
+	"github.com/diskfs/go-diskfs/filesystem"
+	"github.com/diskfs/go-diskfs/filesystem/udf"
+)
+
+func TestUDFType(t *testing.T) {
+	fs := &udf.FileSystem{}
+	if fs.Type() != filesystem.TypeUDF {
+		t.Errorf("Type() returns %v instead of expected %v", fs.Type(), filesystem.TypeUDF)
+	}
+}
+
+func TestCreateInvalidBlocksize(t *testing.T) {
+	for _, blocksize := range []int64{256, 1000, 8192} {
+		if _, err := udf.Create(nil, 0, 0, blocksize, ""); err == nil {
+			t.Errorf("blocksize %d: unexpected nil error", blocksize)
+		}
+	}
+}
+
+func TestFinalize(t *testing.T) {
+	files := map[string]string{
+		"/README.md":                   "readme\n",
+		"/a directory/ünïcode file.md": "unicode\n",
+		"/a directory/漢字.txt":          "wide\n",
+		"/a directory/sub/deeper":      strings.Repeat("0123456789", 1000),
+		"/empty":                       "",
+	}
+	tests := []struct {
+		revision  udf.Revision
+		blocksize int64
+	}{
+		{udf.Revision102, 2048},
+		{udf.Revision201, 2048},
+		{udf.Revision201, 512},
+		{udf.Revision201, 4096},
+	}
+	for _, tt := range tests {
+		tt := tt
+		t.Run(fmt.Sprintf("%s %d", tt.revision, tt.blocksize), func(t *testing.T) {
+			f, err := os.CreateTemp("", "udf_finalize_test")
+			if err != nil {
+				t.Fatalf("Failed to create tmpfile: %v", err)
+			}
+			defer func() {
+				f.Close()
+				os.Remove(f.Name())
+			}()
+
+			b := file.New(f, false)
+			fs, err := udf.Create(b, 0, 0, tt.blocksize, "")
+			if err != nil {
+				t.Fatalf("Failed to udf.Create: %v", err)
+			}
+			if err := fs.Mkdir("/a directory/sub"); err != nil {
+				t.Fatalf("Failed to udf.Mkdir: %v", err)
+			}
+			for filename, contents := range files {
+				udffile, err := fs.OpenFile(filename, os.O_CREATE|os.O_RDWR)
+				if err != nil {
+					t.Fatalf("Failed to udf.OpenFile(%s): %v", filename, err)
+				}
+				_, err = udffile.Write([]byte(contents))
+				udffile.Close()
+				if err != nil {
+					t.Fatalf("error writing to %s: %v", filename, err)
+				}
+			}
+			options := udf.FinalizeOptions{Revision: tt.revision, VolumeIdentifier: "Test Volume"}
+			if err := fs.Finalize(options); err != nil {
+				t.Fatalf("unexpected error fs.Finalize(%+v): %v", options, err)
+			}
+			if err := fs.Finalize(options); err == nil {
+				t.Errorf("unexpected nil error finalizing twice")
+			}
+
+			fs, err = udf.Read(b, 0, 0, 0)
+			if err != nil {
+				t.Fatalf("error reading the tmpfile as udf: %v", err)
+			}
+			if fs.Revision() != tt.revision {
+				t.Errorf("mismatched revision, actual %s expected %s", fs.Revision(), tt.revision)
+			}
+			if fs.Label() != "Test Volume" {
+				t.Errorf("mismatched label, actual %q expected %q", fs.Label(), "Test Volume")
+			}
+			for p, contents := range files {
+				udffile, err := fs.OpenFile(p, os.O_RDONLY)
+				if err != nil {
+					t.Errorf("error opening file %s: %v", p, err)
+					continue
+				}
+				b, err := io.ReadAll(udffile)
+				udffile.Close()
+				if err != nil {
+					t.Errorf("error reading from file %s: %v", p, err)
+				}
+				if string(b) != contents {
+					t.Errorf("mismatched content of %s, actual %d bytes expected %d", p, len(b), len(contents))
+				}
+			}
+
+			entries, err := fs.ReadDir("/a directory")
+			if err != nil {
+				t.Fatalf("error reading directory: %v", err)
+			}
+			names := make([]string, 0, len(entries))
+			for _, e := range entries {
+				names = append(names, e.Name())
+				if e.Name() == "sub" && !e.IsDir() {
+					t.Errorf("sub is not a directory")
+				}
+			}
+			sort.Strings(names)
+			if expected := []string{"sub", "ünïcode file.md", "漢字.txt"}; strings.Join(names, ",") != strings.Join(expected, ",") {
+				t.Errorf("mismatched entries, actual %v expected %v", names, expected)
+			}
+
+			if _, err := fs.OpenFile("/missing", os.O_RDONLY); err == nil {
+				t.Errorf("unexpected nil error opening missing file")
+			}
+			if _, err := fs.OpenFile("/README.md", os.O_RDWR); err == nil {
+				t.Errorf("unexpected nil error opening file for writing")
+			}
+		})
+	}
+}
+
+func TestFinalizeInvalidRevision(t *testing.T) {
+	f, err := os.CreateTemp("", "udf_finalize_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	fs, err := udf.Create(file.New(f, false), 0, 0, 0, "")
+	if err != nil {
+		t.Fatalf("Failed to udf.Create: %v", err)
+	}
+	if err := fs.Finalize(udf.FinalizeOptions{Revision: udf.Revision250}); err == nil {
+		t.Errorf("unexpected nil error writing revision %s", udf.Revision250)
+	}
+}
+
+func TestReadNotUDF(t *testing.T) {
+	f, err := os.CreateTemp("", "udf_read_test")
+	if err != nil {
+		t.Fatalf("Failed to create tmpfile: %v", err)
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	if err := f.Truncate(1024 * 1024); err != nil {
+		t.Fatalf("Failed to truncate tmpfile: %v", err)
+	}
+	if _, err := udf.Read(file.New(f, true), 0, 0, 0); err == nil {
+		t.Errorf("unexpected nil error reading blank image")
+	}
+}
@@ -0,0 +1,212 @@
+package udf
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// compression IDs of OSTA CS0 compressed unicode, for 8 and 16 bits per character. 254 and 255 are the same
+	// as 8 and 16 respectively, but are used by UDF 2.60 for names of deleted entries
+	compressionID8         byte = 8
+	compressionID16        byte = 16
+	compressionID8Deleted  byte = 254
+	compressionID16Deleted byte = 255
+
+	// osta compressed unicode is the only character set UDF uses, CS0 with this as its information
+	ostaCompressedUnicode = "OSTA Compressed Unicode"
+	// unspecifiedTimezone in the type and timezone of a timestamp means the timezone is not known
+	unspecifiedTimezone = -2047
+)
+
+// encodeCS0 encodes a string as OSTA compressed unicode, with 8 bits per character when it can, and 16 otherwise.
+// The first byte is the compression ID.
+func encodeCS0(s string) []byte {
+	r := []rune(s)
+	wide := false
+	for _, c := range r {
+		if c > 0xff {
+			wide = true
+			break
+		}
+	}
+	if !wide {
+		b := make([]byte, 0, 1+len(r))
+		b = append(b, compressionID8)
+		for _, c := range r {
+			b = append(b, byte(c))
+		}
+		return b
+	}
+	u := utf16.Encode(r)
+	b := make([]byte, 1+2*len(u))
+	b[0] = compressionID16
+	for i, c := range u {
+		binary.BigEndian.PutUint16(b[1+2*i:], c)
+	}
+	return b
+}
+
+// decodeCS0 decodes OSTA compressed unicode, whose first byte is the compression ID
+func decodeCS0(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	switch b[0] {
+	case compressionID8, compressionID8Deleted:
+		r := make([]rune, 0, len(b)-1)
+		for _, c := range b[1:] {
+			r = append(r, rune(c))
+		}
+		return string(r)
+	case compressionID16, compressionID16Deleted:
+		u := make([]uint16, 0, (len(b)-1)/2)
+		for i := 1; i+1 < len(b); i += 2 {
+			u = append(u, binary.BigEndian.Uint16(b[i:i+2]))
+		}
+		return string(utf16.Decode(u))
+	}
+	return ""
+}
+
+// validateCS0 checks that a name can be encoded as OSTA compressed unicode in at most size bytes
+func validateCS0(s string, size int) error {
+	for _, c := range s {
+		if c == 0 || c > 0xffff {
+			return fmt.Errorf("name %q must not include NUL or characters beyond UCS-2", s)
+		}
+	}
+	if l := len(encodeCS0(s)); l > size {
+		return fmt.Errorf("name %q takes %d bytes, more than the maximum of %d", s, l, size)
+	}
+	return nil
+}
+
+// dstringToBytes encodes a string as a dstring of the given size: OSTA compressed unicode, padded with zeros, with
+// the number of bytes used in the last byte. A string that does not fit is cut short.
+func dstringToBytes(s string, size int) []byte {
+	b := make([]byte, size)
+	if s == "" {
+		return b
+	}
+	r := []rune(s)
+	e := encodeCS0(string(r))
+	for len(e) > size-1 {
+		r = r[:len(r)-1]
+		e = encodeCS0(string(r))
+	}
+	copy(b, e)
+	b[size-1] = byte(len(e))
+	return b
+}
+
+// bytesToDstring decodes a dstring
+func bytesToDstring(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	l := int(b[len(b)-1])
+	if l == 0 || l > len(b)-1 {
+		return ""
+	}
+	return strings.TrimRight(decodeCS0(b[:l]), "\x00")
+}
+
+// charspecToBytes returns the 64 byte character set specification for CS0, the only one UDF allows
+func charspecToBytes() []byte {
+	b := make([]byte, 64)
+	copy(b[1:], ostaCompressedUnicode)
+	return b
+}
+
+// timeToTimestamp converts a time to the 12 bytes of an ECMA-167 timestamp, in local time with its timezone
+func timeToTimestamp(t time.Time) []byte {
+	b := make([]byte, 12)
+	_, offset := t.Zone()
+	tz := uint16(offset/60) & 0x0fff
+	binary.LittleEndian.PutUint16(b[0:2], 1<<12|tz)
+	binary.LittleEndian.PutUint16(b[2:4], uint16(t.Year()))
+	b[4] = byte(t.Month())
+	b[5] = byte(t.Day())
+	b[6] = byte(t.Hour())
+	b[7] = byte(t.Minute())
+	b[8] = byte(t.Second())
+	ns := t.Nanosecond()
+	b[9] = byte(ns / 10000000)
+	b[10] = byte(ns / 100000 % 100)
+	b[11] = byte(ns / 1000 % 100)
+	return b
+}
+
+// timestampToTime converts the 12 bytes of an ECMA-167 timestamp to a time. A timestamp that is all zeros,
+// i.e. not recorded, is the zero time.
+func timestampToTime(b []byte) time.Time {
+	typeAndTimezone := binary.LittleEndian.Uint16(b[0:2])
+	year := int(int16(binary.LittleEndian.Uint16(b[2:4])))
+	if year == 0 && b[4] == 0 && b[5] == 0 {
+		return time.Time{}
+	}
+	loc := time.UTC
+	// the offset is a signed 12 bit number of minutes
+	offset := int(typeAndTimezone & 0x0fff)
+	if offset&0x0800 != 0 {
+		offset -= 0x1000
+	}
+	if typeAndTimezone>>12 == 1 && offset != unspecifiedTimezone {
+		loc = time.FixedZone("", offset*60)
+	}
+	ns := int(b[9])*10000000 + int(b[10])*100000 + int(b[11])*1000
+	return time.Date(year, time.Month(b[4]), int(b[5]), int(b[6]), int(b[7]), int(b[8]), ns, loc)
+}
+
+// regidToBytes returns the 32 bytes of an entity identifier with the given identifier and suffix
+func regidToBytes(identifier string, suffix []byte) []byte {
+	b := make([]byte, 32)
+	copy(b[1:24], identifier)
+	copy(b[24:32], suffix)
+	return b
+}
+
+// regidIdentifier returns the identifier of an entity identifier
+func regidIdentifier(b []byte) string {
+	return strings.TrimRight(string(b[1:24]), "\x00 ")
+}
+
+// udfSuffix is the identifier suffix of the domain identifier and of UDF entity identifiers, which holds the
+// revision of UDF
+func udfSuffix(revision Revision) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint16(b[0:2], uint16(revision))
+	return b
+}
+
+// extentDescriptor is an extent_ad: a length in bytes and a location in sectors of the volume
+type extentDescriptor struct {
+	length   uint32
+	location uint32
+}
+
+func parseExtentDescriptor(b []byte) extentDescriptor {
+	return extentDescriptor{
+		length:   binary.LittleEndian.Uint32(b[0:4]),
+		location: binary.LittleEndian.Uint32(b[4:8]),
+	}
+}
+
+func (e extentDescriptor) toBytes() []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint32(b[0:4], e.length)
+	binary.LittleEndian.PutUint32(b[4:8], e.location)
+	return b
+}
+
+// maxInt returns the larger of x or y.
+func maxInt(x, y int) int {
+	if x < y {
+		return y
+	}
+	return x
+}
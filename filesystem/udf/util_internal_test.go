@@ -0,0 +1,88 @@
+package udf
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestCS0(t *testing.T) {
+	tests := []struct {
+		s        string
+		encoding []byte
+	}{
+		{"", []byte{compressionID8}},
+		{"abc", []byte{compressionID8, 'a', 'b', 'c'}},
+		{"ü", []byte{compressionID8, 0xfc}},
+		{"a漢", []byte{compressionID16, 0x00, 'a', 0x6f, 0x22}},
+	}
+	for _, tt := range tests {
+		b := encodeCS0(tt.s)
+		if !bytes.Equal(b, tt.encoding) {
+			t.Errorf("encodeCS0(%q) = % x, expected % x", tt.s, b, tt.encoding)
+		}
+		if s := decodeCS0(b); s != tt.s {
+			t.Errorf("decodeCS0(% x) = %q, expected %q", b, s, tt.s)
+		}
+	}
+}
+
+func TestValidateCS0(t *testing.T) {
+	tests := []struct {
+		s     string
+		size  int
+		valid bool
+	}{
+		{"name", 255, true},
+		{"漢字", 5, true},
+		{"漢字", 4, false},
+		{"with\x00nul", 255, false},
+		{"emoji 😀", 255, false},
+	}
+	for _, tt := range tests {
+		err := validateCS0(tt.s, tt.size)
+		if (err == nil) != tt.valid {
+			t.Errorf("validateCS0(%q, %d) returned %v, expected valid %v", tt.s, tt.size, err, tt.valid)
+		}
+	}
+}
+
+func TestDstring(t *testing.T) {
+	tests := []struct {
+		s        string
+		size     int
+		expected string
+	}{
+		{"", 32, ""},
+		{"UDFIMAGE", 32, "UDFIMAGE"},
+		{"a much longer volume name than fits", 16, "a much longer "},
+		{"漢字漢字", 8, "漢字漢"},
+	}
+	for _, tt := range tests {
+		b := dstringToBytes(tt.s, tt.size)
+		if len(b) != tt.size {
+			t.Errorf("dstringToBytes(%q, %d) returned %d bytes", tt.s, tt.size, len(b))
+		}
+		if s := bytesToDstring(b); s != tt.expected {
+			t.Errorf("bytesToDstring(dstringToBytes(%q, %d)) = %q, expected %q", tt.s, tt.size, s, tt.expected)
+		}
+	}
+}
+
+func TestTimestamp(t *testing.T) {
+	times := []time.Time{
+		time.Date(2023, 4, 5, 6, 7, 8, 910111000, time.UTC),
+		time.Date(1999, 12, 31, 23, 59, 59, 0, time.FixedZone("", -5*60*60)),
+	}
+	for _, tm := range times {
+		b := timeToTimestamp(tm)
+		if len(b) != 12 {
+			t.Fatalf("timeToTimestamp(%v) returned %d bytes", tm, len(b))
+		}
+		// timestamps go down to microseconds
+		expected := tm.Truncate(time.Microsecond)
+		if actual := timestampToTime(b); !actual.Equal(expected) {
+			t.Errorf("timestampToTime(timeToTimestamp(%v)) = %v", tm, actual)
+		}
+	}
+}
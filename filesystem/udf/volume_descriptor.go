@@ -0,0 +1,337 @@
+package udf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+const (
+	// volumeStructureDescriptorSize is the size of each descriptor of the volume recognition sequence, which
+	// take a block each if blocks are larger
+	volumeStructureDescriptorSize = 2048
+	// volumeRecognitionStart is where the volume recognition sequence starts, after the system area
+	volumeRecognitionStart int64 = 32 * 1024
+	// anchorLocation is the block of the anchor volume descriptor pointer that all volumes have
+	anchorLocation uint32 = 256
+	// volumeDescriptorSequenceBlocks is the length of the main and reserve volume descriptor sequences we write,
+	// the minimum UDF allows
+	volumeDescriptorSequenceBlocks uint32 = 16
+
+	// partition contents identifiers, for UDF before and from 2.00 on
+	partitionContentsNSR02 = "+NSR02"
+	partitionContentsNSR03 = "+NSR03"
+
+	domainIdentifier       = "*OSTA UDF Compliant"
+	lvInfoIdentifier       = "*UDF LV Info"
+	implementationIdentity = "*go-diskfs"
+
+	// identifiers of type 2 partition maps
+	partitionMapVirtual  = "*UDF Virtual Partition"
+	partitionMapSparable = "*UDF Sparable Partition"
+	partitionMapMetadata = "*UDF Metadata Partition"
+
+	// partitionAccessReadOnly is the access type of a partition that never is written to again
+	partitionAccessReadOnly = 1
+	// partitionFlagAllocated marks that the volume space of a partition is allocated
+	partitionFlagAllocated = 1
+	// integrityTypeClose marks a logical volume integrity descriptor of a volume that is consistent
+	integrityTypeClose = 1
+)
+
+// identifiers of the volume structure descriptors of the volume recognition sequence
+var (
+	beginningExtendedAreaIdentifier   = []byte("BEA01")
+	terminatingExtendedAreaIdentifier = []byte("TEA01")
+	nsr02Identifier                   = []byte("NSR02")
+	nsr03Identifier                   = []byte("NSR03")
+)
+
+// volumeStructureDescriptor returns a descriptor of the volume recognition sequence
+func volumeStructureDescriptor(identifier []byte) []byte {
+	b := make([]byte, volumeStructureDescriptorSize)
+	copy(b[1:6], identifier)
+	b[6] = 1
+	return b
+}
+
+type anchorVolumeDescriptorPointer struct {
+	mainVolumeDescriptorSequence    extentDescriptor
+	reserveVolumeDescriptorSequence extentDescriptor
+}
+
+func parseAnchorVolumeDescriptorPointer(b []byte) *anchorVolumeDescriptorPointer {
+	return &anchorVolumeDescriptorPointer{
+		mainVolumeDescriptorSequence:    parseExtentDescriptor(b[16:24]),
+		reserveVolumeDescriptorSequence: parseExtentDescriptor(b[24:32]),
+	}
+}
+
+func (a *anchorVolumeDescriptorPointer) toBytes(t *descriptorTag) []byte {
+	b := make([]byte, 512)
+	copy(b[16:24], a.mainVolumeDescriptorSequence.toBytes())
+	copy(b[24:32], a.reserveVolumeDescriptorSequence.toBytes())
+	t.writeTo(b)
+	return b
+}
+
+type primaryVolumeDescriptor struct {
+	sequenceNumber      uint32
+	volumeIdentifier    string
+	volumeSetIdentifier string
+	recordingTime       time.Time
+}
+
+func parsePrimaryVolumeDescriptor(b []byte) *primaryVolumeDescriptor {
+	return &primaryVolumeDescriptor{
+		sequenceNumber:      binary.LittleEndian.Uint32(b[16:20]),
+		volumeIdentifier:    bytesToDstring(b[24:56]),
+		volumeSetIdentifier: bytesToDstring(b[72:200]),
+		recordingTime:       timestampToTime(b[376:388]),
+	}
+}
+
+func (p *primaryVolumeDescriptor) toBytes(t *descriptorTag) []byte {
+	b := make([]byte, 512)
+	binary.LittleEndian.PutUint32(b[16:20], p.sequenceNumber)
+	copy(b[24:56], dstringToBytes(p.volumeIdentifier, 32))
+	// volume sequence number and maximum
+	binary.LittleEndian.PutUint16(b[56:58], 1)
+	binary.LittleEndian.PutUint16(b[58:60], 1)
+	// interchange level and maximum, for a volume set of a single volume
+	binary.LittleEndian.PutUint16(b[60:62], 2)
+	binary.LittleEndian.PutUint16(b[62:64], 2)
+	// character set lists have just CS0
+	binary.LittleEndian.PutUint32(b[64:68], 1)
+	binary.LittleEndian.PutUint32(b[68:72], 1)
+	copy(b[72:200], dstringToBytes(p.volumeSetIdentifier, 128))
+	copy(b[200:264], charspecToBytes())
+	copy(b[264:328], charspecToBytes())
+	copy(b[376:388], timeToTimestamp(p.recordingTime))
+	copy(b[388:420], regidToBytes(implementationIdentity, nil))
+	t.writeTo(b)
+	return b
+}
+
+type implementationUseVolumeDescriptor struct {
+	sequenceNumber          uint32
+	revision                Revision
+	logicalVolumeIdentifier string
+}
+
+func (i *implementationUseVolumeDescriptor) toBytes(t *descriptorTag) []byte {
+	b := make([]byte, 512)
+	binary.LittleEndian.PutUint32(b[16:20], i.sequenceNumber)
+	copy(b[20:52], regidToBytes(lvInfoIdentifier, udfSuffix(i.revision)))
+	copy(b[52:116], charspecToBytes())
+	copy(b[116:244], dstringToBytes(i.logicalVolumeIdentifier, 128))
+	copy(b[352:384], regidToBytes(implementationIdentity, nil))
+	t.writeTo(b)
+	return b
+}
+
+type partitionDescriptor struct {
+	sequenceNumber   uint32
+	number           uint16
+	contents         string
+	accessType       uint32
+	startingLocation uint32
+	length           uint32
+}
+
+func parsePartitionDescriptor(b []byte) *partitionDescriptor {
+	return &partitionDescriptor{
+		sequenceNumber:   binary.LittleEndian.Uint32(b[16:20]),
+		number:           binary.LittleEndian.Uint16(b[22:24]),
+		contents:         regidIdentifier(b[24:56]),
+		accessType:       binary.LittleEndian.Uint32(b[184:188]),
+		startingLocation: binary.LittleEndian.Uint32(b[188:192]),
+		length:           binary.LittleEndian.Uint32(b[192:196]),
+	}
+}
+
+func (p *partitionDescriptor) toBytes(t *descriptorTag) []byte {
+	b := make([]byte, 512)
+	binary.LittleEndian.PutUint32(b[16:20], p.sequenceNumber)
+	binary.LittleEndian.PutUint16(b[20:22], partitionFlagAllocated)
+	binary.LittleEndian.PutUint16(b[22:24], p.number)
+	copy(b[24:56], regidToBytes(p.contents, nil))
+	// a read-only partition needs no space bitmap or table, so the partition header stays empty
+	binary.LittleEndian.PutUint32(b[184:188], p.accessType)
+	binary.LittleEndian.PutUint32(b[188:192], p.startingLocation)
+	binary.LittleEndian.PutUint32(b[192:196], p.length)
+	copy(b[196:228], regidToBytes(implementationIdentity, nil))
+	t.writeTo(b)
+	return b
+}
+
+// partitionMap is an entry of the partition maps of a logical volume. Type 1 maps a physical partition directly;
+// type 2 is one of the UDF partition types, told apart by identifier.
+type partitionMap struct {
+	mapType         uint8
+	identifier      string
+	partitionNumber uint16
+	// location of the metadata file and its mirror in the physical partition, for a metadata partition
+	metadataFileLocation       uint32
+	metadataMirrorFileLocation uint32
+}
+
+func parsePartitionMaps(b []byte, count uint32) ([]partitionMap, error) {
+	maps := make([]partitionMap, 0, count)
+	for i := uint32(0); i < count; i++ {
+		if len(b) < 2 || int(b[1]) > len(b) || b[1] < 6 {
+			return nil, fmt.Errorf("partition map %d is beyond the partition map table", i)
+		}
+		m := partitionMap{mapType: b[0]}
+		switch m.mapType {
+		case 1:
+			m.partitionNumber = binary.LittleEndian.Uint16(b[4:6])
+		case 2:
+			if b[1] < 40 {
+				return nil, fmt.Errorf("type 2 partition map %d is of %d bytes instead of 64", i, b[1])
+			}
+			m.identifier = regidIdentifier(b[4:36])
+			m.partitionNumber = binary.LittleEndian.Uint16(b[38:40])
+			if m.identifier == partitionMapMetadata && b[1] >= 48 {
+				m.metadataFileLocation = binary.LittleEndian.Uint32(b[40:44])
+				m.metadataMirrorFileLocation = binary.LittleEndian.Uint32(b[44:48])
+			}
+		default:
+			return nil, fmt.Errorf("partition map %d is of unknown type %d", i, m.mapType)
+		}
+		maps = append(maps, m)
+		b = b[b[1]:]
+	}
+	return maps, nil
+}
+
+type logicalVolumeDescriptor struct {
+	sequenceNumber          uint32
+	logicalVolumeIdentifier string
+	logicalBlockSize        uint32
+	revision                Revision
+	fileSetDescriptor       longAllocationDescriptor
+	partitionMaps           []partitionMap
+	integritySequence       extentDescriptor
+}
+
+func parseLogicalVolumeDescriptor(b []byte) (*logicalVolumeDescriptor, error) {
+	mapTableLength := binary.LittleEndian.Uint32(b[264:268])
+	if 440+int(mapTableLength) > len(b) {
+		return nil, fmt.Errorf("partition map table of %d bytes is beyond the logical volume descriptor", mapTableLength)
+	}
+	maps, err := parsePartitionMaps(b[440:440+mapTableLength], binary.LittleEndian.Uint32(b[268:272]))
+	if err != nil {
+		return nil, err
+	}
+	return &logicalVolumeDescriptor{
+		sequenceNumber:          binary.LittleEndian.Uint32(b[16:20]),
+		logicalVolumeIdentifier: bytesToDstring(b[84:212]),
+		logicalBlockSize:        binary.LittleEndian.Uint32(b[212:216]),
+		revision:                Revision(binary.LittleEndian.Uint16(b[240:242])),
+		fileSetDescriptor:       parseLongAllocationDescriptor(b[248:264]),
+		partitionMaps:           maps,
+		integritySequence:       parseExtentDescriptor(b[432:440]),
+	}, nil
+}
+
+// toBytes for the logical volume descriptor writes a single type 1 partition map, for partition 0
+func (l *logicalVolumeDescriptor) toBytes(t *descriptorTag) []byte {
+	b := make([]byte, 446)
+	binary.LittleEndian.PutUint32(b[16:20], l.sequenceNumber)
+	copy(b[20:84], charspecToBytes())
+	copy(b[84:212], dstringToBytes(l.logicalVolumeIdentifier, 128))
+	binary.LittleEndian.PutUint32(b[212:216], l.logicalBlockSize)
+	copy(b[216:248], regidToBytes(domainIdentifier, udfSuffix(l.revision)))
+	copy(b[248:264], l.fileSetDescriptor.toBytes())
+	binary.LittleEndian.PutUint32(b[264:268], 6)
+	binary.LittleEndian.PutUint32(b[268:272], 1)
+	copy(b[272:304], regidToBytes(implementationIdentity, nil))
+	copy(b[432:440], l.integritySequence.toBytes())
+	b[440] = 1
+	b[441] = 6
+	binary.LittleEndian.PutUint16(b[442:444], 1)
+	binary.LittleEndian.PutUint16(b[444:446], 0)
+	t.writeTo(b)
+	return b
+}
+
+type unallocatedSpaceDescriptor struct {
+	sequenceNumber uint32
+}
+
+func (u *unallocatedSpaceDescriptor) toBytes(t *descriptorTag) []byte {
+	b := make([]byte, 24)
+	binary.LittleEndian.PutUint32(b[16:20], u.sequenceNumber)
+	t.writeTo(b)
+	return b
+}
+
+type terminatingDescriptor struct{}
+
+func (d *terminatingDescriptor) toBytes(t *descriptorTag) []byte {
+	b := make([]byte, 512)
+	t.writeTo(b)
+	return b
+}
+
+// logicalVolumeIntegrityDescriptor for a logical volume of a single partition
+type logicalVolumeIntegrityDescriptor struct {
+	recordingTime       time.Time
+	nextUniqueID        uint64
+	partitionSize       uint32
+	numberOfFiles       uint32
+	numberOfDirectories uint32
+	revision            Revision
+}
+
+func (l *logicalVolumeIntegrityDescriptor) toBytes(t *descriptorTag) []byte {
+	const implementationUseLength = 46
+	b := make([]byte, 88+implementationUseLength)
+	copy(b[16:28], timeToTimestamp(l.recordingTime))
+	binary.LittleEndian.PutUint32(b[28:32], integrityTypeClose)
+	binary.LittleEndian.PutUint64(b[40:48], l.nextUniqueID)
+	binary.LittleEndian.PutUint32(b[72:76], 1)
+	binary.LittleEndian.PutUint32(b[76:80], implementationUseLength)
+	// a read-only partition has no free space
+	binary.LittleEndian.PutUint32(b[80:84], 0)
+	binary.LittleEndian.PutUint32(b[84:88], l.partitionSize)
+	iu := b[88:]
+	copy(iu[0:32], regidToBytes(implementationIdentity, nil))
+	binary.LittleEndian.PutUint32(iu[32:36], l.numberOfFiles)
+	binary.LittleEndian.PutUint32(iu[36:40], l.numberOfDirectories)
+	binary.LittleEndian.PutUint16(iu[40:42], uint16(l.revision))
+	binary.LittleEndian.PutUint16(iu[42:44], uint16(l.revision))
+	binary.LittleEndian.PutUint16(iu[44:46], uint16(l.revision))
+	t.writeTo(b)
+	return b
+}
+
+// volumeDescriptors are the prevailing descriptors of the volume descriptor sequence that we use
+type volumeDescriptors struct {
+	primary    *primaryVolumeDescriptor
+	logical    *logicalVolumeDescriptor
+	partitions map[uint16]*partitionDescriptor
+}
+
+// hasVolumeRecognitionSequence reports whether the descriptors of a volume recognition sequence, read from just
+// after the system area, include the one of a UDF volume. Each descriptor takes step bytes.
+func hasVolumeRecognitionSequence(b []byte, step int) bool {
+	inExtendedArea := false
+	for i := 0; i+6 <= len(b); i += step {
+		identifier := b[i+1 : i+6]
+		switch {
+		case bytes.Equal(identifier, beginningExtendedAreaIdentifier):
+			inExtendedArea = true
+		case bytes.Equal(identifier, terminatingExtendedAreaIdentifier):
+			inExtendedArea = false
+		case inExtendedArea && (bytes.Equal(identifier, nsr02Identifier) || bytes.Equal(identifier, nsr03Identifier)):
+			return true
+		case b[i] == 0 && b[i+1] == 0:
+			// an unrecorded descriptor ends the sequence
+			return false
+		}
+	}
+	return false
+}